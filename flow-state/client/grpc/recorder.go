@@ -0,0 +1,201 @@
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/project-flogo/core/data/metadata"
+	"github.com/project-flogo/core/support/log"
+	"github.com/project-flogo/core/support/service"
+	"github.com/project-flogo/flow/state"
+	"github.com/project-flogo/services/flow-state/rpc"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcmd "google.golang.org/grpc/metadata"
+)
+
+const defaultTimeout = 10000
+
+func init() {
+	_ = service.RegisterFactory(&StateRecorderFactory{})
+}
+
+type StateRecorderFactory struct {
+}
+
+func (s StateRecorderFactory) NewService(config *service.Config) (service.Service, error) {
+	recorder := &StateRecorder{}
+	recorder.logger = log.RootLogger()
+
+	err := recorder.init(config.Settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return recorder, nil
+}
+
+type recorderSettings struct {
+	// Host is the host:port of the state service gRPC server
+	Host string `md:"host,required"`
+	// Timeout is the per call timeout in milliseconds
+	Timeout            int    `md:"timeout"`
+	CACertFile         string `md:"caCertFile"`
+	CertFile           string `md:"certFile"`
+	KeyFile            string `md:"keyFile"`
+	InsecureSkipVerify bool   `md:"insecureSkipVerify"`
+	AuthHeaderName     string `md:"authHeaderName"`
+	AuthHeader         string `md:"authHeader"`
+}
+
+// StateRecorder is an implementation of StateRecorder service
+// that records flow state over gRPC
+type StateRecorder struct {
+	conn     *gogrpc.ClientConn
+	client   rpc.StateClient
+	timeout  time.Duration
+	authName string
+	auth     string
+	logger   log.Logger
+}
+
+func (sr *StateRecorder) Name() string {
+	return "FlowStateRecorder"
+}
+
+// Start implements util.Managed.Start()
+func (sr *StateRecorder) Start() error {
+	// no-op
+	return nil
+}
+
+// Stop implements util.Managed.Stop()
+func (sr *StateRecorder) Stop() error {
+	return sr.conn.Close()
+}
+
+func (sr *StateRecorder) init(settings map[string]interface{}) error {
+	s := &recorderSettings{}
+	if err := metadata.MapToStruct(settings, s, true); err != nil {
+		return fmt.Errorf("StateRecorder: %s", err.Error())
+	}
+	if s.Timeout <= 0 {
+		s.Timeout = defaultTimeout
+	}
+	if s.AuthHeaderName == "" {
+		s.AuthHeaderName = "authorization"
+	}
+
+	creds, err := newCredentials(s)
+	if err != nil {
+		return err
+	}
+
+	// the connection is established lazily and shared by all calls
+	sr.conn, err = gogrpc.Dial(s.Host, gogrpc.WithTransportCredentials(creds))
+	if err != nil {
+		return fmt.Errorf("StateRecorder: unable to connect to %s, %s", s.Host, err.Error())
+	}
+	sr.client = rpc.NewStateClient(sr.conn)
+	sr.timeout = time.Duration(s.Timeout) * time.Millisecond
+	// gRPC metadata keys are lower case
+	sr.authName = strings.ToLower(s.AuthHeaderName)
+	sr.auth = s.AuthHeader
+	sr.logger.Debugf("StateRecorder: StateRecorder Server = %s", s.Host)
+	return nil
+}
+
+func newCredentials(s *recorderSettings) (credentials.TransportCredentials, error) {
+	if s.CACertFile == "" && s.CertFile == "" && !s.InsecureSkipVerify {
+		return insecure.NewCredentials(), nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: s.InsecureSkipVerify}
+	if s.CACertFile != "" {
+		caCert, err := ioutil.ReadFile(s.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("StateRecorder: unable to read CA certificate '%s', %s", s.CACertFile, err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("StateRecorder: no certificate found in '%s'", s.CACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if s.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("StateRecorder: unable to load client certificate, %s", err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+func (sr *StateRecorder) context() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.Background(), sr.timeout)
+	if sr.auth != "" {
+		ctx = grpcmd.AppendToOutgoingContext(ctx, sr.authName, sr.auth)
+	}
+	return ctx, cancel
+}
+
+func (sr *StateRecorder) RecordStart(state *state.FlowState) error {
+	ctx, cancel := sr.context()
+	defer cancel()
+	_, err := sr.client.RecordStart(ctx, state)
+	return err
+}
+
+// RecordSnapshot implements instance.StateRecorder.RecordSnapshot
+func (sr *StateRecorder) RecordSnapshot(snapshot *state.Snapshot) error {
+	ctx, cancel := sr.context()
+	defer cancel()
+	_, err := sr.client.RecordSnapshot(ctx, snapshot)
+	return err
+}
+
+// RecordStep implements instance.StateRecorder.RecordStep
+func (sr *StateRecorder) RecordStep(step *state.Step) error {
+	ctx, cancel := sr.context()
+	defer cancel()
+	_, err := sr.client.RecordStep(ctx, step)
+	return err
+}
+
+// RecordSteps sends the steps over a single RecordSteps stream
+func (sr *StateRecorder) RecordSteps(steps []*state.Step) error {
+	ctx, cancel := sr.context()
+	defer cancel()
+	stream, err := sr.client.RecordSteps(ctx)
+	if err != nil {
+		return err
+	}
+	for _, step := range steps {
+		if err := stream.Send(step); err != nil {
+			// the server status is returned by CloseAndRecv
+			break
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	if resp.Count != len(steps) {
+		return fmt.Errorf("StateRecorder: only %d of %d steps were recorded", resp.Count, len(steps))
+	}
+	return nil
+}
+
+func (sr *StateRecorder) RecordDone(state *state.FlowState) error {
+	ctx, cancel := sr.context()
+	defer cancel()
+	_, err := sr.client.RecordEnd(ctx, state)
+	return err
+}
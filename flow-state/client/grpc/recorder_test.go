@@ -0,0 +1,156 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/project-flogo/core/support/log"
+	"github.com/project-flogo/flow/state"
+	"github.com/project-flogo/services/flow-state/rpc"
+	server "github.com/project-flogo/services/flow-state/server/grpc"
+	"github.com/project-flogo/services/flow-state/server/rest"
+	"github.com/project-flogo/services/flow-state/store/mem"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRecorder(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := gogrpc.NewServer()
+	rpc.RegisterStateServer(srv, server.NewServer(mem.NewStore(), log.RootLogger()))
+	go func() { _ = srv.Serve(listener) }()
+	defer srv.Stop()
+
+	recorder := &StateRecorder{logger: log.RootLogger()}
+	if err := recorder.init(map[string]interface{}{"host": listener.Addr().String()}); err != nil {
+		t.Fatal(err)
+	}
+	defer recorder.Stop()
+
+	if err := recorder.RecordStart(&state.FlowState{FlowInstanceId: "f1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.RecordStep(&state.Step{Id: 0, FlowId: "f1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.RecordSteps([]*state.Step{{Id: 1, FlowId: "f1"}, {Id: 2, FlowId: "f1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := recorder.RecordDone(&state.FlowState{FlowInstanceId: "f1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	steps, err := recorder.client.GetSteps(context.Background(), &rpc.InstanceRequest{FlowId: "f1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps.Steps) != 3 || steps.Steps[2].Id != 2 {
+		t.Fatalf("unexpected steps %+v", steps.Steps)
+	}
+
+	_, err = recorder.client.GetSteps(context.Background(), &rpc.InstanceRequest{FlowId: "unknown"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound, got %v", err)
+	}
+}
+
+func TestRecorderAuthorization(t *testing.T) {
+	auth, err := rest.NewAuthenticator(map[string]interface{}{"type": rest.AuthAPIKey, "keys": map[string]interface{}{
+		"alice-key": map[string]interface{}{"user": "alice", "apps": "app1"},
+		"bob-key":   map[string]interface{}{"user": "bob"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := gogrpc.NewServer(gogrpc.UnaryInterceptor(server.UnaryAuthInterceptor(auth)), gogrpc.StreamInterceptor(server.StreamAuthInterceptor(auth)))
+	rpc.RegisterStateServer(srv, server.NewServer(mem.NewStore(), log.RootLogger()))
+	go func() { _ = srv.Serve(listener) }()
+	defer srv.Stop()
+
+	newRecorder := func(key string) *StateRecorder {
+		recorder := &StateRecorder{logger: log.RootLogger()}
+		settings := map[string]interface{}{"host": listener.Addr().String(), "authHeaderName": rest.APIKeyHeader}
+		if key != "" {
+			settings["authHeader"] = key
+		}
+		if err := recorder.init(settings); err != nil {
+			t.Fatal(err)
+		}
+		return recorder
+	}
+	alice, bob, anonymous := newRecorder("alice-key"), newRecorder("bob-key"), newRecorder("")
+	defer alice.Stop()
+	defer bob.Stop()
+	defer anonymous.Stop()
+
+	started := &state.FlowState{FlowInstanceId: "f1", UserId: "alice", AppName: "app1", AppVersion: "1.0.0"}
+	if err := alice.RecordStart(started); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.RecordSteps([]*state.Step{{Id: 0, FlowId: "f1"}, {Id: 1, FlowId: "f1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	type check struct {
+		name string
+		err  error
+		code codes.Code
+	}
+	checks := []check{
+		{"anonymous step", anonymous.RecordStep(&state.Step{Id: 2, FlowId: "f1"}), codes.Unauthenticated},
+		{"start of another app", alice.RecordStart(&state.FlowState{FlowInstanceId: "f2", UserId: "alice", AppName: "app2"}), codes.PermissionDenied},
+		{"start of another user", bob.RecordStart(&state.FlowState{FlowInstanceId: "f2", UserId: "alice", AppName: "app1"}), codes.PermissionDenied},
+		{"step of another user", bob.RecordStep(&state.Step{Id: 2, FlowId: "f1"}), codes.PermissionDenied},
+		{"streamed step of another user", bob.RecordSteps([]*state.Step{{Id: 0, FlowId: "f3"}, {Id: 2, FlowId: "f1"}}), codes.PermissionDenied},
+		{"snapshot of another user", bob.RecordSnapshot(&state.Snapshot{Id: "f1"}), codes.PermissionDenied},
+		{"end of another user", bob.RecordDone(&state.FlowState{FlowInstanceId: "f1", UserId: "bob", AppName: "app1"}), codes.PermissionDenied},
+	}
+	ctx, cancel := bob.context()
+	defer cancel()
+	_, err = bob.client.GetSteps(ctx, &rpc.InstanceRequest{FlowId: "f1"})
+	checks = append(checks, check{"steps of another user", err, codes.PermissionDenied})
+	_, err = bob.client.GetStatus(ctx, &rpc.InstanceRequest{FlowId: "f1"})
+	checks = append(checks, check{"status of another user", err, codes.PermissionDenied})
+	for _, c := range checks {
+		if status.Code(c.err) != c.code {
+			t.Fatalf("%s: expected %s, got %v", c.name, c.code, c.err)
+		}
+	}
+
+	// the user of the request is replaced by the caller identity
+	instances, err := bob.client.GetInstances(ctx, &rpc.InstancesRequest{Username: "alice", AppName: "app1", AppVersion: "1.0.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances.Instances) != 0 {
+		t.Fatalf("expected no instances of bob, got %+v", instances.Instances)
+	}
+	aliceCtx, aliceCancel := alice.context()
+	defer aliceCancel()
+	if _, err = alice.client.GetInstances(aliceCtx, &rpc.InstancesRequest{AppName: "app2", AppVersion: "1.0.0"}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("expected PermissionDenied for app2, got %v", err)
+	}
+	instances, err = alice.client.GetInstances(aliceCtx, &rpc.InstancesRequest{AppName: "app1", AppVersion: "1.0.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances.Instances) != 1 {
+		t.Fatalf("expected the instance of alice, got %+v", instances.Instances)
+	}
+	steps, err := alice.client.GetSteps(aliceCtx, &rpc.InstanceRequest{FlowId: "f1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps.Steps) != 2 {
+		t.Fatalf("unexpected steps %+v", steps.Steps)
+	}
+}
@@ -0,0 +1,160 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/project-flogo/core/support/log"
+	"github.com/project-flogo/services/flow-state/store/batch"
+)
+
+const (
+	defaultBatchInterval = 200 * time.Millisecond
+	defaultBatchBytes    = 1024 * 1024
+)
+
+// batcher coalesces recorder calls and posts them to the batch endpoint once the
+// batch reaches maxRecords or maxBytes, or interval elapsed since its first record
+type batcher struct {
+	client     *client
+	journal    *journal
+	maxRecords int
+	maxBytes   int
+	interval   time.Duration
+	logger     log.Logger
+
+	mu      sync.Mutex
+	records []*batch.Record
+	size    int
+	timer   *time.Timer
+	closed  bool
+
+	batches chan []*batch.Record
+	done    chan struct{}
+}
+
+func newBatcher(c *client, j *journal, maxRecords, maxBytes int, interval time.Duration, logger log.Logger) *batcher {
+	if maxBytes <= 0 {
+		maxBytes = defaultBatchBytes
+	}
+	if interval <= 0 {
+		interval = defaultBatchInterval
+	}
+	b := &batcher{
+		client:     c,
+		journal:    j,
+		maxRecords: maxRecords,
+		maxBytes:   maxBytes,
+		interval:   interval,
+		logger:     logger,
+		batches:    make(chan []*batch.Record, 16),
+		done:       make(chan struct{}),
+	}
+	// a single sender keeps batches in recording order
+	go b.send()
+	return b
+}
+
+func (b *batcher) add(kind batch.Kind, v interface{}) error {
+	record, err := batch.NewRecord(kind, v)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrStopped
+	}
+	if len(b.records) > 0 && b.size+len(record.Data) > b.maxBytes {
+		b.flushLocked()
+	}
+	b.records = append(b.records, record)
+	b.size += len(record.Data)
+
+	if len(b.records) >= b.maxRecords || b.size >= b.maxBytes {
+		b.flushLocked()
+	} else if b.timer == nil {
+		b.timer = time.AfterFunc(b.interval, b.flush)
+	}
+	return nil
+}
+
+func (b *batcher) flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.flushLocked()
+	}
+}
+
+func (b *batcher) flushLocked() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.records) == 0 {
+		return
+	}
+	b.batches <- b.records
+	b.records = nil
+	b.size = 0
+}
+
+// close posts the pending records and waits for all batches to be sent
+func (b *batcher) close() {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.flushLocked()
+	b.closed = true
+	close(b.batches)
+	b.mu.Unlock()
+	<-b.done
+}
+
+func (b *batcher) send() {
+	defer close(b.done)
+	for records := range b.batches {
+		var err error
+		if b.journal != nil {
+			err = b.journal.send(records, func() error { return b.post(records) })
+		} else {
+			err = b.post(records)
+		}
+		if err != nil {
+			b.logger.Errorf("StateRecorder: unable to record batch of %d records, %s", len(records), err.Error())
+		}
+	}
+}
+
+func (b *batcher) post(records []*batch.Record) error {
+	jsonReq, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	b.logger.Debugf("POST batch of %d records", len(records))
+	content, err := b.client.post("/v1/instances/batch", jsonReq)
+	if err != nil {
+		return err
+	}
+
+	// a 207 lists the records that could not be saved
+	result := &struct {
+		Results []*batch.Result `json:"results"`
+	}{}
+	if err := json.Unmarshal(content, result); err == nil {
+		for _, r := range result.Results {
+			if r.Status >= http.StatusMultipleChoices {
+				b.logger.Errorf("StateRecorder: batch %s record %d failed with status %d, %s", r.Type, r.Index, r.Status, r.Error)
+			}
+		}
+	}
+	return nil
+}
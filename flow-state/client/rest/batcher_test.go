@@ -0,0 +1,52 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/project-flogo/core/support/log"
+	"github.com/project-flogo/flow/state"
+	"github.com/project-flogo/services/flow-state/store/batch"
+)
+
+func TestBatcher(t *testing.T) {
+	var mu sync.Mutex
+	var sizes []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var records []*batch.Record
+		if r.URL.Path != "/v1/instances/batch" || json.NewDecoder(r.Body).Decode(&records) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		sizes = append(sizes, len(records))
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	c := newTestClient(t, map[string]interface{}{"host": server.URL})
+	b := newBatcher(c, nil, 3, 0, 50*time.Millisecond, log.RootLogger())
+	for i := 0; i < 4; i++ {
+		if err := b.add(batch.Step, &state.Step{Id: i, FlowId: "f1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the fourth record is sent once the interval elapses
+	time.Sleep(200 * time.Millisecond)
+	_ = b.add(batch.End, &state.FlowState{FlowInstanceId: "f1"})
+	b.close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sizes) != 3 || sizes[0] != 3 || sizes[1] != 1 || sizes[2] != 1 {
+		t.Fatalf("unexpected batches %v", sizes)
+	}
+	if err := b.add(batch.Step, &state.Step{}); err != ErrStopped {
+		t.Fatalf("expected ErrStopped after close, got %v", err)
+	}
+}
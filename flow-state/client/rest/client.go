@@ -0,0 +1,276 @@
+package rest
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/project-flogo/core/data/metadata"
+	"github.com/project-flogo/core/support/log"
+)
+
+const (
+	asyncCallingHeader = "Async-Calling"
+
+	defaultTimeout      = 10000
+	defaultMaxRetries   = 3
+	defaultRetryBackoff = 100
+	defaultMaxBackoff   = 5000
+)
+
+// ErrStopped is returned for calls made after the recorder was stopped
+var ErrStopped = errors.New("StateRecorder: recorder is stopped")
+
+type recorderSettings struct {
+	Host string `md:"host,required"`
+	Port int    `md:"port"`
+	// Timeout is the per request timeout in milliseconds
+	Timeout            int    `md:"timeout"`
+	CACertFile         string `md:"caCertFile"`
+	CertFile           string `md:"certFile"`
+	KeyFile            string `md:"keyFile"`
+	InsecureSkipVerify bool   `md:"insecureSkipVerify"`
+	AuthHeaderName     string `md:"authHeaderName"`
+	AuthHeader         string `md:"authHeader"`
+	Async              bool   `md:"async"`
+	MaxRetries         int    `md:"maxRetries"`
+	// RetryBackoff and MaxBackoff are in milliseconds
+	RetryBackoff  int `md:"retryBackoff"`
+	MaxBackoff    int `md:"maxBackoff"`
+	BatchSize     int `md:"batchSize"`
+	BatchInterval int `md:"batchInterval"`
+	BatchBytes    int `md:"batchBytes"`
+	// JournalDir enables journaling records the state service could not receive
+	JournalDir     string `md:"journalDir"`
+	JournalMaxSize int64  `md:"journalMaxSize"`
+}
+
+func newRecorderSettings(settings map[string]interface{}) (*recorderSettings, error) {
+	s := &recorderSettings{}
+	if err := metadata.MapToStruct(settings, s, true); err != nil {
+		return nil, fmt.Errorf("StateRecorder: %s", err.Error())
+	}
+	if s.Timeout <= 0 {
+		s.Timeout = defaultTimeout
+	}
+	if _, set := settings["maxRetries"]; !set || s.MaxRetries < 0 {
+		s.MaxRetries = defaultMaxRetries
+	}
+	if s.RetryBackoff <= 0 {
+		s.RetryBackoff = defaultRetryBackoff
+	}
+	if s.MaxBackoff <= 0 {
+		s.MaxBackoff = defaultMaxBackoff
+	}
+	if s.AuthHeaderName == "" {
+		s.AuthHeaderName = "Authorization"
+	}
+	return s, nil
+}
+
+// StatusError is returned when the state service answers with a non 2xx status
+type StatusError struct {
+	Method     string
+	URI        string
+	StatusCode int
+	Message    string
+	retryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("StateRecorder: %s %s failed with status %d, %s", e.Method, e.URI, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("StateRecorder: %s %s failed with status %d", e.Method, e.URI, e.StatusCode)
+}
+
+// Temporary reports whether the request may succeed when retried
+func (e *StatusError) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// client posts to the state service, retrying transient failures with exponential backoff and jitter
+type client struct {
+	host       string
+	httpClient *http.Client
+	headers    map[string]string
+	maxRetries int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	logger     log.Logger
+	done       chan struct{}
+}
+
+func newClient(s *recorderSettings, logger log.Logger) (*client, error) {
+	host := s.Host
+	if !strings.HasPrefix(host, "http://") && !strings.HasPrefix(host, "https://") {
+		scheme := "http://"
+		if s.CACertFile != "" || s.CertFile != "" {
+			scheme = "https://"
+		}
+		host = scheme + host
+	}
+	host = strings.TrimSuffix(host, "/")
+	if s.Port > 0 {
+		host = host + ":" + strconv.Itoa(s.Port)
+	}
+
+	tlsConfig, err := newTLSConfig(s)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.MaxIdleConnsPerHost = 16
+
+	c := &client{
+		host:       host,
+		httpClient: &http.Client{Transport: transport, Timeout: time.Duration(s.Timeout) * time.Millisecond},
+		headers:    map[string]string{"Content-Type": "application/json"},
+		maxRetries: s.MaxRetries,
+		backoff:    time.Duration(s.RetryBackoff) * time.Millisecond,
+		maxBackoff: time.Duration(s.MaxBackoff) * time.Millisecond,
+		logger:     logger,
+		done:       make(chan struct{}),
+	}
+	if s.AuthHeader != "" {
+		c.headers[s.AuthHeaderName] = s.AuthHeader
+	}
+	if s.Async {
+		c.headers[asyncCallingHeader] = "true"
+	}
+	return c, nil
+}
+
+func newTLSConfig(s *recorderSettings) (*tls.Config, error) {
+	if s.CACertFile == "" && s.CertFile == "" && !s.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: s.InsecureSkipVerify}
+	if s.CACertFile != "" {
+		caCert, err := ioutil.ReadFile(s.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("StateRecorder: unable to read CA certificate '%s', %s", s.CACertFile, err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("StateRecorder: no certificate found in '%s'", s.CACertFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if s.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("StateRecorder: unable to load client certificate, %s", err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// post sends body to path and returns the response body of a 2xx response
+func (c *client) post(path string, body []byte) ([]byte, error) {
+	uri := c.host + path
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		var content []byte
+		content, err = c.do(uri, body)
+		if err == nil {
+			return content, nil
+		}
+		if attempt >= c.maxRetries || !isTransient(err) {
+			return nil, err
+		}
+
+		delay := c.delay(attempt, err)
+		c.logger.Debugf("StateRecorder: POST %s failed, retrying in %s, %s", uri, delay, err.Error())
+		select {
+		case <-c.done:
+			return nil, err
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (c *client) do(uri string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodPost, uri, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range c.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	content, _ := ioutil.ReadAll(resp.Body)
+
+	c.logger.Debug("response Status:", resp.Status)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return content, nil
+	}
+
+	statusErr := &StatusError{Method: http.MethodPost, URI: uri, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(content))}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		statusErr.retryAfter = time.Duration(seconds) * time.Second
+	}
+	return nil, statusErr
+}
+
+// delay is a random duration up to the exponential backoff of the attempt, or Retry-After when the server sent it
+func (c *client) delay(attempt int, err error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.retryAfter > 0 {
+		if statusErr.retryAfter > c.maxBackoff {
+			return c.maxBackoff
+		}
+		return statusErr.retryAfter
+	}
+
+	backoff := c.backoff << uint(attempt)
+	if backoff <= 0 || backoff > c.maxBackoff {
+		backoff = c.maxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+func (c *client) close() {
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+	}
+	c.httpClient.CloseIdleConnections()
+}
+
+func isTransient(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Temporary()
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return strings.Contains(err.Error(), "connection refused") || strings.Contains(err.Error(), "connection reset by peer") ||
+		strings.Contains(err.Error(), "EOF")
+}
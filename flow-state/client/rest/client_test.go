@@ -0,0 +1,78 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/project-flogo/core/support/log"
+	"github.com/project-flogo/flow/state"
+)
+
+func newTestClient(t *testing.T, settings map[string]interface{}) *client {
+	s, err := newRecorderSettings(settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := newClient(s, log.RootLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestClientRetry(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get(asyncCallingHeader) != "true" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	recorder := &StateRecorder{logger: log.RootLogger()}
+	err := recorder.init(map[string]interface{}{"host": server.URL, "authHeader": "Bearer token", "async": true, "retryBackoff": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recorder.Stop()
+
+	if err := recorder.RecordStep(&state.Step{Id: 1, FlowId: "f1"}); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&calls) != 3 {
+		t.Fatalf("expected 3 calls, got %d", calls)
+	}
+}
+
+func TestClientStatusError(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		http.Error(w, "invalid step", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	c := newTestClient(t, map[string]interface{}{"host": server.URL, "retryBackoff": 1})
+	defer c.close()
+
+	_, err := c.post("/v1/instances/steps", []byte("{}"))
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		t.Fatalf("expected StatusError, got %v", err)
+	}
+	if statusErr.StatusCode != http.StatusBadRequest || statusErr.Message != "invalid step" {
+		t.Fatalf("unexpected error %v", statusErr)
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("client errors should not be retried, got %d calls", calls)
+	}
+}
@@ -0,0 +1,186 @@
+package rest
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/project-flogo/core/support/log"
+	"github.com/project-flogo/services/flow-state/spool"
+	"github.com/project-flogo/services/flow-state/store/batch"
+)
+
+var journalKinds = map[batch.Kind]spool.Kind{
+	batch.Start:    spool.Start,
+	batch.Step:     spool.Step,
+	batch.Snapshot: spool.Snapshot,
+	batch.End:      spool.End,
+}
+
+var journalPaths = map[spool.Kind]string{
+	spool.Start:    "/v1/instances/start",
+	spool.Step:     "/v1/instances/steps",
+	spool.Snapshot: "/v1/instances/snapshot",
+	spool.End:      "/v1/instances/end",
+}
+
+// BacklogStatus reports the records waiting in the local journal for the state service
+type BacklogStatus struct {
+	Records   int64  `json:"records"`
+	Bytes     int64  `json:"bytes"`
+	MaxBytes  int64  `json:"maxBytes"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// journal keeps the records the state service could not receive on disk and
+// drains them to the service in the order they were journaled
+type journal struct {
+	spool  *spool.Spool
+	client *client
+	logger log.Logger
+
+	maxBytes int64
+	records  int64
+
+	mu      sync.Mutex
+	lastErr string
+	hook    func(BacklogStatus)
+
+	done    chan struct{}
+	drained chan struct{}
+}
+
+func newJournal(s *recorderSettings, c *client, logger log.Logger) (*journal, error) {
+	cfg, err := spool.NewConfig(map[string]interface{}{"dir": s.JournalDir, "maxSize": s.JournalMaxSize})
+	if err != nil {
+		return nil, fmt.Errorf("StateRecorder: %s", err.Error())
+	}
+	sp, err := spool.Open(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("StateRecorder: unable to open journal, %s", err.Error())
+	}
+
+	j := &journal{
+		spool:    sp,
+		client:   c,
+		logger:   logger,
+		maxBytes: cfg.MaxSize,
+		records:  int64(sp.Recovered()),
+		done:     make(chan struct{}),
+		drained:  make(chan struct{}),
+	}
+	if j.records > 0 {
+		logger.Infof("StateRecorder: %d journaled records will be sent to the state service", j.records)
+	}
+	go j.drain()
+	return j, nil
+}
+
+// send delivers records with post, they are journaled instead when older records
+// are still waiting or the state service can not be reached
+func (j *journal) send(records []*batch.Record, post func() error) error {
+	if j.spool.Size() == 0 {
+		err := post()
+		if err == nil || !isTransient(err) {
+			return err
+		}
+		j.setError(err)
+		j.logger.Warnf("StateRecorder: state service unavailable, journaling %d records, %s", len(records), err.Error())
+	}
+
+	for _, record := range records {
+		if err := j.spool.Append(journalKinds[record.Type], record.Data); err != nil {
+			return fmt.Errorf("StateRecorder: unable to journal %s record, %s", record.Type, err.Error())
+		}
+		atomic.AddInt64(&j.records, 1)
+	}
+	j.notify()
+	return nil
+}
+
+func (j *journal) drain() {
+	defer close(j.drained)
+	for {
+		entry, err := j.spool.Next()
+		if err != nil {
+			if err != spool.ErrClosed {
+				j.logger.Errorf("StateRecorder: unable to read journal, %s", err.Error())
+			}
+			return
+		}
+
+		uri := j.client.host + journalPaths[entry.Kind]
+		for attempt := 0; ; attempt++ {
+			_, err = j.client.do(uri, entry.Data)
+			if err == nil || !isTransient(err) {
+				break
+			}
+			j.setError(err)
+			select {
+			case <-j.done:
+				// the entry is not acknowledged and is sent again after a restart
+				return
+			case <-time.After(j.client.delay(attempt, err)):
+			}
+		}
+		if err != nil {
+			j.logger.Errorf("StateRecorder: dropping journaled %s record, %s", entry.Kind, err.Error())
+		}
+
+		j.spool.Ack(entry)
+		atomic.AddInt64(&j.records, -1)
+		j.setError(nil)
+		j.notify()
+	}
+}
+
+func (j *journal) status() BacklogStatus {
+	j.mu.Lock()
+	lastErr := j.lastErr
+	j.mu.Unlock()
+	return BacklogStatus{
+		Records:   atomic.LoadInt64(&j.records),
+		Bytes:     j.spool.Size(),
+		MaxBytes:  j.maxBytes,
+		LastError: lastErr,
+	}
+}
+
+func (j *journal) setHook(hook func(BacklogStatus)) {
+	j.mu.Lock()
+	j.hook = hook
+	j.mu.Unlock()
+}
+
+func (j *journal) setError(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err != nil {
+		j.lastErr = err.Error()
+	} else {
+		j.lastErr = ""
+	}
+}
+
+func (j *journal) notify() {
+	j.mu.Lock()
+	hook := j.hook
+	j.mu.Unlock()
+	if hook != nil {
+		hook(j.status())
+	}
+}
+
+// close stops draining, records that were not sent stay in the journal for the next start
+func (j *journal) close() error {
+	select {
+	case <-j.done:
+		return nil
+	default:
+		close(j.done)
+	}
+	err := j.spool.Close()
+	<-j.drained
+	return err
+}
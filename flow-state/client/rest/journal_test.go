@@ -0,0 +1,86 @@
+package rest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/project-flogo/core/support/log"
+	"github.com/project-flogo/flow/state"
+)
+
+func TestJournalDrainsInOrder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var up int32
+	var mu sync.Mutex
+	var ids []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&up) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		step := &state.Step{}
+		if json.NewDecoder(r.Body).Decode(step) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		ids = append(ids, step.Id)
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	settings := map[string]interface{}{"host": server.URL, "maxRetries": 0, "retryBackoff": 1, "maxBackoff": 10, "journalDir": dir}
+	recorder := &StateRecorder{logger: log.RootLogger()}
+	if err := recorder.init(settings); err != nil {
+		t.Fatal(err)
+	}
+	var hooked int32
+	recorder.SetBacklogHook(func(BacklogStatus) { atomic.AddInt32(&hooked, 1) })
+
+	for i := 1; i <= 3; i++ {
+		if err := recorder.RecordStep(&state.Step{Id: i, FlowId: "f1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if backlog := recorder.Backlog(); backlog.Records != 3 || backlog.Bytes == 0 || backlog.LastError == "" {
+		t.Fatalf("unexpected backlog %+v", backlog)
+	}
+	_ = recorder.Stop()
+
+	// the journal survives a restart and is drained once the service is reachable
+	atomic.StoreInt32(&up, 1)
+	recorder = &StateRecorder{logger: log.RootLogger()}
+	if err := recorder.init(settings); err != nil {
+		t.Fatal(err)
+	}
+	defer recorder.Stop()
+	if err := recorder.RecordStep(&state.Step{Id: 4, FlowId: "f1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for recorder.Backlog().Records > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ids) != 4 || ids[0] != 1 || ids[1] != 2 || ids[2] != 3 || ids[3] != 4 {
+		t.Fatalf("unexpected delivery order %v", ids)
+	}
+	if atomic.LoadInt32(&hooked) == 0 {
+		t.Fatal("expected backlog hook to be called")
+	}
+}
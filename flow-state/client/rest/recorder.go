@@ -1,17 +1,12 @@
 package rest
 
 import (
-	"bytes"
-	"encoding/json"
-	"fmt"
-	"net/http"
-	"strconv"
-	"strings"
-
-	"github.com/project-flogo/core/data/coerce"
+	"time"
+
 	"github.com/project-flogo/core/support/log"
 	"github.com/project-flogo/core/support/service"
 	"github.com/project-flogo/flow/state"
+	"github.com/project-flogo/services/flow-state/store/batch"
 )
 
 func init() {
@@ -36,8 +31,10 @@ func (s StateRecorderFactory) NewService(config *service.Config) (service.Servic
 // StateRecorder is an implementation of StateRecorder service
 // that can access flows via URI
 type StateRecorder struct {
-	host   string
-	logger log.Logger
+	client  *client
+	logger  log.Logger
+	batcher *batcher
+	journal *journal
 }
 
 func (sr *StateRecorder) Name() string {
@@ -52,164 +49,107 @@ func (sr *StateRecorder) Start() error {
 
 // Stop implements util.Managed.Stop()
 func (sr *StateRecorder) Stop() error {
-	// no-op
-	return nil
+	if sr.batcher != nil {
+		sr.batcher.close()
+	}
+	var err error
+	if sr.journal != nil {
+		err = sr.journal.close()
+	}
+	sr.client.close()
+	return err
 }
 
 // Init implements services.StateRecorderService.Init()
 func (sr *StateRecorder) init(settings map[string]interface{}) error {
 
-	sHost, set := settings["host"]
-	if !set {
-		return fmt.Errorf("StateRecorder: required setting 'host' not set")
-	}
-	host, err := coerce.ToString(sHost)
+	s, err := newRecorderSettings(settings)
 	if err != nil {
-		return fmt.Errorf("StateRecorder: invalid host '%v'", sHost)
+		return err
 	}
 
-	sPort, set := settings["port"]
-	if !set {
-		return fmt.Errorf("StateRecorder: required setting 'port' not set")
-	}
-	port, err := coerce.ToInt(sPort)
+	sr.client, err = newClient(s, sr.logger)
 	if err != nil {
-		return fmt.Errorf("StateRecorder: invalid port '%v'", sPort)
+		return err
 	}
+	sr.logger.Debugf("StateRecorder: StateRecorder Server = %s", sr.client.host)
 
-	if strings.Index(host, "http") != 0 {
-		sr.host = "http://" + host + ":" + strconv.Itoa(port)
-	} else {
-		sr.host = host + ":" + strconv.Itoa(port)
+	if s.JournalDir != "" {
+		sr.journal, err = newJournal(s, sr.client, sr.logger)
+		if err != nil {
+			return err
+		}
+		sr.logger.Debugf("StateRecorder: journaling undelivered records in %s", s.JournalDir)
 	}
 
-	sr.logger.Debugf("StateRecorder: StateRecorder Server = %s", sr.host)
+	// batching is enabled when more than one record may be sent per request
+	if s.BatchSize > 1 {
+		sr.batcher = newBatcher(sr.client, sr.journal, s.BatchSize, s.BatchBytes, time.Duration(s.BatchInterval)*time.Millisecond, sr.logger)
+		sr.logger.Debugf("StateRecorder: batching up to %d records", s.BatchSize)
+	}
 	return nil
 }
 
 func (sr *StateRecorder) RecordStart(state *state.FlowState) error {
-
-	uri := sr.host + "/v1/instances/start"
-
-	sr.logger.Debugf("POST record start: %s\n", uri)
-
-	jsonReq, _ := json.Marshal(state)
-
-	sr.logger.Debug("JSON: ", string(jsonReq))
-
-	req, err := http.NewRequest("POST", uri, bytes.NewBuffer(jsonReq))
-	if err != nil {
-		return err
+	if sr.batcher != nil {
+		return sr.batcher.add(batch.Start, state)
 	}
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	sr.logger.Debug("response Status:", resp.Status)
-
-	if resp.StatusCode >= 300 {
-		//todo return error
-	}
-	return nil
+	return sr.record(batch.Start, "/v1/instances/start", state)
 }
 
 // RecordSnapshot implements instance.StateRecorder.RecordSnapshot
 func (sr *StateRecorder) RecordSnapshot(snapshot *state.Snapshot) error {
-
-	uri := sr.host + "/v1/instances/snapshot"
-
-	sr.logger.Debugf("POST Snapshot: %s\n", uri)
-
-	jsonReq, _ := json.Marshal(snapshot)
-
-	sr.logger.Debug("JSON: ", string(jsonReq))
-
-	req, err := http.NewRequest("POST", uri, bytes.NewBuffer(jsonReq))
-	if err != nil {
-		return err
+	if sr.batcher != nil {
+		return sr.batcher.add(batch.Snapshot, snapshot)
 	}
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	sr.logger.Debug("response Status:", resp.Status)
-
-	if resp.StatusCode >= 300 {
-		//todo return error
-	}
-
-	return nil
+	return sr.record(batch.Snapshot, "/v1/instances/snapshot", snapshot)
 }
 
 // RecordStep implements instance.StateRecorder.RecordStep
 func (sr *StateRecorder) RecordStep(step *state.Step) error {
-
-	uri := sr.host + "/v1/instances/steps"
-
-	sr.logger.Debugf("POST Step: %s\n", uri)
-
-	jsonReq, _ := json.Marshal(step)
-
-	sr.logger.Debug("JSON: ", string(jsonReq))
-
-	req, err := http.NewRequest("POST", uri, bytes.NewBuffer(jsonReq))
-	if err != nil {
-		return err
+	if sr.batcher != nil {
+		return sr.batcher.add(batch.Step, step)
 	}
-	req.Header.Set("Content-Type", "application/json")
+	return sr.record(batch.Step, "/v1/instances/steps", step)
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
+func (sr *StateRecorder) RecordDone(state *state.FlowState) error {
+	if sr.batcher != nil {
+		return sr.batcher.add(batch.End, state)
 	}
-	defer resp.Body.Close()
-
-	sr.logger.Debug("response Status:", resp.Status)
+	return sr.record(batch.End, "/v1/instances/end", state)
+}
 
-	if resp.StatusCode >= 300 {
-		//todo return error
+// Backlog reports the records waiting in the local journal, it is empty when journaling is disabled
+func (sr *StateRecorder) Backlog() BacklogStatus {
+	if sr.journal == nil {
+		return BacklogStatus{}
 	}
-
-	return nil
+	return sr.journal.status()
 }
 
-func (sr *StateRecorder) RecordDone(state *state.FlowState) error {
-	uri := sr.host + "/v1/instances/end"
-
-	sr.logger.Debugf("POST record start: %s\n", uri)
-
-	jsonReq, _ := json.Marshal(state)
-
-	sr.logger.Debug("JSON: ", string(jsonReq))
-
-	req, err := http.NewRequest("POST", uri, bytes.NewBuffer(jsonReq))
-	if err != nil {
-		return err
+// SetBacklogHook registers a function called whenever the journal backlog changes
+func (sr *StateRecorder) SetBacklogHook(hook func(BacklogStatus)) {
+	if sr.journal != nil {
+		sr.journal.setHook(hook)
 	}
-	req.Header.Set("Content-Type", "application/json")
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+func (sr *StateRecorder) record(kind batch.Kind, path string, v interface{}) error {
+	record, err := batch.NewRecord(kind, v)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	sr.logger.Debug("response Status:", resp.Status)
+	sr.logger.Debugf("POST %s", path)
+	sr.logger.Debug("JSON: ", string(record.Data))
 
-	if resp.StatusCode >= 300 {
-		//todo return error
+	post := func() error {
+		_, err := sr.client.post(path, record.Data)
+		return err
 	}
-	return nil
+	if sr.journal != nil {
+		return sr.journal.send([]*batch.Record{record}, post)
+	}
+	return post()
 }
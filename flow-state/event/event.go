@@ -1,9 +1,11 @@
 package event
 
 import (
+	"sync/atomic"
+	"time"
+
 	coreevent "github.com/project-flogo/core/engine/event"
 	"github.com/project-flogo/flow/state"
-	"time"
 )
 
 const EventType = "streamingStepEvent"
@@ -23,7 +25,15 @@ func (re *stepEvent) Time() time.Time {
 	return re.time
 }
 
+// Steps is the hub of the step stream, it receives the events once StartStepListener is called
+var Steps = NewHub(DefaultBufferSize, DefaultRetention, DefaultMaxInstances)
+
+var streaming int32
+
 func PostStepEvent(step *state.Step) {
+	if atomic.LoadInt32(&streaming) == 1 {
+		Steps.PublishStep(step)
+	}
 	if coreevent.HasListener(EventType) {
 		fe := &stepEvent{
 			time: time.Now(),
@@ -32,3 +42,17 @@ func PostStepEvent(step *state.Step) {
 		coreevent.Post(EventType, fe)
 	}
 }
+
+// PostStartEvent lets the step stream filter the steps of the flow instance by app, flow and status
+func PostStartEvent(flowState *state.FlowState) {
+	if atomic.LoadInt32(&streaming) == 1 {
+		Steps.PublishStart(flowState)
+	}
+}
+
+// PostEndEvent lets the step stream filter the steps of the flow instance by its final status
+func PostEndEvent(flowState *state.FlowState) {
+	if atomic.LoadInt32(&streaming) == 1 {
+		Steps.PublishEnd(flowState)
+	}
+}
@@ -1,14 +1,16 @@
 package event
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/julienschmidt/httprouter"
-	"github.com/project-flogo/core/engine/event"
 	"github.com/project-flogo/core/support/log"
 	"github.com/project-flogo/flow/state"
 )
@@ -21,6 +23,9 @@ const (
 
 	// PingPeriod Send pings to peer with this period. Must be less than PongWait.
 	pingPeriod = 10 * time.Second
+
+	// writeWait is the time allowed to write a message to the peer
+	writeWait = 10 * time.Second
 )
 
 var upgrader = websocket.Upgrader{
@@ -31,40 +36,70 @@ var upgrader = websocket.Upgrader{
 	WriteBufferSize: 1024,
 }
 
-var stepEventQueue = make(chan *event.Context, 10)
-var stepChan = make(chan *state.Step)
+// StartStepListener starts publishing the recorded steps to the Steps hub
+func StartStepListener() {
+	atomic.StoreInt32(&streaming, 1)
+}
 
-type recorderEvent struct {
+// message is a message of the client, {"type": "subscribe", "filter": {...}} replaces the filter of the stream
+type message struct {
+	Type   string  `json:"type"`
+	Filter *Filter `json:"filter"`
 }
 
-func (ls *recorderEvent) HandleEvent(evt *event.Context) error {
-	stepEventQueue <- evt
-	return nil
+// streamedStep is a step sent to the client with its sequence number
+type streamedStep struct {
+	Seq uint64 `json:"seq"`
+	*state.Step
 }
 
-func StartStepListener() {
-	err := event.RegisterListener("state-recorder-step-listener", &recorderEvent{}, []string{EventType})
-	if err != nil {
-		recorderLog.Errorf("Failed to enable state-recorder-step-listener due to error - '%v'", err)
-	}
-	go handleRecordEvent()
+// lagged tells a client how many steps it missed, because its buffer was full with overflow=lag or because
+// the steps after its since parameter are no longer retained
+type lagged struct {
+	Type   string `json:"type"`
+	Missed uint64 `json:"missed"`
 }
 
-func handleRecordEvent() {
-	for {
-		select {
-		case stepE := <-stepEventQueue:
-			switch t := stepE.GetEvent().(type) {
-			case *stepEvent:
-				stepChan <- t.step
-			}
-		}
+// FilterCheck restricts the filter of a step stream to what the caller of the request may receive, it returns the
+// HTTP status to reply with when the caller may not stream the steps the filter selects
+type FilterCheck func(r *http.Request, filter *Filter) (int, error)
+
+// HandleStepEvent streams the recorded steps over a websocket. The steps are filtered by the app, version, flow,
+// flowinstanceid and status query parameters, or by the filter of a subscribe message. A client that does not keep up
+// is disconnected, unless it connects with overflow=lag to skip the steps that do not fit in its buffer.
+// Every step has a seq number, a client reconnecting with since=<seq> first receives the retained steps after it.
+func HandleStepEvent(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	streamSteps(w, r, nil)
+}
+
+// StepStreamHandler is HandleStepEvent with the filter of the query and the filters of the subscribe messages
+// checked by check. A stream whose subscribe message is rejected is closed with a policy violation.
+func StepStreamHandler(check FilterCheck) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		streamSteps(w, r, check)
 	}
 }
 
-// Status is a basic health check for the server to determine if it is up
-func HandleStepEvent(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+func streamSteps(w http.ResponseWriter, r *http.Request, check FilterCheck) {
 	recorderLog.Debugf("Received step event websocket request: %+v", r)
+	query := r.URL.Query()
+	filter := FilterFromQuery(query)
+	if check != nil {
+		if code, err := check(r, filter); err != nil {
+			http.Error(w, err.Error(), code)
+			return
+		}
+	}
+	var since uint64
+	resume := query.Get("since") != ""
+	if resume {
+		var err error
+		if since, err = strconv.ParseUint(query.Get("since"), 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid since sequence number [%s]", query.Get("since")), http.StatusBadRequest)
+			return
+		}
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		recorderLog.Errorf("websocket upgrade failed: %s", err.Error())
@@ -75,8 +110,17 @@ func HandleStepEvent(w http.ResponseWriter, r *http.Request, _ httprouter.Params
 			recorderLog.Errorf("close websocket error: %v", err)
 		}
 	}()
-	// Send ping
-	ticker := time.NewTicker(pingPeriod)
+
+	var sub *Subscriber
+	var replay []*Event
+	var missed uint64
+	if resume {
+		sub, replay, missed = Steps.SubscribeSince(filter, Overflow(query.Get("overflow")), since)
+	} else {
+		sub = Steps.Subscribe(filter, Overflow(query.Get("overflow")))
+	}
+	defer sub.Close()
+
 	conn.SetPingHandler(func(string) error {
 		if recorderLog.DebugEnabled() {
 			recorderLog.Debug("Ping Handler")
@@ -95,17 +139,91 @@ func HandleStepEvent(w http.ResponseWriter, r *http.Request, _ httprouter.Params
 		return nil
 	})
 
+	// the client messages are read in their own goroutine, which also runs the ping and pong handlers
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadDeadline(time.Now().Add(pongWait))
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			msg := &message{}
+			if err := json.Unmarshal(data, msg); err != nil || msg.Type != "subscribe" {
+				recorderLog.Warnf("Ignored step stream message: %s", string(data))
+				continue
+			}
+			if check != nil {
+				if msg.Filter == nil {
+					msg.Filter = &Filter{}
+				}
+				if _, err := check(r, msg.Filter); err != nil {
+					recorderLog.Debugf("Rejected step stream filter: %s", err.Error())
+					conn.WriteControl(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()), time.Now().Add(writeWait))
+					return
+				}
+			}
+			sub.SetFilter(msg.Filter)
+		}
+	}()
+
+	write := func(e *Event, missed uint64) bool {
+		var err error
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if missed > 0 {
+			err = conn.WriteJSON(&lagged{Type: "lagged", Missed: missed})
+		}
+		if err == nil {
+			err = conn.WriteJSON(&streamedStep{Seq: e.Seq, Step: e.Step})
+		}
+		if err != nil {
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseInternalServerErr, fmt.Sprintf("Write json data error:%s", err.Error())),
+				time.Now().Add(writeWait))
+			recorderLog.Errorf("error writing message: %s", err.Error())
+			return false
+		}
+		return true
+	}
+
+	for _, e := range replay {
+		if e.Kind != KindStep {
+			continue
+		}
+		if !write(e, missed) {
+			return
+		}
+		missed = 0
+	}
+	if missed > 0 {
+		// no retained step is selected, the client still learns it missed steps
+		conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := conn.WriteJSON(&lagged{Type: "lagged", Missed: missed}); err != nil {
+			recorderLog.Errorf("error writing message: %s", err.Error())
+			return
+		}
+	}
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
 	for {
 		select {
-		case step := <-stepChan:
-			err = conn.WriteJSON(step)
-			if err != nil {
-				conn.WriteControl(websocket.CloseMessage,
-					websocket.FormatCloseMessage(websocket.CloseInternalServerErr, fmt.Sprintf("Write json data error:%s", err.Error())),
-					time.Now())
-				recorderLog.Error("error writing message: %s", err.Error())
+		case e := <-sub.Events():
+			// the websocket only streams the steps, the start and end of the flow instances are skipped
+			if e.Kind != KindStep {
+				continue
+			}
+			if !write(e, sub.Missed()) {
 				return
 			}
+		case <-sub.Done():
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "steps were not read fast enough"), time.Now().Add(writeWait))
+			return
+		case <-closed:
+			return
 		case <-ticker.C:
 			recorderLog.Debugf("Sending heartbeat Ping to client")
 			// NOTE: Control frame writes do not need to be synchronized
@@ -115,7 +233,7 @@ func HandleStepEvent(w http.ResponseWriter, r *http.Request, _ httprouter.Params
 				} else {
 					// for any other error we need to close tunnel
 					recorderLog.Warnf("Sent heartbeat Ping error: %v", err)
-					conn.Close()
+					return
 				}
 			}
 		}
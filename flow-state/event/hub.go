@@ -0,0 +1,390 @@
+package event
+
+import (
+	"container/list"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/project-flogo/flow/model"
+	"github.com/project-flogo/flow/state"
+)
+
+const (
+	// DefaultBufferSize is the number of events buffered for a subscriber
+	DefaultBufferSize = 256
+	// DefaultRetention is the number of recent events kept to be replayed to the subscribers that resume
+	DefaultRetention = 1024
+	// DefaultMaxInstances is the number of flow instances whose app, flow and status are kept to filter their steps
+	DefaultMaxInstances = 10000
+)
+
+// Overflow is what happens to a subscriber whose buffer is full when an event is published
+type Overflow string
+
+const (
+	// Drop closes the subscriber, the client has to reconnect
+	Drop Overflow = "drop"
+	// Lag discards the events that do not fit in the buffer and counts them, see Subscriber.Missed
+	Lag Overflow = "lag"
+)
+
+// Filter selects the events of a subscriber, empty fields match every event
+type Filter struct {
+	// UserName is the owner of the flow instances, it is set by the server from the caller identity and a
+	// filter with a user does not match the events of the instances whose owner the hub does not know
+	UserName       string `json:"-"`
+	AppName        string `json:"app,omitempty"`
+	AppVersion     string `json:"version,omitempty"`
+	FlowName       string `json:"flow,omitempty"`
+	FlowInstanceId string `json:"flowInstanceId,omitempty"`
+	// Status is the status of the flow instance, such as Active, Completed or Failed
+	Status string `json:"status,omitempty"`
+	// Kind selects the steps, the starts or the ends only, it is set by the server
+	Kind Kind `json:"-"`
+}
+
+// FilterFromQuery reads the filter from the app, version, flow, flowinstanceid and status query parameters
+func FilterFromQuery(query url.Values) *Filter {
+	return &Filter{
+		AppName:        query.Get("app"),
+		AppVersion:     query.Get("version"),
+		FlowName:       query.Get("flow"),
+		FlowInstanceId: query.Get("flowinstanceid"),
+		Status:         query.Get("status"),
+	}
+}
+
+func (f *Filter) matches(e *Event) bool {
+	if f == nil {
+		return true
+	}
+	return (f.Kind == "" || f.Kind == e.Kind) &&
+		(f.UserName == "" || f.UserName == e.UserName) &&
+		(f.AppName == "" || f.AppName == e.AppName) &&
+		(f.AppVersion == "" || f.AppVersion == e.AppVersion) &&
+		(f.FlowName == "" || f.FlowName == e.FlowName) &&
+		(f.FlowInstanceId == "" || f.FlowInstanceId == e.FlowInstanceId) &&
+		(f.Status == "" || strings.EqualFold(f.Status, e.Status))
+}
+
+// Kind is the kind of an event, a step, or the start or end of a flow instance
+type Kind string
+
+const (
+	KindStep  Kind = "step"
+	KindStart Kind = "start"
+	KindEnd   Kind = "end"
+)
+
+// Event is a step, start or end of a flow instance, with the app, flow and status of the instance when the hub knows it
+type Event struct {
+	// Seq is the sequence number of the event, it increases by one with every published event
+	Seq            uint64
+	Kind           Kind
+	FlowInstanceId string
+	UserName       string
+	AppName        string
+	AppVersion     string
+	FlowName       string
+	Status         string
+	// Step is set for the step events
+	Step *state.Step
+	// FlowState is set for the start and end events
+	FlowState *state.FlowState
+}
+
+// instance is what the hub knows of a flow instance from its start and end
+type instance struct {
+	id         string
+	userName   string
+	appName    string
+	appVersion string
+	flowName   string
+	status     string
+}
+
+// Hub fans the published steps out to its subscribers. Publishing never blocks, every subscriber has its own
+// bounded buffer and a subscriber that does not keep up is dropped or lags, as set by its Overflow.
+// The most recent events are retained so a subscriber can resume after the last event it received.
+type Hub struct {
+	bufferSize   int
+	maxInstances int
+
+	lock        sync.RWMutex
+	subscribers map[*Subscriber]struct{}
+	seq         uint64
+	// retained is a ring of the most recent events, the event of sequence number n is at n % len(retained)
+	retained []*Event
+
+	instanceLock sync.Mutex
+	instances    map[string]*list.Element
+	// order lists the instances from the least recently started or ended
+	order *list.List
+}
+
+// NewHub creates a hub, bufferSize, retention and maxInstances default to DefaultBufferSize, DefaultRetention
+// and DefaultMaxInstances
+func NewHub(bufferSize, retention, maxInstances int) *Hub {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	if maxInstances <= 0 {
+		maxInstances = DefaultMaxInstances
+	}
+	return &Hub{
+		bufferSize:   bufferSize,
+		maxInstances: maxInstances,
+		subscribers:  make(map[*Subscriber]struct{}),
+		retained:     make([]*Event, retention),
+		instances:    make(map[string]*list.Element),
+		order:        list.New(),
+	}
+}
+
+// Subscribe adds a subscriber receiving the events selected by the filter, nil selects every event
+func (h *Hub) Subscribe(filter *Filter, overflow Overflow) *Subscriber {
+	return h.SubscribeWithBuffer(filter, overflow, h.bufferSize)
+}
+
+// SubscribeWithBuffer adds a subscriber like Subscribe with a buffer of bufferSize events instead of the buffer
+// size of the hub, for consumers such as the event sinks which may wait on a remote service
+func (h *Hub) SubscribeWithBuffer(filter *Filter, overflow Overflow, bufferSize int) *Subscriber {
+	if bufferSize <= 0 {
+		bufferSize = h.bufferSize
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.subscribe(filter, overflow, bufferSize)
+}
+
+// SubscribeSince adds a subscriber like Subscribe and returns the retained events published after the since
+// sequence number that the filter selects, they come before the events of the subscriber. missed is the number
+// of events published after since which are no longer retained. When since is ahead of the hub, which restarted,
+// every retained event is returned.
+func (h *Hub) SubscribeSince(filter *Filter, overflow Overflow, since uint64) (s *Subscriber, replay []*Event, missed uint64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	s = h.subscribe(filter, overflow, h.bufferSize)
+
+	oldest := uint64(1)
+	if h.seq > uint64(len(h.retained)) {
+		oldest = h.seq - uint64(len(h.retained)) + 1
+	}
+	switch {
+	case since > h.seq:
+		since = oldest - 1
+	case since+1 < oldest:
+		missed = oldest - since - 1
+		since = oldest - 1
+	}
+	for seq := since + 1; seq <= h.seq; seq++ {
+		if e := h.retained[seq%uint64(len(h.retained))]; s.Filter().matches(e) {
+			replay = append(replay, e)
+		}
+	}
+	return s, replay, missed
+}
+
+func (h *Hub) subscribe(filter *Filter, overflow Overflow, bufferSize int) *Subscriber {
+	if overflow != Lag {
+		overflow = Drop
+	}
+	s := &Subscriber{hub: h, overflow: overflow, events: make(chan *Event, bufferSize), done: make(chan struct{})}
+	s.SetFilter(filter)
+	h.subscribers[s] = struct{}{}
+	return s
+}
+
+// Subscribers is the number of subscribers
+func (h *Hub) Subscribers() int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return len(h.subscribers)
+}
+
+func (h *Hub) remove(s *Subscriber) {
+	h.lock.Lock()
+	delete(h.subscribers, s)
+	h.lock.Unlock()
+}
+
+// Seq is the sequence number of the last published event
+func (h *Hub) Seq() uint64 {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.seq
+}
+
+// PublishStep sends the step to the subscribers it matches
+func (h *Hub) PublishStep(step *state.Step) {
+	e := h.event(KindStep, step.FlowId)
+	e.Step = step
+	if status := stepStatus(step); status != "" {
+		e.Status = status
+	}
+	h.publish(e)
+}
+
+// PublishStart records the app, flow and status of the flow instance, to filter its steps, and sends the start
+// to the subscribers it matches
+func (h *Hub) PublishStart(flowState *state.FlowState) {
+	h.record(flowState)
+	e := h.event(KindStart, flowState.FlowInstanceId)
+	e.FlowState = flowState
+	h.publish(e)
+}
+
+// PublishEnd records the final status of the flow instance and sends the end to the subscribers it matches
+func (h *Hub) PublishEnd(flowState *state.FlowState) {
+	h.record(flowState)
+	e := h.event(KindEnd, flowState.FlowInstanceId)
+	e.FlowState = flowState
+	h.publish(e)
+}
+
+// event creates an event of the flow instance with the owner, app, flow and status the hub knows
+func (h *Hub) event(kind Kind, flowInstanceId string) *Event {
+	e := &Event{Kind: kind, FlowInstanceId: flowInstanceId}
+	if inst := h.instance(flowInstanceId); inst != nil {
+		e.UserName, e.AppName, e.AppVersion, e.FlowName, e.Status = inst.userName, inst.appName, inst.appVersion, inst.flowName, inst.status
+	}
+	return e
+}
+
+// publish numbers and retains the event, then sends it to the subscribers in the order of the sequence numbers,
+// sending never blocks so the lock is held until every subscriber has the event
+func (h *Hub) publish(e *Event) {
+	h.lock.Lock()
+	h.seq++
+	e.Seq = h.seq
+	h.retained[e.Seq%uint64(len(h.retained))] = e
+
+	var dropped []*Subscriber
+	for s := range h.subscribers {
+		if !s.Filter().matches(e) {
+			continue
+		}
+		select {
+		case s.events <- e:
+		default:
+			if s.overflow == Lag {
+				atomic.AddUint64(&s.missed, 1)
+			} else {
+				dropped = append(dropped, s)
+			}
+		}
+	}
+	for _, s := range dropped {
+		delete(h.subscribers, s)
+		close(s.done)
+	}
+	h.lock.Unlock()
+
+	if len(dropped) > 0 {
+		recorderLog.Warnf("Dropped %d step stream subscribers that did not keep up", len(dropped))
+	}
+}
+
+func (h *Hub) record(flowState *state.FlowState) {
+	h.instanceLock.Lock()
+	defer h.instanceLock.Unlock()
+
+	if elem, ok := h.instances[flowState.FlowInstanceId]; ok {
+		inst := elem.Value.(*instance)
+		if flowState.FlowStats != "" {
+			inst.status = flowState.FlowStats
+		}
+		h.order.MoveToBack(elem)
+		return
+	}
+	inst := &instance{
+		id:         flowState.FlowInstanceId,
+		userName:   flowState.UserId,
+		appName:    flowState.AppName,
+		appVersion: flowState.AppVersion,
+		flowName:   flowState.FlowName,
+		status:     flowState.FlowStats,
+	}
+	h.instances[inst.id] = h.order.PushBack(inst)
+	for h.order.Len() > h.maxInstances {
+		oldest := h.order.Front()
+		h.order.Remove(oldest)
+		delete(h.instances, oldest.Value.(*instance).id)
+	}
+}
+
+func (h *Hub) instance(flowInstanceId string) *instance {
+	h.instanceLock.Lock()
+	defer h.instanceLock.Unlock()
+	if elem, ok := h.instances[flowInstanceId]; ok {
+		inst := *elem.Value.(*instance)
+		return &inst
+	}
+	return nil
+}
+
+// stepStatus is the status of the flow instance changed by the step, empty when the step does not change it
+func stepStatus(step *state.Step) string {
+	if change, ok := step.FlowChanges[0]; ok && change != nil {
+		switch model.FlowStatus(change.Status) {
+		case model.FlowStatusActive:
+			return "Active"
+		case model.FlowStatusCompleted:
+			return "Completed"
+		case model.FlowStatusCancelled:
+			return "Cancelled"
+		case model.FlowStatusFailed:
+			return "Failed"
+		}
+	}
+	return ""
+}
+
+// Subscriber receives the events of the hub selected by its filter
+type Subscriber struct {
+	// missed is first to be 64-bit aligned for atomic operations
+	missed   uint64
+	hub      *Hub
+	overflow Overflow
+	filter   atomic.Value
+	events   chan *Event
+	done     chan struct{}
+}
+
+// Events delivers the events, in the order they were published
+func (s *Subscriber) Events() <-chan *Event {
+	return s.events
+}
+
+// Done is closed when the hub drops the subscriber because its buffer is full
+func (s *Subscriber) Done() <-chan struct{} {
+	return s.done
+}
+
+// Missed returns the number of events discarded since it was last called, when the subscriber lags
+func (s *Subscriber) Missed() uint64 {
+	return atomic.SwapUint64(&s.missed, 0)
+}
+
+// Filter is the filter of the subscriber
+func (s *Subscriber) Filter() *Filter {
+	return s.filter.Load().(*Filter)
+}
+
+// SetFilter replaces the filter of the subscriber, nil selects every event
+func (s *Subscriber) SetFilter(filter *Filter) {
+	if filter == nil {
+		filter = &Filter{}
+	}
+	s.filter.Store(filter)
+}
+
+// Close removes the subscriber from the hub
+func (s *Subscriber) Close() {
+	s.hub.remove(s)
+}
@@ -0,0 +1,302 @@
+package event
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-flogo/flow/model"
+	"github.com/project-flogo/flow/state"
+	"github.com/project-flogo/flow/state/change"
+)
+
+func receive(t *testing.T, s *Subscriber) *Event {
+	t.Helper()
+	select {
+	case e := <-s.Events():
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("expected an event")
+	}
+	return nil
+}
+
+func expectNone(t *testing.T, s *Subscriber) {
+	t.Helper()
+	select {
+	case e := <-s.Events():
+		t.Fatalf("expected no event, got step %d of %s", e.Step.Id, e.FlowInstanceId)
+	default:
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	h := NewHub(4, 0, 0)
+	a := h.Subscribe(nil, Drop)
+	b := h.Subscribe(nil, Drop)
+
+	h.PublishStep(&state.Step{Id: 1, FlowId: "i1"})
+	if e := receive(t, a); e.Step.Id != 1 {
+		t.Fatalf("expected step 1, got %d", e.Step.Id)
+	}
+	if e := receive(t, b); e.Step.Id != 1 {
+		t.Fatalf("expected step 1, got %d", e.Step.Id)
+	}
+
+	b.Close()
+	if h.Subscribers() != 1 {
+		t.Fatalf("expected 1 subscriber, got %d", h.Subscribers())
+	}
+	h.PublishStep(&state.Step{Id: 2, FlowId: "i1"})
+	receive(t, a)
+	expectNone(t, b)
+}
+
+func TestFilter(t *testing.T) {
+	h := NewHub(4, 0, 0)
+	h.PublishStart(&state.FlowState{FlowInstanceId: "i1", AppName: "orders", AppVersion: "1.0", FlowName: "create", FlowStats: "Active"})
+	h.PublishStart(&state.FlowState{FlowInstanceId: "i2", AppName: "billing", AppVersion: "1.0", FlowName: "create", FlowStats: "Active"})
+
+	orders := h.Subscribe(FilterFromQuery(url.Values{"app": {"orders"}, "flow": {"create"}}), Drop)
+	instance := h.Subscribe(&Filter{FlowInstanceId: "i2"}, Drop)
+	failed := h.Subscribe(&Filter{Status: "failed"}, Drop)
+	ends := h.Subscribe(&Filter{Kind: KindEnd}, Drop)
+	if h.Seq() != 2 {
+		t.Fatalf("expected the starts to be numbered, got sequence number %d", h.Seq())
+	}
+
+	h.PublishStep(&state.Step{Id: 1, FlowId: "i1"})
+	h.PublishStep(&state.Step{Id: 1, FlowId: "i2"})
+	h.PublishStep(&state.Step{Id: 2, FlowId: "i2", FlowChanges: map[int]*change.Flow{0: {Status: int(model.FlowStatusFailed)}}})
+
+	if e := receive(t, orders); e.FlowInstanceId != "i1" || e.AppName != "orders" || e.FlowName != "create" {
+		t.Fatalf("expected the step of i1, got %+v", e)
+	}
+	expectNone(t, orders)
+
+	receive(t, instance)
+	if e := receive(t, instance); e.Step.Id != 2 || e.Status != "Failed" {
+		t.Fatalf("expected the failed step of i2, got %+v", e)
+	}
+
+	if e := receive(t, failed); e.FlowInstanceId != "i2" || e.Step.Id != 2 {
+		t.Fatalf("expected the failed step of i2, got %+v", e)
+	}
+	expectNone(t, failed)
+
+	// the end status applies to the steps published afterwards
+	h.PublishEnd(&state.FlowState{FlowInstanceId: "i1", FlowStats: "Failed"})
+	h.PublishStep(&state.Step{Id: 3, FlowId: "i1"})
+	if e := receive(t, failed); e.Kind != KindEnd || e.FlowInstanceId != "i1" || e.AppName != "orders" || e.FlowState == nil {
+		t.Fatalf("expected the end of i1, got %+v", e)
+	}
+	if e := receive(t, failed); e.Kind != KindStep || e.FlowInstanceId != "i1" || e.AppName != "orders" {
+		t.Fatalf("expected the step of i1, got %+v", e)
+	}
+	if e := receive(t, ends); e.Kind != KindEnd || e.FlowInstanceId != "i1" {
+		t.Fatalf("expected only the end of i1, got %+v", e)
+	}
+	expectNone(t, ends)
+
+	instance.SetFilter(&Filter{FlowInstanceId: "i1"})
+	h.PublishStep(&state.Step{Id: 4, FlowId: "i1"})
+	if e := receive(t, instance); e.FlowInstanceId != "i1" {
+		t.Fatalf("expected the step of i1 after the filter changed, got %+v", e)
+	}
+}
+
+func TestDrop(t *testing.T) {
+	h := NewHub(2, 0, 0)
+	slow := h.Subscribe(nil, Drop)
+	fast := h.Subscribe(nil, Drop)
+
+	for i := 1; i <= 3; i++ {
+		h.PublishStep(&state.Step{Id: i, FlowId: "i1"})
+		receive(t, fast)
+	}
+	select {
+	case <-slow.Done():
+	default:
+		t.Fatal("expected the slow subscriber to be dropped")
+	}
+	select {
+	case <-fast.Done():
+		t.Fatal("expected the fast subscriber to be kept")
+	default:
+	}
+	if h.Subscribers() != 1 {
+		t.Fatalf("expected 1 subscriber, got %d", h.Subscribers())
+	}
+	// the buffered events are still delivered
+	if e := receive(t, slow); e.Step.Id != 1 {
+		t.Fatalf("expected step 1, got %d", e.Step.Id)
+	}
+	slow.Close()
+}
+
+func TestLag(t *testing.T) {
+	h := NewHub(2, 0, 0)
+	s := h.Subscribe(nil, Lag)
+
+	for i := 1; i <= 5; i++ {
+		h.PublishStep(&state.Step{Id: i, FlowId: "i1"})
+	}
+	if missed := s.Missed(); missed != 3 {
+		t.Fatalf("expected 3 missed events, got %d", missed)
+	}
+	if missed := s.Missed(); missed != 0 {
+		t.Fatalf("expected the missed events to be reset, got %d", missed)
+	}
+	receive(t, s)
+	receive(t, s)
+	h.PublishStep(&state.Step{Id: 6, FlowId: "i1"})
+	if e := receive(t, s); e.Step.Id != 6 {
+		t.Fatalf("expected step 6, got %d", e.Step.Id)
+	}
+	if h.Subscribers() != 1 {
+		t.Fatal("expected the lagging subscriber to be kept")
+	}
+}
+
+func TestInstancesAreBounded(t *testing.T) {
+	h := NewHub(4, 0, 2)
+	h.PublishStart(&state.FlowState{FlowInstanceId: "i1", AppName: "orders"})
+	h.PublishStart(&state.FlowState{FlowInstanceId: "i2", AppName: "orders"})
+	h.PublishStart(&state.FlowState{FlowInstanceId: "i3", AppName: "orders"})
+	if h.instance("i1") != nil || h.instance("i3") == nil {
+		t.Fatal("expected the oldest instance to be forgotten")
+	}
+}
+
+func TestSubscribeSince(t *testing.T) {
+	h := NewHub(8, 4, 0)
+
+	if _, replay, missed := h.SubscribeSince(nil, Drop, 0); len(replay) != 0 || missed != 0 {
+		t.Fatalf("expected nothing to replay, got %d events and %d missed", len(replay), missed)
+	}
+	for i := 1; i <= 3; i++ {
+		h.PublishStep(&state.Step{Id: i, FlowId: "i1"})
+		h.PublishStep(&state.Step{Id: i, FlowId: "i2"})
+	}
+	if h.Seq() != 6 {
+		t.Fatalf("expected sequence number 6, got %d", h.Seq())
+	}
+
+	// events 3 to 6 are retained
+	s, replay, missed := h.SubscribeSince(&Filter{FlowInstanceId: "i1"}, Drop, 3)
+	if missed != 0 || len(replay) != 1 || replay[0].Seq != 5 || replay[0].Step.Id != 3 {
+		t.Fatalf("expected event 5 to be replayed, got %d events and %d missed", len(replay), missed)
+	}
+	h.PublishStep(&state.Step{Id: 4, FlowId: "i1"})
+	if e := receive(t, s); e.Seq != 7 {
+		t.Fatalf("expected event 7 after the replay, got %d", e.Seq)
+	}
+
+	// events 4 to 7 are retained, 2 and 3 are missed
+	_, replay, missed = h.SubscribeSince(nil, Drop, 1)
+	if missed != 2 || len(replay) != 4 || replay[0].Seq != 4 || replay[3].Seq != 7 {
+		t.Fatalf("expected events 4 to 7 to be replayed after 2 missed, got %d events and %d missed", len(replay), missed)
+	}
+
+	// a since ahead of the hub is from before a restart
+	if _, replay, missed = h.SubscribeSince(nil, Drop, 100); missed != 0 || len(replay) != 4 {
+		t.Fatalf("expected every retained event to be replayed, got %d events and %d missed", len(replay), missed)
+	}
+	if _, replay, _ = h.SubscribeSince(nil, Drop, 7); len(replay) != 0 {
+		t.Fatalf("expected nothing to replay, got %d events", len(replay))
+	}
+}
+
+func TestHandleStepEvent(t *testing.T) {
+	Steps = NewHub(DefaultBufferSize, 0, 0)
+	StartStepListener()
+	defer atomic.StoreInt32(&streaming, 0)
+
+	router := httprouter.New()
+	router.GET("/v1/stream/steps", HandleStepEvent)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	endpoint := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/stream/steps?app=orders"
+	conn, _, err := websocket.DefaultDialer.Dial(endpoint, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for Steps.Subscribers() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected a subscriber")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	PostStartEvent(&state.FlowState{FlowInstanceId: "i1", AppName: "billing"})
+	PostStartEvent(&state.FlowState{FlowInstanceId: "i2", AppName: "orders"})
+	PostStepEvent(&state.Step{Id: 1, FlowId: "i1"})
+	PostStepEvent(&state.Step{Id: 1, FlowId: "i2"})
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	step := &state.Step{}
+	if err = conn.ReadJSON(step); err != nil {
+		t.Fatal(err)
+	}
+	if step.FlowId != "i2" || step.Id != 1 {
+		t.Fatalf("expected the step of i2, got %+v", step)
+	}
+
+	// a subscribe message replaces the filter
+	if err = conn.WriteJSON(map[string]interface{}{"type": "subscribe", "filter": map[string]string{"app": "billing"}}); err != nil {
+		t.Fatal(err)
+	}
+	deadline = time.Now().Add(time.Second)
+	for Steps.subscriber().Filter().AppName != "billing" {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the filter to be replaced")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	PostStepEvent(&state.Step{Id: 2, FlowId: "i2"})
+	PostStepEvent(&state.Step{Id: 2, FlowId: "i1"})
+	if err = conn.ReadJSON(step); err != nil {
+		t.Fatal(err)
+	}
+	if step.FlowId != "i1" || step.Id != 2 {
+		t.Fatalf("expected the step of i1, got %+v", step)
+	}
+	conn.Close()
+
+	// a client resuming after the first step of i2 gets the steps of i2 it did not receive
+	conn, _, err = websocket.DefaultDialer.Dial(endpoint+"&since=4", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	resumed := &streamedStep{Step: &state.Step{}}
+	if err = conn.ReadJSON(resumed); err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Seq != 5 || resumed.FlowId != "i2" || resumed.Id != 2 {
+		t.Fatalf("expected step 2 of i2 with sequence number 5, got %d %+v", resumed.Seq, resumed.Step)
+	}
+
+	if _, _, err = websocket.DefaultDialer.Dial(endpoint+"&since=last", nil); err == nil {
+		t.Fatal("expected an invalid since to be rejected")
+	}
+}
+
+// subscriber returns any subscriber of the hub
+func (h *Hub) subscriber() *Subscriber {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	for s := range h.subscribers {
+		return s
+	}
+	return nil
+}
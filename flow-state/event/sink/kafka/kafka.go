@@ -0,0 +1,58 @@
+// Package kafka registers the kafka event sink, the brokers are the host:port bootstrap addresses of the cluster
+package kafka
+
+import (
+	"context"
+
+	"github.com/project-flogo/services/flow-state/event/sink"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+const Type = "kafka"
+
+func init() {
+	sink.Register(Type, New)
+}
+
+// writer is the part of kafka.Writer used by the sink
+type writer interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+type kafkaSink struct {
+	writer writer
+}
+
+// New creates a sink writing the messages to the partition of their key, the topics are created when the cluster
+// allows it. With Acknowledged delivery every in-sync replica acknowledges the messages.
+func New(cfg *sink.Config) (sink.Sink, error) {
+	transport := &kafka.Transport{ClientID: cfg.ClientId}
+	if cfg.Username != "" {
+		transport.SASL = plain.Mechanism{Username: cfg.Username, Password: cfg.Password}
+	}
+	acks := kafka.RequireAll
+	if !cfg.RequiresAck() {
+		acks = kafka.RequireNone
+	}
+	return &kafkaSink{writer: &kafka.Writer{
+		Addr:         kafka.TCP(cfg.Brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: acks,
+		// the messages are sent one by one, in order, and the sink retries them
+		BatchSize:              1,
+		MaxAttempts:            1,
+		WriteTimeout:           cfg.PublishTimeout(),
+		AllowAutoTopicCreation: true,
+		Transport:              transport,
+	}}, nil
+}
+
+func (s *kafkaSink) Publish(ctx context.Context, msg *sink.Message) error {
+	return s.writer.WriteMessages(ctx, kafka.Message{Topic: msg.Topic, Key: []byte(msg.Key), Value: msg.Value})
+}
+
+func (s *kafkaSink) Close() error {
+	return s.writer.Close()
+}
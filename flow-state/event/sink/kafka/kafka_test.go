@@ -0,0 +1,62 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+
+	"github.com/project-flogo/services/flow-state/event/sink"
+	"github.com/segmentio/kafka-go"
+)
+
+// recorder is a kafka.Writer stand-in recording the messages
+type recorder struct {
+	messages []kafka.Message
+}
+
+func (r *recorder) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	r.messages = append(r.messages, msgs...)
+	return nil
+}
+
+func (r *recorder) Close() error {
+	return nil
+}
+
+func newSink(t *testing.T, delivery string) *kafkaSink {
+	configs, err := sink.NewConfigs([]interface{}{map[string]interface{}{
+		"type": Type, "brokers": []string{"localhost:9092", "localhost:9093"}, "delivery": delivery, "username": "flogo", "password": "secret",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(configs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.(*kafkaSink)
+}
+
+func TestNew(t *testing.T) {
+	w := newSink(t, sink.Acknowledged).writer.(*kafka.Writer)
+	if w.RequiredAcks != kafka.RequireAll || w.MaxAttempts != 1 || w.BatchSize != 1 || w.Addr.String() != "localhost:9092,localhost:9093" {
+		t.Fatalf("unexpected writer %+v", w)
+	}
+	if w.Transport.(*kafka.Transport).SASL == nil {
+		t.Fatal("expected the SASL credentials to be set")
+	}
+	if w = newSink(t, sink.AtMostOnce).writer.(*kafka.Writer); w.RequiredAcks != kafka.RequireNone {
+		t.Fatalf("expected no acks for at most once delivery, got %v", w.RequiredAcks)
+	}
+}
+
+func TestPublish(t *testing.T) {
+	s := newSink(t, sink.Acknowledged)
+	r := &recorder{}
+	s.writer = r
+	if err := s.Publish(context.Background(), &sink.Message{Topic: "flogo.orders.create.step", Key: "i1", Value: []byte(`{"seq":1}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.messages) != 1 || r.messages[0].Topic != "flogo.orders.create.step" || string(r.messages[0].Key) != "i1" || string(r.messages[0].Value) != `{"seq":1}` {
+		t.Fatalf("unexpected messages %+v", r.messages)
+	}
+}
@@ -0,0 +1,69 @@
+// Package mqtt registers the mqtt event sink, the brokers are the tcp://host:port, ssl://host:port or ws://host:port
+// URLs of the brokers
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/project-flogo/services/flow-state/event/sink"
+)
+
+const Type = "mqtt"
+
+var errNotConnected = errors.New("not connected to the mqtt broker")
+
+func init() {
+	sink.Register(Type, New)
+}
+
+type mqttSink struct {
+	client paho.Client
+	qos    byte
+}
+
+// New creates a sink publishing the messages on their topic, with QoS 1 for Acknowledged delivery and QoS 0 for
+// AtMostOnce. The connection is retried in the background.
+func New(cfg *sink.Config) (sink.Sink, error) {
+	options := paho.NewClientOptions()
+	for _, broker := range cfg.Brokers {
+		options.AddBroker(broker)
+	}
+	options.SetClientID(cfg.ClientId)
+	options.SetUsername(cfg.Username)
+	options.SetPassword(cfg.Password)
+	options.SetAutoReconnect(true)
+	options.SetConnectRetry(true)
+	options.SetConnectRetryInterval(time.Second)
+	options.SetWriteTimeout(cfg.PublishTimeout())
+
+	client := paho.NewClient(options)
+	client.Connect()
+
+	s := &mqttSink{client: client}
+	if cfg.RequiresAck() {
+		s.qos = 1
+	}
+	return s, nil
+}
+
+func (s *mqttSink) Publish(ctx context.Context, msg *sink.Message) error {
+	// the messages published while connecting are not sent once connected with a clean session, they are retried
+	if !s.client.IsConnectionOpen() {
+		return errNotConnected
+	}
+	token := s.client.Publish(msg.Topic, s.qos, false, msg.Value)
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *mqttSink) Close() error {
+	s.client.Disconnect(250)
+	return nil
+}
@@ -0,0 +1,129 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/project-flogo/services/flow-state/event/sink"
+)
+
+type message struct {
+	topic   string
+	qos     byte
+	payload string
+}
+
+// broker is an MQTT 3.1.1 broker stand-in accepting the connections, answering the pings and acknowledging
+// the QoS 1 messages
+type broker struct {
+	listener net.Listener
+	messages chan *message
+}
+
+func newBroker(t *testing.T) *broker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &broker{listener: listener, messages: make(chan *message, 10)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *broker) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		header, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		length, err := binary.ReadUvarint(r)
+		if err != nil {
+			return
+		}
+		body := make([]byte, length)
+		if _, err = io.ReadFull(r, body); err != nil {
+			return
+		}
+		switch header >> 4 {
+		case 1: // CONNECT
+			conn.Write([]byte{0x20, 0x02, 0x00, 0x00})
+		case 3: // PUBLISH
+			qos := (header >> 1) & 0x03
+			topicLength := int(binary.BigEndian.Uint16(body))
+			msg := &message{topic: string(body[2 : 2+topicLength]), qos: qos}
+			payload := body[2+topicLength:]
+			if qos > 0 {
+				conn.Write([]byte{0x40, 0x02, payload[0], payload[1]})
+				payload = payload[2:]
+			}
+			msg.payload = string(payload)
+			b.messages <- msg
+		case 12: // PINGREQ
+			conn.Write([]byte{0xd0, 0x00})
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+func publish(t *testing.T, b *broker, delivery string) *message {
+	configs, err := sink.NewConfigs([]interface{}{map[string]interface{}{
+		"type": Type, "brokers": []string{"tcp://" + b.listener.Addr().String()}, "clientId": "flow-state-test", "delivery": delivery,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(configs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	// the sink connects in the background
+	for err = errNotConnected; err == errNotConnected; time.Sleep(10 * time.Millisecond) {
+		err = s.Publish(ctx, &sink.Message{Topic: "flogo/orders/create/end", Key: "i1", Value: []byte(`{"seq":2}`)})
+		if ctx.Err() != nil {
+			t.Fatal("expected the sink to connect")
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-b.messages:
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the message to be published")
+	}
+	return nil
+}
+
+func TestPublish(t *testing.T) {
+	b := newBroker(t)
+	defer b.listener.Close()
+
+	msg := publish(t, b, sink.Acknowledged)
+	if msg.topic != "flogo/orders/create/end" || msg.qos != 1 || msg.payload != `{"seq":2}` {
+		t.Fatalf("unexpected message %+v", msg)
+	}
+	if msg = publish(t, b, sink.AtMostOnce); msg.qos != 0 {
+		t.Fatalf("expected QoS 0 for at most once delivery, got %d", msg.qos)
+	}
+}
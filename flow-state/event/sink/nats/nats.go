@@ -0,0 +1,54 @@
+// Package nats registers the nats event sink, the brokers are the nats://host:port URLs of the servers
+package nats
+
+import (
+	"context"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/project-flogo/services/flow-state/event/sink"
+)
+
+const Type = "nats"
+
+func init() {
+	sink.Register(Type, New)
+}
+
+type natsSink struct {
+	conn *nats.Conn
+	ack  bool
+}
+
+// New creates a sink publishing the messages on the subject of their topic. The connection is retried in the
+// background, with Acknowledged delivery a message is sent once the server answered a ping sent after it.
+func New(cfg *sink.Config) (sink.Sink, error) {
+	name := cfg.ClientId
+	if name == "" {
+		name = "flogo-flow-state"
+	}
+	options := []nats.Option{nats.Name(name), nats.RetryOnFailedConnect(true), nats.MaxReconnects(-1)}
+	if cfg.Username != "" {
+		options = append(options, nats.UserInfo(cfg.Username, cfg.Password))
+	}
+	conn, err := nats.Connect(strings.Join(cfg.Brokers, ","), options...)
+	if err != nil {
+		return nil, err
+	}
+	return &natsSink{conn: conn, ack: cfg.RequiresAck()}, nil
+}
+
+func (s *natsSink) Publish(ctx context.Context, msg *sink.Message) error {
+	if err := s.conn.Publish(msg.Topic, msg.Value); err != nil {
+		return err
+	}
+	if s.ack {
+		return s.conn.FlushWithContext(ctx)
+	}
+	return nil
+}
+
+func (s *natsSink) Close() error {
+	s.conn.Close()
+	return nil
+}
@@ -0,0 +1,125 @@
+package nats
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/project-flogo/services/flow-state/event/sink"
+)
+
+// server is a NATS server stand-in answering the pings and recording the published messages
+type server struct {
+	listener net.Listener
+	messages chan [2]string
+}
+
+func newServer(t *testing.T) *server {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{listener: listener, messages: make(chan [2]string, 10)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *server) url() string {
+	return "nats://" + s.listener.Addr().String()
+}
+
+func (s *server) serve(conn net.Conn) {
+	defer conn.Close()
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"standin\",\"version\":\"2.9.0\",\"proto\":1,\"max_payload\":1048576}\r\n")
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "PING":
+			fmt.Fprint(conn, "PONG\r\n")
+		case "PUB":
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			if _, err = io.ReadFull(r, payload); err != nil {
+				return
+			}
+			s.messages <- [2]string{fields[1], string(payload[:size])}
+		}
+	}
+}
+
+func TestPublish(t *testing.T) {
+	standin := newServer(t)
+	defer standin.listener.Close()
+
+	configs, err := sink.NewConfigs([]interface{}{map[string]interface{}{"type": Type, "brokers": []string{standin.url()}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(configs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err = s.Publish(ctx, &sink.Message{Topic: "flogo.orders.create.step", Key: "i1", Value: []byte(`{"seq":1}`)}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-standin.messages:
+		if msg[0] != "flogo.orders.create.step" || msg[1] != `{"seq":1}` {
+			t.Fatalf("unexpected message %v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the message to be published")
+	}
+}
+
+func TestPublishUnavailable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "nats://" + listener.Addr().String()
+	listener.Close()
+
+	configs, err := sink.NewConfigs([]interface{}{map[string]interface{}{"type": Type, "brokers": []string{url}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the connection is retried in the background
+	s, err := New(configs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err = s.Publish(ctx, &sink.Message{Topic: "flogo.orders.create.step", Value: []byte("{}")}); err == nil {
+		t.Fatal("expected the message not to be acknowledged")
+	}
+}
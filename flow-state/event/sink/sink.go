@@ -0,0 +1,427 @@
+// Package sink forwards the step, start and end events of the flow instances to message brokers. The brokers are
+// plugged in by registering a Factory for their type, the kafka, nats and mqtt packages register theirs.
+//
+// The delivery is best-effort end to end: a forwarder reads the events from the hub into a bounded buffer so it
+// never slows the recording down, the events which do not fit and the events still buffered when the service
+// stops are counted as missed, and nothing is kept across restarts. The delivery only sets whether the broker
+// acknowledges each message the forwarder sends.
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/project-flogo/core/support/log"
+	"github.com/project-flogo/flow/state"
+	"github.com/project-flogo/services/flow-state/event"
+)
+
+var sinkLog = log.ChildLogger(log.RootLogger(), "event-sink")
+
+const (
+	// AtMostOnce sends every event once without waiting for the broker to acknowledge it
+	AtMostOnce = "atMostOnce"
+	// Acknowledged waits for the broker to acknowledge every message and retries the messages it did not
+	// acknowledge. It is not at-least-once delivery, the events missed by the forwarder are never sent.
+	Acknowledged = "acknowledged"
+
+	DefaultTopic      = "flogo.{app}.{flow}.{event}"
+	DefaultRetries    = 5
+	DefaultRetryDelay = 500 * time.Millisecond
+	DefaultTimeout    = 10 * time.Second
+	DefaultBufferSize = 4096
+
+	// maxRetryDelay caps the doubling delay between two attempts
+	maxRetryDelay = 30 * time.Second
+)
+
+// Message is an event ready to be sent to a broker
+type Message struct {
+	Topic string
+	// Key is the flow instance id, brokers that partition their topics keep the events of an instance in order
+	Key   string
+	Value []byte
+}
+
+// Sink sends the messages to a broker
+type Sink interface {
+	// Publish sends the message, with Acknowledged delivery it returns once the broker acknowledged it
+	Publish(ctx context.Context, msg *Message) error
+	Close() error
+}
+
+// Factory creates the sink of a config
+type Factory func(cfg *Config) (Sink, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes the sink type available to the configs
+func Register(sinkType string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[sinkType] = factory
+}
+
+func factory(sinkType string) Factory {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	return factories[sinkType]
+}
+
+// Config is the config of a sink
+type Config struct {
+	Name string `json:"name,omitempty"`
+	// Type is the registered type of the sink, such as kafka, nats or mqtt
+	Type string `json:"type"`
+	// Brokers are the addresses of the brokers, host:port for kafka, nats://host:port for nats and tcp://host:port for mqtt
+	Brokers  []string `json:"brokers"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	ClientId string   `json:"clientId,omitempty"`
+	// Topic is the template of the topic of the events, {app}, {version}, {flow}, {flowInstanceId}, {status} and
+	// {event} are replaced by the values of the event
+	Topic string `json:"topic,omitempty"`
+	// Events are the kinds of the events sent, step, start and end, all of them by default
+	Events []string      `json:"events,omitempty"`
+	Filter *event.Filter `json:"filter,omitempty"`
+	// Delivery is AtMostOnce or Acknowledged, the default
+	Delivery string `json:"delivery,omitempty"`
+	// Retries is the number of times an event is sent again with Acknowledged delivery, -1 retries until the sink stops
+	Retries *int `json:"retries,omitempty"`
+	// RetryDelay is the delay before the first retry, such as 500ms, it doubles with every retry
+	RetryDelay string `json:"retryDelay,omitempty"`
+	// Timeout is the time allowed to send an event, such as 10s
+	Timeout string `json:"timeout,omitempty"`
+	// BufferSize is the number of events waiting to be sent, the events which do not fit are counted as missed
+	// and never sent
+	BufferSize int `json:"bufferSize,omitempty"`
+
+	retryDelay time.Duration
+	timeout    time.Duration
+	events     map[event.Kind]bool
+}
+
+func (c *Config) String() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.Type
+}
+
+// NewConfigs reads the sink settings, a list of sink configs, and applies the defaults
+func NewConfigs(settings interface{}) ([]*Config, error) {
+	if settings == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+	var configs []*Config
+	if err = json.Unmarshal(b, &configs); err != nil {
+		return nil, fmt.Errorf("invalid event sinks, %s", err.Error())
+	}
+	for _, cfg := range configs {
+		if err = cfg.init(); err != nil {
+			return nil, err
+		}
+	}
+	return configs, nil
+}
+
+func (c *Config) init() error {
+	if factory(c.Type) == nil {
+		return fmt.Errorf("event sink [%s] has an unsupported type [%s]", c, c.Type)
+	}
+	if len(c.Brokers) == 0 {
+		return fmt.Errorf("event sink [%s] needs brokers", c)
+	}
+	if c.Topic == "" {
+		c.Topic = DefaultTopic
+	}
+	switch c.Delivery {
+	case "":
+		c.Delivery = Acknowledged
+	case AtMostOnce, Acknowledged:
+	default:
+		return fmt.Errorf("event sink [%s] has an unsupported delivery [%s]", c, c.Delivery)
+	}
+	if c.Retries == nil {
+		retries := DefaultRetries
+		c.Retries = &retries
+	}
+	if c.BufferSize <= 0 {
+		c.BufferSize = DefaultBufferSize
+	}
+
+	var err error
+	if c.retryDelay, err = duration(c.RetryDelay, DefaultRetryDelay); err != nil {
+		return fmt.Errorf("event sink [%s] has an invalid retryDelay [%s]", c, c.RetryDelay)
+	}
+	if c.timeout, err = duration(c.Timeout, DefaultTimeout); err != nil {
+		return fmt.Errorf("event sink [%s] has an invalid timeout [%s]", c, c.Timeout)
+	}
+
+	c.events = make(map[event.Kind]bool)
+	if len(c.Events) == 0 {
+		c.Events = []string{string(event.KindStep), string(event.KindStart), string(event.KindEnd)}
+	}
+	for _, kind := range c.Events {
+		switch event.Kind(kind) {
+		case event.KindStep, event.KindStart, event.KindEnd:
+			c.events[event.Kind(kind)] = true
+		default:
+			return fmt.Errorf("event sink [%s] has an unsupported event [%s]", c, kind)
+		}
+	}
+	return nil
+}
+
+func duration(value string, defaultValue time.Duration) (time.Duration, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err == nil && d <= 0 {
+		err = fmt.Errorf("duration must be positive")
+	}
+	return d, err
+}
+
+// RequiresAck reports whether the broker has to acknowledge the messages
+func (c *Config) RequiresAck() bool {
+	return c.Delivery == Acknowledged
+}
+
+// PublishTimeout is the time allowed to send a message
+func (c *Config) PublishTimeout() time.Duration {
+	return c.timeout
+}
+
+// topicReplacer replaces the characters separating the levels or matching several topics in Kafka,
+// NATS and MQTT topics, so the values of an event stay in their level of the topic
+var topicReplacer = strings.NewReplacer(".", "_", "/", "_", "*", "_", ">", "_", "#", "_", "+", "_", " ", "_")
+
+// topic renders the topic template for the event
+func (c *Config) topic(e *event.Event) string {
+	value := func(s string) string {
+		if s == "" {
+			return "unknown"
+		}
+		return topicReplacer.Replace(s)
+	}
+	return strings.NewReplacer(
+		"{app}", value(e.AppName),
+		"{version}", value(e.AppVersion),
+		"{flow}", value(e.FlowName),
+		"{flowInstanceId}", value(e.FlowInstanceId),
+		"{status}", value(e.Status),
+		"{event}", string(e.Kind),
+	).Replace(c.Topic)
+}
+
+// Payload is the JSON value of the messages
+type Payload struct {
+	Seq            uint64           `json:"seq"`
+	Event          event.Kind       `json:"event"`
+	FlowInstanceId string           `json:"flowInstanceId"`
+	AppName        string           `json:"app,omitempty"`
+	AppVersion     string           `json:"version,omitempty"`
+	FlowName       string           `json:"flow,omitempty"`
+	Status         string           `json:"status,omitempty"`
+	Step           *state.Step      `json:"step,omitempty"`
+	FlowState      *state.FlowState `json:"flowState,omitempty"`
+}
+
+func (c *Config) message(e *event.Event) (*Message, error) {
+	value, err := json.Marshal(&Payload{Seq: e.Seq, Event: e.Kind, FlowInstanceId: e.FlowInstanceId, AppName: e.AppName,
+		AppVersion: e.AppVersion, FlowName: e.FlowName, Status: e.Status, Step: e.Step, FlowState: e.FlowState})
+	if err != nil {
+		return nil, err
+	}
+	return &Message{Topic: c.topic(e), Key: e.FlowInstanceId, Value: value}, nil
+}
+
+// Stats are the totals of a sink since it was started
+type Stats struct {
+	Name      string    `json:"name"`
+	Published int64     `json:"published"`
+	Retried   int64     `json:"retried"`
+	Failed    int64     `json:"failed"`
+	Missed    int64     `json:"missed"`
+	LastError string    `json:"lastError,omitempty"`
+	LastFail  time.Time `json:"lastFail,omitempty"`
+}
+
+// Forwarder sends the events of a hub to a sink, in the order they were published. It lags behind the hub
+// rather than blocking it, see Stats.Missed.
+type Forwarder struct {
+	cfg  *Config
+	sink Sink
+	sub  *event.Subscriber
+
+	mu    sync.Mutex
+	stats Stats
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopping chan struct{}
+	done     chan struct{}
+}
+
+// NewForwarder creates the sink of the config and subscribes it to the events of the hub it selects
+func NewForwarder(cfg *Config, hub *event.Hub) (*Forwarder, error) {
+	sink, err := factory(cfg.Type)(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("event sink [%s] can not be created, %s", cfg, err.Error())
+	}
+	return newForwarder(cfg, sink, hub), nil
+}
+
+func newForwarder(cfg *Config, sink Sink, hub *event.Hub) *Forwarder {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Forwarder{
+		cfg:      cfg,
+		sink:     sink,
+		sub:      hub.SubscribeWithBuffer(cfg.Filter, event.Lag, cfg.BufferSize),
+		stats:    Stats{Name: cfg.String()},
+		ctx:      ctx,
+		cancel:   cancel,
+		stopping: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start sends the events until Stop is called
+func (f *Forwarder) Start() {
+	go func() {
+		defer close(f.done)
+		for {
+			select {
+			case e := <-f.sub.Events():
+				f.receive(e)
+			case <-f.stopping:
+				// the events already received are sent before the sink is closed, unless Stop timed out
+				for f.ctx.Err() == nil {
+					select {
+					case e := <-f.sub.Events():
+						f.receive(e)
+					default:
+						return
+					}
+				}
+				if left := len(f.sub.Events()); left > 0 {
+					sinkLog.Warnf("Event sink [%s] stopped before sending %d events", f.cfg, left)
+					f.update(func(s *Stats) { s.Missed += int64(left) })
+				}
+				return
+			}
+		}
+	}()
+}
+
+func (f *Forwarder) receive(e *event.Event) {
+	if missed := f.sub.Missed(); missed > 0 {
+		sinkLog.Warnf("Event sink [%s] missed %d events, the broker does not keep up", f.cfg, missed)
+		f.update(func(s *Stats) { s.Missed += int64(missed) })
+	}
+	if f.cfg.events[e.Kind] {
+		f.forward(e)
+	}
+}
+
+// forward sends the event, with Acknowledged delivery it is sent again until the broker acknowledges it or the retries
+// are exhausted
+func (f *Forwarder) forward(e *event.Event) {
+	msg, err := f.cfg.message(e)
+	if err != nil {
+		sinkLog.Errorf("Event sink [%s] could not encode event [%d], %s", f.cfg, e.Seq, err.Error())
+		f.update(func(s *Stats) { s.Failed++ })
+		return
+	}
+
+	delay := f.cfg.retryDelay
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithTimeout(f.ctx, f.cfg.timeout)
+		err = f.sink.Publish(ctx, msg)
+		cancel()
+		if err == nil {
+			f.update(func(s *Stats) { s.Published++ })
+			return
+		}
+
+		retries := *f.cfg.Retries
+		if !f.cfg.RequiresAck() || (retries >= 0 && attempt >= retries) || f.ctx.Err() != nil {
+			sinkLog.Errorf("Event sink [%s] could not send event [%d] to [%s], %s", f.cfg, e.Seq, msg.Topic, err.Error())
+			f.update(func(s *Stats) {
+				s.Failed++
+				s.LastError, s.LastFail = err.Error(), time.Now()
+			})
+			return
+		}
+		sinkLog.Debugf("Event sink [%s] retries event [%d] in %s, %s", f.cfg, e.Seq, delay, err.Error())
+		f.update(func(s *Stats) { s.Retried++ })
+		select {
+		case <-time.After(delay):
+		case <-f.ctx.Done():
+		}
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+func (f *Forwarder) update(change func(*Stats)) {
+	f.mu.Lock()
+	change(&f.stats)
+	f.mu.Unlock()
+}
+
+// Stats are the totals of the sink
+func (f *Forwarder) Stats() *Stats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	stats := f.stats
+	return &stats
+}
+
+// Stop sends the events already received, for at most the timeout of the config, and closes the sink
+func (f *Forwarder) Stop() error {
+	f.sub.Close()
+	close(f.stopping)
+	select {
+	case <-f.done:
+	case <-time.After(f.cfg.timeout):
+		f.cancel()
+		<-f.done
+	}
+	f.cancel()
+	return f.sink.Close()
+}
+
+// Start creates and starts the forwarders of the configs on the Steps hub
+func Start(configs []*Config) ([]*Forwarder, error) {
+	var forwarders []*Forwarder
+	for _, cfg := range configs {
+		f, err := NewForwarder(cfg, event.Steps)
+		if err != nil {
+			for _, started := range forwarders {
+				_ = started.Stop()
+			}
+			return nil, err
+		}
+		f.Start()
+		forwarders = append(forwarders, f)
+	}
+	if len(forwarders) > 0 {
+		event.StartStepListener()
+	}
+	return forwarders, nil
+}
@@ -0,0 +1,192 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/project-flogo/flow/state"
+	"github.com/project-flogo/services/flow-state/event"
+)
+
+// fakeSink records the messages, the first failures publishes fail
+type fakeSink struct {
+	mu       sync.Mutex
+	failures int
+	attempts int
+	messages []*Message
+	closed   bool
+}
+
+func (s *fakeSink) Publish(ctx context.Context, msg *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts++
+	if s.attempts <= s.failures {
+		return errors.New("broker is not available")
+	}
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+func (s *fakeSink) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *fakeSink) received() []*Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Message(nil), s.messages...)
+}
+
+func init() {
+	Register("fake", func(*Config) (Sink, error) { return &fakeSink{}, nil })
+}
+
+func newConfig(t *testing.T, settings map[string]interface{}) *Config {
+	t.Helper()
+	settings["type"], settings["brokers"] = "fake", []string{"localhost"}
+	configs, err := NewConfigs([]interface{}{settings})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return configs[0]
+}
+
+func TestNewConfigs(t *testing.T) {
+	cfg := newConfig(t, map[string]interface{}{})
+	if cfg.Topic != DefaultTopic || cfg.Delivery != Acknowledged || *cfg.Retries != DefaultRetries || cfg.retryDelay != DefaultRetryDelay ||
+		cfg.timeout != DefaultTimeout || cfg.BufferSize != DefaultBufferSize || len(cfg.events) != 3 {
+		t.Fatalf("expected the defaults, got %+v", cfg)
+	}
+	if cfg = newConfig(t, map[string]interface{}{"retries": 0, "retryDelay": "10ms", "events": []string{"end"}}); *cfg.Retries != 0 ||
+		cfg.retryDelay != 10*time.Millisecond || !cfg.events[event.KindEnd] || cfg.events[event.KindStep] {
+		t.Fatalf("expected the settings, got %+v", cfg)
+	}
+
+	for _, settings := range []map[string]interface{}{
+		{"type": "amqp", "brokers": []string{"localhost"}},
+		{"type": "fake"},
+		{"type": "fake", "brokers": []string{"localhost"}, "delivery": "exactlyOnce"},
+		{"type": "fake", "brokers": []string{"localhost"}, "retryDelay": "soon"},
+		{"type": "fake", "brokers": []string{"localhost"}, "events": []string{"snapshot"}},
+	} {
+		if _, err := NewConfigs([]interface{}{settings}); err == nil {
+			t.Fatalf("expected %v to be rejected", settings)
+		}
+	}
+	if configs, err := NewConfigs(nil); err != nil || configs != nil {
+		t.Fatalf("expected no sinks, got %v %v", configs, err)
+	}
+}
+
+func TestTopic(t *testing.T) {
+	cfg := newConfig(t, map[string]interface{}{"topic": "flogo.{app}.{flow}.steps"})
+	e := &event.Event{Kind: event.KindStep, AppName: "orders", FlowName: "create.order"}
+	if topic := cfg.topic(e); topic != "flogo.orders.create_order.steps" {
+		t.Fatalf("unexpected topic %s", topic)
+	}
+	cfg = newConfig(t, map[string]interface{}{"topic": "flogo/{app}/{version}/{event}/{status}"})
+	if topic := cfg.topic(&event.Event{Kind: event.KindEnd, AppName: "my app", Status: "Failed"}); topic != "flogo/my_app/unknown/end/Failed" {
+		t.Fatalf("unexpected topic %s", topic)
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestForwarder(t *testing.T) {
+	hub := event.NewHub(0, 0, 0)
+	cfg := newConfig(t, map[string]interface{}{"retryDelay": "1ms", "filter": map[string]string{"app": "orders"}})
+	fake := &fakeSink{failures: 2}
+	f := newForwarder(cfg, fake, hub)
+	f.Start()
+
+	hub.PublishStart(&state.FlowState{FlowInstanceId: "i1", AppName: "orders", FlowName: "create", FlowStats: "Active"})
+	hub.PublishStart(&state.FlowState{FlowInstanceId: "i2", AppName: "billing", FlowName: "charge", FlowStats: "Active"})
+	hub.PublishStep(&state.Step{Id: 1, FlowId: "i1"})
+	hub.PublishStep(&state.Step{Id: 1, FlowId: "i2"})
+	hub.PublishEnd(&state.FlowState{FlowInstanceId: "i1", FlowStats: "Completed"})
+
+	waitFor(t, func() bool { return len(fake.received()) == 3 })
+	messages := fake.received()
+	for i, topic := range []string{"flogo.orders.create.start", "flogo.orders.create.step", "flogo.orders.create.end"} {
+		if messages[i].Topic != topic || messages[i].Key != "i1" {
+			t.Fatalf("expected message %d on %s, got %s", i, topic, messages[i].Topic)
+		}
+	}
+	payload := &Payload{}
+	if err := json.Unmarshal(messages[1].Value, payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != event.KindStep || payload.Seq != 3 || payload.AppName != "orders" || payload.Step == nil || payload.Step.Id != 1 {
+		t.Fatalf("unexpected payload %+v", payload)
+	}
+
+	if err := f.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := f.Stats(); stats.Published != 3 || stats.Retried != 2 || stats.Failed != 0 || !fake.closed {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestForwarderFailures(t *testing.T) {
+	hub := event.NewHub(0, 0, 0)
+
+	// the event is dropped once the retries are exhausted
+	fake := &fakeSink{failures: 3}
+	f := newForwarder(newConfig(t, map[string]interface{}{"retries": 2, "retryDelay": "1ms"}), fake, hub)
+	f.Start()
+	hub.PublishStep(&state.Step{Id: 1, FlowId: "i1"})
+	hub.PublishStep(&state.Step{Id: 2, FlowId: "i1"})
+	waitFor(t, func() bool { return len(fake.received()) == 1 })
+	_ = f.Stop()
+	if stats := f.Stats(); stats.Published != 1 || stats.Retried != 2 || stats.Failed != 1 || stats.LastError == "" {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// at most once delivery never retries
+	fake = &fakeSink{failures: 1}
+	f = newForwarder(newConfig(t, map[string]interface{}{"delivery": AtMostOnce}), fake, hub)
+	f.Start()
+	hub.PublishStep(&state.Step{Id: 3, FlowId: "i1"})
+	hub.PublishStep(&state.Step{Id: 4, FlowId: "i1"})
+	waitFor(t, func() bool { return len(fake.received()) == 1 })
+	_ = f.Stop()
+	if stats := f.Stats(); stats.Published != 1 || stats.Retried != 0 || stats.Failed != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestForwarderMissed(t *testing.T) {
+	hub := event.NewHub(0, 0, 0)
+	fake := &fakeSink{}
+	f := newForwarder(newConfig(t, map[string]interface{}{"bufferSize": 2}), fake, hub)
+	// the events do not fit in the buffer until the forwarder starts
+	for i := 1; i <= 5; i++ {
+		hub.PublishStep(&state.Step{Id: i, FlowId: "i1"})
+	}
+	f.Start()
+	waitFor(t, func() bool { return len(fake.received()) == 2 })
+	hub.PublishStep(&state.Step{Id: 6, FlowId: "i1"})
+	waitFor(t, func() bool { return len(fake.received()) == 3 })
+	_ = f.Stop()
+	if stats := f.Stats(); stats.Missed != 3 || stats.Published != 3 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,350 @@
+package ingest
+
+import (
+	"errors"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/project-flogo/core/data/metadata"
+	"github.com/project-flogo/core/support/log"
+)
+
+var logCache = log.ChildLogger(log.RootLogger(), "flow-state.ingest")
+
+const (
+	DefaultQueueSize = 1000
+
+	// maxBatch bounds how many queued jobs a worker takes at once to order steps
+	maxBatch = 64
+
+	// retryDelay is the delay before a failed job is applied again, it doubles up to maxRetryDelay
+	retryDelay    = 100 * time.Millisecond
+	maxRetryDelay = 30 * time.Second
+)
+
+// permanentError is an error that applying the job again does not fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks an error of Apply as not retryable, such as a record that can not be decoded, the job is dropped
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// IsPermanent tells if the error was marked with Permanent
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+type Config struct {
+	Workers   int `md:"workers"`
+	QueueSize int `md:"queueSize"`
+}
+
+// NewConfig reads the ingestion settings, workers defaults to defaultWorkers
+func NewConfig(settings map[string]interface{}, defaultWorkers int) (*Config, error) {
+	cfg := &Config{}
+	if err := metadata.MapToStruct(settings, cfg, false); err != nil {
+		return nil, err
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = defaultWorkers
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = DefaultQueueSize
+	}
+	return cfg, nil
+}
+
+// Job is a unit of work for a flow instance
+type Job struct {
+	FlowId string
+	// Seq orders the steps of a flow, it is ignored for barriers
+	Seq int
+	// Barrier jobs, such as flow start and end, are never reordered with other jobs of the flow
+	Barrier bool
+	// Apply is retried with backoff until it succeeds or returns a Permanent error
+	Apply func() error
+	// Done is called once the job has been applied or dropped with a Permanent error, it is not called for the jobs
+	// given up when the pipeline is closed
+	Done func()
+}
+
+// Pipeline applies jobs on a fixed number of workers. Jobs of a flow always go to the
+// same worker so they are applied in submission order, except that steps of a flow
+// queued together are applied in Seq order. A job that fails is applied again, after a
+// delay, before the next jobs of its worker, so a store outage holds the jobs back in order.
+type Pipeline struct {
+	shards  []chan *Job
+	workers []*worker
+	wg      sync.WaitGroup
+
+	capacity  int
+	inFlight  int64
+	submitted uint64
+	processed uint64
+	dropped   uint64
+	retried   uint64
+	rejected  uint64
+	abandoned uint64
+
+	retryDelay    time.Duration
+	maxRetryDelay time.Duration
+
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+type worker struct {
+	id        int
+	processed uint64
+	dropped   uint64
+	retried   uint64
+	// abandoned is set once a job is given up on close, the jobs after it are not applied to keep the flows in order
+	abandoned bool
+
+	mu      sync.Mutex
+	last    time.Duration
+	max     time.Duration
+	total   time.Duration
+	applied uint64
+}
+
+func New(cfg *Config) *Pipeline {
+	perShard := cfg.QueueSize / cfg.Workers
+	if perShard <= 0 {
+		perShard = 1
+	}
+
+	p := &Pipeline{
+		capacity:      perShard * cfg.Workers,
+		retryDelay:    retryDelay,
+		maxRetryDelay: maxRetryDelay,
+		closing:       make(chan struct{}),
+	}
+	for i := 0; i < cfg.Workers; i++ {
+		shard := make(chan *Job, perShard)
+		w := &worker{id: i}
+		p.shards = append(p.shards, shard)
+		p.workers = append(p.workers, w)
+		p.wg.Add(1)
+		go p.run(w, shard)
+	}
+	logCache.Debugf("Started ingestion pipeline with %d workers and queue size %d", cfg.Workers, p.capacity)
+	return p
+}
+
+// Submit queues a job, it blocks while the queue of the flow's worker is full
+func (p *Pipeline) Submit(job *Job) {
+	atomic.AddUint64(&p.submitted, 1)
+	p.shards[p.shard(job.FlowId)] <- job
+}
+
+// Reject records a job that was refused before reaching the pipeline
+func (p *Pipeline) Reject() {
+	atomic.AddUint64(&p.rejected, 1)
+}
+
+// Close stops accepting jobs and waits for queued jobs to be applied. The jobs failing by then are given
+// up without calling Done, with the jobs queued after them on their worker.
+func (p *Pipeline) Close() {
+	p.closeOnce.Do(func() {
+		close(p.closing)
+		for _, shard := range p.shards {
+			close(shard)
+		}
+	})
+	p.wg.Wait()
+}
+
+func (p *Pipeline) shard(flowId string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(flowId))
+	return int(h.Sum32() % uint32(len(p.shards)))
+}
+
+func (p *Pipeline) run(w *worker, shard chan *Job) {
+	defer p.wg.Done()
+
+	for job := range shard {
+		batch := []*Job{job}
+	drain:
+		for len(batch) < maxBatch {
+			select {
+			case next, ok := <-shard:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+		atomic.AddInt64(&p.inFlight, int64(len(batch)))
+
+		orderSteps(batch)
+		for _, job := range batch {
+			p.apply(w, job)
+		}
+	}
+}
+
+func (p *Pipeline) apply(w *worker, job *Job) {
+	defer atomic.AddInt64(&p.inFlight, -1)
+	if w.abandoned {
+		atomic.AddUint64(&p.abandoned, 1)
+		return
+	}
+
+	delay := p.retryDelay
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := job.Apply()
+		w.observe(time.Since(start))
+
+		switch {
+		case err == nil:
+			atomic.AddUint64(&w.processed, 1)
+			atomic.AddUint64(&p.processed, 1)
+		case IsPermanent(err):
+			atomic.AddUint64(&w.dropped, 1)
+			atomic.AddUint64(&p.dropped, 1)
+			logCache.Errorf("Dropping record of flow [%s], %s", job.FlowId, err.Error())
+		default:
+			if attempt == 1 || attempt%10 == 0 {
+				logCache.Warnf("Could not apply record of flow [%s] after %d attempts, retrying in %s, %s", job.FlowId, attempt, delay, err.Error())
+			}
+			select {
+			case <-time.After(delay):
+				atomic.AddUint64(&w.retried, 1)
+				atomic.AddUint64(&p.retried, 1)
+				if delay *= 2; delay > p.maxRetryDelay {
+					delay = p.maxRetryDelay
+				}
+				continue
+			case <-p.closing:
+				w.abandoned = true
+				atomic.AddUint64(&p.abandoned, 1)
+				logCache.Warnf("Giving up record of flow [%s] on close, it is applied again on restart, %s", job.FlowId, err.Error())
+				return
+			}
+		}
+		break
+	}
+	if job.Done != nil {
+		job.Done()
+	}
+}
+
+// orderSteps sorts the steps of each flow by Seq without moving them across barriers of that flow
+func orderSteps(batch []*Job) {
+	for i := 1; i < len(batch); i++ {
+		job := batch[i]
+		if job.Barrier {
+			continue
+		}
+		j := i
+		for k := i - 1; k >= 0; k-- {
+			prev := batch[k]
+			if prev.FlowId != job.FlowId {
+				continue
+			}
+			if prev.Barrier || prev.Seq <= job.Seq {
+				break
+			}
+			j = k
+		}
+		if j < i {
+			copy(batch[j+1:i+1], batch[j:i])
+			batch[j] = job
+		}
+	}
+}
+
+func (w *worker) observe(latency time.Duration) {
+	w.mu.Lock()
+	w.last = latency
+	w.total += latency
+	w.applied++
+	if latency > w.max {
+		w.max = latency
+	}
+	w.mu.Unlock()
+}
+
+type Stats struct {
+	Workers       int            `json:"workers"`
+	QueueDepth    int            `json:"queueDepth"`
+	QueueCapacity int            `json:"queueCapacity"`
+	InFlight      int64          `json:"inFlight"`
+	Submitted     uint64         `json:"submitted"`
+	Processed     uint64         `json:"processed"`
+	Dropped       uint64         `json:"dropped"`
+	Retried       uint64         `json:"retried"`
+	Rejected      uint64         `json:"rejected"`
+	Abandoned     uint64         `json:"abandoned"`
+	WorkerStats   []*WorkerStats `json:"workerStats"`
+}
+
+type WorkerStats struct {
+	Id            int     `json:"id"`
+	QueueDepth    int     `json:"queueDepth"`
+	Processed     uint64  `json:"processed"`
+	Dropped       uint64  `json:"dropped"`
+	Retried       uint64  `json:"retried"`
+	LastLatencyMs float64 `json:"lastLatencyMs"`
+	AvgLatencyMs  float64 `json:"avgLatencyMs"`
+	MaxLatencyMs  float64 `json:"maxLatencyMs"`
+}
+
+func (p *Pipeline) Stats() *Stats {
+	stats := &Stats{
+		Workers:       len(p.workers),
+		QueueCapacity: p.capacity,
+		InFlight:      atomic.LoadInt64(&p.inFlight),
+		Submitted:     atomic.LoadUint64(&p.submitted),
+		Processed:     atomic.LoadUint64(&p.processed),
+		Dropped:       atomic.LoadUint64(&p.dropped),
+		Retried:       atomic.LoadUint64(&p.retried),
+		Rejected:      atomic.LoadUint64(&p.rejected),
+		Abandoned:     atomic.LoadUint64(&p.abandoned),
+	}
+	for i, w := range p.workers {
+		ws := &WorkerStats{
+			Id:         w.id,
+			QueueDepth: len(p.shards[i]),
+			Processed:  atomic.LoadUint64(&w.processed),
+			Dropped:    atomic.LoadUint64(&w.dropped),
+			Retried:    atomic.LoadUint64(&w.retried),
+		}
+		w.mu.Lock()
+		ws.LastLatencyMs = milliseconds(w.last)
+		ws.MaxLatencyMs = milliseconds(w.max)
+		if w.applied > 0 {
+			ws.AvgLatencyMs = milliseconds(w.total / time.Duration(w.applied))
+		}
+		w.mu.Unlock()
+		stats.QueueDepth += ws.QueueDepth
+		stats.WorkerStats = append(stats.WorkerStats, ws)
+	}
+	return stats
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,159 @@
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOrderSteps(t *testing.T) {
+	job := func(flowId string, seq int, barrier bool) *Job {
+		return &Job{FlowId: flowId, Seq: seq, Barrier: barrier}
+	}
+	batch := []*Job{
+		job("a", 0, true), job("a", 3, false), job("b", 2, false), job("a", 1, false),
+		job("b", 1, false), job("a", 2, false), job("a", 0, true), job("a", 0, false),
+	}
+	orderSteps(batch)
+
+	var order []string
+	for _, j := range batch {
+		kind := "step"
+		if j.Barrier {
+			kind = "barrier"
+		}
+		order = append(order, fmt.Sprintf("%s-%s-%d", j.FlowId, kind, j.Seq))
+	}
+	expected := "[a-barrier-0 a-step-1 a-step-2 a-step-3 b-step-1 b-step-2 a-barrier-0 a-step-0]"
+	if fmt.Sprint(order) != expected {
+		t.Fatalf("unexpected order %v", order)
+	}
+}
+
+func TestPipelineFlowOrder(t *testing.T) {
+	p := New(&Config{Workers: 4, QueueSize: 8})
+
+	var mu sync.Mutex
+	applied := make(map[string][]int)
+	for step := 0; step < 50; step++ {
+		for _, flowId := range []string{"f1", "f2", "f3", "f4", "f5"} {
+			flowId, step := flowId, step
+			p.Submit(&Job{FlowId: flowId, Seq: step, Apply: func() error {
+				mu.Lock()
+				applied[flowId] = append(applied[flowId], step)
+				mu.Unlock()
+				if flowId == "f5" && step == 0 {
+					return Permanent(errors.New("failed"))
+				}
+				return nil
+			}})
+		}
+	}
+	p.Reject()
+	p.Close()
+
+	for flowId, steps := range applied {
+		for i, step := range steps {
+			if step != i {
+				t.Fatalf("flow %s applied out of order: %v", flowId, steps)
+			}
+		}
+	}
+
+	stats := p.Stats()
+	if stats.Submitted != 250 || stats.Processed != 249 || stats.Dropped != 1 || stats.Rejected != 1 || stats.InFlight != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if len(stats.WorkerStats) != 4 || stats.QueueCapacity != 8 {
+		t.Fatalf("unexpected worker stats %+v", stats)
+	}
+}
+
+func TestPipelineRetry(t *testing.T) {
+	p := New(&Config{Workers: 1, QueueSize: 8})
+	p.retryDelay, p.maxRetryDelay = time.Millisecond, 2*time.Millisecond
+
+	// the store is unavailable for the first attempts, the jobs after the failing one wait for it
+	var mu sync.Mutex
+	var applied []int
+	var done int
+	failures := 3
+	for step := 1; step <= 3; step++ {
+		step := step
+		p.Submit(&Job{FlowId: "f1", Seq: step, Apply: func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			if step == 1 && failures > 0 {
+				failures--
+				return errors.New("connection refused")
+			}
+			applied = append(applied, step)
+			return nil
+		}, Done: func() {
+			mu.Lock()
+			done++
+			mu.Unlock()
+		}})
+	}
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		mu.Lock()
+		n := done
+		mu.Unlock()
+		if n == 3 {
+			break
+		}
+	}
+	p.Close()
+
+	if fmt.Sprint(applied) != "[1 2 3]" || done != 3 {
+		t.Fatalf("expected every job applied in order and acknowledged, got %v and %d done", applied, done)
+	}
+	if stats := p.Stats(); stats.Processed != 3 || stats.Retried != 3 || stats.Dropped != 0 || stats.WorkerStats[0].Retried != 3 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestPipelineCloseWhileFailing(t *testing.T) {
+	p := New(&Config{Workers: 1, QueueSize: 8})
+	p.retryDelay, p.maxRetryDelay = time.Millisecond, time.Millisecond
+
+	var mu sync.Mutex
+	attempts := 0
+	done := 0
+	for step := 1; step <= 2; step++ {
+		step := step
+		p.Submit(&Job{FlowId: "f1", Seq: step, Apply: func() error {
+			mu.Lock()
+			defer mu.Unlock()
+			if step == 1 {
+				attempts++
+				return errors.New("connection refused")
+			}
+			return nil
+		}, Done: func() {
+			mu.Lock()
+			done++
+			mu.Unlock()
+		}})
+	}
+	for {
+		mu.Lock()
+		n := attempts
+		mu.Unlock()
+		if n > 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	p.Close()
+
+	// neither the failing job nor the job after it is acknowledged, both are replayed from the spool
+	if done != 0 {
+		t.Fatalf("expected no job to be acknowledged, got %d", done)
+	}
+	if stats := p.Stats(); stats.Abandoned != 2 || stats.Processed != 0 || stats.Dropped != 0 || stats.InFlight != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
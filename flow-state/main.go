@@ -3,16 +3,23 @@ package main
 import (
 	"encoding/json"
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"os/signal"
 	"syscall"
 
+	"github.com/project-flogo/core/data/coerce"
 	"github.com/project-flogo/core/support/log"
 	"github.com/project-flogo/core/support/service"
+	"github.com/project-flogo/services/flow-state/server/grpc"
 	"github.com/project-flogo/services/flow-state/server/rest"
+	"github.com/project-flogo/services/flow-state/store"
+	"github.com/project-flogo/services/flow-state/store/postgres"
 )
 
+const SettingGrpc = "grpc"
+
 var port = flag.String("p", "", "The port of the server")
 
 func init() {
@@ -23,36 +30,16 @@ func main() {
 
 	logger := log.ChildLogger(log.RootLogger(), "FlowStateService")
 
-	configPath := os.Getenv("FLOGO_STATE_CONFIG")
-	if len(configPath) <= 0 {
-		configPath = "config.json"
+	settings, err := loadConfig()
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
 
-	var settings map[string]interface{}
-	if _, err := os.Stat(configPath); err == nil {
-		flogo, err := os.Open(configPath)
-		if err != nil {
-			logger.Errorf("open configuration file error: %s", err.Error())
-			os.Exit(1)
-		}
-
-		jsonBytes, err := ioutil.ReadAll(flogo)
-		if err != nil {
-			logger.Errorf("open configuration file error: %s", err.Error())
-			os.Exit(1)
-		}
-
-		err = json.Unmarshal(jsonBytes, &settings)
-		if err != nil {
-			logger.Errorf("unrecongnized configuration file: %s", err.Error())
-			os.Exit(1)
-		}
-	} else {
-		if err != nil {
-			logger.Errorf("Configuration file [%s] for Persistence component not found", configPath)
-			os.Exit(1)
-		}
+	if flag.Arg(0) == "migrate" {
+		os.Exit(migrate(logger, settings))
 	}
+
 	// honour the flag value for port
 	if *port != "" {
 		settings["port"] = *port
@@ -79,17 +66,82 @@ func main() {
 		os.Exit(1)
 	}
 
+	// the gRPC server shares the persistence initialized by the REST service, and its credentials
+	var gs service.Service
+	if grpcSettings, _ := coerce.ToObject(settings[SettingGrpc]); len(grpcSettings) > 0 {
+		grpcSettings[grpc.Persistence] = settings[rest.Persistence]
+		grpcSettings[grpc.SettingAuth] = settings[rest.SettingAuth]
+		gs, err = (&grpc.StateServiceFactory{}).NewService(&service.Config{Settings: grpcSettings})
+		if err == nil {
+			err = gs.Start()
+		}
+		if err != nil {
+			logger.Errorf("Failed to start Flow State Manager gRPC server: %v\n", err)
+			_ = s.Stop()
+			os.Exit(1)
+		}
+	}
+
 	logger.Info("TIBCO Flogo Flow State Manager Started Successfully")
 
 	exitChan := setupSignalHandling()
 
 	code := <-exitChan
 
+	if gs != nil {
+		_ = gs.Stop()
+	}
 	_ = s.Stop()
 
 	os.Exit(code)
 }
 
+func loadConfig() (map[string]interface{}, error) {
+	configPath := os.Getenv("FLOGO_STATE_CONFIG")
+	if len(configPath) <= 0 {
+		configPath = "config.json"
+	}
+
+	if _, err := os.Stat(configPath); err != nil {
+		return nil, fmt.Errorf("Configuration file [%s] for Persistence component not found", configPath)
+	}
+
+	flogo, err := os.Open(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("open configuration file error: %s", err.Error())
+	}
+	defer flogo.Close()
+
+	jsonBytes, err := ioutil.ReadAll(flogo)
+	if err != nil {
+		return nil, fmt.Errorf("open configuration file error: %s", err.Error())
+	}
+
+	var settings map[string]interface{}
+	err = json.Unmarshal(jsonBytes, &settings)
+	if err != nil {
+		return nil, fmt.Errorf("unrecongnized configuration file: %s", err.Error())
+	}
+	return settings, nil
+}
+
+// migrate upgrades the postgres schema to the latest version and exits
+func migrate(logger log.Logger, settings map[string]interface{}) int {
+	persistenceSettings, _ := coerce.ToObject(settings[rest.Persistence])
+	if persistenceSettings["type"] != store.Postgres {
+		logger.Errorf("Schema migration is only supported for persistence type [%s]", store.Postgres)
+		return 1
+	}
+
+	version, err := postgres.MigrateSchema(persistenceSettings)
+	if err != nil {
+		logger.Errorf("Schema migration failed: %v", err)
+		return 1
+	}
+	logger.Infof("Database schema is at version %s", version)
+	return 0
+}
+
 func setupSignalHandling() chan int {
 
 	signalChan := make(chan os.Signal, 1)
@@ -0,0 +1,378 @@
+package retention
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/project-flogo/core/data/metadata"
+	"github.com/project-flogo/core/support/log"
+	flowEvent "github.com/project-flogo/flow/support/event"
+)
+
+var logCache = log.ChildLogger(log.RootLogger(), "flow-state.retention")
+
+const (
+	DefaultInterval  = 3600
+	DefaultBatchSize = 500
+)
+
+var agePattern = regexp.MustCompile(`^(\d+)\s*(s|m|h|d|w)$`)
+
+// finalStatuses are the statuses of the ended instances
+var finalStatuses = []string{flowEvent.COMPLETED, flowEvent.FAILED, flowEvent.CANCELLED}
+
+// Rule purges the flow instances matching Status, App and Flow that are older than MaxAge,
+// or that exceed the MaxCount newest instances of their app and flow
+type Rule struct {
+	Name     string `json:"name,omitempty"`
+	Status   string `json:"status,omitempty"`
+	App      string `json:"app,omitempty"`
+	Flow     string `json:"flow,omitempty"`
+	MaxAge   string `json:"maxAge,omitempty"`
+	MaxCount int    `json:"maxCount,omitempty"`
+
+	maxAge time.Duration
+}
+
+func (r *Rule) String() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	parts := []string{}
+	for _, p := range []struct{ key, value string }{{"status", r.Status}, {"app", r.App}, {"flow", r.Flow}, {"maxAge", r.MaxAge}} {
+		if p.value != "" {
+			parts = append(parts, p.key+"="+p.value)
+		}
+	}
+	if r.MaxCount > 0 {
+		parts = append(parts, "maxCount="+strconv.Itoa(r.MaxCount))
+	}
+	return strings.Join(parts, ",")
+}
+
+func (r *Rule) filter() *Filter {
+	return &Filter{Status: r.Status, App: r.App, Flow: r.Flow}
+}
+
+// purgesRunning reports whether the rule selects running instances, which it only does when it targets a status
+// that is not final, such as Active
+func (r *Rule) purgesRunning() bool {
+	if r.Status == "" {
+		return false
+	}
+	for _, status := range finalStatuses {
+		if strings.EqualFold(r.Status, status) {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseAge reads a retention age such as 30d, 12h or 2w
+func ParseAge(value string) (time.Duration, error) {
+	m := agePattern.FindStringSubmatch(strings.TrimSpace(value))
+	if m == nil {
+		return 0, fmt.Errorf("invalid age [%s], expected a number followed by s, m, h, d or w", value)
+	}
+	n, _ := strconv.Atoi(m[1])
+	unit := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour, "d": 24 * time.Hour, "w": 7 * 24 * time.Hour}[m[2]]
+	return time.Duration(n) * unit, nil
+}
+
+type Config struct {
+	// Interval is the time between two purges in seconds
+	Interval int
+	// BatchSize is the number of instances deleted at once
+	BatchSize int
+	Rules     []*Rule
+}
+
+type configSettings struct {
+	Interval  int `md:"interval"`
+	BatchSize int `md:"batchSize"`
+}
+
+// NewConfig reads the retention settings, it returns nil when no rule is configured
+func NewConfig(settings map[string]interface{}) (*Config, error) {
+	if len(settings) == 0 {
+		return nil, nil
+	}
+	s := &configSettings{}
+	if err := metadata.MapToStruct(settings, s, false); err != nil {
+		return nil, err
+	}
+	cfg := &Config{Interval: s.Interval, BatchSize: s.BatchSize}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+
+	if rules, set := settings["rules"]; set {
+		b, err := json.Marshal(rules)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &cfg.Rules); err != nil {
+			return nil, fmt.Errorf("invalid retention rules, %s", err.Error())
+		}
+	}
+	if len(cfg.Rules) == 0 {
+		return nil, nil
+	}
+
+	for _, rule := range cfg.Rules {
+		if rule.MaxAge == "" && rule.MaxCount <= 0 {
+			return nil, fmt.Errorf("retention rule [%s] needs maxAge or maxCount", rule)
+		}
+		if rule.MaxAge != "" {
+			age, err := ParseAge(rule.MaxAge)
+			if err != nil {
+				return nil, err
+			}
+			rule.maxAge = age
+		}
+	}
+	return cfg, nil
+}
+
+// Instance is a flow instance considered for retention, Time is its end time or its start time while it runs
+type Instance struct {
+	FlowInstanceId string
+	AppName        string
+	FlowName       string
+	Status         string
+	Time           time.Time
+	// Running is set for the instances that were started and have not ended
+	Running bool
+}
+
+// Filter selects the instances of a rule, empty fields match all instances
+type Filter struct {
+	Status string
+	App    string
+	Flow   string
+}
+
+// Matches reports whether the instance is selected by the filter
+func (f *Filter) Matches(instance *Instance) bool {
+	return (f.Status == "" || strings.EqualFold(f.Status, instance.Status)) &&
+		(f.App == "" || f.App == instance.AppName) &&
+		(f.Flow == "" || f.Flow == instance.FlowName)
+}
+
+// Store is implemented by stores whose flow instances can be purged
+type Store interface {
+	// RetentionInstances lists the instances matching the filter, the status is compared case insensitively
+	RetentionInstances(filter *Filter) ([]*Instance, error)
+	// PurgeInstances deletes the instances with their steps and snapshots and returns the number of deleted rows
+	PurgeInstances(flowIds []string) (int64, error)
+}
+
+// RuleReport is the outcome of a rule, instances already purged by an earlier rule are not counted again
+type RuleReport struct {
+	Rule    string `json:"rule"`
+	Matched int    `json:"matched"`
+	Expired int    `json:"expired"`
+	Excess  int    `json:"excess"`
+	Purged  int    `json:"purged"`
+	flowIds []string
+}
+
+// Report describes a retention run, with DryRun set nothing was deleted
+type Report struct {
+	DryRun    bool          `json:"dryRun"`
+	Time      time.Time     `json:"time"`
+	Instances int           `json:"instances"`
+	Rows      int64         `json:"rows"`
+	Rules     []*RuleReport `json:"rules"`
+}
+
+// Stats are the totals of the purges since the janitor was started
+type Stats struct {
+	Runs            int64            `json:"runs"`
+	LastRun         time.Time        `json:"lastRun,omitempty"`
+	LastDurationMs  int64            `json:"lastDurationMs"`
+	LastError       string           `json:"lastError,omitempty"`
+	InstancesPurged int64            `json:"instancesPurged"`
+	RowsPurged      int64            `json:"rowsPurged"`
+	RulePurged      map[string]int64 `json:"rulePurged"`
+}
+
+// Janitor periodically purges the instances selected by the retention rules
+type Janitor struct {
+	cfg   *Config
+	store Store
+
+	runMu sync.Mutex
+	mu    sync.Mutex
+	stats Stats
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewJanitor(cfg *Config, store Store) *Janitor {
+	return &Janitor{cfg: cfg, store: store, stats: Stats{RulePurged: map[string]int64{}}, done: make(chan struct{})}
+}
+
+// Start runs a purge every interval until Stop is called
+func (j *Janitor) Start() {
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		ticker := time.NewTicker(time.Duration(j.cfg.Interval) * time.Second)
+		defer ticker.Stop()
+		for {
+			if _, err := j.Run(); err != nil {
+				logCache.Errorf("Retention purge failed, %s", err.Error())
+			}
+			select {
+			case <-j.done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (j *Janitor) Stop() {
+	select {
+	case <-j.done:
+	default:
+		close(j.done)
+	}
+	j.wg.Wait()
+}
+
+// Plan reports the instances the next run would purge without deleting them
+func (j *Janitor) Plan() (*Report, error) {
+	return j.plan(time.Now())
+}
+
+// Run purges the instances selected by the rules
+func (j *Janitor) Run() (*Report, error) {
+	j.runMu.Lock()
+	defer j.runMu.Unlock()
+
+	start := time.Now()
+	report, err := j.plan(start)
+	if err == nil {
+		err = j.purge(report)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.stats.Runs++
+	j.stats.LastRun = start
+	j.stats.LastDurationMs = time.Since(start).Milliseconds()
+	j.stats.LastError = ""
+	if err != nil {
+		j.stats.LastError = err.Error()
+	}
+	if report != nil {
+		j.stats.RowsPurged += report.Rows
+		for _, r := range report.Rules {
+			j.stats.InstancesPurged += int64(r.Purged)
+			j.stats.RulePurged[r.Rule] += int64(r.Purged)
+		}
+		if report.Instances > 0 {
+			logCache.Infof("Retention purged %d flow instances and %d rows", report.Instances, report.Rows)
+		}
+	}
+	return report, err
+}
+
+func (j *Janitor) Stats() *Stats {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	stats := j.stats
+	stats.RulePurged = make(map[string]int64, len(j.stats.RulePurged))
+	for k, v := range j.stats.RulePurged {
+		stats.RulePurged[k] = v
+	}
+	return &stats
+}
+
+func (j *Janitor) plan(now time.Time) (*Report, error) {
+	report := &Report{DryRun: true, Time: now}
+	claimed := make(map[string]bool)
+
+	for _, rule := range j.cfg.Rules {
+		filter := rule.filter()
+		instances, err := j.store.RetentionInstances(filter)
+		if err != nil {
+			return nil, fmt.Errorf("Could not list flow instances for retention rule [%s], %s", rule, err.Error())
+		}
+
+		ruleReport := &RuleReport{Rule: rule.String()}
+		report.Rules = append(report.Rules, ruleReport)
+
+		groups := make(map[string][]*Instance)
+		for _, instance := range instances {
+			if !filter.Matches(instance) || (instance.Running && !rule.purgesRunning()) {
+				continue
+			}
+			ruleReport.Matched++
+			key := instance.AppName + "\x00" + instance.FlowName
+			groups[key] = append(groups[key], instance)
+		}
+
+		for _, group := range groups {
+			// newest first, so the count limit keeps the most recent instances
+			sort.SliceStable(group, func(a, b int) bool { return group[a].Time.After(group[b].Time) })
+			for i, instance := range group {
+				expired := rule.maxAge > 0 && now.Sub(instance.Time) > rule.maxAge
+				excess := rule.MaxCount > 0 && i >= rule.MaxCount
+				if (!expired && !excess) || claimed[instance.FlowInstanceId] {
+					continue
+				}
+				claimed[instance.FlowInstanceId] = true
+				if expired {
+					ruleReport.Expired++
+				} else {
+					ruleReport.Excess++
+				}
+				ruleReport.Purged++
+				ruleReport.flowIds = append(ruleReport.flowIds, instance.FlowInstanceId)
+			}
+		}
+		report.Instances += ruleReport.Purged
+	}
+	return report, nil
+}
+
+// purge deletes the planned instances in batches, on error the report only counts the deleted ones
+func (j *Janitor) purge(report *Report) error {
+	report.DryRun = false
+	report.Instances = 0
+	var err error
+	for _, rule := range report.Rules {
+		ids := rule.flowIds
+		rule.Purged = 0
+		for err == nil && len(ids) > 0 {
+			n := j.cfg.BatchSize
+			if n > len(ids) {
+				n = len(ids)
+			}
+			var rows int64
+			rows, err = j.store.PurgeInstances(ids[:n])
+			if err != nil {
+				err = fmt.Errorf("Could not purge flow instances, %s", err.Error())
+				break
+			}
+			report.Rows += rows
+			rule.Purged += n
+			ids = ids[n:]
+		}
+		report.Instances += rule.Purged
+	}
+	return err
+}
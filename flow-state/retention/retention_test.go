@@ -0,0 +1,189 @@
+package retention
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+type testStore struct {
+	instances map[string]*Instance
+	purged    [][]string
+}
+
+func (s *testStore) RetentionInstances(filter *Filter) ([]*Instance, error) {
+	var instances []*Instance
+	for _, instance := range s.instances {
+		if filter.Matches(instance) {
+			instances = append(instances, instance)
+		}
+	}
+	return instances, nil
+}
+
+func (s *testStore) PurgeInstances(flowIds []string) (int64, error) {
+	s.purged = append(s.purged, flowIds)
+	for _, id := range flowIds {
+		delete(s.instances, id)
+	}
+	return int64(len(flowIds)) * 3, nil
+}
+
+func newTestStore(now time.Time) *testStore {
+	s := &testStore{instances: map[string]*Instance{}}
+	add := func(id, app, status string, age time.Duration) {
+		s.instances[id] = &Instance{FlowInstanceId: id, AppName: app, FlowName: "flow", Status: status, Time: now.Add(-age)}
+	}
+	add("c1", "orders", "Completed", time.Hour)
+	add("c2", "orders", "Completed", 2*time.Hour)
+	add("c3", "orders", "Completed", 3*time.Hour)
+	add("c4", "orders", "completed", 4*time.Hour)
+	add("f1", "orders", "Failed", 10*24*time.Hour)
+	add("f2", "orders", "Failed", time.Hour)
+	add("b1", "billing", "Completed", 5*time.Hour)
+	return s
+}
+
+func TestNewConfig(t *testing.T) {
+	cfg, err := NewConfig(nil)
+	if err != nil || cfg != nil {
+		t.Fatalf("expected no config without rules, got %v, %v", cfg, err)
+	}
+
+	cfg, err = NewConfig(map[string]interface{}{"rules": []interface{}{map[string]interface{}{"status": "Failed", "maxAge": "7d"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Interval != DefaultInterval || cfg.BatchSize != DefaultBatchSize || cfg.Rules[0].maxAge != 7*24*time.Hour {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+
+	if _, err = NewConfig(map[string]interface{}{"rules": []interface{}{map[string]interface{}{"status": "Failed"}}}); err == nil {
+		t.Fatal("expected an error for a rule without limit")
+	}
+	if _, err = NewConfig(map[string]interface{}{"rules": []interface{}{map[string]interface{}{"maxAge": "7 days"}}}); err == nil {
+		t.Fatal("expected an error for an invalid age")
+	}
+}
+
+func TestPlanAndRun(t *testing.T) {
+	now := time.Now()
+	s := newTestStore(now)
+	cfg, err := NewConfig(map[string]interface{}{
+		"batchSize": 2,
+		"rules": []interface{}{
+			map[string]interface{}{"name": "failed", "status": "failed", "maxAge": "7d"},
+			map[string]interface{}{"name": "completed", "status": "COMPLETED", "maxCount": 2},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := NewJanitor(cfg, s)
+
+	report, err := j.Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.DryRun || report.Instances != 3 || len(s.purged) != 0 {
+		t.Fatalf("unexpected plan %+v", report)
+	}
+	failed, completed := report.Rules[0], report.Rules[1]
+	if failed.Matched != 2 || failed.Expired != 1 || failed.Purged != 1 {
+		t.Fatalf("unexpected failed rule report %+v", failed)
+	}
+	// the count limit applies per app and flow, billing keeps its only instance
+	if completed.Matched != 5 || completed.Excess != 2 || completed.Purged != 2 {
+		t.Fatalf("unexpected completed rule report %+v", completed)
+	}
+	ids := append([]string{}, completed.flowIds...)
+	sort.Strings(ids)
+	if ids[0] != "c3" || ids[1] != "c4" {
+		t.Fatalf("expected the oldest instances to be purged, got %v", ids)
+	}
+
+	report, err = j.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.DryRun || report.Instances != 3 || report.Rows != 9 {
+		t.Fatalf("unexpected run %+v", report)
+	}
+	if len(s.instances) != 4 || len(s.purged) != 2 {
+		t.Fatalf("expected 3 instances purged in 2 batches, %d left in %d batches", len(s.instances), len(s.purged))
+	}
+
+	stats := j.Stats()
+	if stats.Runs != 1 || stats.InstancesPurged != 3 || stats.RowsPurged != 9 || stats.RulePurged["completed"] != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestRunningInstances(t *testing.T) {
+	now := time.Now()
+	s := newTestStore(now)
+	s.instances["r1"] = &Instance{FlowInstanceId: "r1", AppName: "orders", FlowName: "flow", Status: "Active", Time: now.Add(-30 * 24 * time.Hour), Running: true}
+	s.instances["r2"] = &Instance{FlowInstanceId: "r2", AppName: "orders", FlowName: "flow", Status: "Active", Time: now.Add(-time.Minute), Running: true}
+	cfg, err := NewConfig(map[string]interface{}{
+		"rules": []interface{}{
+			map[string]interface{}{"name": "all", "maxAge": "7d", "maxCount": 1},
+			map[string]interface{}{"name": "completed", "status": "Completed", "maxAge": "7d"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := NewJanitor(cfg, s).Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rule := range report.Rules {
+		for _, id := range rule.flowIds {
+			if id == "r1" || id == "r2" {
+				t.Fatalf("expected the running instances to be kept by rule %s, got %v", rule.Rule, rule.flowIds)
+			}
+		}
+	}
+	// the running instances do not count against maxCount either
+	if all := report.Rules[0]; all.Matched != 7 || all.Purged != 5 {
+		t.Fatalf("unexpected rule report %+v", all)
+	}
+
+	// a rule for a status that is not final purges the running instances by their start time
+	cfg.Rules = []*Rule{{Name: "stuck", Status: "active", MaxAge: "7d", maxAge: 7 * 24 * time.Hour}}
+	report, err = NewJanitor(cfg, s).Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stuck := report.Rules[0]; stuck.Matched != 2 || stuck.Purged != 1 || stuck.flowIds[0] != "r1" {
+		t.Fatalf("expected the instance running for 30 days to be purged, got %+v", stuck)
+	}
+}
+
+func TestOverlappingRules(t *testing.T) {
+	now := time.Now()
+	s := newTestStore(now)
+	cfg, err := NewConfig(map[string]interface{}{
+		"rules": []interface{}{
+			map[string]interface{}{"name": "old", "maxAge": "150m"},
+			map[string]interface{}{"name": "completed", "status": "Completed", "maxCount": 2},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := NewJanitor(cfg, s).Plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, completed := report.Rules[0], report.Rules[1]
+	if old.Expired != 4 || old.Purged != 4 {
+		t.Fatalf("unexpected old rule report %+v", old)
+	}
+	// c3 and c4 are already purged by the old rule
+	if completed.Matched != 5 || completed.Excess != 0 || completed.Purged != 0 || report.Instances != 4 {
+		t.Fatalf("unexpected completed rule report %+v", completed)
+	}
+}
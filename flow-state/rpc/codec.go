@@ -0,0 +1,31 @@
+package rpc
+
+import (
+	"encoding/json"
+
+	"google.golang.org/grpc/encoding"
+)
+
+// CodecName is the content subtype of the state service, messages are sent in
+// the same JSON form the REST API uses
+const CodecName = "json"
+
+func init() {
+	encoding.RegisterCodec(Codec{})
+}
+
+// Codec marshals gRPC messages as JSON
+type Codec struct {
+}
+
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (Codec) Name() string {
+	return CodecName
+}
@@ -0,0 +1,360 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/project-flogo/flow/state"
+	"github.com/project-flogo/services/flow-state/store/metadata"
+	"google.golang.org/grpc"
+)
+
+const ServiceName = "flogo.state.v1.StateService"
+
+// Empty is the reply of the record calls
+type Empty struct {
+}
+
+// InstanceRequest selects a flow instance, the optional fields restrict GetInstance to a user or app
+type InstanceRequest struct {
+	FlowId     string `json:"flowId"`
+	Username   string `json:"username,omitempty"`
+	AppName    string `json:"appName,omitempty"`
+	AppVersion string `json:"appVersion,omitempty"`
+	HostId     string `json:"hostId,omitempty"`
+}
+
+// InstancesRequest filters the flow instances, it carries the query parameters of GET /v1/instances
+type InstancesRequest struct {
+	Username       string `json:"username"`
+	AppName        string `json:"appName"`
+	AppVersion     string `json:"appVersion"`
+	HostId         string `json:"hostId,omitempty"`
+	FlowName       string `json:"flowName,omitempty"`
+	Status         string `json:"status,omitempty"`
+	FlowInstanceId string `json:"flowInstanceId,omitempty"`
+	Interval       string `json:"interval,omitempty"`
+	StartTime      string `json:"startTime,omitempty"`
+	EndTime        string `json:"endTime,omitempty"`
+	Offset         string `json:"offset,omitempty"`
+	Limit          string `json:"limit,omitempty"`
+}
+
+// Metadata converts the request to the store filter
+func (r *InstancesRequest) Metadata() *metadata.Metadata {
+	return &metadata.Metadata{
+		Username:       r.Username,
+		AppName:        r.AppName,
+		AppVersion:     r.AppVersion,
+		HostId:         r.HostId,
+		FlowName:       r.FlowName,
+		Status:         r.Status,
+		FlowInstanceId: r.FlowInstanceId,
+		Interval:       r.Interval,
+		StartTime:      r.StartTime,
+		EndTime:        r.EndTime,
+		Offset:         r.Offset,
+		Limit:          r.Limit,
+	}
+}
+
+// InstancesResponse is a page of flow instances and the total number of matching instances
+type InstancesResponse struct {
+	Count     int32             `json:"count"`
+	Instances []*state.FlowInfo `json:"instances"`
+}
+
+// SnapshotRequest selects the snapshot of a flow instance, or the snapshot after StepId when it is set
+type SnapshotRequest struct {
+	FlowId string `json:"flowId"`
+	StepId *int   `json:"stepId,omitempty"`
+}
+
+type StatusResponse struct {
+	Status int `json:"status"`
+}
+
+type StepsResponse struct {
+	Steps []*state.Step `json:"steps"`
+}
+
+type StepsStatusResponse struct {
+	Steps []map[string]string `json:"steps"`
+}
+
+// RecordStepsResponse reports the number of steps saved by a RecordSteps stream
+type RecordStepsResponse struct {
+	Count int `json:"count"`
+}
+
+// StateServer is the server API of the state service
+type StateServer interface {
+	RecordStart(context.Context, *state.FlowState) (*Empty, error)
+	RecordStep(context.Context, *state.Step) (*Empty, error)
+	RecordSnapshot(context.Context, *state.Snapshot) (*Empty, error)
+	RecordEnd(context.Context, *state.FlowState) (*Empty, error)
+	RecordSteps(State_RecordStepsServer) error
+	GetInstances(context.Context, *InstancesRequest) (*InstancesResponse, error)
+	GetInstance(context.Context, *InstanceRequest) (*state.FlowInfo, error)
+	GetStatus(context.Context, *InstanceRequest) (*StatusResponse, error)
+	GetSteps(context.Context, *InstanceRequest) (*StepsResponse, error)
+	GetStepsStatus(context.Context, *InstanceRequest) (*StepsStatusResponse, error)
+	GetSnapshot(context.Context, *SnapshotRequest) (*state.Snapshot, error)
+}
+
+// State_RecordStepsServer receives the steps of a RecordSteps stream
+type State_RecordStepsServer interface {
+	SendAndClose(*RecordStepsResponse) error
+	Recv() (*state.Step, error)
+	grpc.ServerStream
+}
+
+// RegisterStateServer registers srv on s
+func RegisterStateServer(s grpc.ServiceRegistrar, srv StateServer) {
+	s.RegisterService(&ServiceDesc, srv)
+}
+
+func unaryHandler(method string, newIn func() interface{}, call func(StateServer, context.Context, interface{}) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := newIn()
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(srv.(StateServer), ctx, in)
+			}
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/" + ServiceName + "/" + method,
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(StateServer), ctx, req)
+			}
+			return interceptor(ctx, in, info, handler)
+		},
+	}
+}
+
+func newFlowState() interface{} {
+	return &state.FlowState{}
+}
+
+func newStep() interface{} {
+	return &state.Step{}
+}
+
+func newSnapshot() interface{} {
+	return &state.Snapshot{SnapshotBase: &state.SnapshotBase{}}
+}
+
+func newInstanceRequest() interface{} {
+	return &InstanceRequest{}
+}
+
+// ServiceDesc is the grpc.ServiceDesc of the state service
+var ServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*StateServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryHandler("RecordStart", newFlowState, func(s StateServer, ctx context.Context, in interface{}) (interface{}, error) {
+			return s.RecordStart(ctx, in.(*state.FlowState))
+		}),
+		unaryHandler("RecordStep", newStep, func(s StateServer, ctx context.Context, in interface{}) (interface{}, error) {
+			return s.RecordStep(ctx, in.(*state.Step))
+		}),
+		unaryHandler("RecordSnapshot", newSnapshot, func(s StateServer, ctx context.Context, in interface{}) (interface{}, error) {
+			return s.RecordSnapshot(ctx, in.(*state.Snapshot))
+		}),
+		unaryHandler("RecordEnd", newFlowState, func(s StateServer, ctx context.Context, in interface{}) (interface{}, error) {
+			return s.RecordEnd(ctx, in.(*state.FlowState))
+		}),
+		unaryHandler("GetInstances", func() interface{} { return &InstancesRequest{} }, func(s StateServer, ctx context.Context, in interface{}) (interface{}, error) {
+			return s.GetInstances(ctx, in.(*InstancesRequest))
+		}),
+		unaryHandler("GetInstance", newInstanceRequest, func(s StateServer, ctx context.Context, in interface{}) (interface{}, error) {
+			return s.GetInstance(ctx, in.(*InstanceRequest))
+		}),
+		unaryHandler("GetStatus", newInstanceRequest, func(s StateServer, ctx context.Context, in interface{}) (interface{}, error) {
+			return s.GetStatus(ctx, in.(*InstanceRequest))
+		}),
+		unaryHandler("GetSteps", newInstanceRequest, func(s StateServer, ctx context.Context, in interface{}) (interface{}, error) {
+			return s.GetSteps(ctx, in.(*InstanceRequest))
+		}),
+		unaryHandler("GetStepsStatus", newInstanceRequest, func(s StateServer, ctx context.Context, in interface{}) (interface{}, error) {
+			return s.GetStepsStatus(ctx, in.(*InstanceRequest))
+		}),
+		unaryHandler("GetSnapshot", func() interface{} { return &SnapshotRequest{} }, func(s StateServer, ctx context.Context, in interface{}) (interface{}, error) {
+			return s.GetSnapshot(ctx, in.(*SnapshotRequest))
+		}),
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "RecordSteps",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				return srv.(StateServer).RecordSteps(&recordStepsServer{stream})
+			},
+			ClientStreams: true,
+		},
+	},
+}
+
+type recordStepsServer struct {
+	grpc.ServerStream
+}
+
+func (x *recordStepsServer) SendAndClose(m *RecordStepsResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *recordStepsServer) Recv() (*state.Step, error) {
+	m := &state.Step{}
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// StateClient is the client API of the state service
+type StateClient interface {
+	RecordStart(ctx context.Context, in *state.FlowState, opts ...grpc.CallOption) (*Empty, error)
+	RecordStep(ctx context.Context, in *state.Step, opts ...grpc.CallOption) (*Empty, error)
+	RecordSnapshot(ctx context.Context, in *state.Snapshot, opts ...grpc.CallOption) (*Empty, error)
+	RecordEnd(ctx context.Context, in *state.FlowState, opts ...grpc.CallOption) (*Empty, error)
+	RecordSteps(ctx context.Context, opts ...grpc.CallOption) (State_RecordStepsClient, error)
+	GetInstances(ctx context.Context, in *InstancesRequest, opts ...grpc.CallOption) (*InstancesResponse, error)
+	GetInstance(ctx context.Context, in *InstanceRequest, opts ...grpc.CallOption) (*state.FlowInfo, error)
+	GetStatus(ctx context.Context, in *InstanceRequest, opts ...grpc.CallOption) (*StatusResponse, error)
+	GetSteps(ctx context.Context, in *InstanceRequest, opts ...grpc.CallOption) (*StepsResponse, error)
+	GetStepsStatus(ctx context.Context, in *InstanceRequest, opts ...grpc.CallOption) (*StepsStatusResponse, error)
+	GetSnapshot(ctx context.Context, in *SnapshotRequest, opts ...grpc.CallOption) (*state.Snapshot, error)
+}
+
+// State_RecordStepsClient sends the steps of a RecordSteps stream
+type State_RecordStepsClient interface {
+	Send(*state.Step) error
+	CloseAndRecv() (*RecordStepsResponse, error)
+	grpc.ClientStream
+}
+
+type stateClient struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewStateClient returns a client of the state service, calls use the JSON codec
+func NewStateClient(cc grpc.ClientConnInterface) StateClient {
+	return &stateClient{cc}
+}
+
+func (c *stateClient) invoke(ctx context.Context, method string, in, out interface{}, opts []grpc.CallOption) error {
+	opts = append([]grpc.CallOption{grpc.CallContentSubtype(CodecName)}, opts...)
+	return c.cc.Invoke(ctx, "/"+ServiceName+"/"+method, in, out, opts...)
+}
+
+func (c *stateClient) RecordStart(ctx context.Context, in *state.FlowState, opts ...grpc.CallOption) (*Empty, error) {
+	out := &Empty{}
+	if err := c.invoke(ctx, "RecordStart", in, out, opts); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) RecordStep(ctx context.Context, in *state.Step, opts ...grpc.CallOption) (*Empty, error) {
+	out := &Empty{}
+	if err := c.invoke(ctx, "RecordStep", in, out, opts); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) RecordSnapshot(ctx context.Context, in *state.Snapshot, opts ...grpc.CallOption) (*Empty, error) {
+	out := &Empty{}
+	if err := c.invoke(ctx, "RecordSnapshot", in, out, opts); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) RecordEnd(ctx context.Context, in *state.FlowState, opts ...grpc.CallOption) (*Empty, error) {
+	out := &Empty{}
+	if err := c.invoke(ctx, "RecordEnd", in, out, opts); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) RecordSteps(ctx context.Context, opts ...grpc.CallOption) (State_RecordStepsClient, error) {
+	opts = append([]grpc.CallOption{grpc.CallContentSubtype(CodecName)}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ServiceDesc.Streams[0], "/"+ServiceName+"/RecordSteps", opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &recordStepsClient{stream}, nil
+}
+
+type recordStepsClient struct {
+	grpc.ClientStream
+}
+
+func (x *recordStepsClient) Send(m *state.Step) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *recordStepsClient) CloseAndRecv() (*RecordStepsResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := &RecordStepsResponse{}
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *stateClient) GetInstances(ctx context.Context, in *InstancesRequest, opts ...grpc.CallOption) (*InstancesResponse, error) {
+	out := &InstancesResponse{}
+	if err := c.invoke(ctx, "GetInstances", in, out, opts); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) GetInstance(ctx context.Context, in *InstanceRequest, opts ...grpc.CallOption) (*state.FlowInfo, error) {
+	out := &state.FlowInfo{}
+	if err := c.invoke(ctx, "GetInstance", in, out, opts); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) GetStatus(ctx context.Context, in *InstanceRequest, opts ...grpc.CallOption) (*StatusResponse, error) {
+	out := &StatusResponse{}
+	if err := c.invoke(ctx, "GetStatus", in, out, opts); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) GetSteps(ctx context.Context, in *InstanceRequest, opts ...grpc.CallOption) (*StepsResponse, error) {
+	out := &StepsResponse{}
+	if err := c.invoke(ctx, "GetSteps", in, out, opts); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) GetStepsStatus(ctx context.Context, in *InstanceRequest, opts ...grpc.CallOption) (*StepsStatusResponse, error) {
+	out := &StepsStatusResponse{}
+	if err := c.invoke(ctx, "GetStepsStatus", in, out, opts); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *stateClient) GetSnapshot(ctx context.Context, in *SnapshotRequest, opts ...grpc.CallOption) (*state.Snapshot, error) {
+	out := &state.Snapshot{SnapshotBase: &state.SnapshotBase{}}
+	if err := c.invoke(ctx, "GetSnapshot", in, out, opts); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
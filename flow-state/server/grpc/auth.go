@@ -0,0 +1,77 @@
+package grpc
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/project-flogo/services/flow-state/server/rest"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcmd "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryAuthInterceptor rejects calls without valid credentials and adds the caller identity to their context.
+// Credentials are read from the metadata of the call, with the headers of the REST API, e.g. authorization
+// or x-api-key.
+func UnaryAuthInterceptor(auth rest.Authenticator) gogrpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *gogrpc.UnaryServerInfo, handler gogrpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticate(ctx, auth, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamAuthInterceptor is the UnaryAuthInterceptor of streaming calls
+func StreamAuthInterceptor(auth rest.Authenticator) gogrpc.StreamServerInterceptor {
+	return func(srv interface{}, stream gogrpc.ServerStream, info *gogrpc.StreamServerInfo, handler gogrpc.StreamHandler) error {
+		ctx, err := authenticate(stream.Context(), auth, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &identityStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+func authenticate(ctx context.Context, auth rest.Authenticator, method string) (context.Context, error) {
+	header := http.Header{}
+	if md, ok := grpcmd.FromIncomingContext(ctx); ok {
+		for name, values := range md {
+			for _, value := range values {
+				header.Add(name, value)
+			}
+		}
+	}
+	id, err := auth.Authenticate(&http.Request{Method: http.MethodPost, URL: &url.URL{Path: method}, Header: header})
+	if err != nil {
+		logger.Debugf("Rejecting %s, %s", method, err.Error())
+		return nil, status.Error(codes.Unauthenticated, "unauthorized, "+err.Error())
+	}
+	return rest.ContextWithIdentity(ctx, id), nil
+}
+
+// identityStream is a server stream whose context carries the caller identity
+type identityStream struct {
+	gogrpc.ServerStream
+	ctx context.Context
+}
+
+func (s *identityStream) Context() context.Context {
+	return s.ctx
+}
+
+// authError converts the HTTP status of a failed authorization to a gRPC status
+func authError(code int, err error) error {
+	switch code {
+	case http.StatusBadRequest:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case http.StatusUnauthorized:
+		return status.Error(codes.Unauthenticated, err.Error())
+	case http.StatusForbidden:
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
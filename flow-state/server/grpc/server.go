@@ -0,0 +1,269 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"strconv"
+
+	"github.com/project-flogo/core/support/log"
+	"github.com/project-flogo/flow/state"
+	"github.com/project-flogo/services/flow-state/rpc"
+	"github.com/project-flogo/services/flow-state/server/rest"
+	"github.com/project-flogo/services/flow-state/store"
+	"github.com/project-flogo/services/flow-state/store/batch"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server implements rpc.StateServer over a store.Store
+type Server struct {
+	stepStore store.Store
+	logger    log.Logger
+}
+
+func NewServer(stepStore store.Store, logger log.Logger) *Server {
+	return &Server{stepStore: stepStore, logger: logger}
+}
+
+func (s *Server) RecordStart(ctx context.Context, flowState *state.FlowState) (*rpc.Empty, error) {
+	s.logger.Debugf("RPC[RecordStart] : Called")
+	if err := s.authorizeItem(ctx, &batch.Item{Kind: batch.Start, FlowState: flowState}); err != nil {
+		return nil, err
+	}
+	if err := s.stepStore.RecordStart(flowState); err != nil {
+		s.logger.Errorf("RPC[RecordStart] : Error saving step - %v", err)
+		return nil, status.Error(codes.Internal, "unable to save step")
+	}
+	return &rpc.Empty{}, nil
+}
+
+func (s *Server) RecordStep(ctx context.Context, step *state.Step) (*rpc.Empty, error) {
+	s.logger.Debugf("RPC[RecordStep] : Called")
+	if err := s.authorizeItem(ctx, &batch.Item{Kind: batch.Step, Step: step}); err != nil {
+		return nil, err
+	}
+	if err := s.stepStore.SaveStep(step); err != nil {
+		s.logger.Errorf("RPC[RecordStep] : Error saving step - %v", err)
+		return nil, status.Error(codes.Internal, "unable to save step")
+	}
+	return &rpc.Empty{}, nil
+}
+
+func (s *Server) RecordSnapshot(ctx context.Context, snapshot *state.Snapshot) (*rpc.Empty, error) {
+	s.logger.Debugf("RPC[RecordSnapshot] : Called")
+	if err := s.authorizeItem(ctx, &batch.Item{Kind: batch.Snapshot, Snapshot: snapshot}); err != nil {
+		return nil, err
+	}
+	if err := s.stepStore.SaveSnapshot(snapshot); err != nil {
+		s.logger.Errorf("RPC[RecordSnapshot] : Error saving snapshot - %v", err)
+		return nil, status.Error(codes.Internal, "unable to save snapshot")
+	}
+	return &rpc.Empty{}, nil
+}
+
+func (s *Server) RecordEnd(ctx context.Context, flowState *state.FlowState) (*rpc.Empty, error) {
+	s.logger.Debugf("RPC[RecordEnd] : Called")
+	if err := s.authorizeItem(ctx, &batch.Item{Kind: batch.End, FlowState: flowState}); err != nil {
+		return nil, err
+	}
+	if err := s.stepStore.RecordEnd(flowState); err != nil {
+		s.logger.Errorf("RPC[RecordEnd] : Error saving step - %v", err)
+		return nil, status.Error(codes.Internal, "unable to save step")
+	}
+	return &rpc.Empty{}, nil
+}
+
+// RecordSteps saves the streamed steps in the order they are received, the access to each flow
+// instance is checked with its first step
+func (s *Server) RecordSteps(stream rpc.State_RecordStepsServer) error {
+	s.logger.Debugf("RPC[RecordSteps] : Called")
+	count := 0
+	authorized := make(map[string]bool)
+	for {
+		step, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&rpc.RecordStepsResponse{Count: count})
+		}
+		if err != nil {
+			return err
+		}
+		if !authorized[step.FlowId] {
+			if err := s.authorizeItem(stream.Context(), &batch.Item{Kind: batch.Step, Step: step}); err != nil {
+				return err
+			}
+			authorized[step.FlowId] = true
+		}
+		if err := s.stepStore.SaveStep(step); err != nil {
+			s.logger.Errorf("RPC[RecordSteps] : Error saving step - %v", err)
+			return status.Errorf(codes.Internal, "unable to save step %d, %d steps saved", step.Id, count)
+		}
+		count++
+	}
+}
+
+func (s *Server) GetInstances(ctx context.Context, req *rpc.InstancesRequest) (*rpc.InstancesResponse, error) {
+	s.logger.Debugf("RPC[GetInstances] : Called")
+	if id := rest.IdentityFromContext(ctx); id != nil {
+		// the caller identity replaces the user of the request
+		req.Username = id.User
+		if !id.AllowsApp(req.AppName) {
+			return nil, status.Errorf(codes.PermissionDenied, "access to app %s is not allowed", req.AppName)
+		}
+	}
+	if len(req.Username) <= 0 {
+		return nil, status.Error(codes.Unauthenticated, "please provide user information")
+	}
+	if len(req.AppName) <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "please provide app name")
+	}
+	if len(req.AppVersion) <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "please provide app version")
+	}
+	if !validCount(req.Offset) {
+		return nil, status.Error(codes.InvalidArgument, "please provide offset as a non negative integer")
+	}
+	if !validCount(req.Limit) {
+		return nil, status.Error(codes.InvalidArgument, "please provide limit as a non negative integer")
+	}
+
+	record, err := s.stepStore.GetFlowsWithRecordCount(req.Metadata())
+	if err != nil {
+		s.logger.Errorf("RPC[GetInstances] : Error getting flow instances - %v", err)
+		return nil, status.Error(codes.Internal, "getting flow instance error: "+err.Error())
+	}
+	resp := &rpc.InstancesResponse{Instances: []*state.FlowInfo{}}
+	if record != nil {
+		resp.Count = record.Count
+		if record.FlowData != nil {
+			resp.Instances = record.FlowData
+		}
+	}
+	return resp, nil
+}
+
+func (s *Server) GetInstance(ctx context.Context, req *rpc.InstanceRequest) (*state.FlowInfo, error) {
+	s.logger.Debugf("RPC[GetInstance] : Called for %s", req.FlowId)
+	if err := s.authorizeFlow(ctx, req.FlowId); err != nil {
+		return nil, err
+	}
+	if id := rest.IdentityFromContext(ctx); id != nil {
+		req.Username = id.User
+	}
+	filter := &rpc.InstancesRequest{Username: req.Username, AppName: req.AppName, AppVersion: req.AppVersion, HostId: req.HostId}
+	instance, err := s.stepStore.GetFlow(req.FlowId, filter.Metadata())
+	if err != nil {
+		s.logger.Errorf("RPC[GetInstance] : Error getting flow details - %v", err)
+		return nil, status.Error(codes.Internal, "get flow details error")
+	}
+	if instance == nil {
+		return nil, status.Errorf(codes.NotFound, "flow instance %s not found", req.FlowId)
+	}
+	return instance, nil
+}
+
+func (s *Server) GetStatus(ctx context.Context, req *rpc.InstanceRequest) (*rpc.StatusResponse, error) {
+	s.logger.Debugf("RPC[GetStatus] : Called for %s", req.FlowId)
+	if err := s.authorizeFlow(ctx, req.FlowId); err != nil {
+		return nil, err
+	}
+	flowStatus := s.stepStore.GetStatus(req.FlowId)
+	if flowStatus == -1 {
+		return nil, status.Errorf(codes.NotFound, "flow instance %s not found", req.FlowId)
+	}
+	return &rpc.StatusResponse{Status: flowStatus}, nil
+}
+
+func (s *Server) GetSteps(ctx context.Context, req *rpc.InstanceRequest) (*rpc.StepsResponse, error) {
+	s.logger.Debugf("RPC[GetSteps] : Called for %s", req.FlowId)
+	if err := s.authorizeFlow(ctx, req.FlowId); err != nil {
+		return nil, err
+	}
+	steps, err := s.stepStore.GetSteps(req.FlowId)
+	if err != nil {
+		s.logger.Errorf("RPC[GetSteps] : Error getting steps - %v", err)
+		return nil, status.Error(codes.Internal, "get steps error: "+err.Error())
+	}
+	if steps == nil {
+		return nil, status.Errorf(codes.NotFound, "flow instance %s not found", req.FlowId)
+	}
+	return &rpc.StepsResponse{Steps: steps}, nil
+}
+
+func (s *Server) GetStepsStatus(ctx context.Context, req *rpc.InstanceRequest) (*rpc.StepsStatusResponse, error) {
+	s.logger.Debugf("RPC[GetStepsStatus] : Called for %s", req.FlowId)
+	if err := s.authorizeFlow(ctx, req.FlowId); err != nil {
+		return nil, err
+	}
+	steps, err := s.stepStore.GetStepsStatus(req.FlowId)
+	if err != nil {
+		s.logger.Errorf("RPC[GetStepsStatus] : Error getting steps status - %v", err)
+		return nil, status.Error(codes.Internal, "get steps status error: "+err.Error())
+	}
+	if steps == nil {
+		steps = []map[string]string{}
+	}
+	return &rpc.StepsStatusResponse{Steps: steps}, nil
+}
+
+// GetSnapshot returns the saved snapshot, or the snapshot rebuilt from the steps up to StepId when it is set
+func (s *Server) GetSnapshot(ctx context.Context, req *rpc.SnapshotRequest) (*state.Snapshot, error) {
+	s.logger.Debugf("RPC[GetSnapshot] : Called for %s", req.FlowId)
+	if err := s.authorizeFlow(ctx, req.FlowId); err != nil {
+		return nil, err
+	}
+	if req.StepId == nil {
+		if snapshot := s.stepStore.GetSnapshot(req.FlowId); snapshot != nil {
+			return snapshot, nil
+		}
+	}
+
+	steps, err := s.stepStore.GetSteps(req.FlowId)
+	if err != nil {
+		s.logger.Errorf("RPC[GetSnapshot] : Error getting steps - %v", err)
+		return nil, status.Error(codes.Internal, "get snapshot error: "+err.Error())
+	}
+	if steps == nil {
+		return nil, status.Errorf(codes.NotFound, "flow instance %s not found", req.FlowId)
+	}
+	if req.StepId != nil {
+		stepId := *req.StepId
+		if stepId < 0 || stepId >= len(steps) {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid stepId: %d, only %d exists", stepId, len(steps))
+		}
+		steps = steps[:stepId+1]
+	}
+	return state.StepsToSnapshot(req.FlowId, steps), nil
+}
+
+func validCount(value string) bool {
+	if len(value) == 0 {
+		return true
+	}
+	v, err := strconv.Atoi(value)
+	return err == nil && v >= 0
+}
+
+// authorizeItem checks that the caller records a flow instance of its own user and apps, the calls are recorded
+// before they return so a start does not need to claim its instance
+func (s *Server) authorizeItem(ctx context.Context, item *batch.Item) error {
+	id := rest.IdentityFromContext(ctx)
+	if id == nil {
+		return nil
+	}
+	if code, err := rest.AuthorizeItem(s.stepStore, nil, id, item); err != nil {
+		return authError(code, err)
+	}
+	return nil
+}
+
+// authorizeFlow checks that the flow instance belongs to the caller
+func (s *Server) authorizeFlow(ctx context.Context, flowId string) error {
+	id := rest.IdentityFromContext(ctx)
+	if id == nil {
+		return nil
+	}
+	if code, err := rest.AuthorizeFlow(s.stepStore, id, flowId); err != nil {
+		return authError(code, err)
+	}
+	return nil
+}
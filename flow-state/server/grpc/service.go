@@ -0,0 +1,130 @@
+package grpc
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/project-flogo/core/data/coerce"
+	"github.com/project-flogo/core/support/log"
+	"github.com/project-flogo/core/support/service"
+	"github.com/project-flogo/services/flow-state/rpc"
+	"github.com/project-flogo/services/flow-state/server/rest"
+	"github.com/project-flogo/services/flow-state/store"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	SettingPort      = "port"
+	SettingEnableTLS = "enableTLS"
+	SettingCertFile  = "certFile"
+	SettingKeyFile   = "keyFile"
+	SettingAuth      = "auth"
+
+	Persistence = "persistence"
+)
+
+var logger = log.ChildLogger(log.RootLogger(), "flow-state.grpc")
+
+func init() {
+	_ = service.RegisterFactory(&StateServiceFactory{})
+}
+
+type StateServiceFactory struct {
+}
+
+func (s *StateServiceFactory) NewService(config *service.Config) (service.Service, error) {
+	ss := &StateService{}
+
+	err := ss.init(config.Settings)
+	if err != nil {
+		return nil, err
+	}
+
+	return ss, nil
+}
+
+// StateService serves the state service API over gRPC
+type StateService struct {
+	addr   string
+	server *gogrpc.Server
+}
+
+func (ss *StateService) Name() string {
+	return "FlowStateGrpcService"
+}
+
+// Start implements util.Managed.Start()
+func (ss *StateService) Start() error {
+	listener, err := net.Listen("tcp", ss.addr)
+	if err != nil {
+		return fmt.Errorf("Could not listen on %s, %s", ss.addr, err.Error())
+	}
+	logger.Infof("Listening on %s", listener.Addr().String())
+
+	go func() {
+		if err := ss.server.Serve(listener); err != nil {
+			logger.Errorf("gRPC server stopped, %s", err.Error())
+		}
+	}()
+	return nil
+}
+
+// Stop implements util.Managed.Stop()
+func (ss *StateService) Stop() error {
+	ss.server.GracefulStop()
+	return nil
+}
+
+// Init implements services.StateServiceService.Init()
+func (ss *StateService) init(settings map[string]interface{}) error {
+
+	sPort, set := settings[SettingPort]
+	if !set {
+		return fmt.Errorf("StateService: required setting 'port' not set")
+	}
+	port, err := coerce.ToInt(sPort)
+	if err != nil {
+		return fmt.Errorf("StateService: invalid port '%v'", sPort)
+	}
+	ss.addr = ":" + strconv.Itoa(port)
+
+	var options []gogrpc.ServerOption
+
+	enableTLS := false
+	if sEnableTLS, set := settings[SettingEnableTLS]; set {
+		enableTLS, _ = coerce.ToBool(sEnableTLS)
+	}
+	if enableTLS {
+		certFile, _ := coerce.ToString(settings[SettingCertFile])
+		keyFile, _ := coerce.ToString(settings[SettingKeyFile])
+		creds, err := credentials.NewServerTLSFromFile(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("StateService: unable to load TLS certificate, %s", err.Error())
+		}
+		options = append(options, gogrpc.Creds(creds))
+	}
+
+	// the store is shared with the REST service when it was initialized already
+	if store.RegistedStore() == nil {
+		persistenceSettings, _ := coerce.ToObject(settings[Persistence])
+		if err := store.InitStorage(persistenceSettings); err != nil {
+			return fmt.Errorf("initialize state service persistence failed, due to [%s]", err.Error())
+		}
+	}
+
+	// calls are authenticated like the requests of the REST service
+	if authSettings, _ := coerce.ToObject(settings[SettingAuth]); len(authSettings) > 0 {
+		auth, err := rest.NewAuthenticator(authSettings)
+		if err != nil {
+			return fmt.Errorf("invalid state service auth settings, due to [%s]", err.Error())
+		}
+		options = append(options, gogrpc.UnaryInterceptor(UnaryAuthInterceptor(auth)), gogrpc.StreamInterceptor(StreamAuthInterceptor(auth)))
+	}
+
+	ss.server = gogrpc.NewServer(options...)
+	rpc.RegisterStateServer(ss.server, NewServer(store.RegistedStore(), logger))
+
+	return nil
+}
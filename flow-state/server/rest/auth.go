@@ -0,0 +1,490 @@
+package rest
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/project-flogo/core/data/coerce"
+	"github.com/project-flogo/core/data/metadata"
+)
+
+const (
+	AuthAPIKey = "apiKey"
+	AuthHMAC   = "hmac"
+	AuthJWT    = "jwt"
+
+	APIKeyHeader = "X-API-Key"
+
+	// tokens are accepted this long after they expired to allow for clock skew
+	tokenLeeway = 30 * time.Second
+)
+
+var errNoCredentials = errors.New("no credentials provided")
+
+// Identity is the authenticated caller, an empty Apps list grants access to all apps of the user
+type Identity struct {
+	User string
+	Apps []string
+}
+
+// AllowsApp reports whether the identity may access the flow instances of appName
+func (id *Identity) AllowsApp(appName string) bool {
+	if len(id.Apps) == 0 {
+		return true
+	}
+	for _, app := range id.Apps {
+		if app == appName {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator derives the identity of the caller of a request
+type Authenticator interface {
+	Authenticate(request *http.Request) (*Identity, error)
+}
+
+type authConfig struct {
+	Type     string `md:"type,required"`
+	Secret   string `md:"secret"`
+	JWKSFile string `md:"jwksFile"`
+	Issuer   string `md:"issuer"`
+	Audience string `md:"audience"`
+	// UserClaim and AppsClaim name the token claims holding the user and the allowed apps
+	UserClaim string `md:"userClaim"`
+	AppsClaim string `md:"appsClaim"`
+}
+
+// NewAuthenticator creates the authenticator selected by the type of the auth settings
+func NewAuthenticator(settings map[string]interface{}) (Authenticator, error) {
+	cfg := &authConfig{}
+	if err := metadata.MapToStruct(settings, cfg, true); err != nil {
+		return nil, err
+	}
+	if cfg.UserClaim == "" {
+		cfg.UserClaim = "sub"
+	}
+	if cfg.AppsClaim == "" {
+		cfg.AppsClaim = "apps"
+	}
+
+	switch cfg.Type {
+	case AuthAPIKey:
+		return newAPIKeyAuthenticator(settings["keys"])
+	case AuthHMAC:
+		if cfg.Secret == "" {
+			return nil, fmt.Errorf("required setting 'secret' not set")
+		}
+		return &hmacAuthenticator{secret: []byte(cfg.Secret), cfg: cfg}, nil
+	case AuthJWT:
+		keys, err := loadJWKS(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		return &jwtAuthenticator{keys: keys, cfg: cfg}, nil
+	}
+	return nil, fmt.Errorf("unsupported auth type [%s]", cfg.Type)
+}
+
+type identityKey struct{}
+
+// IdentityFrom returns the identity of an authenticated request, it is nil when authentication is disabled
+func IdentityFrom(request *http.Request) *Identity {
+	return IdentityFromContext(request.Context())
+}
+
+// ContextWithIdentity returns a copy of ctx carrying the identity of the caller
+func ContextWithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the identity carried by ctx, it is nil when authentication is disabled
+func IdentityFromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
+
+// Authenticate rejects requests without valid credentials and adds the caller identity to the request context
+func Authenticate(auth Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if request.Method == http.MethodOptions || request.URL.Path == "/v1/health" {
+			next.ServeHTTP(response, request)
+			return
+		}
+
+		id, err := auth.Authenticate(request)
+		if err != nil {
+			logger.Debugf("Rejecting %s %s, %s", request.Method, request.URL.Path, err.Error())
+			http.Error(response, "unauthorized, "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(response, request.WithContext(ContextWithIdentity(request.Context(), id)))
+	})
+}
+
+func bearerToken(request *http.Request) (string, error) {
+	header := request.Header.Get("Authorization")
+	if header == "" {
+		return "", errNoCredentials
+	}
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return "", fmt.Errorf("unsupported authorization scheme")
+	}
+	return strings.TrimSpace(header[7:]), nil
+}
+
+// apiKeyAuthenticator maps static API keys to identities, keys are kept as sha256 hashes
+type apiKeyAuthenticator struct {
+	keys map[[sha256.Size]byte]*Identity
+}
+
+func newAPIKeyAuthenticator(setting interface{}) (*apiKeyAuthenticator, error) {
+	keys, err := coerce.ToObject(setting)
+	if err != nil || len(keys) == 0 {
+		return nil, fmt.Errorf("required setting 'keys' not set")
+	}
+
+	auth := &apiKeyAuthenticator{keys: make(map[[sha256.Size]byte]*Identity, len(keys))}
+	for key, value := range keys {
+		entry, err := coerce.ToObject(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API key entry, %s", err.Error())
+		}
+		id := &Identity{}
+		id.User, _ = coerce.ToString(entry["user"])
+		if id.User == "" {
+			return nil, fmt.Errorf("API key entry without user")
+		}
+		id.Apps, err = toApps(entry["apps"])
+		if err != nil {
+			return nil, err
+		}
+		auth.keys[sha256.Sum256([]byte(key))] = id
+	}
+	return auth, nil
+}
+
+func (a *apiKeyAuthenticator) Authenticate(request *http.Request) (*Identity, error) {
+	key := request.Header.Get(APIKeyHeader)
+	if key == "" {
+		return nil, errNoCredentials
+	}
+	id, ok := a.keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return nil, fmt.Errorf("invalid API key")
+	}
+	return id, nil
+}
+
+// hmacAuthenticator accepts tokens made of base64url encoded JSON claims and their HMAC-SHA256 signature
+type hmacAuthenticator struct {
+	secret []byte
+	cfg    *authConfig
+}
+
+// NewHMACToken issues a token for id that is accepted by the hmac authenticator until ttl elapsed
+func NewHMACToken(secret string, id *Identity, ttl time.Duration) (string, error) {
+	claims := map[string]interface{}{
+		"sub":  id.User,
+		"apps": id.Apps,
+		"exp":  time.Now().Add(ttl).Unix(),
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(encoded))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (a *hmacAuthenticator) Authenticate(request *http.Request) (*Identity, error) {
+	token, err := bearerToken(request)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature")
+	}
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(parts[0]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, fmt.Errorf("invalid token signature")
+	}
+
+	claims, err := decodeClaims(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	return identityFromClaims(claims, a.cfg)
+}
+
+// jwtAuthenticator validates RS and ES signed JWTs against the keys of a local JWKS file
+type jwtAuthenticator struct {
+	keys map[string]crypto.PublicKey
+	cfg  *authConfig
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func loadJWKS(path string) (map[string]crypto.PublicKey, error) {
+	if path == "" {
+		return nil, fmt.Errorf("required setting 'jwksFile' not set")
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read JWKS file '%s', %s", path, err.Error())
+	}
+	jwks := &struct {
+		Keys []*jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(content, jwks); err != nil {
+		return nil, fmt.Errorf("invalid JWKS file '%s', %s", path, err.Error())
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key [%s] in JWKS file '%s', %s", k.Kid, path, err.Error())
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys found in JWKS file '%s'", path)
+	}
+	return keys, nil
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve [%s]", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type [%s]", k.Kty)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (a *jwtAuthenticator) Authenticate(request *http.Request) (*Identity, error) {
+	token, err := bearerToken(request)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	header := &struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if b, err := base64.RawURLEncoding.DecodeString(parts[0]); err != nil || json.Unmarshal(b, header) != nil {
+		return nil, fmt.Errorf("malformed token header")
+	}
+	key, ok := a.keys[header.Kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key [%s]", header.Kid)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature")
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	claims, err := decodeClaims(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if a.cfg.Issuer != "" && claims["iss"] != a.cfg.Issuer {
+		return nil, fmt.Errorf("invalid token issuer")
+	}
+	if a.cfg.Audience != "" && !hasAudience(claims["aud"], a.cfg.Audience) {
+		return nil, fmt.Errorf("invalid token audience")
+	}
+	return identityFromClaims(claims, a.cfg)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	if len(alg) != 5 {
+		return fmt.Errorf("unsupported token algorithm [%s]", alg)
+	}
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported token algorithm [%s]", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			break
+		}
+		if rsa.VerifyPKCS1v15(pub, hash, digest, signature) != nil {
+			return fmt.Errorf("invalid token signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			break
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("invalid token signature")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return fmt.Errorf("invalid token signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("token algorithm [%s] does not match the signing key", alg)
+}
+
+func hasAudience(aud interface{}, audience string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == audience
+	case []interface{}:
+		for _, a := range v {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeClaims(encoded string) (map[string]interface{}, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("malformed token claims")
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims")
+	}
+	return claims, nil
+}
+
+func identityFromClaims(claims map[string]interface{}, cfg *authConfig) (*Identity, error) {
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); ok && now.After(time.Unix(int64(exp), 0).Add(tokenLeeway)) {
+		return nil, fmt.Errorf("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(tokenLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("token not valid yet")
+	}
+
+	id := &Identity{}
+	id.User, _ = claims[cfg.UserClaim].(string)
+	if id.User == "" {
+		return nil, fmt.Errorf("token without [%s] claim", cfg.UserClaim)
+	}
+	apps, err := toApps(claims[cfg.AppsClaim])
+	if err != nil {
+		return nil, err
+	}
+	id.Apps = apps
+	return id, nil
+}
+
+// toApps reads an app list given as an array or as a comma or space separated string
+func toApps(value interface{}) ([]string, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if s, ok := value.(string); ok {
+		return strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }), nil
+	}
+	apps, err := coerce.ToArray(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid apps, %s", err.Error())
+	}
+	result := make([]string, 0, len(apps))
+	for _, app := range apps {
+		s, _ := coerce.ToString(app)
+		if s != "" {
+			result = append(result, s)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,183 @@
+package rest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-flogo/core/support/log"
+	"github.com/project-flogo/flow/state"
+	"github.com/project-flogo/services/flow-state/store"
+)
+
+func newJWT(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func authRequest(header, value string) *http.Request {
+	request := httptest.NewRequest(http.MethodGet, "/v1/instances", nil)
+	request.Header.Set(header, value)
+	return request
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	jwks := map[string]interface{}{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "k1",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}
+	content, _ := json.Marshal(jwks)
+	jwksFile := filepath.Join(dir, "jwks.json")
+	if err := ioutil.WriteFile(jwksFile, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	auth, err := NewAuthenticator(map[string]interface{}{"type": AuthJWT, "jwksFile": jwksFile, "issuer": "flogo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := time.Now().Add(time.Hour).Unix()
+	token := newJWT(t, key, "k1", map[string]interface{}{"sub": "alice", "apps": []string{"app1"}, "iss": "flogo", "exp": exp})
+	id, err := auth.Authenticate(authRequest("Authorization", "Bearer "+token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.User != "alice" || !id.AllowsApp("app1") || id.AllowsApp("app2") {
+		t.Fatalf("unexpected identity %+v", id)
+	}
+
+	expired := newJWT(t, key, "k1", map[string]interface{}{"sub": "alice", "iss": "flogo", "exp": time.Now().Add(-time.Hour).Unix()})
+	wrongIssuer := newJWT(t, key, "k1", map[string]interface{}{"sub": "alice", "iss": "other", "exp": exp})
+	parts := strings.Split(token, ".")
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"bob","iss":"flogo"}`)) + "." + parts[2]
+	for _, invalid := range []string{expired, wrongIssuer, tampered} {
+		if _, err := auth.Authenticate(authRequest("Authorization", "Bearer "+invalid)); err == nil {
+			t.Fatalf("expected token to be rejected: %s", invalid)
+		}
+	}
+}
+
+func TestHMACAndAPIKeyAuthenticators(t *testing.T) {
+	hmacAuth, err := NewAuthenticator(map[string]interface{}{"type": AuthHMAC, "secret": "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _ := NewHMACToken("s3cret", &Identity{User: "alice"}, time.Minute)
+	if id, err := hmacAuth.Authenticate(authRequest("Authorization", "Bearer "+token)); err != nil || id.User != "alice" {
+		t.Fatalf("unexpected identity %+v, %v", id, err)
+	}
+	forged, _ := NewHMACToken("other", &Identity{User: "alice"}, time.Minute)
+	if _, err := hmacAuth.Authenticate(authRequest("Authorization", "Bearer "+forged)); err == nil {
+		t.Fatal("expected forged token to be rejected")
+	}
+
+	keyAuth, err := NewAuthenticator(map[string]interface{}{"type": AuthAPIKey, "keys": map[string]interface{}{"k-1": map[string]interface{}{"user": "bob", "apps": "app1,app2"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := keyAuth.Authenticate(authRequest(APIKeyHeader, "k-1")); err != nil || id.User != "bob" || !id.AllowsApp("app2") {
+		t.Fatalf("unexpected identity %+v, %v", id, err)
+	}
+	if _, err := keyAuth.Authenticate(authRequest(APIKeyHeader, "k-2")); err == nil {
+		t.Fatal("expected unknown key to be rejected")
+	}
+}
+
+func TestFlowAuthorization(t *testing.T) {
+	if err := store.InitStorage(nil); err != nil {
+		t.Fatal(err)
+	}
+	router := httprouter.New()
+	AppendEndpoints(router, log.RootLogger(), true, false)
+	auth, err := NewAuthenticator(map[string]interface{}{"type": AuthAPIKey, "keys": map[string]interface{}{
+		"alice-key": map[string]interface{}{"user": "alice"},
+		"bob-key":   map[string]interface{}{"user": "bob"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := Authenticate(auth, router)
+
+	call := func(method, path, key string, body interface{}) int {
+		var content []byte
+		if body != nil {
+			content, _ = json.Marshal(body)
+		}
+		request := httptest.NewRequest(method, path, strings.NewReader(string(content)))
+		if key != "" {
+			request.Header.Set(APIKeyHeader, key)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	if code := call(http.MethodPost, "/v1/instances/start", "alice-key", &state.FlowState{FlowInstanceId: "f1", UserId: "alice", AppName: "app1"}); code != http.StatusOK {
+		t.Fatalf("start failed with %d", code)
+	}
+	if code := call(http.MethodPost, "/v1/instances/steps", "alice-key", &state.Step{Id: 0, FlowId: "f1"}); code != http.StatusOK {
+		t.Fatalf("step failed with %d", code)
+	}
+
+	checks := []struct {
+		method, path, key string
+		body              interface{}
+		code              int
+	}{
+		{http.MethodGet, "/v1/instances/f1/steps", "", nil, http.StatusUnauthorized},
+		{http.MethodGet, "/v1/instances/f1/steps", "alice-key", nil, http.StatusOK},
+		{http.MethodGet, "/v1/instances/f1/steps", "bob-key", nil, http.StatusForbidden},
+		{http.MethodGet, "/v1/instances/f1/snapshot", "bob-key", nil, http.StatusForbidden},
+		{http.MethodDelete, "/v1/instances/f1", "bob-key", nil, http.StatusForbidden},
+		{http.MethodPost, "/v1/instances/steps", "bob-key", &state.Step{Id: 1, FlowId: "f1"}, http.StatusForbidden},
+		{http.MethodPost, "/v1/instances/start", "bob-key", &state.FlowState{FlowInstanceId: "f2", UserId: "alice"}, http.StatusForbidden},
+		// only a start records an instance whose owner is not known
+		{http.MethodPost, "/v1/instances/steps", "alice-key", &state.Step{Id: 1, FlowId: "f3"}, http.StatusForbidden},
+		{http.MethodPost, "/v1/instances/snapshot", "alice-key", &state.Snapshot{SnapshotBase: &state.SnapshotBase{}, Id: "f3"}, http.StatusForbidden},
+		{http.MethodPost, "/v1/instances/end", "alice-key", &state.FlowState{FlowInstanceId: "f3", UserId: "alice", AppName: "app1"}, http.StatusForbidden},
+		// the start claims the instance for the records following it in the batch
+		{http.MethodPost, "/v1/instances/batch", "alice-key", json.RawMessage(`[{"type":"start","data":{"flowInstanceId":"f4","userId":"alice","appName":"app1"}},` +
+			`{"type":"step","data":{"id":1,"flowId":"f4"}},{"type":"end","data":{"flowInstanceId":"f4","userId":"alice","appName":"app1"}}]`), http.StatusOK},
+		{http.MethodPost, "/v1/instances/batch", "bob-key", json.RawMessage(`[{"type":"start","data":{"flowInstanceId":"f4","userId":"bob","appName":"app1"}}]`), http.StatusMultiStatus},
+		{http.MethodGet, "/v1/instances/f4/steps", "alice-key", nil, http.StatusOK},
+		{http.MethodGet, "/v1/health", "", nil, http.StatusOK},
+	}
+	for _, check := range checks {
+		if code := call(check.method, check.path, check.key, check.body); code != check.code {
+			t.Fatalf("%s %s with key [%s] returned %d, expected %d", check.method, check.path, check.key, code, check.code)
+		}
+	}
+}
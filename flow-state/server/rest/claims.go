@@ -0,0 +1,59 @@
+package rest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/project-flogo/services/flow-state/store/metadata"
+)
+
+// claims are dropped when their start is still not recorded after claimTTL
+const claimTTL = 10 * time.Minute
+
+// Claims holds the owners of the flow instances whose start is accepted but not recorded yet, the records
+// following the start in a batch or in the spool are authorized against the owner of the start
+type Claims struct {
+	mu     sync.Mutex
+	owners map[string]*claim
+	pruned time.Time
+}
+
+type claim struct {
+	owner *metadata.Owner
+	time  time.Time
+}
+
+func NewClaims() *Claims {
+	return &Claims{owners: make(map[string]*claim)}
+}
+
+// claim returns the owner claiming the flow instance, a start claims an instance no one claimed yet
+func (c *Claims) claim(flowId string, start *metadata.Owner) *metadata.Owner {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.pruned) > claimTTL {
+		for id, cl := range c.owners {
+			if now.Sub(cl.time) > claimTTL {
+				delete(c.owners, id)
+			}
+		}
+		c.pruned = now
+	}
+
+	if cl, ok := c.owners[flowId]; ok {
+		return cl.owner
+	}
+	if start != nil {
+		c.owners[flowId] = &claim{owner: start, time: now}
+	}
+	return start
+}
+
+// release drops the claim of a flow instance once the store knows its owner
+func (c *Claims) release(flowId string) {
+	c.mu.Lock()
+	delete(c.owners, flowId)
+	c.mu.Unlock()
+}
@@ -5,12 +5,16 @@ import (
 	"fmt"
 	flowEvent "github.com/project-flogo/flow/support/event"
 	"github.com/project-flogo/services/flow-state/event"
+	"github.com/project-flogo/services/flow-state/ingest"
+	"github.com/project-flogo/services/flow-state/retention"
+	"github.com/project-flogo/services/flow-state/spool"
+	"github.com/project-flogo/services/flow-state/store/batch"
 	"github.com/project-flogo/services/flow-state/store/metadata"
-	"github.com/project-flogo/services/flow-state/store/postgres"
+	"github.com/project-flogo/services/flow-state/webhook"
 	"io/ioutil"
 	"net/http"
 	"strconv"
-	"sync"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/project-flogo/core/support/log"
@@ -34,24 +38,53 @@ const (
 	ASYNC_CALLING_HEADER = "Async-Calling"
 	START_TIME           = "startTime"
 	END_TIME             = "endTime"
+
+	// MaxBatchSize is the maximum number of records accepted by the batch endpoint
+	MaxBatchSize = 1000
 )
 
 type ServiceEndpoints struct {
-	muc *sync.Cond
-	// mu            *sync.Mutex
-	stepSlice     [][]byte
+	claims        *Claims
+	spool         *spool.Spool
+	pipeline      *ingest.Pipeline
+	janitor       *retention.Janitor
 	logger        log.Logger
 	stepStore     store.Store
 	streamingStep bool
+	webhooks      *webhook.Manager
+}
+
+// EndpointOptions select the endpoints of the service, the endpoints of the services that are not set are not
+// registered
+type EndpointOptions struct {
+	// ExposeRecorder registers the endpoints recording the flow instances
+	ExposeRecorder bool
+	// StreamingStep registers the step and event streams
+	StreamingStep bool
+	// Spool and Pipeline record the flow instances asynchronously
+	Spool    *spool.Spool
+	Pipeline *ingest.Pipeline
+	Janitor  *retention.Janitor
+	Webhooks *webhook.Manager
 }
 
+// AppendEndpoints registers the endpoints of the service without the optional services
 func AppendEndpoints(router *httprouter.Router, logger log.Logger, exposeRecorder bool, streamingStep bool) {
+	AppendEndpointsWithOptions(router, logger, &EndpointOptions{ExposeRecorder: exposeRecorder, StreamingStep: streamingStep})
+}
+
+// AppendEndpointsWithOptions registers the endpoints selected by the options
+func AppendEndpointsWithOptions(router *httprouter.Router, logger log.Logger, options *EndpointOptions) {
 
 	sm := &ServiceEndpoints{
-		muc:       sync.NewCond(&sync.Mutex{}),
-		stepSlice: [][]byte{},
-		logger:    logger,
-		stepStore: store.RegistedStore(),
+		claims:        NewClaims(),
+		spool:         options.Spool,
+		pipeline:      options.Pipeline,
+		janitor:       options.Janitor,
+		logger:        logger,
+		stepStore:     store.RegistedStore(),
+		streamingStep: options.StreamingStep,
+		webhooks:      options.Webhooks,
 	}
 
 	router.GET("/v1/health", sm.getHealthCheck)
@@ -71,8 +104,9 @@ func AppendEndpoints(router *httprouter.Router, logger log.Logger, exposeRecorde
 	router.POST("/v1/app/state/:appName", sm.saveAppState)
 	router.DELETE("/v1/app/state/:appName", sm.saveAppState)
 
-	if streamingStep {
-		router.GET("/v1/stream/steps", event.HandleStepEvent)
+	if options.StreamingStep {
+		router.GET("/v1/stream/steps", event.StepStreamHandler(checkStreamFilter))
+		router.GET("/v1/stream/events", sm.streamEvents)
 		event.StartStepListener()
 	}
 
@@ -82,16 +116,28 @@ func AppendEndpoints(router *httprouter.Router, logger log.Logger, exposeRecorde
 	router.DELETE("/v1/instances/:flowId/step/:stepId", sm.deleteSteps)
 	router.GET("/v1/instances/:flowId/failedtask", sm.getFaildTaskStepId)
 
-	if exposeRecorder {
+	if options.ExposeRecorder {
 		router.POST("/v1/instances/snapshot", sm.saveSnapshot)
 		router.POST("/v1/instances/steps", sm.saveStep)
 		router.POST("/v1/instances/start", sm.saveStart)
 		router.POST("/v1/instances/end", sm.saveEnd)
+		router.POST("/v1/instances/batch", sm.saveBatch)
+		router.GET("/v1/ingestion/status", sm.getIngestionStatus)
 	}
-	// start saveStep worker go routines
-	maxOpenConn := sm.stepStore.MaxConcurrencyLimit()
-	for i := 0; i < maxOpenConn; i++ {
-		go saveStepWorker(sm)
+	if sm.janitor != nil {
+		router.GET("/v1/retention/report", sm.getRetentionReport)
+		router.GET("/v1/retention/stats", sm.getRetentionStats)
+	}
+	if sm.webhooks != nil {
+		router.POST("/v1/webhooks", sm.createWebhook)
+		router.GET("/v1/webhooks", sm.getWebhooks)
+		router.GET("/v1/webhooks/:id", sm.getWebhook)
+		router.PUT("/v1/webhooks/:id", sm.updateWebhook)
+		router.DELETE("/v1/webhooks/:id", sm.deleteWebhook)
+		router.GET("/v1/webhooks/:id/deliveries", sm.getWebhookDeliveries)
+	}
+	if sm.spool != nil && sm.pipeline != nil {
+		go sm.dispatchSpool()
 	}
 }
 
@@ -100,7 +146,7 @@ func (se *ServiceEndpoints) getHealthCheck(response http.ResponseWriter, request
 	switch request.Method {
 	case http.MethodGet:
 
-		status := se.stepStore.Status().(*postgres.DBDetails)
+		status := se.stepStore.Status()
 
 		response.Header().Set("Content-Type", "application/json")
 		response.WriteHeader(http.StatusOK)
@@ -115,7 +161,7 @@ func (se *ServiceEndpoints) getHealthCheck(response http.ResponseWriter, request
 func (se *ServiceEndpoints) getInstances(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
 	se.logger.Debugf("Endpoint[GET:/instances] : Called")
 
-	userName := request.Header.Get(Flogo_UserName)
+	userName := se.userName(request)
 	if len(userName) <= 0 {
 		se.logger.Error("Sending error response as user information not provided")
 		http.Error(response, "unauthorized, please provide user information", http.StatusUnauthorized)
@@ -129,6 +175,10 @@ func (se *ServiceEndpoints) getInstances(response http.ResponseWriter, request *
 		return
 	}
 
+	if !se.authorizeApp(response, request, appName) {
+		return
+	}
+
 	appVersion := request.URL.Query().Get(FLOGO_APPVERSION)
 	if len(appVersion) <= 0 {
 		se.logger.Error("Sending error response as app version not provided")
@@ -146,11 +196,21 @@ func (se *ServiceEndpoints) getInstances(response http.ResponseWriter, request *
 
 	offsetValue := request.URL.Query().Get(OFFSET)
 	if len(offsetValue) > 0 {
+		if v, err := strconv.Atoi(offsetValue); err != nil || v < 0 {
+			se.logger.Error("Sending error response as offset is not a valid number")
+			http.Error(response, "Please provide offset as a non negative integer", http.StatusBadRequest)
+			return
+		}
 		metadata.Offset = offsetValue
 	}
 
 	limitValue := request.URL.Query().Get(LIMIT)
 	if len(limitValue) > 0 {
+		if v, err := strconv.Atoi(limitValue); err != nil || v < 0 {
+			se.logger.Error("Sending error response as limit is not a valid number")
+			http.Error(response, "Please provide limit as a non negative integer", http.StatusBadRequest)
+			return
+		}
 		metadata.Limit = limitValue
 	}
 
@@ -216,8 +276,11 @@ func (se *ServiceEndpoints) getInstances(response http.ResponseWriter, request *
 func (se *ServiceEndpoints) getInstance(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
 	flowId := params.ByName("flowId")
 	se.logger.Debugf("Endpoint[GET:/instances/%s/details] : Called", flowId)
+	if !se.authorizeFlow(response, request, flowId) {
+		return
+	}
 
-	userName := request.Header.Get(Flogo_UserName)
+	userName := se.userName(request)
 	if len(userName) <= 0 {
 		se.logger.Error("Sending error response as user information not provided")
 		http.Error(response, "unauthorized, please provide user information", http.StatusUnauthorized)
@@ -262,6 +325,9 @@ func (se *ServiceEndpoints) getInstance(response http.ResponseWriter, request *h
 func (se *ServiceEndpoints) getStatus(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
 	flowId := params.ByName("flowId")
 	se.logger.Debugf("Endpoint[GET:/instances/%s/status] : Called", flowId)
+	if !se.authorizeFlow(response, request, flowId) {
+		return
+	}
 	status := se.stepStore.GetStatus(flowId)
 
 	if status == -1 {
@@ -287,6 +353,9 @@ func (se *ServiceEndpoints) getStatus(response http.ResponseWriter, request *htt
 func (se *ServiceEndpoints) getSteps(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
 	flowId := params.ByName("flowId")
 	se.logger.Debugf("Endpoint[GET:/instances/%s/steps] : Called", flowId)
+	if !se.authorizeFlow(response, request, flowId) {
+		return
+	}
 	steps, err := se.stepStore.GetSteps(flowId)
 	if err != nil {
 		se.logger.Error("Sending error response as get steps error: " + err.Error())
@@ -308,6 +377,9 @@ func (se *ServiceEndpoints) getSteps(response http.ResponseWriter, request *http
 func (se *ServiceEndpoints) getStepsStatus(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
 	flowId := params.ByName("flowId")
 	se.logger.Debugf("Endpoint[GET:/instances/%s/steps/status] : Called", flowId)
+	if !se.authorizeFlow(response, request, flowId) {
+		return
+	}
 	steps, err := se.stepStore.GetStepsStatus(flowId)
 	if err != nil {
 		se.logger.Error("Sending error response as get steps status error: " + err.Error())
@@ -327,6 +399,9 @@ func (se *ServiceEndpoints) getStepsStatus(response http.ResponseWriter, request
 func (se *ServiceEndpoints) getStepsAsTasks(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
 	flowId := params.ByName("flowId")
 	se.logger.Debugf("Endpoint[GET:/instances/%s/steps/tasks] : Called", flowId)
+	if !se.authorizeFlow(response, request, flowId) {
+		return
+	}
 	tasks, err := se.stepStore.GetStepsAsTasks(flowId)
 	if err != nil {
 		se.logger.Error("Sending error response as get tasks error:" + err.Error())
@@ -349,6 +424,9 @@ func (se *ServiceEndpoints) deleteSteps(response http.ResponseWriter, request *h
 	flowId := params.ByName("flowId")
 	stepId := params.ByName("stepId")
 	se.logger.Debugf("Endpoint[GET:/instances/%s/step/%s] : Called", flowId, stepId)
+	if !se.authorizeFlow(response, request, flowId) {
+		return
+	}
 	err := se.stepStore.DeleteSteps(flowId, stepId)
 	if err != nil {
 		se.logger.Error("Sending error response as deleteSteps error:" + err.Error())
@@ -364,6 +442,9 @@ func (se *ServiceEndpoints) getStepdataForActivity(response http.ResponseWriter,
 	stepid := params.ByName("stepId")
 	taskname := request.URL.Query().Get("taskName")
 	se.logger.Debugf("Endpoint[GET:/instances/%s/step/%s/taskdata] : Called", flowId, stepid)
+	if !se.authorizeFlow(response, request, flowId) {
+		return
+	}
 	stepdata, err := se.stepStore.GetStepdataForActivity(flowId, stepid, taskname)
 	if err != nil {
 		se.logger.Error("Sending error response as get stepdata error:" + err.Error())
@@ -385,7 +466,7 @@ func (se *ServiceEndpoints) getStepdataForActivity(response http.ResponseWriter,
 func (se *ServiceEndpoints) getFlowNames(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
 	se.logger.Debugf("Endpoint[GET:/flows] : Called")
 
-	userName := request.Header.Get(Flogo_UserName)
+	userName := se.userName(request)
 	if len(userName) <= 0 {
 		se.logger.Error("Sending error response as user information not provided")
 		http.Error(response, "unauthorized, please provide user information", http.StatusUnauthorized)
@@ -399,6 +480,10 @@ func (se *ServiceEndpoints) getFlowNames(response http.ResponseWriter, request *
 		return
 	}
 
+	if !se.authorizeApp(response, request, appName) {
+		return
+	}
+
 	appVersion := request.URL.Query().Get(FLOGO_APPVERSION)
 	if len(appVersion) <= 0 {
 		se.logger.Error("Sending error response as app version not provided")
@@ -434,13 +519,17 @@ func (se *ServiceEndpoints) getAppVersions(response http.ResponseWriter, request
 	appName := params.ByName("appName")
 	se.logger.Debugf("Endpoint[GET:/apps/%s/versions] : Called", appName)
 
-	userName := request.Header.Get(Flogo_UserName)
+	userName := se.userName(request)
 	if len(userName) <= 0 {
 		se.logger.Error("Sending error response as user information not provided")
 		http.Error(response, "unauthorized, please provide user information", http.StatusUnauthorized)
 		return
 	}
 
+	if !se.authorizeApp(response, request, appName) {
+		return
+	}
+
 	metadata := &metadata.Metadata{
 		Username: userName,
 		AppName:  appName,
@@ -468,13 +557,17 @@ func (se *ServiceEndpoints) getAppState(response http.ResponseWriter, request *h
 
 	se.logger.Debugf("Endpoint[GET:/app/state/%s] : Called", appName)
 
-	userName := request.Header.Get(Flogo_UserName)
+	userName := se.userName(request)
 	if len(userName) <= 0 {
 		se.logger.Error("Sending error response as user information not provided")
 		http.Error(response, "unauthorized, please provide user information", http.StatusUnauthorized)
 		return
 	}
 
+	if !se.authorizeApp(response, request, appName) {
+		return
+	}
+
 	metadata := &metadata.Metadata{
 		Username: userName,
 		AppName:  appName,
@@ -498,13 +591,17 @@ func (se *ServiceEndpoints) saveAppState(response http.ResponseWriter, request *
 	appName := params.ByName("appName")
 	se.logger.Debugf("Endpoint[%s:/app/state/%s] : Called", request.Method, appName)
 
-	userName := request.Header.Get(Flogo_UserName)
+	userName := se.userName(request)
 	if len(userName) <= 0 {
 		se.logger.Error("Sending error response as user information not provided")
 		http.Error(response, "unauthorized, please provide user information", http.StatusUnauthorized)
 		return
 	}
 
+	if !se.authorizeApp(response, request, appName) {
+		return
+	}
+
 	persistEnable := false
 	switch request.Method {
 	case http.MethodPost:
@@ -534,6 +631,9 @@ func (se *ServiceEndpoints) saveAppState(response http.ResponseWriter, request *
 func (se *ServiceEndpoints) getSnapshot(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
 	flowId := params.ByName("flowId")
 	se.logger.Debugf("Endpoint[GET:/instances/%s/snapshot] : Called", flowId)
+	if !se.authorizeFlow(response, request, flowId) {
+		return
+	}
 	snapshot := se.stepStore.GetSnapshot(flowId)
 
 	if snapshot == nil {
@@ -563,6 +663,9 @@ func (se *ServiceEndpoints) getSnapshotAtStep(response http.ResponseWriter, requ
 	stepIdStr := params.ByName("stepId")
 
 	se.logger.Debugf("Endpoint[GET:/instances/%s/snapshot/%s] : Called", flowId, stepIdStr)
+	if !se.authorizeFlow(response, request, flowId) {
+		return
+	}
 	steps, err := se.stepStore.GetSteps(flowId)
 	if err != nil {
 		http.Error(response, "get getSnapshotAtStep error:"+err.Error(), http.StatusInternalServerError)
@@ -598,6 +701,9 @@ func (se *ServiceEndpoints) getSnapshotAtStep(response http.ResponseWriter, requ
 func (se *ServiceEndpoints) getFaildTaskStepId(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
 	flowId := params.ByName("flowId")
 	se.logger.Debugf("Endpoint[GET:/instances/%s/failedtask] : Called", flowId)
+	if !se.authorizeFlow(response, request, flowId) {
+		return
+	}
 	steps, err := se.stepStore.GetStepsStatus(flowId)
 	if err != nil {
 		http.Error(response, "get getSnapshotAtStep error:"+err.Error(), http.StatusInternalServerError)
@@ -623,6 +729,9 @@ func (se *ServiceEndpoints) getFaildTaskStepId(response http.ResponseWriter, req
 func (se *ServiceEndpoints) deleteInstance(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
 	flowId := params.ByName("flowId")
 	se.logger.Debugf("Endpoint[DEL:/instances/%s] : Called", flowId)
+	if !se.authorizeFlow(response, request, flowId) {
+		return
+	}
 
 	//se.stepStore.Delete(flowId)
 	se.stepStore.Delete(flowId)
@@ -640,26 +749,13 @@ func (se *ServiceEndpoints) saveStart(response http.ResponseWriter, request *htt
 		se.logger.Error("Endpoint[POST:/instances/start] : %v", err)
 		return
 	}
+	if code, err := se.authorizeRecord(request, batch.Start, content); err != nil {
+		se.error(response, code, err)
+		return
+	}
 	if asyncCalling {
 		se.logger.Debug("Calling saveStart in Async way")
-		response.Header().Set("Content-Type", "application/json")
-		response.WriteHeader(http.StatusAccepted)
-		se.logger.Debug("Response sent with StatusAccepted.")
-
-		go func() {
-			step := &state.FlowState{}
-			err = json.Unmarshal(content, step)
-			if err != nil {
-				se.logger.Debugf("Endpoint[POST:/instances/start] : Step content - %s ", string(content))
-				se.logger.Errorf("Endpoint[POST:/instances/start] : Error unmarshalling step - %v", err)
-				return
-			}
-			err = se.stepStore.RecordStart(step)
-			if err != nil {
-				se.logger.Errorf("Endpoint[POST:/instances/start] : Error saving step - %v", err)
-				return
-			}
-		}()
+		se.spoolRecord(response, spool.Start, content)
 	} else {
 		step := &state.FlowState{}
 		err = json.Unmarshal(content, step)
@@ -683,28 +779,6 @@ func (se *ServiceEndpoints) saveStart(response http.ResponseWriter, request *htt
 
 }
 
-func (se *ServiceEndpoints) addToStepSlice(content []byte) {
-	se.muc.L.Lock()
-	defer se.muc.L.Unlock()
-	se.stepSlice = append(se.stepSlice, content)
-	se.muc.Broadcast() // broadcast signal to all waiting go routines
-}
-func (se *ServiceEndpoints) popFromStepSlice() []byte {
-	se.muc.L.Lock()
-	defer se.muc.L.Unlock()
-	var firstItem []byte
-	for {
-		if len(se.stepSlice) > 0 {
-			firstItem, se.stepSlice = se.stepSlice[0], se.stepSlice[1:]
-			return firstItem
-		} else {
-			// se.logger.Infof("StepSlice is Empty so going to wait")
-			se.muc.Wait() // wait for condition to have non empty slice
-			// se.logger.Infof("Received signal, came out of wait")
-		}
-	}
-	return nil
-}
 func (se *ServiceEndpoints) saveStep(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
 	se.logger.Debugf("Endpoint[POST:/instances/steps] : Called")
 	asyncCalling := request.Header.Get(ASYNC_CALLING_HEADER) == "true"
@@ -714,16 +788,13 @@ func (se *ServiceEndpoints) saveStep(response http.ResponseWriter, request *http
 		se.logger.Error("Endpoint[POST:/instances/steps] : %v", err)
 		return
 	}
+	if code, err := se.authorizeRecord(request, batch.Step, content); err != nil {
+		se.error(response, code, err)
+		return
+	}
 	if asyncCalling {
 		se.logger.Debug("Calling saveStep in Async way")
-		response.Header().Set("Content-Type", "application/json")
-		response.WriteHeader(http.StatusAccepted)
-		se.logger.Debug("Response sent with StatusAccepted.")
-		// store content in channel, it will be picked by limited goroutines for db processing
-		go func(content []byte) {
-			se.addToStepSlice(content) // add item to slice in separate go routine
-			se.logger.Info("content added to shared step slice.")
-		}(content)
+		se.spoolRecord(response, spool.Step, content)
 	} else {
 		step := &state.Step{}
 		err = json.Unmarshal(content, step)
@@ -744,34 +815,6 @@ func (se *ServiceEndpoints) saveStep(response http.ResponseWriter, request *http
 	}
 }
 
-func saveStepWorker(se *ServiceEndpoints) {
-	for { // infinite loop for getting work from channel
-		// se.logger.Infof("pop from slice")
-		content := se.popFromStepSlice()
-		if content != nil {
-			se.logger.Info("content received from slice.")
-			step := &state.Step{}
-			err := json.Unmarshal(content, step)
-			if err != nil {
-				se.logger.Debugf("Endpoint[POST:/instances/steps] : Step content - %s ", string(content))
-				se.logger.Errorf("Endpoint[POST:/instances/steps] : Error unmarshalling step - %v", err)
-				continue
-			}
-			err = se.stepStore.SaveStep(step)
-			if err != nil {
-				se.logger.Errorf("Endpoint[POST:/instances/steps] : Error saving step - %v", err)
-			}
-		} else {
-			// se.logger.Infof("**** Should never get here, StepSlice is Empty")
-			/*	se.muc.L.Lock()
-				se.logger.Infof("StepSlice is Empty so going to wait")
-				se.muc.Wait()
-				se.logger.Infof("StepSlice out of wait")
-				se.muc.L.Unlock()*/
-		}
-	}
-}
-
 /*func (se *ServiceEndpoints) saveStep(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
 	se.logger.Debugf("Endpoint[POST:/instances/steps] : Called")
 	asyncCalling := request.Header.Get(ASYNC_CALLING_HEADER) == "true"
@@ -829,27 +872,14 @@ func (se *ServiceEndpoints) saveSnapshot(response http.ResponseWriter, request *
 		se.logger.Error("Endpoint[POST:/instances/snapshot] : %v", err)
 		return
 	}
+	if code, err := se.authorizeRecord(request, batch.Snapshot, content); err != nil {
+		se.error(response, code, err)
+		return
+	}
 
 	if asyncCalling {
 		se.logger.Debug("Calling saveSnapshot in Async way")
-		response.Header().Set("Content-Type", "application/json")
-		response.WriteHeader(http.StatusAccepted)
-		se.logger.Debug("Response sent with StatusAccepted.")
-
-		go func() {
-			snapshot := &state.Snapshot{SnapshotBase: &state.SnapshotBase{}}
-			err = json.Unmarshal(content, snapshot)
-			if err != nil {
-				se.logger.Debugf("Endpoint[POST:/instances/snapshot] : Snapshot content - %s ", string(content))
-				se.logger.Errorf("Endpoint[POST:/instances/snapshot] : Error unmarshalling snapshot - %v", err)
-				return
-			}
-			err = se.stepStore.SaveSnapshot(snapshot)
-			if err != nil {
-				se.logger.Errorf("Endpoint[POST:/instances/snapshot] : Error saving snapshot - %v", err)
-				return
-			}
-		}()
+		se.spoolRecord(response, spool.Snapshot, content)
 	} else {
 		snapshot := &state.Snapshot{SnapshotBase: &state.SnapshotBase{}}
 		err = json.Unmarshal(content, snapshot)
@@ -879,27 +909,14 @@ func (se *ServiceEndpoints) saveEnd(response http.ResponseWriter, request *http.
 		se.logger.Error("Endpoint[POST:/instances/end] : %v", err)
 		return
 	}
+	if code, err := se.authorizeRecord(request, batch.End, content); err != nil {
+		se.error(response, code, err)
+		return
+	}
 
 	if asyncCalling {
 		se.logger.Debug("Calling saveEnd in Async way")
-		response.Header().Set("Content-Type", "application/json")
-		response.WriteHeader(http.StatusAccepted)
-		se.logger.Debug("Response sent with StatusAccepted.")
-
-		go func() {
-			step := &state.FlowState{}
-			err = json.Unmarshal(content, step)
-			if err != nil {
-				se.logger.Debugf("Endpoint[POST:/instances/end] : Step content - %s ", string(content))
-				se.logger.Errorf("Endpoint[POST:/instances/end] : Error unmarshalling step - %v", err)
-				return
-			}
-			err = se.stepStore.RecordEnd(step)
-			if err != nil {
-				se.logger.Errorf("Endpoint[POST:/instances/end] : Error saving step - %v", err)
-				return
-			}
-		}()
+		se.spoolRecord(response, spool.End, content)
 	} else {
 		step := &state.FlowState{}
 		err = json.Unmarshal(content, step)
@@ -920,11 +937,403 @@ func (se *ServiceEndpoints) saveEnd(response http.ResponseWriter, request *http.
 	}
 }
 
+type batchResponse struct {
+	Results []*batch.Result `json:"results"`
+}
+
+var spoolKinds = map[batch.Kind]spool.Kind{
+	batch.Start:    spool.Start,
+	batch.Step:     spool.Step,
+	batch.Snapshot: spool.Snapshot,
+	batch.End:      spool.End,
+}
+
+func (se *ServiceEndpoints) saveBatch(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	se.logger.Debugf("Endpoint[POST:/instances/batch] : Called")
+	asyncCalling := request.Header.Get(ASYNC_CALLING_HEADER) == "true"
+	content, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		se.error(response, http.StatusBadRequest, fmt.Errorf("unable to read body"))
+		se.logger.Errorf("Endpoint[POST:/instances/batch] : %v", err)
+		return
+	}
+
+	records, err := batch.Parse(content)
+	if err != nil {
+		se.error(response, http.StatusBadRequest, fmt.Errorf("unable to unmarshal batch, %s", err.Error()))
+		return
+	}
+	if len(records) > MaxBatchSize {
+		se.error(response, http.StatusRequestEntityTooLarge, fmt.Errorf("batch has %d records, at most %d are allowed", len(records), MaxBatchSize))
+		return
+	}
+
+	results := make([]*batch.Result, len(records))
+	var items []*batch.Item
+	var indexes []int
+	for i, record := range records {
+		results[i] = &batch.Result{Index: i}
+		if record != nil {
+			results[i].Type = record.Type
+		}
+		item, err := record.Decode()
+		if err != nil {
+			results[i].Status, results[i].Error = http.StatusBadRequest, err.Error()
+			continue
+		}
+		if code, err := se.authorizeItem(request, item); err != nil {
+			results[i].Status, results[i].Error = code, err.Error()
+			continue
+		}
+		if asyncCalling {
+			se.spoolBatchRecord(results[i], record)
+			continue
+		}
+		items = append(items, item)
+		indexes = append(indexes, i)
+	}
+
+	if len(items) > 0 {
+		se.applyBatch(items, indexes, results)
+	}
+
+	code := http.StatusOK
+	for _, result := range results {
+		if result.Status >= http.StatusMultipleChoices {
+			code = http.StatusMultiStatus
+			break
+		}
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(code)
+	if err := json.NewEncoder(response).Encode(&batchResponse{Results: results}); err != nil {
+		se.logger.Error(err.Error())
+	}
+}
+
+func (se *ServiceEndpoints) spoolBatchRecord(result *batch.Result, record *batch.Record) {
+	if se.spool == nil {
+		result.Status, result.Error = http.StatusServiceUnavailable, "async recording is not enabled"
+		return
+	}
+	err := se.spool.Append(spoolKinds[record.Type], record.Data)
+	switch {
+	case err == nil:
+		result.Status = http.StatusAccepted
+	case err == spool.ErrFull:
+		if se.pipeline != nil {
+			se.pipeline.Reject()
+		}
+		result.Status, result.Error = http.StatusServiceUnavailable, fmt.Sprintf("async %s queue is full, retry later", record.Type)
+	default:
+		se.logger.Errorf("Unable to spool async %s - %v", record.Type, err)
+		result.Status, result.Error = http.StatusInternalServerError, fmt.Sprintf("unable to queue %s", record.Type)
+	}
+}
+
+// applyBatch saves the items in one transaction when the store supports it, item by item otherwise
+func (se *ServiceEndpoints) applyBatch(items []*batch.Item, indexes []int, results []*batch.Result) {
+	if batchStore, ok := se.stepStore.(batch.Store); ok {
+		err := batchStore.SaveBatch(items)
+		for _, index := range indexes {
+			results[index].Status = http.StatusOK
+			if err != nil {
+				results[index].Status, results[index].Error = http.StatusFailedDependency, "batch rolled back"
+			}
+		}
+		if err != nil {
+			se.logger.Errorf("Endpoint[POST:/instances/batch] : Error saving batch - %v", err)
+			if itemErr, ok := err.(*batch.ItemError); ok && itemErr.Index < len(indexes) {
+				failed := results[indexes[itemErr.Index]]
+				failed.Status, failed.Error = http.StatusInternalServerError, itemErr.Err.Error()
+			} else {
+				for _, index := range indexes {
+					results[index].Status, results[index].Error = http.StatusInternalServerError, err.Error()
+				}
+			}
+		}
+		return
+	}
+
+	for i, item := range items {
+		var err error
+		switch item.Kind {
+		case batch.Start:
+			err = se.stepStore.RecordStart(item.FlowState)
+		case batch.Step:
+			err = se.stepStore.SaveStep(item.Step)
+		case batch.Snapshot:
+			err = se.stepStore.SaveSnapshot(item.Snapshot)
+		case batch.End:
+			err = se.stepStore.RecordEnd(item.FlowState)
+		}
+		result := results[indexes[i]]
+		result.Status = http.StatusOK
+		if err != nil {
+			se.logger.Errorf("Endpoint[POST:/instances/batch] : Error saving %s - %v", item.Kind, err)
+			result.Status, result.Error = http.StatusInternalServerError, fmt.Sprintf("unable to save %s", item.Kind)
+		}
+	}
+}
+
+// spoolRecord durably queues an async recorder call, the client is asked to back off when the spool is full
+func (se *ServiceEndpoints) spoolRecord(response http.ResponseWriter, kind spool.Kind, content []byte) {
+	if !json.Valid(content) {
+		se.error(response, http.StatusBadRequest, fmt.Errorf("unable to unmarshal %s json", kind))
+		se.logger.Debugf("Async %s content - %s ", kind, string(content))
+		return
+	}
+
+	if err := se.spool.Append(kind, content); err != nil {
+		if err == spool.ErrFull {
+			se.logger.Warnf("Rejecting async %s, spool is full", kind)
+			if se.pipeline != nil {
+				se.pipeline.Reject()
+			}
+			response.Header().Set("Retry-After", "1")
+			se.error(response, http.StatusServiceUnavailable, fmt.Errorf("async %s queue is full, retry later", kind))
+			return
+		}
+		se.logger.Errorf("Unable to spool async %s - %v", kind, err)
+		se.error(response, http.StatusInternalServerError, fmt.Errorf("unable to queue %s", kind))
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusAccepted)
+	se.logger.Debug("Response sent with StatusAccepted.")
+}
+
+// dispatchSpool hands spooled records to the ingestion pipeline in spool order, records
+// left from a previous run are replayed first
+func (se *ServiceEndpoints) dispatchSpool() {
+	if recovered := se.spool.Recovered(); recovered > 0 {
+		se.logger.Infof("Replaying %d spooled records", recovered)
+	}
+	for {
+		entry, err := se.spool.Next()
+		if err != nil {
+			if err == spool.ErrClosed {
+				se.pipeline.Close()
+				return
+			}
+			se.logger.Errorf("Unable to read spooled record - %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		se.pipeline.Submit(se.newJob(entry))
+	}
+}
+
+func (se *ServiceEndpoints) newJob(entry *spool.Entry) *ingest.Job {
+	job := &ingest.Job{Barrier: true, Done: func() { se.spool.Ack(entry) }}
+
+	var err error
+	switch entry.Kind {
+	case spool.Start, spool.End:
+		flowState := &state.FlowState{}
+		if err = json.Unmarshal(entry.Data, flowState); err == nil {
+			job.FlowId = flowState.FlowInstanceId
+			if entry.Kind == spool.Start {
+				job.Apply = func() error { return se.stepStore.RecordStart(flowState) }
+			} else {
+				job.Apply = func() error { return se.stepStore.RecordEnd(flowState) }
+			}
+		}
+	case spool.Step:
+		step := &state.Step{}
+		if err = json.Unmarshal(entry.Data, step); err == nil {
+			job.FlowId, job.Seq, job.Barrier = step.FlowId, step.Id, false
+			job.Apply = func() error { return se.stepStore.SaveStep(step) }
+		}
+	case spool.Snapshot:
+		snapshot := &state.Snapshot{SnapshotBase: &state.SnapshotBase{}}
+		if err = json.Unmarshal(entry.Data, snapshot); err == nil {
+			job.FlowId = snapshot.Id
+			job.Apply = func() error { return se.stepStore.SaveSnapshot(snapshot) }
+		}
+	default:
+		err = fmt.Errorf("unknown record kind %d", entry.Kind)
+	}
+
+	if err != nil {
+		se.logger.Debugf("Async %s content - %s ", entry.Kind, string(entry.Data))
+		err = ingest.Permanent(fmt.Errorf("unable to unmarshal async %s - %v", entry.Kind, err))
+		job.Apply = func() error { return err }
+	}
+	return job
+}
+
+type ingestionStatus struct {
+	*ingest.Stats
+	SpoolBytes        int64 `json:"spoolBytes"`
+	SpoolPendingBytes int64 `json:"spoolPendingBytes"`
+}
+
+func (se *ServiceEndpoints) getIngestionStatus(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
+	se.logger.Debugf("Endpoint[GET:/ingestion/status] : Called")
+	if se.pipeline == nil {
+		se.error(response, http.StatusNotFound, fmt.Errorf("async ingestion is not enabled"))
+		return
+	}
+
+	status := &ingestionStatus{Stats: se.pipeline.Stats()}
+	if se.spool != nil {
+		status.SpoolBytes = se.spool.Size()
+		status.SpoolPendingBytes = se.spool.Pending()
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(response).Encode(status); err != nil {
+		se.logger.Error(err.Error())
+	}
+}
+
+type retentionReport struct {
+	*retention.Report
+	Stats *retention.Stats `json:"stats"`
+}
+
+// getRetentionReport lists what the next retention run would purge without deleting anything
+func (se *ServiceEndpoints) getRetentionReport(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	se.logger.Debugf("Endpoint[GET:/retention/report] : Called")
+	report, err := se.janitor.Plan()
+	if err != nil {
+		se.logger.Errorf("Endpoint[GET:/retention/report] : Error planning retention - %v", err)
+		se.error(response, http.StatusInternalServerError, err)
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(response).Encode(&retentionReport{Report: report, Stats: se.janitor.Stats()}); err != nil {
+		se.logger.Error(err.Error())
+	}
+}
+
+func (se *ServiceEndpoints) getRetentionStats(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	se.logger.Debugf("Endpoint[GET:/retention/stats] : Called")
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(response).Encode(se.janitor.Stats()); err != nil {
+		se.logger.Error(err.Error())
+	}
+}
+
 type StateError struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
 }
 
+// userName is the authenticated user, or the username header when authentication is disabled
+func (se *ServiceEndpoints) userName(request *http.Request) string {
+	if id := IdentityFrom(request); id != nil {
+		return id.User
+	}
+	return request.Header.Get(Flogo_UserName)
+}
+
+// authorizeApp replies 403 and returns false when the caller may not access appName
+func (se *ServiceEndpoints) authorizeApp(response http.ResponseWriter, request *http.Request, appName string) bool {
+	if id := IdentityFrom(request); id != nil && !id.AllowsApp(appName) {
+		se.logger.Debugf("User [%s] is not allowed to access app [%s]", id.User, appName)
+		se.error(response, http.StatusForbidden, fmt.Errorf("access to app %s is not allowed", appName))
+		return false
+	}
+	return true
+}
+
+// authorizeFlow replies with an error and returns false when the flow instance does not belong to the caller
+func (se *ServiceEndpoints) authorizeFlow(response http.ResponseWriter, request *http.Request, flowId string) bool {
+	id := IdentityFrom(request)
+	if id == nil {
+		return true
+	}
+	if code, err := AuthorizeFlow(se.stepStore, id, flowId); err != nil {
+		se.error(response, code, err)
+		return false
+	}
+	return true
+}
+
+// authorizeRecord checks a recorder call before it is saved or spooled
+func (se *ServiceEndpoints) authorizeRecord(request *http.Request, kind batch.Kind, content []byte) (int, error) {
+	if IdentityFrom(request) == nil {
+		return 0, nil
+	}
+	item, err := (&batch.Record{Type: kind, Data: content}).Decode()
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	return se.authorizeItem(request, item)
+}
+
+// authorizeItem checks that the caller records a flow instance of its own user and apps
+func (se *ServiceEndpoints) authorizeItem(request *http.Request, item *batch.Item) (int, error) {
+	id := IdentityFrom(request)
+	if id == nil {
+		return 0, nil
+	}
+	return AuthorizeItem(se.stepStore, se.claims, id, item)
+}
+
+// AuthorizeItem returns the status to reply with when id may not record the item, a flow instance
+// of another user or app. Only a start may record an instance whose owner is not known yet, with claims
+// the start claims the instance for the records that follow it until it is recorded
+func AuthorizeItem(stepStore store.Store, claims *Claims, id *Identity, item *batch.Item) (int, error) {
+	var flowId string
+	var start *metadata.Owner
+	switch item.Kind {
+	case batch.Start, batch.End:
+		if item.FlowState.UserId != id.User || !id.AllowsApp(item.FlowState.AppName) {
+			return http.StatusForbidden, fmt.Errorf("recording flow instances of user %s and app %s is not allowed", item.FlowState.UserId, item.FlowState.AppName)
+		}
+		flowId = item.FlowState.FlowInstanceId
+		if item.Kind == batch.Start {
+			start = &metadata.Owner{Username: item.FlowState.UserId, AppName: item.FlowState.AppName, AppVersion: item.FlowState.AppVersion}
+		}
+	case batch.Step:
+		flowId = item.Step.FlowId
+	case batch.Snapshot:
+		flowId = item.Snapshot.Id
+	}
+	return authorizeFlow(stepStore, claims, id, flowId, start)
+}
+
+// AuthorizeFlow returns the status to reply with when id may not access the flow instance, instances
+// whose owner the store does not know are not accessible
+func AuthorizeFlow(stepStore store.Store, id *Identity, flowId string) (int, error) {
+	return authorizeFlow(stepStore, nil, id, flowId, nil)
+}
+
+func authorizeFlow(stepStore store.Store, claims *Claims, id *Identity, flowId string, start *metadata.Owner) (int, error) {
+	var owner *metadata.Owner
+	if ownerStore, ok := stepStore.(store.OwnerStore); ok {
+		var err error
+		owner, err = ownerStore.GetFlowOwner(flowId)
+		if err != nil {
+			logger.Errorf("Unable to get owner of flow instance [%s] - %v", flowId, err)
+			return http.StatusInternalServerError, fmt.Errorf("unable to check access to flow instance %s", flowId)
+		}
+	}
+
+	switch {
+	case owner != nil && claims != nil:
+		claims.release(flowId)
+	case claims != nil:
+		owner = claims.claim(flowId, start)
+	case start != nil:
+		owner = start
+	}
+
+	if owner != nil && owner.Username == id.User && id.AllowsApp(owner.AppName) {
+		return 0, nil
+	}
+	logger.Debugf("User [%s] is not allowed to access flow instance [%s]", id.User, flowId)
+	return http.StatusForbidden, fmt.Errorf("access to flow instance %s is not allowed", flowId)
+}
+
 func (se *ServiceEndpoints) error(response http.ResponseWriter, code int, err error) {
 	flowError := &StateError{
 		Code:    code,
@@ -2,7 +2,14 @@ package rest
 
 import (
 	"fmt"
+	"github.com/project-flogo/services/flow-state/event"
+	"github.com/project-flogo/services/flow-state/event/sink"
+	"github.com/project-flogo/services/flow-state/ingest"
+	"github.com/project-flogo/services/flow-state/retention"
+	"github.com/project-flogo/services/flow-state/spool"
 	"github.com/project-flogo/services/flow-state/store"
+	"github.com/project-flogo/services/flow-state/webhook"
+	"net/http"
 	"strconv"
 
 	"github.com/julienschmidt/httprouter"
@@ -12,6 +19,9 @@ import (
 	"github.com/rs/cors"
 
 	_ "github.com/lib/pq"
+	_ "github.com/project-flogo/services/flow-state/event/sink/kafka"
+	_ "github.com/project-flogo/services/flow-state/event/sink/mqtt"
+	_ "github.com/project-flogo/services/flow-state/event/sink/nats"
 )
 
 const (
@@ -21,6 +31,15 @@ const (
 	SettingEnableTLS      = "enableTLS"
 	SettingCertFile       = "certFile"
 	SettingKeyFile        = "keyFile"
+	SettingSpool          = "spool"
+	SettingIngestion      = "ingestion"
+	SettingAuth           = "auth"
+	SettingRetention      = "retention"
+	SettingEventSinks     = "eventSinks"
+	SettingWebhooks       = "webhooks"
+
+	// SettingStreamingStepRetention is the number of recent steps kept to be replayed to the stream clients that resume
+	SettingStreamingStepRetention = "streamingStepRetention"
 
 	Persistence = "persistence"
 )
@@ -48,7 +67,14 @@ func (s *StateServiceFactory) NewService(config *service.Config) (service.Servic
 // StateService is an implementation of StateService service
 // that can access flows via URI
 type StateService struct {
-	server *Server
+	server   *Server
+	spool    *spool.Spool
+	pipeline *ingest.Pipeline
+	janitor  *retention.Janitor
+
+	sinkConfigs []*sink.Config
+	sinks       []*sink.Forwarder
+	webhooks    *webhook.Manager
 }
 
 func (ss *StateService) Name() string {
@@ -62,12 +88,43 @@ func (ss *StateService) Enabled() bool {
 
 // Start implements util.Managed.Start()
 func (ss *StateService) Start() error {
-	return ss.server.Start()
+	if err := ss.server.Start(); err != nil {
+		return err
+	}
+	if ss.janitor != nil {
+		ss.janitor.Start()
+	}
+	sinks, err := sink.Start(ss.sinkConfigs)
+	if err != nil {
+		return err
+	}
+	ss.sinks = sinks
+	if ss.webhooks != nil {
+		ss.webhooks.Start()
+	}
+	return nil
 }
 
 // Stop implements util.Managed.Stop()
 func (ss *StateService) Stop() error {
-	return ss.server.Stop()
+	if ss.janitor != nil {
+		ss.janitor.Stop()
+	}
+	err := ss.server.Stop()
+	if ss.webhooks != nil {
+		ss.webhooks.Stop()
+	}
+	for _, forwarder := range ss.sinks {
+		if sinkErr := forwarder.Stop(); sinkErr != nil && err == nil {
+			err = sinkErr
+		}
+	}
+	if ss.spool != nil {
+		if spoolErr := ss.spool.Close(); spoolErr != nil && err == nil {
+			err = spoolErr
+		}
+	}
+	return err
 }
 
 // Init implements services.StateServiceService.Init()
@@ -93,6 +150,13 @@ func (ss *StateService) init(settings map[string]interface{}) error {
 	if stream, set := settings[SettingStreamingStep]; set {
 		streamingStep, _ = coerce.ToBool(stream)
 	}
+	if sRetained, set := settings[SettingStreamingStepRetention]; set && streamingStep {
+		retained, err := coerce.ToInt(sRetained)
+		if err != nil || retained <= 0 {
+			return fmt.Errorf("StateRecorder: invalid streamingStepRetention '%v'", sRetained)
+		}
+		event.Steps = event.NewHub(event.DefaultBufferSize, retained, event.DefaultMaxInstances)
+	}
 
 	var options []func(*Server)
 
@@ -114,6 +178,11 @@ func (ss *StateService) init(settings map[string]interface{}) error {
 		options = append(options, TLS(certFile, keyFile))
 	}
 
+	if streamingStep {
+		// the event streams write for as long as their clients are connected
+		options = append(options, Timeouts(httpDefaultReadTimeout, 0))
+	}
+
 	options = append(options, Logger(logger))
 
 	persistenceSettings, _ := coerce.ToObject(settings[Persistence])
@@ -121,14 +190,81 @@ func (ss *StateService) init(settings map[string]interface{}) error {
 		return fmt.Errorf("initialize state service persistence failed, due to [%s]", err.Error())
 	}
 
-	AppendEndpoints(router, logger, exposeRecorder, streamingStep)
+	if exposeRecorder {
+		spoolSettings, _ := coerce.ToObject(settings[SettingSpool])
+		spoolConfig, err := spool.NewConfig(spoolSettings)
+		if err != nil {
+			return fmt.Errorf("invalid state service spool settings, due to [%s]", err.Error())
+		}
+		ss.spool, err = spool.Open(spoolConfig)
+		if err != nil {
+			return fmt.Errorf("initialize state service spool failed, due to [%s]", err.Error())
+		}
+
+		// workers default to the number of concurrent calls the store supports
+		ingestionSettings, _ := coerce.ToObject(settings[SettingIngestion])
+		ingestionConfig, err := ingest.NewConfig(ingestionSettings, store.RegistedStore().MaxConcurrencyLimit())
+		if err != nil {
+			return fmt.Errorf("invalid state service ingestion settings, due to [%s]", err.Error())
+		}
+		ss.pipeline = ingest.New(ingestionConfig)
+	}
+
+	retentionSettings, _ := coerce.ToObject(settings[SettingRetention])
+	retentionConfig, err := retention.NewConfig(retentionSettings)
+	if err != nil {
+		return fmt.Errorf("invalid state service retention settings, due to [%s]", err.Error())
+	}
+	if retentionConfig != nil {
+		retentionStore, ok := store.RegistedStore().(retention.Store)
+		if !ok {
+			return fmt.Errorf("state service persistence does not support retention rules")
+		}
+		ss.janitor = retention.NewJanitor(retentionConfig, retentionStore)
+	}
+
+	ss.sinkConfigs, err = sink.NewConfigs(settings[SettingEventSinks])
+	if err != nil {
+		return fmt.Errorf("invalid state service event sinks settings, due to [%s]", err.Error())
+	}
+
+	webhookSettings, _ := coerce.ToObject(settings[SettingWebhooks])
+	webhookConfig, err := webhook.NewConfig(webhookSettings)
+	if err != nil {
+		return fmt.Errorf("invalid state service webhooks settings, due to [%s]", err.Error())
+	}
+	if webhookConfig != nil {
+		ss.webhooks, err = webhook.New(webhookConfig, store.RegistedStore())
+		if err != nil {
+			return fmt.Errorf("initialize state service webhooks failed, due to [%s]", err.Error())
+		}
+	}
+
+	AppendEndpointsWithOptions(router, logger, &EndpointOptions{
+		ExposeRecorder: exposeRecorder,
+		StreamingStep:  streamingStep,
+		Spool:          ss.spool,
+		Pipeline:       ss.pipeline,
+		Janitor:        ss.janitor,
+		Webhooks:       ss.webhooks,
+	})
+
+	var handler http.Handler = router
+	if authSettings, _ := coerce.ToObject(settings[SettingAuth]); len(authSettings) > 0 {
+		auth, err := NewAuthenticator(authSettings)
+		if err != nil {
+			return fmt.Errorf("invalid state service auth settings, due to [%s]", err.Error())
+		}
+		handler = Authenticate(auth, router)
+	}
 
 	c := cors.New(cors.Options{
 		AllowCredentials: true,
 		AllowedMethods:   []string{"GET", "POST", "DELETE", "PUT", "OPTIONS"},
+		AllowedHeaders:   []string{"Origin", "Accept", "Content-Type", "X-Requested-With", "Authorization", APIKeyHeader, Flogo_UserName},
 	})
 
-	server, err := newServer(":"+strconv.Itoa(port), c.Handler(router), options...)
+	server, err := newServer(":"+strconv.Itoa(port), c.Handler(handler), options...)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,155 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-flogo/services/flow-state/event"
+)
+
+const (
+	LAST_EVENT_ID_HEADER = "Last-Event-ID"
+	SINCE                = "since"
+	OVERFLOW             = "overflow"
+
+	// streamHeartbeat is the period of the comments keeping the idle event streams open through proxies
+	streamHeartbeat = 10 * time.Second
+)
+
+// streamEvents streams the steps, starts and ends of the flow instances as Server-Sent Events, filtered by the app,
+// version, flow, flowinstanceid and status query parameters like the websocket step stream. The id of every event is
+// its sequence number, a client reconnecting with the Last-Event-ID header or the since query parameter first
+// receives the retained events after it.
+func (se *ServiceEndpoints) streamEvents(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	se.logger.Debugf("Endpoint[GET:/stream/events] : Called")
+
+	flusher, ok := response.(http.Flusher)
+	if !ok {
+		se.error(response, http.StatusInternalServerError, fmt.Errorf("event streams are not supported by the connection"))
+		return
+	}
+
+	query := request.URL.Query()
+	filter := event.FilterFromQuery(query)
+	if code, err := checkStreamFilter(request, filter); err != nil {
+		se.error(response, code, err)
+		return
+	}
+
+	cursor := request.Header.Get(LAST_EVENT_ID_HEADER)
+	if cursor == "" {
+		cursor = query.Get(SINCE)
+	}
+	overflow := event.Overflow(query.Get(OVERFLOW))
+
+	var sub *event.Subscriber
+	var replay []*event.Event
+	var missed uint64
+	if cursor != "" {
+		since, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			se.error(response, http.StatusBadRequest, fmt.Errorf("Please provide the last event id as a non negative integer"))
+			return
+		}
+		sub, replay, missed = event.Steps.SubscribeSince(filter, overflow, since)
+	} else {
+		sub = event.Steps.Subscribe(filter, overflow)
+	}
+	defer sub.Close()
+
+	response.Header().Set("Content-Type", "text/event-stream")
+	response.Header().Set("Cache-Control", "no-cache")
+	// nginx buffers the responses unless told not to
+	response.Header().Set("X-Accel-Buffering", "no")
+	response.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for _, e := range replay {
+		if err := writeEvent(response, e, missed); err != nil {
+			se.logger.Debugf("Event stream closed: %v", err)
+			return
+		}
+		missed = 0
+	}
+	if missed > 0 {
+		if err := writeLagged(response, missed); err != nil {
+			se.logger.Debugf("Event stream closed: %v", err)
+			return
+		}
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(streamHeartbeat)
+	defer ticker.Stop()
+	for {
+		var err error
+		select {
+		case e := <-sub.Events():
+			err = writeEvent(response, e, sub.Missed())
+		case <-ticker.C:
+			_, err = fmt.Fprint(response, ": heartbeat\n\n")
+		case <-sub.Done():
+			// the client reconnects with the id of the last event it received and the retained events are replayed
+			se.logger.Warnf("Closed the event stream of a client that did not keep up")
+			return
+		case <-request.Context().Done():
+			return
+		}
+		if err != nil {
+			se.logger.Debugf("Event stream closed: %v", err)
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// checkStreamFilter restricts the filter of an event or step stream to the instances of the caller, of every
+// app when no app is set. An app is required when the caller is restricted to some apps.
+func checkStreamFilter(request *http.Request, filter *event.Filter) (int, error) {
+	id := IdentityFrom(request)
+	if id == nil {
+		return 0, nil
+	}
+	filter.UserName = id.User
+	if len(id.Apps) > 0 && filter.AppName == "" {
+		return http.StatusBadRequest, fmt.Errorf("Please provide app name")
+	}
+	if !id.AllowsApp(filter.AppName) {
+		logger.Debugf("User [%s] is not allowed to access app [%s]", id.User, filter.AppName)
+		return http.StatusForbidden, fmt.Errorf("access to app %s is not allowed", filter.AppName)
+	}
+	return 0, nil
+}
+
+// writeEvent writes the event with the step or the flow state as data, preceded by a lagged event when
+// events were missed
+func writeEvent(response http.ResponseWriter, e *event.Event, missed uint64) error {
+	if missed > 0 {
+		if err := writeLagged(response, missed); err != nil {
+			return err
+		}
+	}
+	var data []byte
+	var err error
+	if e.Kind == event.KindStep {
+		data, err = json.Marshal(e.Step)
+	} else {
+		data, err = json.Marshal(e.FlowState)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(response, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Kind, data)
+	return err
+}
+
+// writeLagged tells the client how many events it missed, because its buffer was full with overflow=lag or
+// because the events after its last event id are no longer retained
+func writeLagged(response http.ResponseWriter, missed uint64) error {
+	_, err := fmt.Fprintf(response, "event: lagged\ndata: {\"missed\":%d}\n\n", missed)
+	return err
+}
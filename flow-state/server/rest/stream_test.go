@@ -0,0 +1,290 @@
+package rest
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-flogo/core/support/log"
+	"github.com/project-flogo/flow/state"
+	"github.com/project-flogo/services/flow-state/event"
+	"github.com/project-flogo/services/flow-state/store"
+)
+
+type sse struct {
+	id, event, data string
+}
+
+// readEvent reads the next event of the stream, skipping the comments
+func readEvent(t *testing.T, r *bufio.Reader) *sse {
+	t.Helper()
+	e := &sse{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "" && e.event != "":
+			return e
+		case strings.HasPrefix(line, "id: "):
+			e.id = line[4:]
+		case strings.HasPrefix(line, "event: "):
+			e.event = line[7:]
+		case strings.HasPrefix(line, "data: "):
+			e.data = line[6:]
+		}
+	}
+}
+
+func openStream(t *testing.T, url, lastEventId, key string) (*http.Response, *bufio.Reader) {
+	t.Helper()
+	request, _ := http.NewRequest(http.MethodGet, url, nil)
+	if lastEventId != "" {
+		request.Header.Set(LAST_EVENT_ID_HEADER, lastEventId)
+	}
+	if key != "" {
+		request.Header.Set(APIKeyHeader, key)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusOK || response.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %d %s", response.StatusCode, response.Header.Get("Content-Type"))
+	}
+	return response, bufio.NewReader(response.Body)
+}
+
+func TestStreamEvents(t *testing.T) {
+	if err := store.InitStorage(nil); err != nil {
+		t.Fatal(err)
+	}
+	event.Steps = event.NewHub(0, 0, 0)
+	router := httprouter.New()
+	AppendEndpoints(router, log.RootLogger(), true, true)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	post := func(path string, body interface{}) {
+		content, _ := json.Marshal(body)
+		response, err := http.Post(server.URL+path, "application/json", strings.NewReader(string(content)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("%s failed with %d", path, response.StatusCode)
+		}
+	}
+
+	response, stream := openStream(t, server.URL+"/v1/stream/events?app=orders", "", "")
+	defer response.Body.Close()
+
+	post("/v1/instances/start", &state.FlowState{FlowInstanceId: "s1", UserId: "alice", AppName: "billing", FlowName: "charge", FlowStats: "Active"})
+	post("/v1/instances/start", &state.FlowState{FlowInstanceId: "s2", UserId: "alice", AppName: "orders", FlowName: "create", FlowStats: "Active"})
+	post("/v1/instances/steps", &state.Step{Id: 1, FlowId: "s1"})
+	post("/v1/instances/steps", &state.Step{Id: 1, FlowId: "s2"})
+	post("/v1/instances/end", &state.FlowState{FlowInstanceId: "s2", FlowStats: "Completed", EndTime: time.Now()})
+
+	start := readEvent(t, stream)
+	flowState := &state.FlowState{}
+	if err := json.Unmarshal([]byte(start.data), flowState); err != nil {
+		t.Fatal(err)
+	}
+	if start.event != "start" || start.id != "2" || flowState.FlowInstanceId != "s2" || flowState.FlowName != "create" {
+		t.Fatalf("expected the start of s2, got %+v", start)
+	}
+	step := readEvent(t, stream)
+	if step.event != "step" || step.id != "4" || !strings.Contains(step.data, `"s2"`) {
+		t.Fatalf("expected the step of s2, got %+v", step)
+	}
+	end := readEvent(t, stream)
+	if end.event != "end" || end.id != "5" || !strings.Contains(end.data, "Completed") {
+		t.Fatalf("expected the end of s2, got %+v", end)
+	}
+	response.Body.Close()
+
+	// a client resuming after the start of s2 gets its step and end again
+	response, stream = openStream(t, server.URL+"/v1/stream/events?app=orders", "2", "")
+	defer response.Body.Close()
+	if e := readEvent(t, stream); e.id != "4" {
+		t.Fatalf("expected event 4 to be replayed, got %+v", e)
+	}
+	if e := readEvent(t, stream); e.id != "5" {
+		t.Fatalf("expected event 5 to be replayed, got %+v", e)
+	}
+	post("/v1/instances/steps", &state.Step{Id: 2, FlowId: "s2"})
+	if e := readEvent(t, stream); e.id != "6" || e.event != "step" {
+		t.Fatalf("expected the live event 6, got %+v", e)
+	}
+
+	bad, err := http.Get(server.URL + "/v1/stream/events?since=last")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad.Body.Close()
+	if bad.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected an invalid since to be rejected, got %d", bad.StatusCode)
+	}
+}
+
+func TestStreamEventsAuthorization(t *testing.T) {
+	if err := store.InitStorage(nil); err != nil {
+		t.Fatal(err)
+	}
+	event.Steps = event.NewHub(0, 0, 0)
+	router := httprouter.New()
+	AppendEndpoints(router, log.RootLogger(), true, true)
+	auth, err := NewAuthenticator(map[string]interface{}{"type": AuthAPIKey, "keys": map[string]interface{}{
+		"orders-key": map[string]interface{}{"user": "alice", "apps": []interface{}{"orders"}},
+		"bob-key":    map[string]interface{}{"user": "bob"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := Authenticate(auth, router)
+
+	for path, code := range map[string]int{
+		"/v1/stream/events":             http.StatusBadRequest,
+		"/v1/stream/events?app=billing": http.StatusForbidden,
+	} {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set(APIKeyHeader, "orders-key")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != code {
+			t.Fatalf("%s returned %d, expected %d", path, recorder.Code, code)
+		}
+	}
+
+	// the events of the instances of alice are not streamed to bob
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	post := func(path, key string, body interface{}) {
+		content, _ := json.Marshal(body)
+		request, _ := http.NewRequest(http.MethodPost, server.URL+path, strings.NewReader(string(content)))
+		request.Header.Set(APIKeyHeader, key)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("%s failed with %d", path, response.StatusCode)
+		}
+	}
+	response, stream := openStream(t, server.URL+"/v1/stream/events", "", "bob-key")
+	defer response.Body.Close()
+	post("/v1/instances/start", "orders-key", &state.FlowState{FlowInstanceId: "a1", UserId: "alice", AppName: "orders", FlowName: "create", FlowStats: "Active"})
+	post("/v1/instances/steps", "orders-key", &state.Step{Id: 1, FlowId: "a1"})
+	post("/v1/instances/start", "bob-key", &state.FlowState{FlowInstanceId: "b1", UserId: "bob", AppName: "orders", FlowName: "create", FlowStats: "Active"})
+	post("/v1/instances/steps", "bob-key", &state.Step{Id: 1, FlowId: "b1"})
+	if e := readEvent(t, stream); e.event != "start" || !strings.Contains(e.data, `"b1"`) {
+		t.Fatalf("expected the start of the instance of bob, got %+v", e)
+	}
+	if e := readEvent(t, stream); e.event != "step" || !strings.Contains(e.data, `"b1"`) {
+		t.Fatalf("expected the step of the instance of bob, got %+v", e)
+	}
+
+	// nor replayed to him
+	response, stream = openStream(t, server.URL+"/v1/stream/events?app=orders", "0", "bob-key")
+	defer response.Body.Close()
+	if e := readEvent(t, stream); e.event != "start" || e.id != "3" {
+		t.Fatalf("expected the replay to start with the instance of bob, got %+v", e)
+	}
+}
+
+func TestStreamStepsAuthorization(t *testing.T) {
+	if err := store.InitStorage(nil); err != nil {
+		t.Fatal(err)
+	}
+	event.Steps = event.NewHub(0, 0, 0)
+	router := httprouter.New()
+	AppendEndpoints(router, log.RootLogger(), true, true)
+	auth, err := NewAuthenticator(map[string]interface{}{"type": AuthAPIKey, "keys": map[string]interface{}{
+		"orders-key": map[string]interface{}{"user": "alice", "apps": []interface{}{"orders"}},
+		"bob-key":    map[string]interface{}{"user": "bob"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(Authenticate(auth, router))
+	defer server.Close()
+	endpoint := "ws" + strings.TrimPrefix(server.URL, "http") + "/v1/stream/steps"
+	dial := func(query, key string) (*websocket.Conn, int) {
+		conn, response, err := websocket.DefaultDialer.Dial(endpoint+query, http.Header{APIKeyHeader: {key}})
+		if err != nil {
+			if response == nil {
+				t.Fatal(err)
+			}
+			return nil, response.StatusCode
+		}
+		return conn, http.StatusSwitchingProtocols
+	}
+	post := func(path, key string, body interface{}) {
+		content, _ := json.Marshal(body)
+		request, _ := http.NewRequest(http.MethodPost, server.URL+path, strings.NewReader(string(content)))
+		request.Header.Set(APIKeyHeader, key)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			t.Fatalf("%s failed with %d", path, response.StatusCode)
+		}
+	}
+	waitSubscribers := func(count int) {
+		deadline := time.Now().Add(time.Second)
+		for event.Steps.Subscribers() != count {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d subscribers", count)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	if _, code := dial("", "orders-key"); code != http.StatusBadRequest {
+		t.Fatalf("expected an app to be required, got %d", code)
+	}
+	if _, code := dial("?app=billing", "orders-key"); code != http.StatusForbidden {
+		t.Fatalf("expected app billing to be forbidden, got %d", code)
+	}
+
+	// the steps of the instances of alice are not streamed to bob
+	bob, _ := dial("?app=orders", "bob-key")
+	defer bob.Close()
+	waitSubscribers(1)
+	post("/v1/instances/start", "orders-key", &state.FlowState{FlowInstanceId: "a1", UserId: "alice", AppName: "orders", FlowName: "create", FlowStats: "Active"})
+	post("/v1/instances/steps", "orders-key", &state.Step{Id: 1, FlowId: "a1"})
+	post("/v1/instances/start", "bob-key", &state.FlowState{FlowInstanceId: "b1", UserId: "bob", AppName: "orders", FlowName: "create", FlowStats: "Active"})
+	post("/v1/instances/steps", "bob-key", &state.Step{Id: 1, FlowId: "b1"})
+	bob.SetReadDeadline(time.Now().Add(time.Second))
+	step := &state.Step{}
+	if err := bob.ReadJSON(step); err != nil {
+		t.Fatal(err)
+	}
+	if step.FlowId != "b1" {
+		t.Fatalf("expected the step of the instance of bob, got %+v", step)
+	}
+
+	// a subscribe message is checked like the query
+	alice, _ := dial("?app=orders", "orders-key")
+	defer alice.Close()
+	waitSubscribers(2)
+	if err := alice.WriteJSON(map[string]interface{}{"type": "subscribe", "filter": map[string]string{"app": "billing"}}); err != nil {
+		t.Fatal(err)
+	}
+	alice.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := alice.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Fatalf("expected the stream to be closed for app billing, got %v", err)
+	}
+}
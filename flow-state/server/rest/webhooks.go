@@ -0,0 +1,172 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-flogo/services/flow-state/webhook"
+)
+
+// createWebhook subscribes a URL to the end of the instances of an app, or of one of its flows. The secret signing
+// the deliveries is only returned by this call.
+func (se *ServiceEndpoints) createWebhook(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	se.logger.Debugf("Endpoint[POST:/webhooks] : Called")
+	userName := se.webhookUser(response, request)
+	if userName == "" {
+		return
+	}
+	sub := &webhook.Subscription{}
+	if err := json.NewDecoder(request.Body).Decode(sub); err != nil {
+		se.error(response, http.StatusBadRequest, fmt.Errorf("Unable to decode webhook: %s", err.Error()))
+		return
+	}
+	if sub.App == "" {
+		se.error(response, http.StatusBadRequest, fmt.Errorf("Please provide app name"))
+		return
+	}
+	if !se.authorizeApp(response, request, sub.App) {
+		return
+	}
+	created, err := se.webhooks.Create(userName, sub)
+	if err != nil {
+		se.webhookError(response, err)
+		return
+	}
+	se.writeWebhook(response, http.StatusCreated, created)
+}
+
+// getWebhooks lists the webhooks of the user, filtered by the app and flow query parameters
+func (se *ServiceEndpoints) getWebhooks(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	se.logger.Debugf("Endpoint[GET:/webhooks] : Called")
+	userName := se.webhookUser(response, request)
+	if userName == "" {
+		return
+	}
+	appName := request.URL.Query().Get(FLOGO_APPNAME)
+	if appName != "" && !se.authorizeApp(response, request, appName) {
+		return
+	}
+	id := IdentityFrom(request)
+	subs := make([]*webhook.Subscription, 0)
+	for _, sub := range se.webhooks.List(userName, appName, request.URL.Query().Get(FLOGO_FlowName)) {
+		if id == nil || id.AllowsApp(sub.App) {
+			sub.Secret = ""
+			subs = append(subs, sub)
+		}
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(response).Encode(subs); err != nil {
+		se.logger.Error(err.Error())
+	}
+}
+
+func (se *ServiceEndpoints) getWebhook(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	se.logger.Debugf("Endpoint[GET:/webhooks/%s] : Called", params.ByName("id"))
+	if sub := se.ownWebhook(response, request, params.ByName("id")); sub != nil {
+		sub.Secret = ""
+		se.writeWebhook(response, http.StatusOK, sub)
+	}
+}
+
+// updateWebhook replaces the flow, statuses and url of a webhook, and its secret when one is provided
+func (se *ServiceEndpoints) updateWebhook(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	id := params.ByName("id")
+	se.logger.Debugf("Endpoint[PUT:/webhooks/%s] : Called", id)
+	if se.ownWebhook(response, request, id) == nil {
+		return
+	}
+	change := &webhook.Subscription{}
+	if err := json.NewDecoder(request.Body).Decode(change); err != nil {
+		se.error(response, http.StatusBadRequest, fmt.Errorf("Unable to decode webhook: %s", err.Error()))
+		return
+	}
+	updated, err := se.webhooks.Update(id, change)
+	if err != nil {
+		se.webhookError(response, err)
+		return
+	}
+	updated.Secret = ""
+	se.writeWebhook(response, http.StatusOK, updated)
+}
+
+func (se *ServiceEndpoints) deleteWebhook(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	id := params.ByName("id")
+	se.logger.Debugf("Endpoint[DEL:/webhooks/%s] : Called", id)
+	if se.ownWebhook(response, request, id) == nil {
+		return
+	}
+	if err := se.webhooks.Delete(id); err != nil {
+		se.webhookError(response, err)
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// getWebhookDeliveries returns the most recent deliveries of a webhook, from the newest
+func (se *ServiceEndpoints) getWebhookDeliveries(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	id := params.ByName("id")
+	se.logger.Debugf("Endpoint[GET:/webhooks/%s/deliveries] : Called", id)
+	if se.ownWebhook(response, request, id) == nil {
+		return
+	}
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(response).Encode(se.webhooks.Deliveries(id)); err != nil {
+		se.logger.Error(err.Error())
+	}
+}
+
+// webhookUser returns the user of the request, it replies 401 and returns an empty user when it is not provided
+func (se *ServiceEndpoints) webhookUser(response http.ResponseWriter, request *http.Request) string {
+	userName := se.userName(request)
+	if len(userName) <= 0 {
+		se.logger.Error("Sending error response as user information not provided")
+		se.error(response, http.StatusUnauthorized, fmt.Errorf("unauthorized, please provide user information"))
+	}
+	return userName
+}
+
+// ownWebhook returns the webhook when it belongs to the user of the request and its app is allowed, it replies
+// with an error and returns nil otherwise. The webhooks of the other users are not found.
+func (se *ServiceEndpoints) ownWebhook(response http.ResponseWriter, request *http.Request, id string) *webhook.Subscription {
+	userName := se.webhookUser(response, request)
+	if userName == "" {
+		return nil
+	}
+	sub, err := se.webhooks.Get(id)
+	if err == nil && sub.User != userName {
+		err = webhook.ErrNotFound
+	}
+	if err != nil {
+		se.webhookError(response, err)
+		return nil
+	}
+	if !se.authorizeApp(response, request, sub.App) {
+		return nil
+	}
+	return sub
+}
+
+func (se *ServiceEndpoints) webhookError(response http.ResponseWriter, err error) {
+	if err == webhook.ErrNotFound {
+		se.error(response, http.StatusNotFound, err)
+		return
+	}
+	if _, ok := err.(webhook.ValidationError); ok {
+		se.error(response, http.StatusBadRequest, err)
+		return
+	}
+	se.logger.Errorf("Sending error response as webhook could not be saved: %v", err)
+	se.error(response, http.StatusInternalServerError, err)
+}
+
+func (se *ServiceEndpoints) writeWebhook(response http.ResponseWriter, code int, sub *webhook.Subscription) {
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(code)
+	if err := json.NewEncoder(response).Encode(sub); err != nil {
+		se.logger.Error(err.Error())
+	}
+}
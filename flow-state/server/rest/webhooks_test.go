@@ -0,0 +1,106 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/project-flogo/core/support/log"
+	"github.com/project-flogo/services/flow-state/store"
+	"github.com/project-flogo/services/flow-state/webhook"
+)
+
+func TestWebhooks(t *testing.T) {
+	if err := store.InitStorage(nil); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := webhook.NewConfig(map[string]interface{}{"dir": t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhooks, err := webhook.New(cfg, store.RegistedStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := httprouter.New()
+	AppendEndpointsWithOptions(router, log.RootLogger(), &EndpointOptions{Webhooks: webhooks})
+	auth, err := NewAuthenticator(map[string]interface{}{"type": AuthAPIKey, "keys": map[string]interface{}{
+		"alice-key": map[string]interface{}{"user": "alice", "apps": "app1"},
+		"bob-key":   map[string]interface{}{"user": "bob"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := Authenticate(auth, router)
+
+	call := func(method, path, key string, body interface{}, result interface{}) int {
+		var content []byte
+		if body != nil {
+			content, _ = json.Marshal(body)
+		}
+		request := httptest.NewRequest(method, path, strings.NewReader(string(content)))
+		request.Header.Set(APIKeyHeader, key)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if result != nil {
+			_ = json.Unmarshal(recorder.Body.Bytes(), result)
+		}
+		return recorder.Code
+	}
+
+	created := &webhook.Subscription{}
+	if code := call(http.MethodPost, "/v1/webhooks", "alice-key", &webhook.Subscription{App: "app1", URL: "https://example.com/hook"}, created); code != http.StatusCreated {
+		t.Fatalf("create failed with %d", code)
+	}
+	if created.Id == "" || created.Secret == "" || created.User != "alice" || len(created.Statuses) != 3 {
+		t.Fatalf("unexpected webhook %+v", created)
+	}
+	path := "/v1/webhooks/" + created.Id
+
+	checks := []struct {
+		method, path, key string
+		body              interface{}
+		code              int
+	}{
+		{http.MethodPost, "/v1/webhooks", "alice-key", &webhook.Subscription{App: "app2", URL: "https://example.com/hook"}, http.StatusForbidden},
+		{http.MethodPost, "/v1/webhooks", "alice-key", &webhook.Subscription{App: "app1", URL: "example.com"}, http.StatusBadRequest},
+		{http.MethodPost, "/v1/webhooks", "alice-key", &webhook.Subscription{URL: "https://example.com/hook"}, http.StatusBadRequest},
+		{http.MethodGet, path, "bob-key", nil, http.StatusNotFound},
+		{http.MethodPut, path, "bob-key", &webhook.Subscription{URL: "https://example.com/other"}, http.StatusNotFound},
+		{http.MethodDelete, path, "bob-key", nil, http.StatusNotFound},
+		{http.MethodGet, path + "/deliveries", "bob-key", nil, http.StatusNotFound},
+		{http.MethodGet, "/v1/webhooks/missing", "alice-key", nil, http.StatusNotFound},
+		{http.MethodPut, path, "alice-key", &webhook.Subscription{URL: "https://example.com/other", Statuses: []string{"Active"}}, http.StatusBadRequest},
+		{http.MethodGet, path + "/deliveries", "alice-key", nil, http.StatusOK},
+	}
+	for _, check := range checks {
+		if code := call(check.method, check.path, check.key, check.body, nil); code != check.code {
+			t.Fatalf("%s %s as %s: expected %d, got %d", check.method, check.path, check.key, check.code, code)
+		}
+	}
+
+	var list []*webhook.Subscription
+	if code := call(http.MethodGet, "/v1/webhooks?app=app1", "alice-key", nil, &list); code != http.StatusOK || len(list) != 1 || list[0].Secret != "" {
+		t.Fatalf("unexpected webhooks %d %+v", code, list)
+	}
+	if code := call(http.MethodGet, "/v1/webhooks", "bob-key", nil, &list); code != http.StatusOK || len(list) != 0 {
+		t.Fatalf("expected no webhooks of bob, got %d %+v", code, list)
+	}
+
+	updated := &webhook.Subscription{}
+	if code := call(http.MethodPut, path, "alice-key", &webhook.Subscription{Flow: "create", URL: "https://example.com/other", Statuses: []string{"Failed"}}, updated); code != http.StatusOK {
+		t.Fatalf("update failed with %d", code)
+	}
+	if updated.Flow != "create" || updated.URL != "https://example.com/other" || updated.Secret != "" || updated.App != "app1" {
+		t.Fatalf("unexpected update %+v", updated)
+	}
+	if code := call(http.MethodDelete, path, "alice-key", nil, nil); code != http.StatusNoContent {
+		t.Fatalf("delete failed with %d", code)
+	}
+	if code := call(http.MethodGet, path, "alice-key", nil, nil); code != http.StatusNotFound {
+		t.Fatalf("expected the deleted webhook not to be found, got %d", code)
+	}
+}
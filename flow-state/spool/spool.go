@@ -0,0 +1,566 @@
+package spool
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/project-flogo/core/data/metadata"
+	"github.com/project-flogo/core/support/log"
+)
+
+var logCache = log.ChildLogger(log.RootLogger(), "flow-state.spool")
+
+const (
+	DefaultDir                = "spool"
+	DefaultMaxSize            = 256 * 1024 * 1024
+	DefaultSegmentSize        = 16 * 1024 * 1024
+	DefaultCheckpointInterval = 1000
+
+	checkpointFile = "checkpoint"
+	segmentPrefix  = "segment-"
+	segmentSuffix  = ".log"
+
+	// record header: data length, crc32 of kind and data, kind
+	headerSize = 9
+)
+
+// Kind identifies the recorder call a spooled record belongs to
+type Kind byte
+
+const (
+	Start Kind = iota + 1
+	Step
+	Snapshot
+	End
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Start:
+		return "start"
+	case Step:
+		return "step"
+	case Snapshot:
+		return "snapshot"
+	case End:
+		return "end"
+	}
+	return "unknown"
+}
+
+var (
+	ErrFull   = errors.New("spool is full")
+	ErrClosed = errors.New("spool is closed")
+)
+
+type Config struct {
+	Dir         string `md:"dir"`
+	MaxSize     int64  `md:"maxSize"`
+	SegmentSize int64  `md:"segmentSize"`
+	// CheckpointInterval is in milliseconds
+	CheckpointInterval int `md:"checkpointInterval"`
+}
+
+// NewConfig reads the spool settings and applies defaults
+func NewConfig(settings map[string]interface{}) (*Config, error) {
+	cfg := &Config{}
+	if err := metadata.MapToStruct(settings, cfg, false); err != nil {
+		return nil, err
+	}
+	if cfg.Dir == "" {
+		cfg.Dir = DefaultDir
+	}
+	if cfg.MaxSize <= 0 {
+		cfg.MaxSize = DefaultMaxSize
+	}
+	if cfg.SegmentSize <= 0 {
+		cfg.SegmentSize = DefaultSegmentSize
+	}
+	if cfg.CheckpointInterval <= 0 {
+		cfg.CheckpointInterval = DefaultCheckpointInterval
+	}
+	return cfg, nil
+}
+
+type position struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// Entry is a record handed out by Next, it must be passed to Ack once applied
+type Entry struct {
+	Kind Kind
+	Data []byte
+
+	pos   position
+	size  int64
+	acked bool
+}
+
+// Spool is a durable FIFO of records stored in append-only segment files.
+// Records are fsynced before Append returns and are handed out in append order,
+// the checkpoint only advances over the acknowledged prefix so unapplied records
+// are replayed after a restart.
+type Spool struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	cfg *Config
+
+	segments []uint64
+	sizes    map[uint64]int64
+	writer   *os.File
+
+	reader    *os.File
+	readerSeg uint64
+	read      position
+
+	checkpoint position
+	dirty      bool
+	inflight   []*Entry
+	size       int64
+	recovered  int
+
+	closed bool
+	done   chan struct{}
+}
+
+// Open opens or creates the spool in cfg.Dir, pending records are kept for replay
+func Open(cfg *Config) (*Spool, error) {
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("Could not create spool directory %s, %s", cfg.Dir, err.Error())
+	}
+
+	s := &Spool{cfg: cfg, sizes: make(map[uint64]int64), done: make(chan struct{})}
+	s.cond = sync.NewCond(&s.mu)
+
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+
+	go s.checkpointLoop()
+	return s, nil
+}
+
+func (s *Spool) recover() error {
+	ids, err := listSegments(s.cfg.Dir)
+	if err != nil {
+		return err
+	}
+
+	cp, err := readCheckpoint(s.cfg.Dir)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if id < cp.Segment {
+			// fully applied before the last checkpoint
+			_ = os.Remove(segmentPath(s.cfg.Dir, id))
+			continue
+		}
+		var from int64
+		if id == cp.Segment {
+			from = cp.Offset
+		}
+		size, records, err := validSize(segmentPath(s.cfg.Dir, id), from)
+		if err != nil {
+			return err
+		}
+		s.segments = append(s.segments, id)
+		s.sizes[id] = size
+		s.recovered += records
+	}
+
+	if len(s.segments) == 0 {
+		id := cp.Segment
+		if id == 0 {
+			id = 1
+		}
+		if err := s.createSegment(id); err != nil {
+			return err
+		}
+	} else {
+		last := s.segments[len(s.segments)-1]
+		s.writer, err = os.OpenFile(segmentPath(s.cfg.Dir, last), os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("Could not open spool segment %d, %s", last, err.Error())
+		}
+	}
+
+	if cp.Segment != s.segments[0] {
+		cp = position{Segment: s.segments[0]}
+	}
+	if cp.Offset > s.sizes[cp.Segment] {
+		cp.Offset = s.sizes[cp.Segment]
+	}
+	s.checkpoint = cp
+	s.read = cp
+
+	for _, id := range s.segments {
+		s.size += s.sizes[id]
+	}
+	s.size -= cp.Offset
+
+	if s.recovered > 0 {
+		logCache.Infof("Spool %s has %d pending records to replay", s.cfg.Dir, s.recovered)
+	}
+	return nil
+}
+
+// Append durably stores a record, ErrFull is returned when the spool reached its max size
+func (s *Spool) Append(kind Kind, data []byte) error {
+	n := int64(headerSize + len(data))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+	if s.size+n > s.cfg.MaxSize {
+		return ErrFull
+	}
+
+	last := s.segments[len(s.segments)-1]
+	if s.sizes[last] > 0 && s.sizes[last]+n > s.cfg.SegmentSize {
+		if err := s.writer.Close(); err != nil {
+			return err
+		}
+		last++
+		if err := s.createSegment(last); err != nil {
+			return err
+		}
+	}
+
+	buf := make([]byte, n)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(data)))
+	buf[8] = byte(kind)
+	copy(buf[headerSize:], data)
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(buf[8:]))
+
+	if _, err := s.writer.Write(buf); err != nil {
+		// drop the partial record so the segment stays readable
+		_ = s.writer.Truncate(s.sizes[last])
+		return fmt.Errorf("Could not write spool record, %s", err.Error())
+	}
+	if err := s.writer.Sync(); err != nil {
+		_ = s.writer.Truncate(s.sizes[last])
+		return fmt.Errorf("Could not sync spool segment, %s", err.Error())
+	}
+
+	s.sizes[last] += n
+	s.size += n
+	s.cond.Broadcast()
+	return nil
+}
+
+// Next blocks until a record is available and returns it, records are returned in append order
+func (s *Spool) Next() (*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for {
+		if s.closed {
+			return nil, ErrClosed
+		}
+		if s.read.Offset < s.sizes[s.read.Segment] {
+			break
+		}
+		if next, ok := s.nextSegment(s.read.Segment); ok {
+			s.read = position{Segment: next}
+			continue
+		}
+		s.cond.Wait()
+	}
+
+	entry, err := s.readEntry(s.read)
+	if err != nil {
+		return nil, err
+	}
+	s.read.Offset += entry.size
+	s.inflight = append(s.inflight, entry)
+	return entry, nil
+}
+
+// Ack marks an entry as applied, the checkpoint advances once all earlier entries are acknowledged
+func (s *Spool) Ack(entry *Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry.acked = true
+	for len(s.inflight) > 0 && s.inflight[0].acked {
+		head := s.inflight[0]
+		s.inflight[0] = nil
+		s.inflight = s.inflight[1:]
+		s.checkpoint = position{Segment: head.pos.Segment, Offset: head.pos.Offset + head.size}
+		s.size -= head.size
+		s.dirty = true
+	}
+}
+
+// Size returns the bytes of records that are not yet acknowledged
+func (s *Spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Recovered returns the number of unapplied records found when the spool was opened
+func (s *Spool) Recovered() int {
+	return s.recovered
+}
+
+// Pending returns the bytes of records that have not been handed out by Next yet
+func (s *Spool) Pending() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pending := s.sizes[s.read.Segment] - s.read.Offset
+	for _, id := range s.segments {
+		if id > s.read.Segment {
+			pending += s.sizes[id]
+		}
+	}
+	return pending
+}
+
+// Close writes the checkpoint and releases the segment files, blocked Next calls return ErrClosed
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+	s.cond.Broadcast()
+	err := s.flushCheckpoint()
+	if s.reader != nil {
+		_ = s.reader.Close()
+	}
+	_ = s.writer.Close()
+	s.mu.Unlock()
+	return err
+}
+
+func (s *Spool) checkpointLoop() {
+	ticker := time.NewTicker(time.Duration(s.cfg.CheckpointInterval) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if err := s.flushCheckpoint(); err != nil {
+				logCache.Errorf("Could not write spool checkpoint, %s", err.Error())
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// flushCheckpoint persists the checkpoint and removes segments before it, s.mu must be held
+func (s *Spool) flushCheckpoint() error {
+	if !s.dirty {
+		return nil
+	}
+	if err := writeCheckpoint(s.cfg.Dir, s.checkpoint); err != nil {
+		return err
+	}
+	s.dirty = false
+
+	for len(s.segments) > 1 && s.segments[0] < s.checkpoint.Segment {
+		id := s.segments[0]
+		if s.reader != nil && s.readerSeg == id {
+			_ = s.reader.Close()
+			s.reader = nil
+		}
+		if err := os.Remove(segmentPath(s.cfg.Dir, id)); err != nil && !os.IsNotExist(err) {
+			logCache.Warnf("Could not remove spool segment %d, %s", id, err.Error())
+		}
+		delete(s.sizes, id)
+		s.segments = s.segments[1:]
+	}
+	return nil
+}
+
+func (s *Spool) readEntry(pos position) (*Entry, error) {
+	if s.reader == nil || s.readerSeg != pos.Segment {
+		if s.reader != nil {
+			_ = s.reader.Close()
+		}
+		f, err := os.Open(segmentPath(s.cfg.Dir, pos.Segment))
+		if err != nil {
+			s.reader = nil
+			return nil, fmt.Errorf("Could not open spool segment %d, %s", pos.Segment, err.Error())
+		}
+		s.reader, s.readerSeg = f, pos.Segment
+	}
+
+	kind, data, err := readRecord(s.reader, pos.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read spool segment %d at %d, %s", pos.Segment, pos.Offset, err.Error())
+	}
+	return &Entry{Kind: kind, Data: data, pos: pos, size: int64(headerSize + len(data))}, nil
+}
+
+func (s *Spool) nextSegment(id uint64) (uint64, bool) {
+	for _, seg := range s.segments {
+		if seg > id {
+			return seg, true
+		}
+	}
+	return 0, false
+}
+
+func (s *Spool) createSegment(id uint64) error {
+	f, err := os.OpenFile(segmentPath(s.cfg.Dir, id), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("Could not create spool segment %d, %s", id, err.Error())
+	}
+	syncDir(s.cfg.Dir)
+	s.writer = f
+	s.segments = append(s.segments, id)
+	s.sizes[id] = 0
+	return nil
+}
+
+func readRecord(r io.ReaderAt, offset int64) (Kind, []byte, error) {
+	header := make([]byte, headerSize)
+	if _, err := r.ReadAt(header, offset); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	body := make([]byte, 1+int(length))
+	body[0] = header[8]
+	if _, err := r.ReadAt(body[1:], offset+headerSize); err != nil {
+		return 0, nil, err
+	}
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:8]) {
+		return 0, nil, errors.New("checksum mismatch")
+	}
+	return Kind(body[0]), body[1:], nil
+}
+
+// validSize scans a segment, truncates a torn or corrupt tail left by a crash and
+// counts the records starting at from
+func validSize(path string, from int64) (int64, int, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Could not open spool segment %s, %s", path, err.Error())
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var offset int64
+	records := 0
+	for offset < info.Size() {
+		_, data, err := readRecord(f, offset)
+		if err != nil {
+			logCache.Warnf("Truncating spool segment %s at %d, %s", path, offset, err.Error())
+			if err := f.Truncate(offset); err != nil {
+				return 0, 0, err
+			}
+			if err := f.Sync(); err != nil {
+				return 0, 0, err
+			}
+			break
+		}
+		if offset >= from {
+			records++
+		}
+		offset += int64(headerSize + len(data))
+	}
+	return offset, records, nil
+}
+
+func listSegments(dir string) ([]uint64, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint64
+	for _, file := range files {
+		name := file.Name()
+		if !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func segmentPath(dir string, id uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%020d%s", segmentPrefix, id, segmentSuffix))
+}
+
+func readCheckpoint(dir string) (position, error) {
+	var cp position
+	content, err := ioutil.ReadFile(filepath.Join(dir, checkpointFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cp, nil
+		}
+		return cp, err
+	}
+	if err := json.Unmarshal(content, &cp); err != nil {
+		return cp, fmt.Errorf("Could not read spool checkpoint, %s", err.Error())
+	}
+	return cp, nil
+}
+
+func writeCheckpoint(dir string, cp position) error {
+	content, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(dir, checkpointFile+".tmp")
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(content); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, checkpointFile)); err != nil {
+		return err
+	}
+	syncDir(dir)
+	return nil
+}
+
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+}
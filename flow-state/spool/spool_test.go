@@ -0,0 +1,133 @@
+package spool
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func newTestSpool(t *testing.T, dir string, maxSize, segmentSize int64) *Spool {
+	cfg, err := NewConfig(map[string]interface{}{"dir": dir, "maxSize": maxSize, "segmentSize": segmentSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestReplayAfterRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := newTestSpool(t, dir, 1024*1024, 64)
+	records := []string{`{"id":1}`, `{"id":2}`, `{"id":3}`, `{"id":4}`, `{"id":5}`}
+	for i, r := range records {
+		if err := s.Append(Kind(i%4+1), []byte(r)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first, _ := s.Next()
+	second, _ := s.Next()
+	third, _ := s.Next()
+	// acknowledging out of order only checkpoints the applied prefix
+	s.Ack(third)
+	s.Ack(first)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_ = second
+
+	s = newTestSpool(t, dir, 1024*1024, 64)
+	defer s.Close()
+
+	if s.Recovered() != 4 {
+		t.Fatalf("expected 4 recovered records, got %d", s.Recovered())
+	}
+	for i, expected := range records[1:] {
+		entry, err := s.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(entry.Data) != expected || entry.Kind != Kind((i+1)%4+1) {
+			t.Fatalf("expected %s, got %s %s", expected, entry.Kind, entry.Data)
+		}
+		s.Ack(entry)
+	}
+	if s.Size() != 0 || s.Pending() != 0 {
+		t.Fatalf("expected empty spool, size %d pending %d", s.Size(), s.Pending())
+	}
+}
+
+func TestFull(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := newTestSpool(t, dir, 2*(headerSize+10), 1024)
+	defer s.Close()
+
+	data := []byte("0123456789")
+	for i := 0; i < 2; i++ {
+		if err := s.Append(Step, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Append(Step, data); err != ErrFull {
+		t.Fatalf("expected ErrFull, got %v", err)
+	}
+
+	entry, _ := s.Next()
+	s.Ack(entry)
+	if err := s.Append(Step, data); err != nil {
+		t.Fatalf("expected room after ack, got %v", err)
+	}
+}
+
+func TestTornTail(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := newTestSpool(t, dir, 1024, 1024)
+	if err := s.Append(Start, []byte(`{"id":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	_ = s.Close()
+
+	// simulate a crash in the middle of writing the next record
+	f, err := os.OpenFile(segmentPath(dir, 1), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Write([]byte{0, 0, 0, 20, 1, 2})
+	_ = f.Close()
+
+	s = newTestSpool(t, dir, 1024, 1024)
+	defer s.Close()
+	if s.Recovered() != 1 {
+		t.Fatalf("expected 1 recovered record, got %d", s.Recovered())
+	}
+	if err := s.Append(End, []byte(`{"id":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, kind := range []Kind{Start, End} {
+		entry, err := s.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.Kind != kind {
+			t.Fatalf("expected %s, got %s", kind, entry.Kind)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package batch
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/project-flogo/flow/state"
+)
+
+// Kind is the recorder call a batch record stands for
+type Kind string
+
+const (
+	Start    Kind = "start"
+	Step     Kind = "step"
+	Snapshot Kind = "snapshot"
+	End      Kind = "end"
+)
+
+// Record is the wire form of a batch entry
+type Record struct {
+	Type Kind            `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Item is a decoded batch record, only the field matching Kind is set
+type Item struct {
+	Kind      Kind
+	FlowState *state.FlowState
+	Step      *state.Step
+	Snapshot  *state.Snapshot
+}
+
+// Result is the outcome of a single batch record
+type Result struct {
+	Index  int    `json:"index"`
+	Type   Kind   `json:"type"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Store is implemented by stores that apply a batch in a single transaction
+type Store interface {
+	SaveBatch(items []*Item) error
+}
+
+// ItemError reports the item that caused a batch to be rolled back
+type ItemError struct {
+	Index int
+	Err   error
+}
+
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("batch item %d failed, %s", e.Index, e.Err.Error())
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+func NewRecord(kind Kind, v interface{}) (*Record, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &Record{Type: kind, Data: data}, nil
+}
+
+// Parse reads the records of a batch sent either as a JSON array or as newline delimited JSON
+func Parse(content []byte) ([]*Record, error) {
+	content = bytes.TrimSpace(content)
+	if len(content) == 0 {
+		return nil, nil
+	}
+
+	var records []*Record
+	if content[0] == '[' {
+		if err := json.Unmarshal(content, &records); err != nil {
+			return nil, err
+		}
+		return records, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	for {
+		record := &Record{}
+		err := decoder.Decode(record)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid record at line %d, %s", len(records)+1, err.Error())
+		}
+		records = append(records, record)
+	}
+}
+
+// Decode unmarshals the record data according to its type
+func (r *Record) Decode() (*Item, error) {
+	if r == nil || len(r.Data) == 0 {
+		return nil, fmt.Errorf("record data is missing")
+	}
+
+	item := &Item{Kind: r.Type}
+	var err error
+	switch r.Type {
+	case Start, End:
+		item.FlowState = &state.FlowState{}
+		err = json.Unmarshal(r.Data, item.FlowState)
+	case Step:
+		item.Step = &state.Step{}
+		err = json.Unmarshal(r.Data, item.Step)
+	case Snapshot:
+		item.Snapshot = &state.Snapshot{SnapshotBase: &state.SnapshotBase{}}
+		err = json.Unmarshal(r.Data, item.Snapshot)
+	default:
+		return nil, fmt.Errorf("unknown record type [%s]", r.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal %s json, %s", r.Type, err.Error())
+	}
+	return item, nil
+}
@@ -0,0 +1,35 @@
+package batch
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	array := `[{"type":"start","data":{"flowInstanceId":"f1"}},{"type":"step","data":{"id":1,"flowId":"f1"}}]`
+	ndjson := "{\"type\":\"start\",\"data\":{\"flowInstanceId\":\"f1\"}}\n{\"type\":\"step\",\"data\":{\"id\":1,\"flowId\":\"f1\"}}\n"
+
+	for _, content := range []string{array, ndjson} {
+		records, err := Parse([]byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 2 {
+			t.Fatalf("expected 2 records, got %d", len(records))
+		}
+		start, err := records[0].Decode()
+		if err != nil || start.FlowState.FlowInstanceId != "f1" {
+			t.Fatalf("unexpected start %+v, %v", start, err)
+		}
+		step, err := records[1].Decode()
+		if err != nil || step.Step.Id != 1 || step.Step.FlowId != "f1" {
+			t.Fatalf("unexpected step %+v, %v", step, err)
+		}
+	}
+
+	if _, err := Parse([]byte("{\"type\":\"start\"}\n{broken")); err == nil {
+		t.Fatal("expected error for malformed NDJSON")
+	}
+	if _, err := (&Record{Type: "unknown", Data: []byte("{}")}).Decode(); err == nil {
+		t.Fatal("expected error for unknown record type")
+	}
+}
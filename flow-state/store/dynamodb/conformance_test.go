@@ -0,0 +1,46 @@
+package dynamodb_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/project-flogo/services/flow-state/store"
+	"github.com/project-flogo/services/flow-state/store/dynamodb"
+	"github.com/project-flogo/services/flow-state/store/storetest"
+)
+
+// TestConformance runs against the DynamoDB compatible server of FLOGO_STATE_TEST_DYNAMODB_ENDPOINT, such as
+// DynamoDB Local, every test creates its own table. It runs against an in-process fake when it is not set.
+func TestConformance(t *testing.T) {
+	endpoint := os.Getenv("FLOGO_STATE_TEST_DYNAMODB_ENDPOINT")
+	if endpoint == "" {
+		storetest.Run(t, func(t *testing.T) store.Store {
+			s, err := dynamodb.NewStoreWithClient(newFakeDynamoDB(), map[string]interface{}{"createTable": true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return s
+		})
+		return
+	}
+
+	run := time.Now().UnixNano()
+	n := 0
+	storetest.Run(t, func(t *testing.T) store.Store {
+		n++
+		s, err := dynamodb.NewStore(map[string]interface{}{
+			"endpoint":        endpoint,
+			"region":          "us-east-1",
+			"accessKeyId":     "test",
+			"secretAccessKey": "test",
+			"table":           fmt.Sprintf("flowstate_test_%d_%d", run, n),
+			"createTable":     true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
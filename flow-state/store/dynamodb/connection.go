@@ -0,0 +1,246 @@
+// Package dynamodb stores the flow state in a single DynamoDB table
+//
+// Every item has a PK partition key and a SK sort key, the key parts are path escaped and joined with #
+//
+//	PK                  SK                         item
+//	INST#<flowId>       STATE                      flow instance state, inputs, outputs and the status from its steps
+//	INST#<flowId>       STEP#<stepId, 10 digits>   step, so the steps of an instance are queried in step id order
+//	INST#<flowId>       SNAPSHOT                   latest snapshot
+//	APP#<user>#<app>    APPSTATE                   persistence enabled
+//	APP#<user>#<app>    VERSION#<version>          a version the app recorded instances for
+//	APP#<user>#<app>    FLOW#<version>#<flow>      a flow the app version recorded instances for, with its host ids
+//
+// The started instances are listed from two global secondary indexes sorted by start time and instance id
+//
+//	app-index     GSI1PK <user>#<app>#<version>           GSI1SK <start time>#<flowId>
+//	status-index  GSI2PK <user>#<app>#<version>#<status>  GSI2SK <start time>#<flowId>
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/project-flogo/core/data/metadata"
+	"github.com/project-flogo/core/support/log"
+)
+
+var logCache = log.ChildLogger(log.RootLogger(), "flow-state.dynamodb")
+
+const (
+	DefaultTable   = "flowstate"
+	DefaultTimeout = 10
+
+	AppIndex    = "app-index"
+	StatusIndex = "status-index"
+
+	attrPK     = "PK"
+	attrSK     = "SK"
+	attrGSI1PK = "GSI1PK"
+	attrGSI1SK = "GSI1SK"
+	attrGSI2PK = "GSI2PK"
+	attrGSI2SK = "GSI2SK"
+
+	instancePrefix = "INST#"
+	appPrefix      = "APP#"
+	stateKey       = "STATE"
+	snapshotKey    = "SNAPSHOT"
+	stepPrefix     = "STEP#"
+	appStateKey    = "APPSTATE"
+	versionPrefix  = "VERSION#"
+	flowPrefix     = "FLOW#"
+
+	// timeLayout has a fixed width so start times sort as strings
+	timeLayout = "2006-01-02T15:04:05.000000000Z"
+)
+
+// API is the part of the DynamoDB client used by the store
+type API interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error)
+	DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error)
+	CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error)
+}
+
+type dynamoSettings struct {
+	Table  string `md:"table"`
+	Region string `md:"region"`
+	// Endpoint replaces the AWS endpoint, to use DynamoDB Local or another compatible server
+	Endpoint        string `md:"endpoint"`
+	AccessKeyId     string `md:"accessKeyId"`
+	SecretAccessKey string `md:"secretAccessKey"`
+	// CreateTable creates the table and its indexes with on-demand capacity when it does not exist
+	CreateTable bool `md:"createTable"`
+	// Timeout is the time allowed to a DynamoDB call in seconds
+	Timeout int `md:"timeout"`
+}
+
+func readSettings(settings map[string]interface{}) (*dynamoSettings, error) {
+	s := &dynamoSettings{}
+	if err := metadata.MapToStruct(settings, s, false); err != nil {
+		return nil, err
+	}
+	if s.Table == "" {
+		s.Table = DefaultTable
+	}
+	if s.Timeout <= 0 {
+		s.Timeout = DefaultTimeout
+	}
+	return s, nil
+}
+
+// NewClient creates the DynamoDB client from the settings, the credentials are read from the
+// environment and the shared AWS config unless accessKeyId is set
+func NewClient(settings map[string]interface{}) (*dynamodb.Client, error) {
+	s, err := readSettings(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	var options []func(*config.LoadOptions) error
+	if s.Region != "" {
+		options = append(options, config.WithRegion(s.Region))
+	}
+	if s.AccessKeyId != "" {
+		options = append(options, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(s.AccessKeyId, s.SecretAccessKey, "")))
+	}
+	cfg, err := config.LoadDefaultConfig(context.Background(), options...)
+	if err != nil {
+		return nil, fmt.Errorf("Could not load the AWS configuration, %s", err.Error())
+	}
+	return dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		if s.Endpoint != "" {
+			o.EndpointResolver = dynamodb.EndpointResolverFromURL(s.Endpoint)
+		}
+	}), nil
+}
+
+// createTable creates the table and waits for it to be active, an existing table is left as is
+func createTable(ctx context.Context, client API, table string, timeout time.Duration) error {
+	_, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)})
+	if err == nil {
+		return nil
+	}
+	var notFound *types.ResourceNotFoundException
+	if !errors.As(err, &notFound) {
+		return err
+	}
+
+	logCache.Infof("Creating DynamoDB table [%s]", table)
+	attrs := []types.AttributeDefinition{}
+	for _, name := range []string{attrPK, attrSK, attrGSI1PK, attrGSI1SK, attrGSI2PK, attrGSI2SK} {
+		attrs = append(attrs, types.AttributeDefinition{AttributeName: aws.String(name), AttributeType: types.ScalarAttributeTypeS})
+	}
+	index := func(name, pk, sk string) types.GlobalSecondaryIndex {
+		return types.GlobalSecondaryIndex{
+			IndexName: aws.String(name),
+			KeySchema: []types.KeySchemaElement{
+				{AttributeName: aws.String(pk), KeyType: types.KeyTypeHash},
+				{AttributeName: aws.String(sk), KeyType: types.KeyTypeRange},
+			},
+			Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+		}
+	}
+	_, err = client.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName:            aws.String(table),
+		AttributeDefinitions: attrs,
+		KeySchema: []types.KeySchemaElement{
+			{AttributeName: aws.String(attrPK), KeyType: types.KeyTypeHash},
+			{AttributeName: aws.String(attrSK), KeyType: types.KeyTypeRange},
+		},
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+			index(AppIndex, attrGSI1PK, attrGSI1SK),
+			index(StatusIndex, attrGSI2PK, attrGSI2SK),
+		},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	if err != nil {
+		return err
+	}
+	return dynamodb.NewTableExistsWaiter(client, func(o *dynamodb.TableExistsWaiterOptions) {
+		o.MinDelay = time.Second
+		o.MaxDelay = 5 * time.Second
+	}).Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)}, timeout)
+}
+
+// key escapes the parts so they can be joined with #
+func key(parts ...string) string {
+	escaped := make([]string, len(parts))
+	for i, part := range parts {
+		escaped[i] = url.PathEscape(part)
+	}
+	return strings.Join(escaped, "#")
+}
+
+func instanceKey(flowId string) string {
+	return instancePrefix + key(flowId)
+}
+
+func appKey(user, app string) string {
+	return appPrefix + key(user, app)
+}
+
+func stepKey(stepId int) string {
+	return fmt.Sprintf("%s%010d", stepPrefix, stepId)
+}
+
+func timeKey(t time.Time) string {
+	return t.UTC().Format(timeLayout)
+}
+
+// startKey is the index sort key, instances with the same start time are ordered by instance id
+func startKey(t time.Time, flowId string) string {
+	return timeKey(t) + "#" + key(flowId)
+}
+
+func itemKey(pk, sk string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{attrPK: str(pk), attrSK: str(sk)}
+}
+
+func str(value string) types.AttributeValue {
+	return &types.AttributeValueMemberS{Value: value}
+}
+
+func num(value int) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: fmt.Sprint(value)}
+}
+
+func getString(item map[string]types.AttributeValue, name string) string {
+	if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
+
+func getInt(item map[string]types.AttributeValue, name string) int {
+	var n int
+	if v, ok := item[name].(*types.AttributeValueMemberN); ok {
+		_, _ = fmt.Sscan(v.Value, &n)
+	}
+	return n
+}
+
+func getBool(item map[string]types.AttributeValue, name string) bool {
+	v, ok := item[name].(*types.AttributeValueMemberBOOL)
+	return ok && v.Value
+}
+
+func getTime(item map[string]types.AttributeValue, name string) time.Time {
+	t, err := time.Parse(timeLayout, getString(item, name))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
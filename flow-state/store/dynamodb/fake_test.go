@@ -0,0 +1,345 @@
+package dynamodb_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	store "github.com/project-flogo/services/flow-state/store/dynamodb"
+)
+
+type item = map[string]types.AttributeValue
+
+// fakeDynamoDB is an in-process DynamoDB API holding a single table. It only understands the expressions used by the
+// store, and returns the query and scan results in pages of pageSize items to exercise the pagination.
+type fakeDynamoDB struct {
+	pageSize int
+
+	mu      sync.Mutex
+	created bool
+	items   map[[2]string]item
+}
+
+func newFakeDynamoDB() *fakeDynamoDB {
+	return &fakeDynamoDB{pageSize: 3, items: make(map[[2]string]item)}
+}
+
+func key(i item) [2]string {
+	return [2]string{stringValue(i["PK"]), stringValue(i["SK"])}
+}
+
+func stringValue(v types.AttributeValue) string {
+	if s, ok := v.(*types.AttributeValueMemberS); ok {
+		return s.Value
+	}
+	return ""
+}
+
+func copyItem(i item, projection *string) item {
+	c := make(item, len(i))
+	if projection != nil {
+		for _, name := range strings.Split(*projection, ",") {
+			if v, ok := i[strings.TrimSpace(name)]; ok {
+				c[strings.TrimSpace(name)] = v
+			}
+		}
+		return c
+	}
+	for name, v := range i {
+		c[name] = v
+	}
+	return c
+}
+
+func (f *fakeDynamoDB) GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	out := &dynamodb.GetItemOutput{}
+	if i, ok := f.items[key(params.Key)]; ok {
+		out.Item = copyItem(i, params.ProjectionExpression)
+	}
+	return out, nil
+}
+
+func (f *fakeDynamoDB) PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.items[key(params.Item)] = copyItem(params.Item, nil)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+// UpdateItem applies the SET, REMOVE and ADD clauses of the expression, with attribute_exists as only condition
+func (f *fakeDynamoDB) UpdateItem(ctx context.Context, params *dynamodb.UpdateItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	k := key(params.Key)
+	current, exists := f.items[k]
+	if condition := aws.ToString(params.ConditionExpression); condition != "" {
+		name := strings.TrimSuffix(strings.TrimPrefix(condition, "attribute_exists("), ")")
+		if name == condition {
+			return nil, fmt.Errorf("unsupported condition [%s]", condition)
+		}
+		if _, ok := current[name]; !exists || !ok {
+			return nil, &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+		}
+	}
+	updated := copyItem(params.Key, nil)
+	if exists {
+		updated = copyItem(current, nil)
+	}
+
+	name := func(token string) string {
+		token = strings.TrimSuffix(token, ",")
+		if strings.HasPrefix(token, "#") {
+			return params.ExpressionAttributeNames[token]
+		}
+		return token
+	}
+	value := func(token string) (types.AttributeValue, error) {
+		v, ok := params.ExpressionAttributeValues[strings.TrimSuffix(token, ",")]
+		if !ok {
+			return nil, fmt.Errorf("unknown value [%s]", token)
+		}
+		return v, nil
+	}
+	tokens := strings.Fields(aws.ToString(params.UpdateExpression))
+	clause := ""
+	for i := 0; i < len(tokens); i++ {
+		switch tokens[i] {
+		case "SET", "REMOVE", "ADD":
+			clause = tokens[i]
+			continue
+		}
+		switch clause {
+		case "SET":
+			if i+2 >= len(tokens) || tokens[i+1] != "=" {
+				return nil, fmt.Errorf("unsupported update expression [%s]", aws.ToString(params.UpdateExpression))
+			}
+			v, err := value(tokens[i+2])
+			if err != nil {
+				return nil, err
+			}
+			updated[name(tokens[i])] = v
+			i += 2
+		case "REMOVE":
+			delete(updated, name(tokens[i]))
+		case "ADD":
+			if i+1 >= len(tokens) {
+				return nil, fmt.Errorf("unsupported update expression [%s]", aws.ToString(params.UpdateExpression))
+			}
+			v, err := value(tokens[i+1])
+			if err != nil {
+				return nil, err
+			}
+			attr := name(tokens[i])
+			sum, err := add(updated[attr], v)
+			if err != nil {
+				return nil, err
+			}
+			updated[attr] = sum
+			i++
+		default:
+			return nil, fmt.Errorf("unsupported update expression [%s]", aws.ToString(params.UpdateExpression))
+		}
+	}
+	f.items[k] = updated
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+// add is the ADD action on a number or a string set, current is nil when the attribute is not set
+func add(current, v types.AttributeValue) (types.AttributeValue, error) {
+	switch v := v.(type) {
+	case *types.AttributeValueMemberN:
+		n, err := strconv.ParseInt(v.Value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		if c, ok := current.(*types.AttributeValueMemberN); ok {
+			m, err := strconv.ParseInt(c.Value, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			n += m
+		}
+		return &types.AttributeValueMemberN{Value: strconv.FormatInt(n, 10)}, nil
+	case *types.AttributeValueMemberSS:
+		set := map[string]bool{}
+		if c, ok := current.(*types.AttributeValueMemberSS); ok {
+			for _, s := range c.Value {
+				set[s] = true
+			}
+		}
+		for _, s := range v.Value {
+			set[s] = true
+		}
+		values := make([]string, 0, len(set))
+		for s := range set {
+			values = append(values, s)
+		}
+		sort.Strings(values)
+		return &types.AttributeValueMemberSS{Value: values}, nil
+	}
+	return nil, fmt.Errorf("unsupported ADD value %T", v)
+}
+
+// Query supports a partition key equality and an optional begins_with, BETWEEN, >= or <= sort key condition
+func (f *fakeDynamoDB) Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	pkName, skName := "PK", "SK"
+	switch aws.ToString(params.IndexName) {
+	case "":
+	case store.AppIndex:
+		pkName, skName = "GSI1PK", "GSI1SK"
+	case store.StatusIndex:
+		pkName, skName = "GSI2PK", "GSI2SK"
+	default:
+		return nil, fmt.Errorf("unknown index [%s]", aws.ToString(params.IndexName))
+	}
+	values := params.ExpressionAttributeValues
+	parts := strings.SplitN(aws.ToString(params.KeyConditionExpression), " AND ", 2)
+	if parts[0] != pkName+" = :pk" {
+		return nil, fmt.Errorf("unsupported key condition [%s]", aws.ToString(params.KeyConditionExpression))
+	}
+	pk := stringValue(values[":pk"])
+	matches := func(sk string) bool { return true }
+	if len(parts) == 2 {
+		switch parts[1] {
+		case "begins_with(" + skName + ", :prefix)":
+			matches = func(sk string) bool { return strings.HasPrefix(sk, stringValue(values[":prefix"])) }
+		case skName + " BETWEEN :from AND :to":
+			matches = func(sk string) bool { return sk >= stringValue(values[":from"]) && sk <= stringValue(values[":to"]) }
+		case skName + " >= :from":
+			matches = func(sk string) bool { return sk >= stringValue(values[":from"]) }
+		case skName + " <= :to":
+			matches = func(sk string) bool { return sk <= stringValue(values[":to"]) }
+		default:
+			return nil, fmt.Errorf("unsupported key condition [%s]", aws.ToString(params.KeyConditionExpression))
+		}
+	}
+
+	f.mu.Lock()
+	var selected []item
+	for _, i := range f.items {
+		// the indexes are sparse, items without the index keys are not in them
+		if _, ok := i[skName]; ok && stringValue(i[pkName]) == pk && matches(stringValue(i[skName])) {
+			selected = append(selected, copyItem(i, nil))
+		}
+	}
+	f.mu.Unlock()
+	sort.Slice(selected, func(a, b int) bool {
+		if sa, sb := stringValue(selected[a][skName]), stringValue(selected[b][skName]); sa != sb {
+			return sa < sb
+		}
+		return stringValue(selected[a]["PK"]) < stringValue(selected[b]["PK"])
+	})
+	if params.ScanIndexForward != nil && !*params.ScanIndexForward {
+		for a, b := 0, len(selected)-1; a < b; a, b = a+1, b-1 {
+			selected[a], selected[b] = selected[b], selected[a]
+		}
+	}
+	page, last := f.page(selected, params.ExclusiveStartKey, params.ProjectionExpression)
+	return &dynamodb.QueryOutput{Items: page, Count: int32(len(page)), LastEvaluatedKey: last}, nil
+}
+
+// Scan supports a filter of attribute equalities joined by AND
+func (f *fakeDynamoDB) Scan(ctx context.Context, params *dynamodb.ScanInput, optFns ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	type equality struct {
+		name  string
+		value types.AttributeValue
+	}
+	var filter []equality
+	if expression := aws.ToString(params.FilterExpression); expression != "" {
+		for _, condition := range strings.Split(expression, " AND ") {
+			parts := strings.Split(condition, " = ")
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("unsupported filter [%s]", expression)
+			}
+			filter = append(filter, equality{parts[0], params.ExpressionAttributeValues[parts[1]]})
+		}
+	}
+
+	f.mu.Lock()
+	var all []item
+	for _, i := range f.items {
+		all = append(all, copyItem(i, nil))
+	}
+	f.mu.Unlock()
+	sort.Slice(all, func(a, b int) bool {
+		ka, kb := key(all[a]), key(all[b])
+		return ka[0] < kb[0] || (ka[0] == kb[0] && ka[1] < kb[1])
+	})
+
+	// the filter applies to the items of the page, so a page may be empty
+	page, last := f.page(all, params.ExclusiveStartKey, nil)
+	var selected []item
+	for _, i := range page {
+		keep := true
+		for _, e := range filter {
+			keep = keep && reflect.DeepEqual(i[e.name], e.value)
+		}
+		if keep {
+			selected = append(selected, copyItem(i, params.ProjectionExpression))
+		}
+	}
+	return &dynamodb.ScanOutput{Items: selected, Count: int32(len(selected)), LastEvaluatedKey: last}, nil
+}
+
+// page returns the pageSize items after the item of the start key, and the key of the last item when more items follow
+func (f *fakeDynamoDB) page(items []item, start item, projection *string) ([]item, item) {
+	if len(start) > 0 {
+		for n, i := range items {
+			if key(i) == key(start) {
+				items = items[n+1:]
+				break
+			}
+		}
+	}
+	var last item
+	if len(items) > f.pageSize {
+		items = items[:f.pageSize]
+		last = copyItem(items[len(items)-1], aws.String("PK, SK"))
+	}
+	page := make([]item, 0, len(items))
+	for _, i := range items {
+		page = append(page, copyItem(i, projection))
+	}
+	return page, last
+}
+
+func (f *fakeDynamoDB) BatchWriteItem(ctx context.Context, params *dynamodb.BatchWriteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.BatchWriteItemOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, requests := range params.RequestItems {
+		for _, request := range requests {
+			if request.DeleteRequest != nil {
+				delete(f.items, key(request.DeleteRequest.Key))
+			}
+			if request.PutRequest != nil {
+				f.items[key(request.PutRequest.Item)] = copyItem(request.PutRequest.Item, nil)
+			}
+		}
+	}
+	return &dynamodb.BatchWriteItemOutput{}, nil
+}
+
+func (f *fakeDynamoDB) DescribeTable(ctx context.Context, params *dynamodb.DescribeTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.created {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("Requested resource not found")}
+	}
+	return &dynamodb.DescribeTableOutput{Table: &types.TableDescription{TableName: params.TableName, TableStatus: types.TableStatusActive}}, nil
+}
+
+func (f *fakeDynamoDB) CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.created = true
+	return &dynamodb.CreateTableOutput{TableDescription: &types.TableDescription{TableName: params.TableName, TableStatus: types.TableStatusCreating}}, nil
+}
@@ -0,0 +1,49 @@
+package dynamodb
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestStepKeyOrder(t *testing.T) {
+	keys := []string{stepKey(10), stepKey(2), stepKey(1), stepKey(100)}
+	sort.Strings(keys)
+	if keys[0] != stepKey(1) || keys[1] != stepKey(2) || keys[2] != stepKey(10) || keys[3] != stepKey(100) {
+		t.Fatalf("expected the step keys in step id order, got %v", keys)
+	}
+	if stepKey(10) > stepPrefix+"~" {
+		t.Fatal("expected the step keys below the upper bound of DeleteSteps")
+	}
+}
+
+func TestStartKeyOrder(t *testing.T) {
+	base := time.Date(2023, 6, 1, 10, 0, 0, 0, time.UTC)
+	// a time with trailing zero nanoseconds must not sort after a later one
+	early, late := startKey(base.Add(100*time.Millisecond), "b"), startKey(base.Add(123456789*time.Nanosecond), "a")
+	if early >= late {
+		t.Fatalf("expected %s before %s", early, late)
+	}
+	if startKey(base, "a") >= startKey(base, "b") {
+		t.Fatal("expected instances with the same start time ordered by id")
+	}
+	local := base.In(time.FixedZone("CEST", 2*3600))
+	if timeKey(local) != timeKey(base) {
+		t.Fatal("expected start keys in UTC")
+	}
+	item := map[string]types.AttributeValue{"startTime": str(timeKey(local))}
+	if !getTime(item, "startTime").Equal(base) {
+		t.Fatalf("expected %s, got %s", base, getTime(item, "startTime"))
+	}
+}
+
+func TestKeyEscaping(t *testing.T) {
+	if key("a#b", "c") == key("a", "b#c") {
+		t.Fatal("expected # in key parts to be escaped")
+	}
+	if appKey("alice", "orders") != "APP#alice#orders" {
+		t.Fatalf("unexpected app key %s", appKey("alice", "orders"))
+	}
+}
@@ -0,0 +1,71 @@
+package dynamodb
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/project-flogo/services/flow-state/retention"
+)
+
+// RetentionInstances scans the instance items, instances only known from their steps are not dated and never expire
+func (s *StepStore) RetentionInstances(filter *retention.Filter) ([]*retention.Instance, error) {
+	input := &dynamodb.ScanInput{
+		TableName:                 aws.String(s.table),
+		FilterExpression:          aws.String("SK = :state AND started = :started"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":state": str(stateKey), ":started": &types.AttributeValueMemberBOOL{Value: true}},
+	}
+
+	var instances []*retention.Instance
+	for {
+		ctx, cancel := s.context()
+		out, err := s.client.Scan(ctx, input)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range out.Items {
+			instance := &retention.Instance{
+				FlowInstanceId: getString(item, "flowInstanceId"),
+				AppName:        getString(item, "appName"),
+				FlowName:       getString(item, "flowName"),
+				Status:         getString(item, "status"),
+				Time:           getTime(item, "startTime"),
+			}
+			if end := getTime(item, "endTime"); !end.IsZero() {
+				instance.Time = end
+			} else {
+				instance.Running = true
+			}
+			if filter.Matches(instance) {
+				instances = append(instances, instance)
+			}
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			return instances, nil
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+// PurgeInstances deletes all the items of the instances, each item counts as a row
+func (s *StepStore) PurgeInstances(flowIds []string) (int64, error) {
+	var rows int64
+	for _, id := range flowIds {
+		items, err := s.query(&dynamodb.QueryInput{
+			KeyConditionExpression:    aws.String("PK = :pk"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{":pk": str(instanceKey(id))},
+			ProjectionExpression:      aws.String("PK, SK"),
+			ConsistentRead:            aws.Bool(true),
+		})
+		if err != nil {
+			return rows, fmt.Errorf("Could not query the items of flow instance [%s], %s", id, err.Error())
+		}
+		if err = s.deleteItems(items); err != nil {
+			return rows, fmt.Errorf("Could not delete flow instance [%s], %s", id, err.Error())
+		}
+		rows += int64(len(items))
+	}
+	return rows, nil
+}
@@ -1,133 +1,692 @@
-package mem
+package dynamodb
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/project-flogo/flow/state"
 	"github.com/project-flogo/services/flow-state/event"
-	"github.com/project-flogo/services/flow-state/store"
-	"sync"
+	"github.com/project-flogo/services/flow-state/store/metadata"
+	"github.com/project-flogo/services/flow-state/store/task"
 )
 
-func init() {
-	store.SetStepStore(&StepStore{stepContainers: make(map[string]*stepContainer)})
+// batchSize is the number of items BatchWriteItem accepts at once
+const batchSize = 25
+
+func NewStore(settings map[string]interface{}) (*StepStore, error) {
+	client, err := NewClient(settings)
+	if err != nil {
+		return nil, err
+	}
+	return NewStoreWithClient(client, settings)
+}
+
+// NewStoreWithClient creates the store on a client configured by the caller, the client settings are ignored
+func NewStoreWithClient(client API, settings map[string]interface{}) (*StepStore, error) {
+	s, err := readSettings(settings)
+	if err != nil {
+		return nil, err
+	}
+	store := &StepStore{client: client, table: s.Table, timeout: time.Duration(s.Timeout) * time.Second}
+	if s.CreateTable {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		if err = createTable(ctx, client, s.Table, 5*time.Minute); err != nil {
+			return nil, fmt.Errorf("Could not create DynamoDB table [%s], %s", s.Table, err.Error())
+		}
+	}
+	return store, nil
+}
+
+type TableDetails struct {
+	Table   string `json:"table"`
+	Message string `json:"message"`
+	Status  bool   `json:"status"`
 }
 
+// StepStore is a store.Store keeping the flow state in a DynamoDB table
 type StepStore struct {
-	sync.RWMutex
+	client  API
+	table   string
+	timeout time.Duration
 }
 
-func (s *StepStore) GetStatus(flowId string) int {
+func (s *StepStore) Status() interface{} {
+	ctx, cancel := s.context()
+	defer cancel()
+	details := &TableDetails{Table: s.table, Status: true}
+	out, err := s.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(s.table)})
+	if err != nil {
+		details.Status = false
+		details.Message = err.Error()
+	} else {
+		details.Message = string(out.Table.TableStatus)
+	}
+	return details
+}
+
+func (s *StepStore) MaxConcurrencyLimit() int {
+	return 20
+}
 
-	s.RLock()
-	sc, ok := s.stepContainers[flowId]
-	s.RUnlock()
+func (s *StepStore) context() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), s.timeout)
+}
 
-	if ok {
-		return sc.Status()
+func (s *StepStore) getItem(pk, sk string) (map[string]types.AttributeValue, error) {
+	ctx, cancel := s.context()
+	defer cancel()
+	out, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{TableName: aws.String(s.table), Key: itemKey(pk, sk), ConsistentRead: aws.Bool(true)})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Item) == 0 {
+		return nil, nil
 	}
+	return out.Item, nil
+}
 
-	return -1
+func (s *StepStore) putItem(item map[string]types.AttributeValue) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	_, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: aws.String(s.table), Item: item})
+	return err
 }
 
-func (s *StepStore) GetFlow(flowId string) *state.FlowInfo {
+// update sets the values of an item, the names of the values are the attribute names
+func (s *StepStore) update(pk, sk string, set map[string]types.AttributeValue, remove ...string) error {
+	names := map[string]string{}
+	values := map[string]types.AttributeValue{}
+	var sets []string
+	i := 0
+	for name, value := range set {
+		i++
+		names[fmt.Sprintf("#a%d", i)] = name
+		values[fmt.Sprintf(":v%d", i)] = value
+		sets = append(sets, fmt.Sprintf("#a%d = :v%d", i, i))
+	}
+	sort.Strings(sets)
+	expression := "SET " + strings.Join(sets, ", ")
+	if len(remove) > 0 {
+		var removes []string
+		for _, name := range remove {
+			i++
+			names[fmt.Sprintf("#a%d", i)] = name
+			removes = append(removes, fmt.Sprintf("#a%d", i))
+		}
+		expression += " REMOVE " + strings.Join(removes, ", ")
+	}
 
-	s.RLock()
-	sc, ok := s.stepContainers[flowId]
-	s.RUnlock()
+	ctx, cancel := s.context()
+	defer cancel()
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(s.table),
+		Key:                       itemKey(pk, sk),
+		UpdateExpression:          aws.String(expression),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	return err
+}
 
-	if ok {
-		return &state.FlowInfo{Id: flowId, Status: sc.Status(), FlowURI: sc.flowURI}
+// query runs the query until all pages are read
+func (s *StepStore) query(input *dynamodb.QueryInput) ([]map[string]types.AttributeValue, error) {
+	input.TableName = aws.String(s.table)
+	var items []map[string]types.AttributeValue
+	for {
+		ctx, cancel := s.context()
+		out, err := s.client.Query(ctx, input)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, out.Items...)
+		if len(out.LastEvaluatedKey) == 0 {
+			return items, nil
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
 	}
+}
+
+// queryPrefix lists the items of the partition whose sort key starts with prefix
+func (s *StepStore) queryPrefix(pk, prefix string) ([]map[string]types.AttributeValue, error) {
+	return s.query(&dynamodb.QueryInput{
+		KeyConditionExpression:    aws.String("PK = :pk AND begins_with(SK, :prefix)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":pk": str(pk), ":prefix": str(prefix)},
+		ConsistentRead:            aws.Bool(true),
+	})
+}
+
+// deleteItems deletes the items in batches, retrying the items DynamoDB did not process
+func (s *StepStore) deleteItems(items []map[string]types.AttributeValue) error {
+	for len(items) > 0 {
+		n := batchSize
+		if n > len(items) {
+			n = len(items)
+		}
+		var requests []types.WriteRequest
+		for _, item := range items[:n] {
+			requests = append(requests, types.WriteRequest{DeleteRequest: &types.DeleteRequest{Key: itemKey(getString(item, attrPK), getString(item, attrSK))}})
+		}
+		items = items[n:]
 
+		for attempt := 0; len(requests) > 0; attempt++ {
+			if attempt > 0 {
+				if attempt > 5 {
+					return fmt.Errorf("%d items were not deleted", len(requests))
+				}
+				time.Sleep(time.Duration(attempt*attempt) * 50 * time.Millisecond)
+			}
+			ctx, cancel := s.context()
+			out, err := s.client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: map[string][]types.WriteRequest{s.table: requests}})
+			cancel()
+			if err != nil {
+				return err
+			}
+			requests = out.UnprocessedItems[s.table]
+		}
+	}
 	return nil
 }
 
-func (s *StepStore) GetFlows() []*state.FlowInfo {
+func (s *StepStore) GetStatus(flowId string) int {
+	item, err := s.getItem(instanceKey(flowId), stateKey)
+	if err != nil {
+		logCache.Errorf("Could not read flow instance [%s], %s", flowId, err.Error())
+		return -1
+	}
+	if item == nil || !getBool(item, "hasSteps") {
+		return -1
+	}
+	return getInt(item, "stepStatus")
+}
 
-	var infos []*state.FlowInfo
+// GetFlow reads the state item of the instance, the item only has the flow name and inputs once the start is recorded
+func (s *StepStore) GetFlow(flowid string, fmetadata *metadata.Metadata) (*state.FlowInfo, error) {
+	item, err := s.getItem(instanceKey(flowid), stateKey)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read flow instance [%s], %s", flowid, err.Error())
+	}
+	if item == nil {
+		return nil, nil
+	}
+	if !getBool(item, "started") {
+		if getBool(item, "hasSteps") {
+			return &state.FlowInfo{Id: flowid, Status: getInt(item, "stepStatus"), FlowURI: getString(item, "flowURI")}, nil
+		}
+		return nil, nil
+	}
+	if !metadata.OptionalMatch(getString(item, "userId"), fmetadata.Username) || !metadata.OptionalMatch(getString(item, "appName"), fmetadata.AppName) ||
+		!metadata.OptionalMatch(getString(item, "appVersion"), fmetadata.AppVersion) || !metadata.OptionalMatch(getString(item, "hostId"), fmetadata.HostId) {
+		return nil, nil
+	}
+
+	flowName := getString(item, "flowName")
+	info := &state.FlowInfo{Id: flowid, FlowName: flowName, FlowStatus: getString(item, "status"), FlowURI: "res://flow:" + flowName}
+	if info.FlowInputs, err = getJSON(item, "inputs"); err != nil {
+		return nil, err
+	}
+	if getBool(item, "hasSteps") {
+		info.Status = getInt(item, "stepStatus")
+	}
+	return info, nil
+}
+
+func (s *StepStore) GetFlows(mtdata *metadata.Metadata) ([]*state.FlowInfo, error) {
+	flows, err := s.listFlows(mtdata, mtdata.Status, false)
+	if err != nil {
+		return nil, err
+	}
+	return metadata.Page(flows, mtdata)
+}
+
+func (s *StepStore) GetFlowsWithRecordCount(mtdata *metadata.Metadata) (*metadata.FlowRecord, error) {
+	flows, err := s.listFlows(mtdata, mtdata.Status, true)
+	if err != nil {
+		return nil, err
+	}
+	count := len(flows)
+	flows, err = metadata.Page(flows, mtdata)
+	if err != nil {
+		return nil, err
+	}
+	return &metadata.FlowRecord{Count: int32(count), FlowData: flows}, nil
+}
+
+func (s *StepStore) GetFailedFlows(metadata *metadata.Metadata) ([]*state.FlowInfo, error) {
+	return s.listFlows(metadata, "Failed", false)
+}
+
+func (s *StepStore) GetCompletedFlows(metadata *metadata.Metadata) ([]*state.FlowInfo, error) {
+	return s.listFlows(metadata, "Completed", false)
+}
 
-	s.RLock()
-	for id, value := range s.stepContainers {
-		infos = append(infos, &state.FlowInfo{Id: id, FlowURI: value.flowURI, Status: value.Status()})
+// listFlows queries the app or status index of the user, app and version, the instances listing also applies
+// the instance id and time range filters, instances are ordered by start time, newest first
+func (s *StepStore) listFlows(mtdata *metadata.Metadata, status string, records bool) ([]*state.FlowInfo, error) {
+	var from, to time.Time
+	if records {
+		var err error
+		if from, to, err = metadata.TimeRange(mtdata); err != nil {
+			return nil, err
+		}
+	}
+
+	input := &dynamodb.QueryInput{IndexName: aws.String(AppIndex), ScanIndexForward: aws.Bool(false)}
+	pk, sk := attrGSI1PK, attrGSI1SK
+	values := map[string]types.AttributeValue{":pk": str(key(mtdata.Username, mtdata.AppName, mtdata.AppVersion))}
+	if status != "" {
+		input.IndexName = aws.String(StatusIndex)
+		pk, sk = attrGSI2PK, attrGSI2SK
+		values[":pk"] = str(key(mtdata.Username, mtdata.AppName, mtdata.AppVersion, status))
+	}
+	condition := pk + " = :pk"
+	switch {
+	case !from.IsZero() && !to.IsZero():
+		condition += " AND " + sk + " BETWEEN :from AND :to"
+		values[":from"], values[":to"] = str(timeKey(from)), str(timeKey(to)+"#~")
+	case !from.IsZero():
+		condition += " AND " + sk + " >= :from"
+		values[":from"] = str(timeKey(from))
+	case !to.IsZero():
+		condition += " AND " + sk + " <= :to"
+		values[":to"] = str(timeKey(to) + "#~")
+	}
+	input.KeyConditionExpression = aws.String(condition)
+	input.ExpressionAttributeValues = values
+
+	items, err := s.query(input)
+	if err != nil {
+		return nil, fmt.Errorf("Could not query flow instances, %s", err.Error())
 	}
-	s.RUnlock()
 
-	return infos
+	// the index returns the instances with the same start time in descending id order
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := getString(items[i], sk), getString(items[j], sk)
+		if a[:len(timeLayout)] != b[:len(timeLayout)] {
+			return a > b
+		}
+		return a < b
+	})
+
+	var flows []*state.FlowInfo
+	for _, item := range items {
+		id := getString(item, "flowInstanceId")
+		if !metadata.OptionalMatch(getString(item, "hostId"), mtdata.HostId) || !metadata.OptionalMatch(getString(item, "flowName"), mtdata.FlowName) {
+			continue
+		}
+		if records && len(mtdata.FlowInstanceId) > 0 && id != mtdata.FlowInstanceId && getString(item, "originalInstanceId") != mtdata.FlowInstanceId {
+			continue
+		}
+		flows = append(flows, &state.FlowInfo{
+			Id:                 id,
+			FlowName:           getString(item, "flowName"),
+			HostId:             getString(item, "hostId"),
+			FlowStatus:         getString(item, "status"),
+			StartTime:          metadata.FormatTime(getTime(item, "startTime")),
+			EndTime:            metadata.FormatTime(getTime(item, "endTime")),
+			ExecutionTime:      getString(item, "executionTime"),
+			OriginalInstanceId: getString(item, "originalInstanceId"),
+			RerunCount:         getInt(item, "rerunCount"),
+			FlowInputs:         make(map[string]interface{}),
+		})
+	}
+	return flows, nil
 }
 
+// SaveStep writes the step item and records the flow status and URI of the step on the instance
 func (s *StepStore) SaveStep(step *state.Step) error {
+	b, err := json.Marshal(step)
+	if err != nil {
+		return err
+	}
+	pk := instanceKey(step.FlowId)
+	item := itemKey(pk, stepKey(step.Id))
+	item["data"] = str(string(b))
+	if err = s.putItem(item); err != nil {
+		return fmt.Errorf("Could not save step [%d] of flow instance [%s], %s", step.Id, step.FlowId, err.Error())
+	}
+
+	set := map[string]types.AttributeValue{"flowInstanceId": str(step.FlowId), "hasSteps": &types.AttributeValueMemberBOOL{Value: true}}
+	if flow, ok := step.FlowChanges[0]; ok && flow != nil && flow.SubflowId == 0 {
+		if flow.Status != -1 {
+			set["stepStatus"] = num(flow.Status)
+		}
+		if flow.FlowURI != "" {
+			set["flowURI"] = str(flow.FlowURI)
+		}
+	}
+	if err = s.update(pk, stateKey, set); err != nil {
+		return fmt.Errorf("Could not save the status of flow instance [%s], %s", step.FlowId, err.Error())
+	}
 	event.PostStepEvent(step)
-	s.RLock()
-	sc, ok := s.stepContainers[step.FlowId]
-	s.RUnlock()
+	return nil
+}
+
+func (s *StepStore) GetSteps(flowId string) ([]*state.Step, error) {
+	items, err := s.queryPrefix(instanceKey(flowId), stepPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("Could not query the steps of flow instance [%s], %s", flowId, err.Error())
+	}
 
-	if !ok {
-		s.Lock()
-		sc, ok = s.stepContainers[step.FlowId]
-		if !ok {
-			sc = &stepContainer{}
+	var steps []*state.Step
+	for _, item := range items {
+		step := &state.Step{}
+		if err = json.Unmarshal([]byte(getString(item, "data")), step); err != nil {
+			return nil, fmt.Errorf("Could not read step [%s] of flow instance [%s], %s", getString(item, attrSK), flowId, err.Error())
 		}
-		s.stepContainers[step.FlowId] = sc
-		s.Unlock()
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+func (s *StepStore) GetStepsAsTasks(flowId string) ([][]*task.Task, error) {
+	steps, err := s.GetSteps(flowId)
+	if err != nil || steps == nil {
+		return nil, err
 	}
+	return task.StepsAsTasks(steps)
+}
 
-	sc.AddStep(step)
+func (s *StepStore) GetStepsStatus(flowId string) ([]map[string]string, error) {
+	steps, err := s.GetSteps(flowId)
+	if err != nil || steps == nil {
+		return nil, err
+	}
+	return task.StepsStatus(steps)
+}
 
-	return nil
+func (s *StepStore) GetStepdataForActivity(flowId, stepid, taskname string) ([]*task.Task, error) {
+	id, err := strconv.Atoi(stepid)
+	if err != nil {
+		return nil, fmt.Errorf("No step data found for matching input")
+	}
+	steps, err := s.GetSteps(flowId)
+	if err != nil {
+		return nil, err
+	}
+	return task.StepdataForActivity(steps, id, taskname)
 }
 
-func (s *StepStore) GetSteps(flowId string) []*state.Step {
-	s.RLock()
-	sc, ok := s.stepContainers[flowId]
-	s.RUnlock()
-	if ok {
-		return sc.Steps()
+// GetFlowNames lists the flows the app recorded instances for, instances deleted since are still counted
+func (s *StepStore) GetFlowNames(mtdata *metadata.Metadata) ([]string, error) {
+	prefix := flowPrefix
+	if mtdata.AppVersion != "" {
+		prefix += key(mtdata.AppVersion) + "#"
+	}
+	items, err := s.queryPrefix(appKey(mtdata.Username, mtdata.AppName), prefix)
+	if err != nil {
+		return nil, fmt.Errorf("Could not query the flow names, %s", err.Error())
+	}
+
+	names := make(map[string]bool)
+	for _, item := range items {
+		if mtdata.HostId != "" {
+			hosts, _ := item["hostIds"].(*types.AttributeValueMemberSS)
+			if hosts == nil || !contains(hosts.Value, mtdata.HostId) {
+				continue
+			}
+		}
+		names[getString(item, "flowName")] = true
 	}
+	return metadata.Sorted(names), nil
+}
 
+// GetAppVersions lists the versions the app recorded instances for, instances deleted since are still counted
+func (s *StepStore) GetAppVersions(mtdata *metadata.Metadata) ([]string, error) {
+	items, err := s.queryPrefix(appKey(mtdata.Username, mtdata.AppName), versionPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("Could not query the app versions, %s", err.Error())
+	}
+	versions := make(map[string]bool)
+	for _, item := range items {
+		versions[getString(item, "appVersion")] = true
+	}
+	return metadata.Sorted(versions), nil
+}
+
+func (s *StepStore) GetAppState(metadata *metadata.Metadata) (string, error) {
+	item, err := s.getItem(appKey(metadata.Username, metadata.AppName), appStateKey)
+	if err != nil {
+		return "", fmt.Errorf("Could not read the app state, %s", err.Error())
+	}
+	if item == nil {
+		return "", nil
+	}
+	return strconv.FormatBool(getBool(item, "enabled")), nil
+}
+
+func (s *StepStore) SaveAppState(metadata *metadata.Metadata) error {
+	item := itemKey(appKey(metadata.Username, metadata.AppName), appStateKey)
+	item["enabled"] = &types.AttributeValueMemberBOOL{Value: metadata.PersistEnabled}
+	if err := s.putItem(item); err != nil {
+		return fmt.Errorf("Could not save the app state, %s", err.Error())
+	}
 	return nil
 }
 
 func (s *StepStore) Delete(flowId string) {
-	s.Lock()
-	delete(s.stepContainers, flowId)
-	s.Unlock()
+	if _, err := s.PurgeInstances([]string{flowId}); err != nil {
+		logCache.Errorf("Could not delete flow instance [%s], %s", flowId, err.Error())
+	}
 }
 
-type stepContainer struct {
-	sync.RWMutex
-	status  int
-	flowURI string
-	steps   []*state.Step
+func (s *StepStore) SaveSnapshot(snapshot *state.Snapshot) error {
+	b, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	// replaces existing snapshot
+	item := itemKey(instanceKey(snapshot.Id), snapshotKey)
+	item["data"] = str(string(b))
+	if err = s.putItem(item); err != nil {
+		return fmt.Errorf("Could not save the snapshot of flow instance [%s], %s", snapshot.Id, err.Error())
+	}
+	return nil
 }
 
-func (sc *stepContainer) Status() int {
-	sc.RLock()
-	status := sc.status
-	sc.RUnlock()
+func (s *StepStore) GetSnapshot(flowId string) *state.Snapshot {
+	item, err := s.getItem(instanceKey(flowId), snapshotKey)
+	if err != nil {
+		logCache.Errorf("Could not read the snapshot of flow instance [%s], %s", flowId, err.Error())
+		return nil
+	}
+	if item == nil {
+		return nil
+	}
+	snapshot := &state.Snapshot{}
+	if err = json.Unmarshal([]byte(getString(item, "data")), snapshot); err != nil {
+		logCache.Errorf("Could not read the snapshot of flow instance [%s], %s", flowId, err.Error())
+		return nil
+	}
+	return snapshot
+}
 
-	return status
+// RecordStart saves the instance state and indexes it, the app version and flow name are added to the app items
+func (s *StepStore) RecordStart(flowState *state.FlowState) error {
+	inputs := flowState.FlowInputs
+	if inputs == nil {
+		inputs = make(map[string]interface{})
+	}
+	b, err := json.Marshal(inputs)
+	if err != nil {
+		return err
+	}
+
+	if flowState.OriginalInstanceId != "" {
+		if err = s.incrementRerunCount(flowState.OriginalInstanceId); err != nil {
+			return fmt.Errorf("Could not update the rerun count of flow instance [%s], %s", flowState.OriginalInstanceId, err.Error())
+		}
+	}
+
+	user, app, version := flowState.UserId, flowState.AppName, flowState.AppVersion
+	set := map[string]types.AttributeValue{
+		"flowInstanceId":     str(flowState.FlowInstanceId),
+		"started":            &types.AttributeValueMemberBOOL{Value: true},
+		"userId":             str(user),
+		"appName":            str(app),
+		"appVersion":         str(version),
+		"hostId":             str(flowState.HostId),
+		"flowName":           str(flowState.FlowName),
+		"status":             str(flowState.FlowStats),
+		"startTime":          str(timeKey(flowState.StartTime)),
+		"inputs":             str(string(b)),
+		"originalInstanceId": str(flowState.OriginalInstanceId),
+		"rerunCount":         num(flowState.RerunCount),
+		attrGSI1PK:           str(key(user, app, version)),
+		attrGSI1SK:           str(startKey(flowState.StartTime, flowState.FlowInstanceId)),
+		attrGSI2PK:           str(key(user, app, version, flowState.FlowStats)),
+		attrGSI2SK:           str(startKey(flowState.StartTime, flowState.FlowInstanceId)),
+	}
+	remove := []string{"outputs", "executionTime", "endTime"}
+	if !flowState.EndTime.IsZero() {
+		set["endTime"] = str(timeKey(flowState.EndTime))
+		remove = remove[:2]
+	}
+	if err = s.update(instanceKey(flowState.FlowInstanceId), stateKey, set, remove...); err != nil {
+		return fmt.Errorf("Could not save the start of flow instance [%s], %s", flowState.FlowInstanceId, err.Error())
+	}
+
+	versionItem := itemKey(appKey(user, app), versionPrefix+key(version))
+	versionItem["appVersion"] = str(version)
+	if err = s.putItem(versionItem); err != nil {
+		return fmt.Errorf("Could not save the app version, %s", err.Error())
+	}
+	if err = s.addFlow(user, app, version, flowState.FlowName, flowState.HostId); err != nil {
+		return fmt.Errorf("Could not save the flow name, %s", err.Error())
+	}
+	event.PostStartEvent(flowState)
+	return nil
 }
 
-func (sc *stepContainer) AddStep(step *state.Step) {
-	sc.Lock()
+func (s *StepStore) incrementRerunCount(flowId string) error {
+	ctx, cancel := s.context()
+	defer cancel()
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(s.table),
+		Key:                       itemKey(instanceKey(flowId), stateKey),
+		UpdateExpression:          aws.String("ADD rerunCount :one"),
+		ConditionExpression:       aws.String("attribute_exists(userId)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":one": num(1)},
+	})
+	var notStarted *types.ConditionalCheckFailedException
+	if errors.As(err, &notStarted) {
+		return nil
+	}
+	return err
+}
 
-	if len(step.FlowChanges) > 0 {
-		if step.FlowChanges[0] != nil && step.FlowChanges[0].SubflowId == 0 {
-			if status := step.FlowChanges[0].Status; status != -1 {
-				sc.status = status
-			}
-			if uri := step.FlowChanges[0].FlowURI; uri != "" {
-				sc.flowURI = uri
-			}
+// addFlow records the flow name of the app version and adds the host to its hosts
+func (s *StepStore) addFlow(user, app, version, flowName, hostId string) error {
+	expression := "SET flowName = :flow"
+	values := map[string]types.AttributeValue{":flow": str(flowName)}
+	if hostId != "" {
+		expression += " ADD hostIds :host"
+		values[":host"] = &types.AttributeValueMemberSS{Value: []string{hostId}}
+	}
+	ctx, cancel := s.context()
+	defer cancel()
+	_, err := s.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(s.table),
+		Key:                       itemKey(appKey(user, app), flowPrefix+key(version, flowName)),
+		UpdateExpression:          aws.String(expression),
+		ExpressionAttributeValues: values,
+	})
+	return err
+}
+
+func (s *StepStore) GetFlowOwner(flowId string) (*metadata.Owner, error) {
+	item, err := s.getItem(instanceKey(flowId), stateKey)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read flow instance [%s], %s", flowId, err.Error())
+	}
+	if item == nil || !getBool(item, "started") {
+		return nil, nil
+	}
+	return &metadata.Owner{Username: getString(item, "userId"), AppName: getString(item, "appName"), AppVersion: getString(item, "appVersion")}, nil
+}
+
+// RecordEnd saves the end of the flow instance and moves it to the status index of its final status
+func (s *StepStore) RecordEnd(flowState *state.FlowState) error {
+	pk := instanceKey(flowState.FlowInstanceId)
+	item, err := s.getItem(pk, stateKey)
+	if err != nil {
+		return fmt.Errorf("Could not read flow instance [%s], %s", flowState.FlowInstanceId, err.Error())
+	}
+
+	set := map[string]types.AttributeValue{
+		"flowInstanceId": str(flowState.FlowInstanceId),
+		"status":         str(flowState.FlowStats),
+		"endTime":        str(timeKey(flowState.EndTime)),
+	}
+	if flowState.FlowOutputs != nil {
+		b, err := json.Marshal(flowState.FlowOutputs)
+		if err != nil {
+			return err
+		}
+		set["outputs"] = str(string(b))
+	}
+	if item != nil && getBool(item, "started") {
+		if started := getTime(item, "startTime"); !started.IsZero() {
+			elapsed := float64(flowState.EndTime.Sub(started).Microseconds()) / 1000
+			set["executionTime"] = str(strconv.FormatFloat(elapsed, 'f', -1, 64))
 		}
+		set[attrGSI2PK] = str(key(getString(item, "userId"), getString(item, "appName"), getString(item, "appVersion"), flowState.FlowStats))
+	} else {
+		set["flowName"] = str(flowState.FlowName)
+	}
+	if err = s.update(pk, stateKey, set); err != nil {
+		return fmt.Errorf("Could not save the end of flow instance [%s], %s", flowState.FlowInstanceId, err.Error())
 	}
+	event.PostEndEvent(flowState)
+	return nil
+}
 
-	sc.steps = append(sc.steps, step)
-	sc.Unlock()
+// DeleteSteps removes the steps from stepId on so the instance can be rerun from that step
+func (s *StepStore) DeleteSteps(flowId string, stepId string) error {
+	intStepId, err := strconv.Atoi(stepId)
+	if err != nil {
+		return fmt.Errorf("Error while converting stepid to Int: %s", err.Error())
+	}
+	items, err := s.query(&dynamodb.QueryInput{
+		KeyConditionExpression:    aws.String("PK = :pk AND SK BETWEEN :from AND :to"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{":pk": str(instanceKey(flowId)), ":from": str(stepKey(intStepId)), ":to": str(stepPrefix + "~")},
+		ProjectionExpression:      aws.String("PK, SK"),
+		ConsistentRead:            aws.Bool(true),
+	})
+	if err == nil {
+		err = s.deleteItems(items)
+	}
+	if err != nil {
+		return fmt.Errorf("Could not delete the steps of flow instance [%s], %s", flowId, err.Error())
+	}
+	return nil
 }
 
-func (sc *stepContainer) Steps() []*state.Step {
-	sc.RLock()
-	steps := sc.steps
-	sc.RUnlock()
-	return steps
+func getJSON(item map[string]types.AttributeValue, name string) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	if data := getString(item, name); data != "" {
+		if err := json.Unmarshal([]byte(data), &values); err != nil {
+			return nil, fmt.Errorf("Could not read the %s of flow instance [%s], %s", name, getString(item, "flowInstanceId"), err.Error())
+		}
+	}
+	return values, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,20 @@
+package file_test
+
+import (
+	"testing"
+
+	"github.com/project-flogo/services/flow-state/store"
+	"github.com/project-flogo/services/flow-state/store/file"
+	"github.com/project-flogo/services/flow-state/store/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		s, err := file.NewStore(map[string]interface{}{"path": t.TempDir(), "compactAfter": 4})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { _ = s.Close() })
+		return s
+	})
+}
@@ -0,0 +1,576 @@
+// Package file stores the flow state in a directory, one directory per app and flow instance
+//
+//	<path>/index.log                            listing index, one JSON record per change
+//	<path>/appstate.json                        persistence enabled per user and app
+//	<path>/instances/<app>/<flowId>/meta.json   flow instance state, inputs and outputs
+//	<path>/instances/<app>/<flowId>/steps.log   append-only step log
+//	<path>/instances/<app>/<flowId>/steps.json  steps compacted from the step log
+//	<path>/instances/<app>/<flowId>/snapshot.json
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/project-flogo/core/data/metadata"
+	"github.com/project-flogo/core/support/log"
+	"github.com/project-flogo/flow/state"
+)
+
+var logCache = log.ChildLogger(log.RootLogger(), "flow-state.file")
+
+const (
+	DefaultCompactAfter = 100
+
+	indexFile    = "index.log"
+	appStateFile = "appstate.json"
+	instancesDir = "instances"
+	metaFile     = "meta.json"
+	stepLogFile  = "steps.log"
+	stepsFile    = "steps.json"
+	snapshotFile = "snapshot.json"
+
+	// unknownApp holds the instances whose steps were saved before their start was recorded
+	unknownApp = "_"
+)
+
+type storeSettings struct {
+	Path string `md:"path"`
+	// CompactAfter is the number of step log records after which the steps are compacted
+	CompactAfter int `md:"compactAfter"`
+	// Sync flushes every write to disk before returning
+	Sync bool `md:"sync"`
+}
+
+// StoreStatus is the file store health
+type StoreStatus struct {
+	Status    bool   `json:"status"`
+	Path      string `json:"path"`
+	Instances int    `json:"instances"`
+	Message   string `json:"message,omitempty"`
+}
+
+// entry is what the index keeps about a flow instance to answer the listing queries
+type entry struct {
+	Id                 string    `json:"id"`
+	Dir                string    `json:"dir"`
+	Started            bool      `json:"started,omitempty"`
+	Username           string    `json:"username,omitempty"`
+	AppName            string    `json:"appName,omitempty"`
+	AppVersion         string    `json:"appVersion,omitempty"`
+	HostId             string    `json:"hostId,omitempty"`
+	FlowName           string    `json:"flowName,omitempty"`
+	Status             string    `json:"status,omitempty"`
+	StartTime          time.Time `json:"startTime,omitempty"`
+	EndTime            time.Time `json:"endTime,omitempty"`
+	ExecutionTime      string    `json:"executionTime,omitempty"`
+	OriginalInstanceId string    `json:"originalInstanceId,omitempty"`
+	RerunCount         int       `json:"rerunCount,omitempty"`
+	HasSteps           bool      `json:"hasSteps,omitempty"`
+	StepStatus         int       `json:"stepStatus,omitempty"`
+	FlowURI            string    `json:"flowURI,omitempty"`
+	Updated            time.Time `json:"updated"`
+
+	// logRecords is the number of records in the step log, -1 until it is counted
+	logRecords int
+}
+
+// instanceMeta is the content of meta.json, the index entry with the flow inputs and outputs
+type instanceMeta struct {
+	*entry
+	Inputs  map[string]interface{} `json:"inputs,omitempty"`
+	Outputs map[string]interface{} `json:"outputs,omitempty"`
+}
+
+// indexRecord is a line of the index log, either an entry replacing the previous one or a deletion
+type indexRecord struct {
+	Entry  *entry `json:"entry,omitempty"`
+	Delete string `json:"delete,omitempty"`
+}
+
+// logRecord is a line of the step log, either a step replacing the step with the same id or a truncation
+type logRecord struct {
+	Step     *state.Step `json:"step,omitempty"`
+	Truncate *int        `json:"truncate,omitempty"`
+}
+
+func NewStore(settings map[string]interface{}) (*StepStore, error) {
+	s := &storeSettings{}
+	if err := metadata.MapToStruct(settings, s, false); err != nil {
+		return nil, err
+	}
+	if s.Path == "" {
+		return nil, fmt.Errorf("Required Parameter Path is missing")
+	}
+	if s.CompactAfter <= 0 {
+		s.CompactAfter = DefaultCompactAfter
+	}
+	if err := os.MkdirAll(filepath.Join(s.Path, instancesDir), 0o755); err != nil {
+		return nil, fmt.Errorf("Could not create the state directory [%s], %s", s.Path, err.Error())
+	}
+
+	store := &StepStore{
+		path:         s.Path,
+		compactAfter: s.CompactAfter,
+		sync:         s.Sync,
+		entries:      make(map[string]*entry),
+		appStates:    make(map[string]map[string]bool),
+	}
+	if err := store.open(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// StepStore is a store.Store keeping the flow state in files, the index is held in memory and
+// the steps, inputs, outputs and snapshots are read from disk
+type StepStore struct {
+	mu           sync.RWMutex
+	path         string
+	compactAfter int
+	sync         bool
+
+	entries      map[string]*entry
+	appStates    map[string]map[string]bool
+	index        *os.File
+	indexRecords int
+}
+
+// open loads the app states and the index, the index is rebuilt from the meta files when it can not be read
+// and rewritten when it holds more records than instances
+func (s *StepStore) open() error {
+	if b, err := os.ReadFile(filepath.Join(s.path, appStateFile)); err == nil {
+		if err := json.Unmarshal(b, &s.appStates); err != nil {
+			return fmt.Errorf("Could not read the app states, %s", err.Error())
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("Could not read the app states, %s", err.Error())
+	}
+
+	records, err := s.loadIndex()
+	if err != nil {
+		logCache.Warnf("Rebuilding the index of [%s], %s", s.path, err.Error())
+		if err = s.rebuildIndex(); err != nil {
+			return err
+		}
+		records = -1
+	}
+	if records != len(s.entries) {
+		return s.compactIndex()
+	}
+	s.indexRecords = records
+	s.index, err = os.OpenFile(filepath.Join(s.path, indexFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("Could not open the index, %s", err.Error())
+	}
+	return nil
+}
+
+// Close closes the index, the store can not be used afterwards
+func (s *StepStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.index == nil {
+		return nil
+	}
+	err := s.index.Close()
+	s.index = nil
+	return err
+}
+
+func (s *StepStore) loadIndex() (int, error) {
+	f, err := os.Open(filepath.Join(s.path, indexFile))
+	if os.IsNotExist(err) {
+		// a new directory, or one whose index was removed
+		return 0, s.rebuildIndex()
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	records := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		record := &indexRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			return 0, fmt.Errorf("invalid index record %d, %s", records+1, err.Error())
+		}
+		records++
+		if record.Entry != nil {
+			record.Entry.logRecords = -1
+			s.entries[record.Entry.Id] = record.Entry
+		} else {
+			delete(s.entries, record.Delete)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// rebuildIndex reads the meta file of every instance directory
+func (s *StepStore) rebuildIndex() error {
+	s.entries = make(map[string]*entry)
+	apps, err := os.ReadDir(filepath.Join(s.path, instancesDir))
+	if err != nil {
+		return fmt.Errorf("Could not read the instances of [%s], %s", s.path, err.Error())
+	}
+	for _, app := range apps {
+		if !app.IsDir() {
+			continue
+		}
+		dirs, err := os.ReadDir(filepath.Join(s.path, instancesDir, app.Name()))
+		if err != nil {
+			return fmt.Errorf("Could not read the instances of [%s], %s", s.path, err.Error())
+		}
+		for _, dir := range dirs {
+			rel := filepath.Join(app.Name(), dir.Name())
+			meta, err := s.readMeta(rel)
+			if err != nil || meta.entry == nil {
+				logCache.Warnf("Skipping the instance directory [%s], its meta file can not be read", rel)
+				continue
+			}
+			meta.Dir = rel
+			meta.logRecords = -1
+			s.entries[meta.Id] = meta.entry
+		}
+	}
+	return nil
+}
+
+// compactIndex rewrites the index with one record per instance
+func (s *StepStore) compactIndex() error {
+	ids := make([]string, 0, len(s.entries))
+	for id := range s.entries {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var buf bytes.Buffer
+	for _, id := range ids {
+		b, err := json.Marshal(&indexRecord{Entry: s.entries[id]})
+		if err != nil {
+			return err
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+
+	if s.index != nil {
+		_ = s.index.Close()
+		s.index = nil
+	}
+	if err := s.writeFile(indexFile, buf.Bytes()); err != nil {
+		return fmt.Errorf("Could not write the index, %s", err.Error())
+	}
+	f, err := os.OpenFile(filepath.Join(s.path, indexFile), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("Could not open the index, %s", err.Error())
+	}
+	s.index = f
+	s.indexRecords = len(ids)
+	return nil
+}
+
+// appendIndex records the change of an instance, the index is compacted once most of its records are outdated
+func (s *StepStore) appendIndex(record *indexRecord) error {
+	if s.index == nil {
+		return fmt.Errorf("the file store is closed")
+	}
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err = s.appendLine(s.index, b); err != nil {
+		return fmt.Errorf("Could not write the index, %s", err.Error())
+	}
+	s.indexRecords++
+	if s.indexRecords > 2*len(s.entries)+s.compactAfter {
+		return s.compactIndex()
+	}
+	return nil
+}
+
+// save writes the meta file of the instance and records it in the index
+func (s *StepStore) save(e *entry, inputs, outputs map[string]interface{}) error {
+	e.Updated = time.Now().UTC()
+	b, err := json.Marshal(&instanceMeta{entry: e, Inputs: inputs, Outputs: outputs})
+	if err != nil {
+		return err
+	}
+	if err = s.writeFile(filepath.Join(instancesDir, e.Dir, metaFile), b); err != nil {
+		return fmt.Errorf("Could not save the state of flow instance [%s], %s", e.Id, err.Error())
+	}
+	s.entries[e.Id] = e
+	return s.appendIndex(&indexRecord{Entry: e})
+}
+
+func (s *StepStore) readMeta(dir string) (*instanceMeta, error) {
+	b, err := os.ReadFile(filepath.Join(s.path, instancesDir, dir, metaFile))
+	if err != nil {
+		return nil, err
+	}
+	meta := &instanceMeta{entry: &entry{}}
+	if err = json.Unmarshal(b, meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+// dirName escapes a flow instance id or app name to a single path element, a leading dot is escaped as
+// well so that "." and ".." do not name the instances directory or its parent
+func dirName(name string) string {
+	name = url.PathEscape(name)
+	if strings.HasPrefix(name, ".") {
+		name = "%2E" + name[1:]
+	}
+	return name
+}
+
+// instancePath returns the path of an instance directory, it fails when dir is not an app/instance
+// directory below the instances directory
+func (s *StepStore) instancePath(dir string) (string, error) {
+	root := filepath.Join(s.path, instancesDir)
+	path := filepath.Join(root, dir)
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel != dir || len(strings.Split(rel, string(filepath.Separator))) != 2 || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("invalid flow instance directory [%s]", dir)
+	}
+	return path, nil
+}
+
+// instance returns the index entry of the flow instance, creating its directory when it is not known yet
+func (s *StepStore) instance(flowId, appName string) (*entry, error) {
+	if e, ok := s.entries[flowId]; ok {
+		return e, nil
+	}
+	if flowId == "" {
+		return nil, fmt.Errorf("flow instance id is required")
+	}
+	app := unknownApp
+	if appName != "" {
+		app = dirName(appName)
+	}
+	e := &entry{Id: flowId, Dir: filepath.Join(app, dirName(flowId))}
+	path, err := s.instancePath(e.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return nil, fmt.Errorf("Could not create the directory of flow instance [%s], %s", flowId, err.Error())
+	}
+	return e, nil
+}
+
+// moveToApp moves an instance created by its steps to the directory of the app it was started for
+func (s *StepStore) moveToApp(e *entry, appName string) error {
+	if appName == "" || filepath.Dir(e.Dir) != unknownApp {
+		return nil
+	}
+	dir := filepath.Join(dirName(appName), dirName(e.Id))
+	from, err := s.instancePath(e.Dir)
+	if err != nil {
+		return err
+	}
+	to, err := s.instancePath(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err != nil {
+		return fmt.Errorf("Could not move flow instance [%s] to its app, %s", e.Id, err.Error())
+	}
+	e.Dir = dir
+	return nil
+}
+
+// remove deletes the instance directory and records the deletion in the index
+func (s *StepStore) remove(flowId string) error {
+	e, ok := s.entries[flowId]
+	if !ok {
+		return nil
+	}
+	path, err := s.instancePath(e.Dir)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return fmt.Errorf("Could not delete flow instance [%s], %s", flowId, err.Error())
+	}
+	delete(s.entries, flowId)
+	return s.appendIndex(&indexRecord{Delete: flowId})
+}
+
+// readSteps loads the compacted steps and replays the step log over them, a torn last record is ignored
+func (s *StepStore) readSteps(e *entry) ([]*state.Step, int, error) {
+	var steps []*state.Step
+	dir := filepath.Join(s.path, instancesDir, e.Dir)
+	if b, err := os.ReadFile(filepath.Join(dir, stepsFile)); err == nil {
+		if err = json.Unmarshal(b, &steps); err != nil {
+			return nil, 0, fmt.Errorf("Could not read the steps of flow instance [%s], %s", e.Id, err.Error())
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, 0, err
+	}
+
+	f, err := os.Open(filepath.Join(dir, stepLogFile))
+	if os.IsNotExist(err) {
+		return steps, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	records := 0
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) > 0 {
+				logCache.Warnf("Ignoring the incomplete last step of flow instance [%s]", e.Id)
+			}
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		record := &logRecord{}
+		if err = json.Unmarshal(line, record); err != nil {
+			return nil, 0, fmt.Errorf("Could not read the step log of flow instance [%s], %s", e.Id, err.Error())
+		}
+		records++
+		if record.Step != nil {
+			steps = upsert(steps, record.Step)
+		} else if record.Truncate != nil {
+			steps = truncate(steps, *record.Truncate)
+		}
+	}
+	return steps, records, nil
+}
+
+// appendStepLog appends a record to the step log of the instance and compacts the log once it is long enough
+func (s *StepStore) appendStepLog(e *entry, record *logRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if e.logRecords < 0 {
+		if _, e.logRecords, err = s.readSteps(e); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(filepath.Join(s.path, instancesDir, e.Dir, stepLogFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	err = s.appendLine(f, b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	e.logRecords++
+	if e.logRecords >= s.compactAfter {
+		return s.compactSteps(e)
+	}
+	return nil
+}
+
+// compactSteps writes the current steps to steps.json and empties the step log, replaying
+// the log over its own compaction gives the same steps so a crash in between loses nothing
+func (s *StepStore) compactSteps(e *entry) error {
+	steps, records, err := s.readSteps(e)
+	if err != nil || records == 0 {
+		return err
+	}
+	if steps == nil {
+		steps = []*state.Step{}
+	}
+	b, err := json.Marshal(steps)
+	if err != nil {
+		return err
+	}
+	if err = s.writeFile(filepath.Join(instancesDir, e.Dir, stepsFile), b); err != nil {
+		return fmt.Errorf("Could not compact the steps of flow instance [%s], %s", e.Id, err.Error())
+	}
+	if err = os.Remove(filepath.Join(s.path, instancesDir, e.Dir, stepLogFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	e.logRecords = 0
+	return nil
+}
+
+func (s *StepStore) appendLine(f *os.File, b []byte) error {
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if s.sync {
+		return f.Sync()
+	}
+	return nil
+}
+
+// writeFile replaces the file relative to the store path by writing a temporary file and renaming it
+func (s *StepStore) writeFile(name string, b []byte) error {
+	path := filepath.Join(s.path, name)
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if err == nil && s.sync {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// upsert saves the step in step id order, replacing a step with the same id
+func upsert(steps []*state.Step, step *state.Step) []*state.Step {
+	i := sort.Search(len(steps), func(i int) bool { return steps[i].Id >= step.Id })
+	switch {
+	case i == len(steps):
+		return append(steps, step)
+	case steps[i].Id == step.Id:
+		steps[i] = step
+		return steps
+	default:
+		steps = append(steps, nil)
+		copy(steps[i+1:], steps[i:])
+		steps[i] = step
+		return steps
+	}
+}
+
+// truncate removes the steps from stepId on
+func truncate(steps []*state.Step, stepId int) []*state.Step {
+	i := sort.Search(len(steps), func(i int) bool { return steps[i].Id >= stepId })
+	return steps[:i]
+}
@@ -0,0 +1,218 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/project-flogo/flow/model"
+	"github.com/project-flogo/flow/state"
+	"github.com/project-flogo/flow/state/change"
+	"github.com/project-flogo/services/flow-state/store/metadata"
+)
+
+var app = metadata.Metadata{Username: "alice", AppName: "orders", AppVersion: "1.0"}
+
+func open(t *testing.T, path string) *StepStore {
+	t.Helper()
+	s, err := NewStore(map[string]interface{}{"path": path, "compactAfter": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func start(t *testing.T, s *StepStore, id string, started time.Time) {
+	t.Helper()
+	err := s.RecordStart(&state.FlowState{FlowInstanceId: id, UserId: "alice", AppName: "orders", AppVersion: "1.0", FlowName: "create",
+		HostId: "host", FlowStats: "Active", StartTime: started, FlowInputs: map[string]interface{}{"order": id}})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func saveStep(t *testing.T, s *StepStore, flowId string, id int, status model.FlowStatus) {
+	t.Helper()
+	err := s.SaveStep(&state.Step{Id: id, FlowId: flowId, FlowChanges: map[int]*change.Flow{
+		0: {FlowURI: "res://flow:create", Status: int(status), Tasks: map[string]*change.Task{"log": {Status: int(model.TaskStatusDone)}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	path := t.TempDir()
+	s := open(t, path)
+	now := time.Now().UTC()
+	start(t, s, "a", now.Add(-time.Minute))
+	start(t, s, "b", now)
+	for id := 1; id <= 5; id++ {
+		saveStep(t, s, "a", id, model.FlowStatusActive)
+	}
+	if err := s.DeleteSteps("a", "4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveSnapshot(&state.Snapshot{SnapshotBase: &state.SnapshotBase{Status: int(model.FlowStatusActive)}, Id: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveAppState(&metadata.Metadata{Username: "alice", AppName: "orders", PersistEnabled: true}); err != nil {
+		t.Fatal(err)
+	}
+	s.Delete("b")
+	_ = s.Close()
+
+	s = open(t, path)
+	steps, err := s.GetSteps("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(steps) != 3 || steps[2].Id != 3 {
+		t.Fatalf("expected steps 1 to 3 after reopening, got %d steps", len(steps))
+	}
+	info, err := s.GetFlow("a", &app)
+	if err != nil || info == nil || info.FlowInputs["order"] != "a" || info.Status != int(model.FlowStatusActive) {
+		t.Fatalf("expected the flow state after reopening, got %+v, %v", info, err)
+	}
+	if info, _ = s.GetFlow("b", &app); info != nil {
+		t.Fatal("expected the deleted instance to stay deleted")
+	}
+	if snapshot := s.GetSnapshot("a"); snapshot == nil || snapshot.Status != int(model.FlowStatusActive) {
+		t.Fatalf("expected the snapshot after reopening, got %+v", snapshot)
+	}
+	if enabled, _ := s.GetAppState(&app); enabled != "true" {
+		t.Fatalf("expected the app state after reopening, got %q", enabled)
+	}
+	if status := s.Status().(*StoreStatus); !status.Status || status.Instances != 1 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	s := open(t, t.TempDir())
+	start(t, s, "a", time.Now())
+	dir := filepath.Join(s.path, instancesDir, s.entries["a"].Dir)
+
+	saveStep(t, s, "a", 1, model.FlowStatusActive)
+	saveStep(t, s, "a", 2, model.FlowStatusActive)
+	if _, err := os.Stat(filepath.Join(dir, stepsFile)); !os.IsNotExist(err) {
+		t.Fatal("expected the steps to stay in the step log")
+	}
+	saveStep(t, s, "a", 3, model.FlowStatusActive)
+	if _, err := os.Stat(filepath.Join(dir, stepLogFile)); !os.IsNotExist(err) {
+		t.Fatal("expected the step log to be compacted")
+	}
+
+	saveStep(t, s, "a", 4, model.FlowStatusCompleted)
+	if err := s.RecordEnd(&state.FlowState{FlowInstanceId: "a", FlowStats: "Completed", EndTime: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, stepLogFile)); !os.IsNotExist(err) {
+		t.Fatal("expected the steps of an ended instance to be compacted")
+	}
+	if steps, _ := s.GetSteps("a"); len(steps) != 4 {
+		t.Fatalf("expected 4 steps, got %d", len(steps))
+	}
+	if status := s.GetStatus("a"); status != int(model.FlowStatusCompleted) {
+		t.Fatalf("expected the completed status, got %d", status)
+	}
+}
+
+func TestTornStepLog(t *testing.T) {
+	path := t.TempDir()
+	s := open(t, path)
+	start(t, s, "a", time.Now())
+	saveStep(t, s, "a", 1, model.FlowStatusActive)
+
+	// a crash while appending leaves an incomplete last record
+	f, err := os.OpenFile(filepath.Join(path, instancesDir, s.entries["a"].Dir, stepLogFile), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"step":{"id":2,`)
+	_ = f.Close()
+
+	if steps, err := s.GetSteps("a"); err != nil || len(steps) != 1 {
+		t.Fatalf("expected the complete step only, got %d steps, %v", len(steps), err)
+	}
+}
+
+func TestIndexRebuild(t *testing.T) {
+	path := t.TempDir()
+	s := open(t, path)
+	start(t, s, "a", time.Now())
+	saveStep(t, s, "a", 1, model.FlowStatusActive)
+	saveStep(t, s, "orphan", 1, model.FlowStatusActive)
+	_ = s.Close()
+
+	if err := os.WriteFile(filepath.Join(path, indexFile), []byte("{not json\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s = open(t, path)
+	record, err := s.GetFlowsWithRecordCount(&app)
+	if err != nil || record.Count != 1 || record.FlowData[0].Id != "a" {
+		t.Fatalf("expected the instance from its meta file, got %+v, %v", record, err)
+	}
+	if steps, _ := s.GetSteps("orphan"); len(steps) != 1 {
+		t.Fatal("expected the instance only known from its steps")
+	}
+}
+
+func TestStartAfterSteps(t *testing.T) {
+	s := open(t, t.TempDir())
+	saveStep(t, s, "a", 1, model.FlowStatusActive)
+	if dir := filepath.Dir(s.entries["a"].Dir); dir != unknownApp {
+		t.Fatalf("expected the instance in the unknown app directory, got %s", dir)
+	}
+	start(t, s, "a", time.Now())
+	if dir := filepath.Dir(s.entries["a"].Dir); dir != "orders" {
+		t.Fatalf("expected the instance in the app directory, got %s", dir)
+	}
+	if steps, _ := s.GetSteps("a"); len(steps) != 1 {
+		t.Fatal("expected the steps to move with the instance")
+	}
+}
+
+func TestDotNames(t *testing.T) {
+	path := t.TempDir()
+	s := open(t, path)
+	start(t, s, "a", time.Now())
+	saveStep(t, s, "a", 1, model.FlowStatusActive)
+	saveStep(t, s, "..", 1, model.FlowStatusActive)
+	saveStep(t, s, ".", 1, model.FlowStatusActive)
+	err := s.RecordStart(&state.FlowState{FlowInstanceId: "b", UserId: "alice", AppName: "..", AppVersion: "1.0", FlowName: "create", StartTime: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveStep(&state.Step{Id: 1, FlowId: ""}); err == nil {
+		t.Fatal("expected a step without flow instance id to be rejected")
+	}
+	for _, id := range []string{"..", ".", "b"} {
+		if dir := s.entries[id].Dir; strings.HasPrefix(filepath.Base(dir), ".") || strings.HasPrefix(dir, ".") {
+			t.Fatalf("expected the directory of %q to be escaped, got %s", id, dir)
+		}
+	}
+
+	s.Delete("..")
+	s.Delete(".")
+	s.Delete("b")
+	if _, err := os.Stat(filepath.Join(path, instancesDir, s.entries["a"].Dir)); err != nil {
+		t.Fatalf("expected the other instances to be kept, %v", err)
+	}
+	if steps, _ := s.GetSteps("a"); steps == nil {
+		t.Fatal("expected instance a to be kept")
+	}
+	if _, err := os.Stat(filepath.Join(path, indexFile)); err != nil {
+		t.Fatalf("expected the store files to be kept, %v", err)
+	}
+
+	s.entries["c"] = &entry{Id: "c", Dir: filepath.Join("..", "..")}
+	s.Delete("c")
+	if _, err := os.Stat(filepath.Join(path, indexFile)); err != nil {
+		t.Fatalf("expected a directory outside the instances not to be deleted, %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/project-flogo/services/flow-state/retention"
+)
+
+// RetentionInstances lists the indexed instances, instances only known from their steps are dated by their last change
+func (s *StepStore) RetentionInstances(filter *retention.Filter) ([]*retention.Instance, error) {
+	var instances []*retention.Instance
+
+	s.mu.RLock()
+	for id, e := range s.entries {
+		instance := &retention.Instance{FlowInstanceId: id, Time: e.Updated}
+		if e.Started {
+			instance.AppName, instance.FlowName, instance.Status, instance.Time = e.AppName, e.FlowName, e.Status, e.StartTime
+			if !e.EndTime.IsZero() {
+				instance.Time = e.EndTime
+			} else {
+				instance.Running = true
+			}
+		}
+		if filter.Matches(instance) {
+			instances = append(instances, instance)
+		}
+	}
+	s.mu.RUnlock()
+
+	return instances, nil
+}
+
+// PurgeInstances deletes the instance directories, each instance counts its steps, snapshot and state as rows
+func (s *StepStore) PurgeInstances(flowIds []string) (int64, error) {
+	var rows int64
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range flowIds {
+		e, ok := s.entries[id]
+		if !ok {
+			continue
+		}
+		if e.HasSteps {
+			if steps, _, err := s.readSteps(e); err == nil {
+				rows += int64(len(steps))
+			}
+		}
+		if e.Started {
+			rows++
+		}
+		if _, err := os.Stat(filepath.Join(s.path, instancesDir, e.Dir, snapshotFile)); err == nil {
+			rows++
+		}
+		if err := s.remove(id); err != nil {
+			return rows, err
+		}
+	}
+	return rows, nil
+}
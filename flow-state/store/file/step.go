@@ -0,0 +1,442 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/project-flogo/flow/state"
+	"github.com/project-flogo/services/flow-state/event"
+	"github.com/project-flogo/services/flow-state/store/metadata"
+	"github.com/project-flogo/services/flow-state/store/task"
+)
+
+func (s *StepStore) Status() interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	status := &StoreStatus{Status: s.index != nil, Path: s.path, Instances: len(s.entries)}
+	if s.index == nil {
+		status.Message = "Closed"
+	} else if _, err := os.Stat(filepath.Join(s.path, instancesDir)); err != nil {
+		status.Status = false
+		status.Message = err.Error()
+	}
+	return status
+}
+
+func (s *StepStore) MaxConcurrencyLimit() int {
+	return 20
+}
+
+func (s *StepStore) GetStatus(flowId string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if e, ok := s.entries[flowId]; ok && e.HasSteps {
+		return e.StepStatus
+	}
+	return -1
+}
+
+// GetFlow reads the inputs of a started instance from its meta file, the index alone answers an instance with only steps
+func (s *StepStore) GetFlow(flowid string, fmetadata *metadata.Metadata) (*state.FlowInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	e, ok := s.entries[flowid]
+	if !ok {
+		return nil, nil
+	}
+	if !e.Started {
+		if e.HasSteps {
+			return &state.FlowInfo{Id: flowid, Status: e.StepStatus, FlowURI: e.FlowURI}, nil
+		}
+		return nil, nil
+	}
+	if !metadata.OptionalMatch(e.Username, fmetadata.Username) || !metadata.OptionalMatch(e.AppName, fmetadata.AppName) ||
+		!metadata.OptionalMatch(e.AppVersion, fmetadata.AppVersion) || !metadata.OptionalMatch(e.HostId, fmetadata.HostId) {
+		return nil, nil
+	}
+
+	meta, err := s.readMeta(e.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the state of flow instance [%s], %s", flowid, err.Error())
+	}
+	info := &state.FlowInfo{Id: flowid, FlowName: e.FlowName, FlowStatus: e.Status, FlowURI: "res://flow:" + e.FlowName, FlowInputs: meta.Inputs}
+	if info.FlowInputs == nil {
+		info.FlowInputs = make(map[string]interface{})
+	}
+	if e.HasSteps {
+		info.Status = e.StepStatus
+	}
+	return info, nil
+}
+
+func (s *StepStore) GetFlows(mtdata *metadata.Metadata) ([]*state.FlowInfo, error) {
+	flows, err := s.listFlows(mtdata, mtdata.Status, false)
+	if err != nil {
+		return nil, err
+	}
+	return metadata.Page(flows, mtdata)
+}
+
+func (s *StepStore) GetFlowsWithRecordCount(mtdata *metadata.Metadata) (*metadata.FlowRecord, error) {
+	flows, err := s.listFlows(mtdata, mtdata.Status, true)
+	if err != nil {
+		return nil, err
+	}
+	count := len(flows)
+	flows, err = metadata.Page(flows, mtdata)
+	if err != nil {
+		return nil, err
+	}
+	return &metadata.FlowRecord{Count: int32(count), FlowData: flows}, nil
+}
+
+func (s *StepStore) GetFailedFlows(metadata *metadata.Metadata) ([]*state.FlowInfo, error) {
+	return s.listFlows(metadata, "Failed", false)
+}
+
+func (s *StepStore) GetCompletedFlows(metadata *metadata.Metadata) ([]*state.FlowInfo, error) {
+	return s.listFlows(metadata, "Completed", false)
+}
+
+// listFlows lists the started instances of the user, app and version from the index, the instances listing
+// also applies the instance id and time range filters and is ordered by start time, newest first
+func (s *StepStore) listFlows(mtdata *metadata.Metadata, status string, records bool) ([]*state.FlowInfo, error) {
+	var from, to time.Time
+	if records {
+		var err error
+		if from, to, err = metadata.TimeRange(mtdata); err != nil {
+			return nil, err
+		}
+	}
+
+	var matches []*entry
+	s.mu.RLock()
+	for id, e := range s.entries {
+		if !e.Started || e.Username != mtdata.Username || e.AppName != mtdata.AppName || e.AppVersion != mtdata.AppVersion ||
+			!metadata.OptionalMatch(e.HostId, mtdata.HostId) || !metadata.OptionalMatch(e.FlowName, mtdata.FlowName) || !metadata.OptionalMatch(e.Status, status) {
+			continue
+		}
+		if records {
+			if len(mtdata.FlowInstanceId) > 0 && id != mtdata.FlowInstanceId && e.OriginalInstanceId != mtdata.FlowInstanceId {
+				continue
+			}
+			if !metadata.InRange(e.StartTime, from, to) {
+				continue
+			}
+		}
+		matches = append(matches, e)
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if !matches[i].StartTime.Equal(matches[j].StartTime) {
+			return matches[i].StartTime.After(matches[j].StartTime)
+		}
+		return matches[i].Id < matches[j].Id
+	})
+
+	var flows []*state.FlowInfo
+	for _, e := range matches {
+		flows = append(flows, &state.FlowInfo{
+			Id:                 e.Id,
+			FlowName:           e.FlowName,
+			HostId:             e.HostId,
+			FlowStatus:         e.Status,
+			StartTime:          metadata.FormatTime(e.StartTime),
+			EndTime:            metadata.FormatTime(e.EndTime),
+			ExecutionTime:      e.ExecutionTime,
+			OriginalInstanceId: e.OriginalInstanceId,
+			RerunCount:         e.RerunCount,
+			FlowInputs:         make(map[string]interface{}),
+		})
+	}
+	s.mu.RUnlock()
+	return flows, nil
+}
+
+// SaveStep appends the step to the step log, the meta file and the index are only written
+// when the step changes the flow status or URI
+func (s *StepStore) SaveStep(step *state.Step) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, err := s.instance(step.FlowId, "")
+	if err != nil {
+		return err
+	}
+	if err = s.appendStepLog(e, &logRecord{Step: step}); err != nil {
+		return fmt.Errorf("Could not save step [%d] of flow instance [%s], %s", step.Id, step.FlowId, err.Error())
+	}
+
+	changed := !e.HasSteps
+	if flow, ok := step.FlowChanges[0]; ok && flow != nil && flow.SubflowId == 0 {
+		if flow.Status != -1 && flow.Status != e.StepStatus {
+			e.StepStatus, changed = flow.Status, true
+		}
+		if flow.FlowURI != "" && flow.FlowURI != e.FlowURI {
+			e.FlowURI, changed = flow.FlowURI, true
+		}
+	}
+	if changed {
+		e.HasSteps = true
+		if err = s.saveEntry(e); err != nil {
+			return err
+		}
+	}
+	event.PostStepEvent(step)
+	return nil
+}
+
+// saveEntry saves the entry keeping the inputs and outputs already in the meta file
+func (s *StepStore) saveEntry(e *entry) error {
+	var inputs, outputs map[string]interface{}
+	if meta, err := s.readMeta(e.Dir); err == nil {
+		inputs, outputs = meta.Inputs, meta.Outputs
+	}
+	return s.save(e, inputs, outputs)
+}
+
+func (s *StepStore) GetSteps(flowId string) ([]*state.Step, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.entries[flowId]
+	if !ok || !e.HasSteps {
+		return nil, nil
+	}
+	steps, _, err := s.readSteps(e)
+	return steps, err
+}
+
+func (s *StepStore) GetStepsAsTasks(flowId string) ([][]*task.Task, error) {
+	steps, err := s.GetSteps(flowId)
+	if err != nil || steps == nil {
+		return nil, err
+	}
+	return task.StepsAsTasks(steps)
+}
+
+func (s *StepStore) GetStepsStatus(flowId string) ([]map[string]string, error) {
+	steps, err := s.GetSteps(flowId)
+	if err != nil || steps == nil {
+		return nil, err
+	}
+	return task.StepsStatus(steps)
+}
+
+func (s *StepStore) GetStepdataForActivity(flowId, stepid, taskname string) ([]*task.Task, error) {
+	id, err := strconv.Atoi(stepid)
+	if err != nil {
+		return nil, fmt.Errorf("No step data found for matching input")
+	}
+	steps, err := s.GetSteps(flowId)
+	if err != nil {
+		return nil, err
+	}
+	return task.StepdataForActivity(steps, id, taskname)
+}
+
+func (s *StepStore) GetFlowNames(metadata *metadata.Metadata) ([]string, error) {
+	return s.distinct(metadata, func(e *entry) string { return e.FlowName }, true), nil
+}
+
+func (s *StepStore) GetAppVersions(metadata *metadata.Metadata) ([]string, error) {
+	return s.distinct(metadata, func(e *entry) string { return e.AppVersion }, false), nil
+}
+
+// distinct lists the sorted values of the started instances matching the user, app and optionally the version and host
+func (s *StepStore) distinct(mtdata *metadata.Metadata, value func(e *entry) string, byVersion bool) []string {
+	values := metadata.NewDistinct(mtdata, byVersion)
+	s.mu.RLock()
+	for _, e := range s.entries {
+		if e.Started {
+			values.Add(e.Username, e.AppName, e.AppVersion, e.HostId, value(e))
+		}
+	}
+	s.mu.RUnlock()
+	return values.Values()
+}
+
+func (s *StepStore) GetAppState(metadata *metadata.Metadata) (string, error) {
+	s.mu.RLock()
+	enabled, ok := s.appStates[metadata.Username][metadata.AppName]
+	s.mu.RUnlock()
+	if !ok {
+		return "", nil
+	}
+	return strconv.FormatBool(enabled), nil
+}
+
+func (s *StepStore) SaveAppState(metadata *metadata.Metadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.appStates[metadata.Username] == nil {
+		s.appStates[metadata.Username] = make(map[string]bool)
+	}
+	s.appStates[metadata.Username][metadata.AppName] = metadata.PersistEnabled
+	b, err := json.Marshal(s.appStates)
+	if err != nil {
+		return err
+	}
+	if err = s.writeFile(appStateFile, b); err != nil {
+		return fmt.Errorf("Could not save the app state, %s", err.Error())
+	}
+	return nil
+}
+
+func (s *StepStore) Delete(flowId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.remove(flowId); err != nil {
+		logCache.Errorf("%s", err.Error())
+	}
+}
+
+func (s *StepStore) SaveSnapshot(snapshot *state.Snapshot) error {
+	b, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, err := s.instance(snapshot.Id, "")
+	if err != nil {
+		return err
+	}
+	// replaces existing snapshot
+	if err = s.writeFile(filepath.Join(instancesDir, e.Dir, snapshotFile), b); err != nil {
+		return fmt.Errorf("Could not save the snapshot of flow instance [%s], %s", snapshot.Id, err.Error())
+	}
+	if _, known := s.entries[e.Id]; !known {
+		return s.save(e, nil, nil)
+	}
+	return nil
+}
+
+func (s *StepStore) GetSnapshot(flowId string) *state.Snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.entries[flowId]
+	if !ok {
+		return nil
+	}
+	b, err := os.ReadFile(filepath.Join(s.path, instancesDir, e.Dir, snapshotFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logCache.Errorf("Could not read the snapshot of flow instance [%s], %s", flowId, err.Error())
+		}
+		return nil
+	}
+	snapshot := &state.Snapshot{}
+	if err = json.Unmarshal(b, snapshot); err != nil {
+		logCache.Errorf("Could not read the snapshot of flow instance [%s], %s", flowId, err.Error())
+		return nil
+	}
+	return snapshot
+}
+
+func (s *StepStore) RecordStart(flowState *state.FlowState) error {
+	inputs := flowState.FlowInputs
+	if inputs == nil {
+		inputs = make(map[string]interface{})
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if original, ok := s.entries[flowState.OriginalInstanceId]; ok && original.Started {
+		original.RerunCount++
+		if err := s.saveEntry(original); err != nil {
+			return err
+		}
+	}
+
+	e, err := s.instance(flowState.FlowInstanceId, flowState.AppName)
+	if err != nil {
+		return err
+	}
+	if err = s.moveToApp(e, flowState.AppName); err != nil {
+		return err
+	}
+	e.Started = true
+	e.Username, e.AppName, e.AppVersion = flowState.UserId, flowState.AppName, flowState.AppVersion
+	e.HostId = flowState.HostId
+	e.FlowName = flowState.FlowName
+	e.Status = flowState.FlowStats
+	e.StartTime, e.EndTime = flowState.StartTime, flowState.EndTime
+	e.ExecutionTime = ""
+	e.OriginalInstanceId = flowState.OriginalInstanceId
+	e.RerunCount = flowState.RerunCount
+	if err = s.save(e, inputs, nil); err != nil {
+		return err
+	}
+	event.PostStartEvent(flowState)
+	return nil
+}
+
+func (s *StepStore) GetFlowOwner(flowId string) (*metadata.Owner, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if e, ok := s.entries[flowId]; ok && e.Started {
+		return &metadata.Owner{Username: e.Username, AppName: e.AppName, AppVersion: e.AppVersion}, nil
+	}
+	return nil, nil
+}
+
+// RecordEnd saves the end of the flow instance and compacts its steps, no more steps are expected
+func (s *StepStore) RecordEnd(flowState *state.FlowState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, err := s.instance(flowState.FlowInstanceId, "")
+	if err != nil {
+		return err
+	}
+	var inputs map[string]interface{}
+	if e.Started {
+		if meta, err := s.readMeta(e.Dir); err == nil {
+			inputs = meta.Inputs
+		}
+		if !e.StartTime.IsZero() {
+			elapsed := float64(flowState.EndTime.Sub(e.StartTime).Microseconds()) / 1000
+			e.ExecutionTime = strconv.FormatFloat(elapsed, 'f', -1, 64)
+		}
+	} else {
+		e.FlowName = flowState.FlowName
+	}
+	e.Status = flowState.FlowStats
+	e.EndTime = flowState.EndTime
+	if err = s.save(e, inputs, flowState.FlowOutputs); err != nil {
+		return err
+	}
+	if e.HasSteps {
+		if err = s.compactSteps(e); err != nil {
+			return err
+		}
+	}
+	event.PostEndEvent(flowState)
+	return nil
+}
+
+// DeleteSteps records the removal of the steps from stepId on so the instance can be rerun from that step
+func (s *StepStore) DeleteSteps(flowId string, stepId string) error {
+	intStepId, err := strconv.Atoi(stepId)
+	if err != nil {
+		return fmt.Errorf("Error while converting stepid to Int: %s", err.Error())
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[flowId]
+	if !ok || !e.HasSteps {
+		return nil
+	}
+	if err = s.appendStepLog(e, &logRecord{Truncate: &intStepId}); err != nil {
+		return fmt.Errorf("Could not delete the steps of flow instance [%s], %s", flowId, err.Error())
+	}
+	return nil
+}
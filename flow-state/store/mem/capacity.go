@@ -0,0 +1,197 @@
+package mem
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/project-flogo/core/data/metadata"
+)
+
+const (
+	// EvictLRU evicts the least recently used instance
+	EvictLRU = "lru"
+	// EvictCompletedFirst evicts the least recently used instance that ended, running instances are
+	// only evicted when no ended instance is left
+	EvictCompletedFirst = "completedFirst"
+)
+
+type storeSettings struct {
+	// MaxInstances is the number of flow instances kept, 0 keeps all of them
+	MaxInstances int `md:"maxInstances"`
+	// MaxBytes is the size of the steps and snapshots kept, 0 does not limit it
+	MaxBytes int64  `md:"maxBytes"`
+	Eviction string `md:"eviction"`
+}
+
+// StoreStatus is the memory store health, Bytes is only accounted when MaxBytes is set
+type StoreStatus struct {
+	Status       bool   `json:"status"`
+	Instances    int    `json:"instances"`
+	Bytes        int64  `json:"bytes"`
+	MaxInstances int    `json:"maxInstances,omitempty"`
+	MaxBytes     int64  `json:"maxBytes,omitempty"`
+	Eviction     string `json:"eviction"`
+	Evictions    int64  `json:"evictions"`
+	EvictedBytes int64  `json:"evictedBytes"`
+}
+
+// usage is the size and recency of a flow instance
+type usage struct {
+	flowId        string
+	stepBytes     int64
+	snapshotBytes int64
+	done          bool
+}
+
+func (u *usage) bytes() int64 {
+	return u.stepBytes + u.snapshotBytes
+}
+
+// capacity tracks the flow instances in least recently used order and picks the ones to evict
+type capacity struct {
+	mu           sync.Mutex
+	maxInstances int
+	maxBytes     int64
+	eviction     string
+
+	order   *list.List
+	entries map[string]*list.Element
+	bytes   int64
+
+	evictions    int64
+	evictedBytes int64
+}
+
+func newCapacity(settings map[string]interface{}) (*capacity, error) {
+	s := &storeSettings{}
+	if err := metadata.MapToStruct(settings, s, false); err != nil {
+		return nil, err
+	}
+	if s.MaxInstances < 0 || s.MaxBytes < 0 {
+		return nil, fmt.Errorf("maxInstances and maxBytes can not be negative")
+	}
+	switch s.Eviction {
+	case "":
+		s.Eviction = EvictLRU
+	case EvictLRU, EvictCompletedFirst:
+	default:
+		return nil, fmt.Errorf("unsupported eviction [%s], expected %s or %s", s.Eviction, EvictLRU, EvictCompletedFirst)
+	}
+	return &capacity{
+		maxInstances: s.MaxInstances,
+		maxBytes:     s.MaxBytes,
+		eviction:     s.Eviction,
+		order:        list.New(),
+		entries:      make(map[string]*list.Element),
+	}, nil
+}
+
+// sizeOf is the size accounted for a step or snapshot, nothing is accounted when the size is not limited
+func (c *capacity) sizeOf(v interface{}) int64 {
+	if c.maxBytes == 0 {
+		return 0
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return 0
+	}
+	return int64(len(b))
+}
+
+// use marks the instance as the most recently used one after applying update,
+// and returns the instances to evict to get back under the limits
+func (c *capacity) use(flowId string, update func(u *usage)) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[flowId]
+	if !ok {
+		e = c.order.PushFront(&usage{flowId: flowId})
+		c.entries[flowId] = e
+	} else {
+		c.order.MoveToFront(e)
+	}
+
+	u := e.Value.(*usage)
+	before := u.bytes()
+	if update != nil {
+		update(u)
+	}
+	c.bytes += u.bytes() - before
+
+	var evicted []string
+	for c.exceeded() {
+		victim := c.victim(e)
+		if victim == nil {
+			// the instance in use is kept even when it alone exceeds the limits
+			break
+		}
+		v := c.remove(victim)
+		c.evictions++
+		c.evictedBytes += v.bytes()
+		evicted = append(evicted, v.flowId)
+	}
+	return evicted
+}
+
+// touch marks a known instance as the most recently used one
+func (c *capacity) touch(flowId string) {
+	c.mu.Lock()
+	if e, ok := c.entries[flowId]; ok {
+		c.order.MoveToFront(e)
+	}
+	c.mu.Unlock()
+}
+
+// forget stops tracking an instance deleted from the store
+func (c *capacity) forget(flowId string) {
+	c.mu.Lock()
+	if e, ok := c.entries[flowId]; ok {
+		c.remove(e)
+	}
+	c.mu.Unlock()
+}
+
+func (c *capacity) status() *StoreStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &StoreStatus{
+		Status:       true,
+		Instances:    c.order.Len(),
+		Bytes:        c.bytes,
+		MaxInstances: c.maxInstances,
+		MaxBytes:     c.maxBytes,
+		Eviction:     c.eviction,
+		Evictions:    c.evictions,
+		EvictedBytes: c.evictedBytes,
+	}
+}
+
+func (c *capacity) exceeded() bool {
+	return (c.maxInstances > 0 && c.order.Len() > c.maxInstances) || (c.maxBytes > 0 && c.bytes > c.maxBytes)
+}
+
+func (c *capacity) victim(inUse *list.Element) *list.Element {
+	if c.eviction == EvictCompletedFirst {
+		for e := c.order.Back(); e != nil; e = e.Prev() {
+			if e != inUse && e.Value.(*usage).done {
+				return e
+			}
+		}
+	}
+	for e := c.order.Back(); e != nil; e = e.Prev() {
+		if e != inUse {
+			return e
+		}
+	}
+	return nil
+}
+
+func (c *capacity) remove(e *list.Element) *usage {
+	u := c.order.Remove(e).(*usage)
+	delete(c.entries, u.flowId)
+	c.bytes -= u.bytes()
+	return u
+}
@@ -0,0 +1,66 @@
+package mem
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/project-flogo/flow/model"
+	"github.com/project-flogo/flow/state"
+	"github.com/project-flogo/flow/state/change"
+)
+
+func step(flowId string, id int, status model.FlowStatus, data string) *state.Step {
+	return &state.Step{Id: id, FlowId: flowId, FlowChanges: map[int]*change.Flow{
+		0: {Status: int(status), Attrs: map[string]interface{}{"data": data}},
+	}}
+}
+
+func TestLRUEviction(t *testing.T) {
+	s, err := NewBoundedStore(map[string]interface{}{"maxInstances": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = s.SaveStep(step("a", 1, model.FlowStatusActive, ""))
+	_ = s.SaveStep(step("b", 1, model.FlowStatusActive, ""))
+	// reading a makes b the least recently used instance
+	_, _ = s.GetSteps("a")
+	_ = s.SaveStep(step("c", 1, model.FlowStatusActive, ""))
+
+	if s.GetStatus("b") != -1 || s.GetStatus("a") == -1 || s.GetStatus("c") == -1 {
+		t.Fatal("expected b to be evicted")
+	}
+	status := s.Status().(*StoreStatus)
+	if status.Instances != 2 || status.Evictions != 1 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+}
+
+func TestCompletedFirstEviction(t *testing.T) {
+	s, err := NewBoundedStore(map[string]interface{}{"maxBytes": 1000, "eviction": EvictCompletedFirst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := strings.Repeat("x", 300)
+	_ = s.SaveStep(step("running", 1, model.FlowStatusActive, data))
+	_ = s.SaveStep(step("done", 1, model.FlowStatusCompleted, data))
+	_ = s.SaveStep(step("new", 1, model.FlowStatusActive, data))
+
+	if s.GetStatus("done") != -1 || s.GetStatus("running") == -1 {
+		t.Fatal("expected the completed instance to be evicted before the older running one")
+	}
+	status := s.Status().(*StoreStatus)
+	if status.Bytes > 1000 || status.EvictedBytes == 0 {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	s.Delete("running")
+	if status = s.Status().(*StoreStatus); status.Instances != 1 {
+		t.Fatalf("expected deleted instances to be released, got %+v", status)
+	}
+}
+
+func TestInvalidSettings(t *testing.T) {
+	if _, err := NewBoundedStore(map[string]interface{}{"eviction": "fifo"}); err == nil {
+		t.Fatal("expected an error for an unsupported eviction")
+	}
+}
@@ -0,0 +1,15 @@
+package mem_test
+
+import (
+	"testing"
+
+	"github.com/project-flogo/services/flow-state/store"
+	"github.com/project-flogo/services/flow-state/store/mem"
+	"github.com/project-flogo/services/flow-state/store/storetest"
+)
+
+func TestConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return mem.NewStore()
+	})
+}
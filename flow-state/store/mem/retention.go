@@ -0,0 +1,64 @@
+package mem
+
+import (
+	"github.com/project-flogo/services/flow-state/retention"
+)
+
+// RetentionInstances lists the recorded instances, instances only known from their steps are dated by their last step
+func (s *StepStore) RetentionInstances(filter *retention.Filter) ([]*retention.Instance, error) {
+	var instances []*retention.Instance
+
+	s.RLock()
+	for id, fi := range s.instances {
+		instance := &retention.Instance{FlowInstanceId: id, AppName: fi.owner.AppName, FlowName: fi.flowName, Status: fi.status, Time: fi.startTime}
+		if !fi.endTime.IsZero() {
+			instance.Time = fi.endTime
+		} else {
+			instance.Running = true
+		}
+		if filter.Matches(instance) {
+			instances = append(instances, instance)
+		}
+	}
+	for id, sc := range s.stepContainers {
+		if _, started := s.instances[id]; started {
+			continue
+		}
+		sc.RLock()
+		instance := &retention.Instance{FlowInstanceId: id, Time: sc.updated}
+		sc.RUnlock()
+		if filter.Matches(instance) {
+			instances = append(instances, instance)
+		}
+	}
+	s.RUnlock()
+
+	return instances, nil
+}
+
+// PurgeInstances deletes the instances, each instance counts its steps, snapshot and state as rows
+func (s *StepStore) PurgeInstances(flowIds []string) (int64, error) {
+	var rows int64
+	for _, id := range flowIds {
+		s.capacity.forget(id)
+	}
+	s.Lock()
+	for _, id := range flowIds {
+		if sc, ok := s.stepContainers[id]; ok {
+			rows += int64(len(sc.Steps()))
+			delete(s.stepContainers, id)
+		}
+		if _, ok := s.instances[id]; ok {
+			rows++
+			delete(s.instances, id)
+		}
+	}
+	s.Unlock()
+
+	for _, id := range flowIds {
+		if _, ok := s.snapshots.LoadAndDelete(id); ok {
+			rows++
+		}
+	}
+	return rows, nil
+}
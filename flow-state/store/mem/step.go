@@ -1,8 +1,14 @@
 package mem
 
 import (
+	"container/list"
+	"fmt"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 
+	"github.com/project-flogo/flow/model"
 	"github.com/project-flogo/flow/state"
 	"github.com/project-flogo/services/flow-state/event"
 	"github.com/project-flogo/services/flow-state/store/metadata"
@@ -14,7 +20,25 @@ import (
 //}
 
 func NewStore() *StepStore {
-	return &StepStore{stepContainers: make(map[string]*stepContainer)}
+	s, _ := NewBoundedStore(nil)
+	return s
+}
+
+// NewBoundedStore creates a memory store that evicts flow instances once maxInstances or maxBytes is reached
+func NewBoundedStore(settings map[string]interface{}) (*StepStore, error) {
+	c := &capacity{eviction: EvictLRU, order: list.New(), entries: make(map[string]*list.Element)}
+	if len(settings) > 0 {
+		var err error
+		if c, err = newCapacity(settings); err != nil {
+			return nil, err
+		}
+	}
+	return &StepStore{
+		stepContainers: make(map[string]*stepContainer),
+		instances:      make(map[string]*flowInstance),
+		appStates:      make(map[string]bool),
+		capacity:       c,
+	}, nil
 }
 
 type StepStore struct {
@@ -23,11 +47,46 @@ type StepStore struct {
 	appId          string
 	stepContainers map[string]*stepContainer
 	snapshots      sync.Map
+	instances      map[string]*flowInstance
+	appStates      map[string]bool
+	capacity       *capacity
+}
+
+// flowInstance holds what RecordStart and RecordEnd report about an instance, it is replaced rather than updated
+type flowInstance struct {
+	owner              metadata.Owner
+	hostId             string
+	flowName           string
+	status             string
+	startTime          time.Time
+	endTime            time.Time
+	executionTime      string
+	inputs             map[string]interface{}
+	outputs            map[string]interface{}
+	originalInstanceId string
+	rerunCount         int
+}
+
+func (fi *flowInstance) info(id string) *state.FlowInfo {
+	info := &state.FlowInfo{
+		Id:                 id,
+		FlowName:           fi.flowName,
+		HostId:             fi.hostId,
+		FlowStatus:         fi.status,
+		StartTime:          metadata.FormatTime(fi.startTime),
+		EndTime:            metadata.FormatTime(fi.endTime),
+		ExecutionTime:      fi.executionTime,
+		OriginalInstanceId: fi.originalInstanceId,
+		RerunCount:         fi.rerunCount,
+	}
+	if fi.inputs != nil {
+		info.FlowInputs = make(map[string]interface{})
+	}
+	return info
 }
 
 func (s *StepStore) Status() interface{} {
-	//TODO
-	return true
+	return s.capacity.status()
 }
 
 func (s *StepStore) MaxConcurrencyLimit() int {
@@ -48,77 +107,112 @@ func (s *StepStore) GetStatus(flowId string) int {
 	return -1
 }
 
+// GetFlow answers a started instance from its recorded start, otherwise only from the status of its steps
 func (s *StepStore) GetFlow(flowid string, fmetadata *metadata.Metadata) (*state.FlowInfo, error) {
 
 	s.RLock()
+	fi, started := s.instances[flowid]
 	sc, ok := s.stepContainers[flowid]
 	s.RUnlock()
 
+	if started {
+		if !metadata.OptionalMatch(fi.owner.Username, fmetadata.Username) || !metadata.OptionalMatch(fi.owner.AppName, fmetadata.AppName) ||
+			!metadata.OptionalMatch(fi.owner.AppVersion, fmetadata.AppVersion) || !metadata.OptionalMatch(fi.hostId, fmetadata.HostId) {
+			return nil, nil
+		}
+		info := &state.FlowInfo{Id: flowid, FlowName: fi.flowName, FlowStatus: fi.status, FlowURI: "res://flow:" + fi.flowName, FlowInputs: fi.inputs}
+		if ok {
+			info.Status = sc.Status()
+		}
+		return info, nil
+	}
+
 	if ok {
-		return &state.FlowInfo{Id: flowid, Status: sc.Status(), FlowURI: sc.flowURI}, nil
+		return &state.FlowInfo{Id: flowid, Status: sc.Status(), FlowURI: sc.FlowURI()}, nil
 	}
 
 	return nil, nil
 }
 
-func (s *StepStore) GetFlows(metadata *metadata.Metadata) ([]*state.FlowInfo, error) {
-
-	var infos []*state.FlowInfo
-
-	s.RLock()
-	for id, value := range s.stepContainers {
-		infos = append(infos, &state.FlowInfo{Id: id, FlowURI: value.flowURI, Status: value.Status()})
+func (s *StepStore) GetFlows(mtdata *metadata.Metadata) ([]*state.FlowInfo, error) {
+	flows, err := s.listFlows(mtdata, mtdata.Status, false)
+	if err != nil {
+		return nil, err
 	}
-	s.RUnlock()
-
-	return infos, nil
+	return metadata.Page(flows, mtdata)
 }
 
-func (s *StepStore) GetFlowsWithRecordCount(metadata *metadata.Metadata) (*metadata.FlowRecord, error) {
-
-	var infos []*state.FlowInfo
-
-	s.RLock()
-	for id, value := range s.stepContainers {
-		infos = append(infos, &state.FlowInfo{Id: id, FlowURI: value.flowURI, Status: value.Status()})
+func (s *StepStore) GetFlowsWithRecordCount(mtdata *metadata.Metadata) (*metadata.FlowRecord, error) {
+	flows, err := s.listFlows(mtdata, mtdata.Status, true)
+	if err != nil {
+		return nil, err
 	}
-	s.RUnlock()
-
-	return nil, nil
+	count := len(flows)
+	flows, err = metadata.Page(flows, mtdata)
+	if err != nil {
+		return nil, err
+	}
+	return &metadata.FlowRecord{Count: int32(count), FlowData: flows}, nil
 }
 
 func (s *StepStore) GetFailedFlows(metadata *metadata.Metadata) ([]*state.FlowInfo, error) {
+	return s.listFlows(metadata, "Failed", false)
+}
 
-	var infos []*state.FlowInfo
+func (s *StepStore) GetCompletedFlows(metadata *metadata.Metadata) ([]*state.FlowInfo, error) {
+	return s.listFlows(metadata, "Completed", false)
+}
 
-	s.RLock()
-	for id, value := range s.stepContainers {
-		if value.Status() == 500 {
-			infos = append(infos, &state.FlowInfo{Id: id, FlowURI: value.flowURI, Status: value.Status()})
+// listFlows lists the started instances of the user, app and version, the instances listing also
+// applies the instance id and time range filters and is ordered by start time, newest first
+func (s *StepStore) listFlows(mtdata *metadata.Metadata, status string, records bool) ([]*state.FlowInfo, error) {
+	var from, to time.Time
+	if records {
+		var err error
+		if from, to, err = metadata.TimeRange(mtdata); err != nil {
+			return nil, err
 		}
 	}
-	s.RUnlock()
-
-	return infos, nil
-}
-
-func (s *StepStore) GetCompletedFlows(metadata *metadata.Metadata) ([]*state.FlowInfo, error) {
 
-	var infos []*state.FlowInfo
+	type match struct {
+		info  *state.FlowInfo
+		start time.Time
+	}
+	var matches []match
 
 	s.RLock()
-	for id, value := range s.stepContainers {
-		if value.Status() == 100 {
-			infos = append(infos, &state.FlowInfo{Id: id, FlowURI: value.flowURI, Status: value.Status()})
+	for id, fi := range s.instances {
+		if fi.owner.Username != mtdata.Username || fi.owner.AppName != mtdata.AppName || fi.owner.AppVersion != mtdata.AppVersion ||
+			!metadata.OptionalMatch(fi.hostId, mtdata.HostId) || !metadata.OptionalMatch(fi.flowName, mtdata.FlowName) || !metadata.OptionalMatch(fi.status, status) {
+			continue
+		}
+		if records {
+			if len(mtdata.FlowInstanceId) > 0 && id != mtdata.FlowInstanceId && fi.originalInstanceId != mtdata.FlowInstanceId {
+				continue
+			}
+			if !metadata.InRange(fi.startTime, from, to) {
+				continue
+			}
 		}
+		matches = append(matches, match{info: fi.info(id), start: fi.startTime})
 	}
 	s.RUnlock()
 
-	return infos, nil
+	sort.SliceStable(matches, func(i, j int) bool {
+		if !matches[i].start.Equal(matches[j].start) {
+			return matches[i].start.After(matches[j].start)
+		}
+		return matches[i].info.Id < matches[j].info.Id
+	})
+
+	var flows []*state.FlowInfo
+	for _, m := range matches {
+		flows = append(flows, m.info)
+	}
+	return flows, nil
 }
 
 func (s *StepStore) SaveStep(step *state.Step) error {
-	event.PostStepEvent(step)
 	s.RLock()
 	sc, ok := s.stepContainers[step.FlowId]
 	s.RUnlock()
@@ -133,7 +227,13 @@ func (s *StepStore) SaveStep(step *state.Step) error {
 		s.Unlock()
 	}
 
-	sc.AddStep(step)
+	done := sc.AddStep(step)
+	event.PostStepEvent(step)
+	size := s.capacity.sizeOf(step)
+	s.evict(s.capacity.use(step.FlowId, func(u *usage) {
+		u.stepBytes += size
+		u.done = u.done || done
+	}))
 
 	return nil
 }
@@ -143,6 +243,7 @@ func (s *StepStore) GetSteps(flowId string) ([]*state.Step, error) {
 	sc, ok := s.stepContainers[flowId]
 	s.RUnlock()
 	if ok {
+		s.capacity.touch(flowId)
 		return sc.Steps(), nil
 	}
 
@@ -150,43 +251,88 @@ func (s *StepStore) GetSteps(flowId string) ([]*state.Step, error) {
 }
 
 func (s *StepStore) GetStepsAsTasks(flowId string) ([][]*task.Task, error) {
-
-	return nil, nil
+	steps, err := s.GetSteps(flowId)
+	if err != nil || steps == nil {
+		return nil, err
+	}
+	return task.StepsAsTasks(steps)
 }
+
 func (s *StepStore) GetStepsStatus(flowId string) ([]map[string]string, error) {
-	s.RLock()
-	_, ok := s.stepContainers[flowId]
-	s.RUnlock()
-	if ok {
-		return nil, nil
+	steps, err := s.GetSteps(flowId)
+	if err != nil || steps == nil {
+		return nil, err
 	}
-
-	return nil, nil
+	return task.StepsStatus(steps)
 }
 
 func (s *StepStore) GetStepdataForActivity(flowId, stepid, taskname string) ([]*task.Task, error) {
-	return nil, nil
+	id, err := strconv.Atoi(stepid)
+	if err != nil {
+		return nil, fmt.Errorf("No step data found for matching input")
+	}
+	steps, err := s.GetSteps(flowId)
+	if err != nil {
+		return nil, err
+	}
+
+	return task.StepdataForActivity(steps, id, taskname)
 }
+
 func (s *StepStore) GetFlowNames(metadata *metadata.Metadata) ([]string, error) {
-	return nil, nil
+	return s.distinct(metadata, func(fi *flowInstance) string { return fi.flowName }, true), nil
 }
 
 func (s *StepStore) GetAppVersions(metadata *metadata.Metadata) ([]string, error) {
-	return nil, nil
+	return s.distinct(metadata, func(fi *flowInstance) string { return fi.owner.AppVersion }, false), nil
+}
+
+// distinct lists the sorted values of the instances matching the user, app and optionally the version and host
+func (s *StepStore) distinct(mtdata *metadata.Metadata, value func(fi *flowInstance) string, byVersion bool) []string {
+	values := metadata.NewDistinct(mtdata, byVersion)
+	s.RLock()
+	for _, fi := range s.instances {
+		values.Add(fi.owner.Username, fi.owner.AppName, fi.owner.AppVersion, fi.hostId, value(fi))
+	}
+	s.RUnlock()
+	return values.Values()
 }
 
 func (s *StepStore) GetAppState(metadata *metadata.Metadata) (string, error) {
-	return "", nil
+	s.RLock()
+	enabled, ok := s.appStates[metadata.Username+"\x00"+metadata.AppName]
+	s.RUnlock()
+	if !ok {
+		return "", nil
+	}
+	return strconv.FormatBool(enabled), nil
 }
 
 func (s *StepStore) SaveAppState(metadata *metadata.Metadata) error {
+	s.Lock()
+	s.appStates[metadata.Username+"\x00"+metadata.AppName] = metadata.PersistEnabled
+	s.Unlock()
 	return nil
 }
 
 func (s *StepStore) Delete(flowId string) {
+	s.capacity.forget(flowId)
+	s.remove(flowId)
+}
+
+// evict removes the instances evicted by the capacity
+func (s *StepStore) evict(flowIds []string) {
+	for _, flowId := range flowIds {
+		s.remove(flowId)
+	}
+}
+
+func (s *StepStore) remove(flowId string) {
 	s.Lock()
 	delete(s.stepContainers, flowId)
+	delete(s.instances, flowId)
 	s.Unlock()
+	s.snapshots.Delete(flowId)
 }
 
 type stepContainer struct {
@@ -194,6 +340,7 @@ type stepContainer struct {
 	status  int
 	flowURI string
 	steps   []*state.Step
+	updated time.Time
 }
 
 func (sc *stepContainer) Status() int {
@@ -204,7 +351,16 @@ func (sc *stepContainer) Status() int {
 	return status
 }
 
-func (sc *stepContainer) AddStep(step *state.Step) {
+func (sc *stepContainer) FlowURI() string {
+	sc.RLock()
+	flowURI := sc.flowURI
+	sc.RUnlock()
+
+	return flowURI
+}
+
+// AddStep saves the step in step id order, replacing a step with the same id, and reports whether the flow instance ended
+func (sc *stepContainer) AddStep(step *state.Step) bool {
 	sc.Lock()
 
 	if len(step.FlowChanges) > 0 {
@@ -218,13 +374,35 @@ func (sc *stepContainer) AddStep(step *state.Step) {
 		}
 	}
 
-	sc.steps = append(sc.steps, step)
+	i := sort.Search(len(sc.steps), func(i int) bool { return sc.steps[i].Id >= step.Id })
+	switch {
+	case i == len(sc.steps):
+		sc.steps = append(sc.steps, step)
+	case sc.steps[i].Id == step.Id:
+		sc.steps[i] = step
+	default:
+		sc.steps = append(sc.steps, nil)
+		copy(sc.steps[i+1:], sc.steps[i:])
+		sc.steps[i] = step
+	}
+	sc.updated = time.Now()
+	done := sc.status >= int(model.FlowStatusCompleted)
+	sc.Unlock()
+	return done
+}
+
+// Truncate removes the steps from stepId on, the steps slice handed out by Steps is not modified
+func (sc *stepContainer) Truncate(stepId int) {
+	sc.Lock()
+	i := sort.Search(len(sc.steps), func(i int) bool { return sc.steps[i].Id >= stepId })
+	sc.steps = sc.steps[:i:i]
 	sc.Unlock()
 }
 
 func (sc *stepContainer) Steps() []*state.Step {
 	sc.RLock()
-	steps := sc.steps
+	steps := make([]*state.Step, len(sc.steps))
+	copy(steps, sc.steps)
 	sc.RUnlock()
 	return steps
 }
@@ -232,24 +410,103 @@ func (sc *stepContainer) Steps() []*state.Step {
 func (s *StepStore) SaveSnapshot(snapshot *state.Snapshot) error {
 	//replaces existing snapshot
 	s.snapshots.Store(snapshot.Id, snapshot)
+	size := s.capacity.sizeOf(snapshot)
+	s.evict(s.capacity.use(snapshot.Id, func(u *usage) {
+		u.snapshotBytes = size
+	}))
 	return nil
 }
 
 func (s *StepStore) GetSnapshot(flowId string) *state.Snapshot {
 	if snapshot, ok := s.snapshots.Load(flowId); ok {
+		s.capacity.touch(flowId)
 		return snapshot.(*state.Snapshot)
 	}
 	return nil
 }
 
 func (s *StepStore) RecordStart(flowState *state.FlowState) error {
+	inputs := flowState.FlowInputs
+	if inputs == nil {
+		inputs = make(map[string]interface{})
+	}
+
+	s.Lock()
+	if original, ok := s.instances[flowState.OriginalInstanceId]; ok {
+		rerun := *original
+		rerun.rerunCount++
+		s.instances[flowState.OriginalInstanceId] = &rerun
+	}
+	s.instances[flowState.FlowInstanceId] = &flowInstance{
+		owner:              metadata.Owner{Username: flowState.UserId, AppName: flowState.AppName, AppVersion: flowState.AppVersion},
+		hostId:             flowState.HostId,
+		flowName:           flowState.FlowName,
+		status:             flowState.FlowStats,
+		startTime:          flowState.StartTime,
+		endTime:            flowState.EndTime,
+		inputs:             inputs,
+		originalInstanceId: flowState.OriginalInstanceId,
+		rerunCount:         flowState.RerunCount,
+	}
+	s.Unlock()
+	event.PostStartEvent(flowState)
+
+	s.evict(s.capacity.use(flowState.FlowInstanceId, nil))
 	return nil
 }
 
+func (s *StepStore) GetFlowOwner(flowId string) (*metadata.Owner, error) {
+	s.RLock()
+	fi, ok := s.instances[flowId]
+	s.RUnlock()
+	if ok {
+		owner := fi.owner
+		return &owner, nil
+	}
+	return nil, nil
+}
+
 func (s *StepStore) RecordEnd(flowState *state.FlowState) error {
+	s.Lock()
+	instance := &flowInstance{flowName: flowState.FlowName}
+	if started, ok := s.instances[flowState.FlowInstanceId]; ok {
+		*instance = *started
+		if !started.startTime.IsZero() {
+			elapsed := float64(flowState.EndTime.Sub(started.startTime).Microseconds()) / 1000
+			instance.executionTime = strconv.FormatFloat(elapsed, 'f', -1, 64)
+		}
+	}
+	instance.status = flowState.FlowStats
+	instance.endTime = flowState.EndTime
+	instance.outputs = flowState.FlowOutputs
+	s.instances[flowState.FlowInstanceId] = instance
+	s.Unlock()
+	event.PostEndEvent(flowState)
+
+	s.evict(s.capacity.use(flowState.FlowInstanceId, func(u *usage) {
+		u.done = true
+	}))
 	return nil
 }
 
+// DeleteSteps removes the steps from stepId on so the instance can be rerun from that step
 func (s *StepStore) DeleteSteps(flowId string, stepId string) error {
+	intStepId, err := strconv.Atoi(stepId)
+	if err != nil {
+		return fmt.Errorf("Error while converting stepid to Int: %s", err.Error())
+	}
+	s.RLock()
+	sc, ok := s.stepContainers[flowId]
+	s.RUnlock()
+	if ok {
+		sc.Truncate(intStepId)
+		var size int64
+		for _, step := range sc.Steps() {
+			size += s.capacity.sizeOf(step)
+		}
+		s.capacity.use(flowId, func(u *usage) {
+			u.stepBytes = size
+		})
+	}
 	return nil
 }
@@ -0,0 +1,104 @@
+package mem
+
+import (
+	"testing"
+	"time"
+
+	"github.com/project-flogo/flow/model"
+	"github.com/project-flogo/flow/state"
+	"github.com/project-flogo/flow/state/change"
+	"github.com/project-flogo/services/flow-state/store/metadata"
+)
+
+func start(s *StepStore, id, flow, original string, started time.Time) {
+	_ = s.RecordStart(&state.FlowState{FlowInstanceId: id, UserId: "alice", AppName: "orders", AppVersion: "1.0", FlowName: flow,
+		HostId: "host", FlowStats: "Active", StartTime: started, OriginalInstanceId: original})
+}
+
+func TestListFlows(t *testing.T) {
+	s := NewStore()
+	now := time.Now()
+	start(s, "a", "create", "", now.Add(-3*time.Hour))
+	start(s, "b", "create", "", now.Add(-2*time.Hour))
+	start(s, "c", "cancel", "", now.Add(-time.Hour))
+	start(s, "d", "create", "a", now)
+	_ = s.RecordEnd(&state.FlowState{FlowInstanceId: "b", FlowStats: "Failed", EndTime: now})
+
+	app := metadata.Metadata{Username: "alice", AppName: "orders", AppVersion: "1.0"}
+
+	record, err := s.GetFlowsWithRecordCount(&app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Count != 4 || record.FlowData[0].Id != "d" || record.FlowData[3].Id != "a" {
+		t.Fatalf("expected the instances newest first, got %+v", record)
+	}
+
+	filter := app
+	filter.FlowName, filter.Offset, filter.Limit = "create", "1", "1"
+	if record, _ = s.GetFlowsWithRecordCount(&filter); record.Count != 3 || len(record.FlowData) != 1 || record.FlowData[0].Id != "b" {
+		t.Fatalf("expected the second create instance, got %+v", record)
+	}
+
+	filter = app
+	filter.FlowInstanceId = "a"
+	if record, _ = s.GetFlowsWithRecordCount(&filter); record.Count != 2 || record.FlowData[1].RerunCount != 1 {
+		t.Fatalf("expected a and its rerun, got %+v", record)
+	}
+
+	filter = app
+	filter.Interval = "90 minutes"
+	if record, _ = s.GetFlowsWithRecordCount(&filter); record.Count != 2 {
+		t.Fatalf("expected 2 instances in the interval, got %d", record.Count)
+	}
+
+	if failed, _ := s.GetFailedFlows(&app); len(failed) != 1 || failed[0].Id != "b" || failed[0].ExecutionTime == "" {
+		t.Fatalf("unexpected failed flows %+v", failed)
+	}
+	if flows, _ := s.GetFlows(&metadata.Metadata{Username: "bob", AppName: "orders", AppVersion: "1.0"}); len(flows) != 0 {
+		t.Fatal("expected no flows for another user")
+	}
+	if names, _ := s.GetFlowNames(&app); len(names) != 2 || names[0] != "cancel" {
+		t.Fatalf("unexpected flow names %v", names)
+	}
+
+	_ = s.SaveAppState(&metadata.Metadata{Username: "alice", AppName: "orders", PersistEnabled: true})
+	if enabled, _ := s.GetAppState(&app); enabled != "true" {
+		t.Fatalf("expected the app state to be saved, got %q", enabled)
+	}
+}
+
+func TestStepsAndRerun(t *testing.T) {
+	s := NewStore()
+	for _, id := range []int{1, 3, 2, 2} {
+		_ = s.SaveStep(&state.Step{Id: id, FlowId: "a", FlowChanges: map[int]*change.Flow{
+			0: {FlowURI: "res://flow:create", TaskId: "log", Status: int(model.FlowStatusActive), Tasks: map[string]*change.Task{"log": {Status: 40}}},
+		}})
+	}
+
+	steps, _ := s.GetSteps("a")
+	if len(steps) != 3 || steps[0].Id != 1 || steps[2].Id != 3 {
+		t.Fatalf("expected the steps in id order, got %d steps", len(steps))
+	}
+	tasks, err := s.GetStepsAsTasks("a")
+	if err != nil || len(tasks) != 3 || tasks[0][0].Id != "log" {
+		t.Fatalf("unexpected tasks %v, %v", tasks, err)
+	}
+	status, _ := s.GetStepsStatus("a")
+	if len(status) != 3 || status[0]["taskName"] != "log" || status[0]["flowname"] != "create" {
+		t.Fatalf("unexpected steps status %v", status)
+	}
+	if data, err := s.GetStepdataForActivity("a", "2", "log"); err != nil || len(data) != 1 {
+		t.Fatalf("unexpected step data %v, %v", data, err)
+	}
+
+	if err := s.DeleteSteps("a", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if steps, _ = s.GetSteps("a"); len(steps) != 1 {
+		t.Fatalf("expected the steps from 2 on to be deleted, got %d steps", len(steps))
+	}
+	if _, err := s.GetStepdataForActivity("a", "3", ""); err == nil {
+		t.Fatal("expected no data for a deleted step")
+	}
+}
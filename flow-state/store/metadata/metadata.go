@@ -11,3 +11,8 @@ type FlowRecord struct {
 	Count    int32
 	FlowData []*state.FlowInfo
 }
+
+// Owner is the user and app a flow instance was recorded for
+type Owner struct {
+	Username, AppName, AppVersion string
+}
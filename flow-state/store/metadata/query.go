@@ -0,0 +1,122 @@
+package metadata
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/project-flogo/flow/state"
+)
+
+// OptionalMatch reports whether the value matches the filter, an empty filter matches every value
+func OptionalMatch(value, filter string) bool {
+	return len(filter) == 0 || value == filter
+}
+
+// FlowName is the name of the flow of a flow URI such as res://flow:name
+func FlowName(flowURI string) string {
+	if strings.Contains(flowURI, ":") {
+		return flowURI[strings.LastIndex(flowURI, ":")+1:]
+	}
+	return flowURI
+}
+
+// FormatTime formats the start and end times of the instances, a zero time is empty
+func FormatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.String()
+}
+
+// Page applies the offset and limit of the metadata, paging only applies when both are set
+func Page(flows []*state.FlowInfo, mtdata *Metadata) ([]*state.FlowInfo, error) {
+	if len(mtdata.Offset) == 0 || len(mtdata.Limit) == 0 {
+		return flows, nil
+	}
+	offset, err := strconv.Atoi(mtdata.Offset)
+	if err != nil || offset < 0 {
+		return nil, fmt.Errorf("invalid offset [%s]", mtdata.Offset)
+	}
+	limit, err := strconv.Atoi(mtdata.Limit)
+	if err != nil || limit < 0 {
+		return nil, fmt.Errorf("invalid limit [%s]", mtdata.Limit)
+	}
+	if offset >= len(flows) {
+		return nil, nil
+	}
+	if end := offset + limit; end < len(flows) {
+		return flows[offset:end], nil
+	}
+	return flows[offset:], nil
+}
+
+// TimeRange parses the interval and the start and end time filters of the metadata, the range starts at the later
+// of the interval and the start time, a zero from or to leaves that side of the range open
+func TimeRange(mtdata *Metadata) (from, to time.Time, err error) {
+	if len(mtdata.Interval) > 0 {
+		interval, err := ParseInterval(mtdata.Interval)
+		if err != nil {
+			return from, to, err
+		}
+		from = time.Now().Add(-interval)
+	}
+	if len(mtdata.StartTime) > 0 && len(mtdata.EndTime) > 0 {
+		start, err := ParseTime(mtdata.StartTime)
+		if err != nil {
+			return from, to, err
+		}
+		if to, err = ParseTime(mtdata.EndTime); err != nil {
+			return from, to, err
+		}
+		if start.After(from) {
+			from = start
+		}
+	}
+	return from, to, nil
+}
+
+// InRange reports whether the time is in the range returned by TimeRange
+func InRange(t, from, to time.Time) bool {
+	return !t.Before(from) && (to.IsZero() || !t.After(to))
+}
+
+// Distinct collects the distinct values of the instances matching the user and app of the metadata, and also
+// its version and host when byVersion is set
+type Distinct struct {
+	mtdata    *Metadata
+	byVersion bool
+	values    map[string]bool
+}
+
+func NewDistinct(mtdata *Metadata, byVersion bool) *Distinct {
+	return &Distinct{mtdata: mtdata, byVersion: byVersion, values: make(map[string]bool)}
+}
+
+// Add collects the value of an instance when the instance matches
+func (d *Distinct) Add(username, appName, appVersion, hostId, value string) {
+	if !OptionalMatch(username, d.mtdata.Username) || !OptionalMatch(appName, d.mtdata.AppName) {
+		return
+	}
+	if d.byVersion && (!OptionalMatch(appVersion, d.mtdata.AppVersion) || !OptionalMatch(hostId, d.mtdata.HostId)) {
+		return
+	}
+	d.values[value] = true
+}
+
+// Values lists the collected values, sorted
+func (d *Distinct) Values() []string {
+	return Sorted(d.values)
+}
+
+// Sorted lists the values of a set, sorted
+func Sorted(set map[string]bool) []string {
+	var values []string
+	for v := range set {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return values
+}
@@ -0,0 +1,54 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseTime reads the start and end times of the instances listing
+func ParseTime(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time [%s]", value)
+}
+
+// ParseInterval accepts postgres style intervals such as "2 days" or "30 minutes" as well as Go durations
+func ParseInterval(value string) (time.Duration, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return d, nil
+	}
+
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("invalid interval [%s]", value)
+	}
+	n, err := strconv.Atoi(fields[0])
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid interval [%s]", value)
+	}
+
+	var unit time.Duration
+	switch strings.TrimSuffix(strings.ToLower(fields[1]), "s") {
+	case "second", "sec":
+		unit = time.Second
+	case "minute", "min":
+		unit = time.Minute
+	case "hour":
+		unit = time.Hour
+	case "day":
+		unit = 24 * time.Hour
+	case "week":
+		unit = 7 * 24 * time.Hour
+	case "month":
+		unit = 30 * 24 * time.Hour
+	default:
+		return 0, errors.New("invalid interval unit [" + fields[1] + "]")
+	}
+	return time.Duration(n) * unit, nil
+}
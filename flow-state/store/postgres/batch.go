@@ -0,0 +1,74 @@
+package postgres
+
+import (
+	"errors"
+
+	"github.com/project-flogo/services/flow-state/event"
+	"github.com/project-flogo/services/flow-state/store/batch"
+)
+
+// SaveBatch applies the items in a single transaction, the whole batch is rolled back when an item fails.
+// The events of the items are posted once the transaction is committed.
+func (s *StatefulDB) SaveBatch(items []*batch.Item) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	txDB := &StatefulDB{db: s.db, dbDetails: s.dbDetails, tx: tx}
+
+	for i, item := range items {
+		switch item.Kind {
+		case batch.Start:
+			_, err = txDB.InsertFlowState(item.FlowState)
+		case batch.Step:
+			_, err = txDB.InsertSteps(item.Step)
+		case batch.End:
+			_, err = txDB.UpdateFlowState(item.FlowState)
+		case batch.Snapshot:
+			err = txDB.SaveSnapshot(item.Snapshot)
+		}
+		if err != nil {
+			_ = tx.Rollback()
+			return &batch.ItemError{Index: i, Err: err}
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	postEvents(items)
+	return nil
+}
+
+// postEvents posts the start, step and end events of the items, in the order of the batch
+func postEvents(items []*batch.Item) {
+	for _, item := range items {
+		switch item.Kind {
+		case batch.Start:
+			event.PostStartEvent(item.FlowState)
+		case batch.Step:
+			event.PostStepEvent(item.Step)
+		case batch.End:
+			event.PostEndEvent(item.FlowState)
+		}
+	}
+}
+
+func (s *StepStore) SaveBatch(items []*batch.Item) error {
+	if !s.db.dbDetails.Connected {
+		return errors.New("Database is not connected")
+	}
+
+	err := s.db.SaveBatch(items)
+	if err != nil && isConnectionError(err) {
+		if retryErr := s.RetryDBConnection(); retryErr != nil {
+			logCache.Errorf("Could not connect to database server error:, %s", retryErr.Error())
+			return retryErr
+		}
+		logCache.Debug("Retrying from SaveBatch after successful connection retry  ")
+		err = s.db.SaveBatch(items)
+	}
+	if err != nil {
+		logCache.Errorf("Could not save batch, %s", err.Error())
+	}
+	return err
+}
@@ -0,0 +1,43 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/project-flogo/flow/state"
+	"github.com/project-flogo/services/flow-state/event"
+	"github.com/project-flogo/services/flow-state/store/batch"
+)
+
+func TestPostEvents(t *testing.T) {
+	hub := event.Steps
+	defer func() { event.Steps = hub }()
+	event.Steps = event.NewHub(0, 0, 0)
+	event.StartStepListener()
+	subscriber := event.Steps.Subscribe(nil, event.Lag)
+	defer subscriber.Close()
+
+	flowState := &state.FlowState{FlowInstanceId: "a", AppName: "orders", FlowName: "create", FlowStats: "Active"}
+	postEvents([]*batch.Item{
+		{Kind: batch.Start, FlowState: flowState},
+		{Kind: batch.Step, Step: &state.Step{Id: 1, FlowId: "a"}},
+		{Kind: batch.Snapshot, Snapshot: &state.Snapshot{Id: "a"}},
+		{Kind: batch.Step, Step: &state.Step{Id: 2, FlowId: "a"}},
+		{Kind: batch.End, FlowState: &state.FlowState{FlowInstanceId: "a", FlowStats: "Completed"}},
+	})
+
+	for _, kind := range []event.Kind{event.KindStart, event.KindStep, event.KindStep, event.KindEnd} {
+		select {
+		case e := <-subscriber.Events():
+			if e.Kind != kind || e.FlowInstanceId != "a" || e.AppName != "orders" {
+				t.Fatalf("expected the %s event of a, got %+v", kind, e)
+			}
+		default:
+			t.Fatalf("expected the %s event of a", kind)
+		}
+	}
+	select {
+	case e := <-subscriber.Events():
+		t.Fatalf("unexpected %s event", e.Kind)
+	default:
+	}
+}
@@ -0,0 +1,66 @@
+package postgres_test
+
+import (
+	"database/sql"
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+	"github.com/project-flogo/services/flow-state/store"
+	"github.com/project-flogo/services/flow-state/store/postgres"
+	"github.com/project-flogo/services/flow-state/store/storetest"
+)
+
+// TestConformance runs against the database of FLOGO_STATE_TEST_POSTGRES_URL, or against an embedded
+// postgres started for the test when it is not set. The tables are emptied before every test.
+func TestConformance(t *testing.T) {
+	url := os.Getenv("FLOGO_STATE_TEST_POSTGRES_URL")
+	if url == "" {
+		if testing.Short() {
+			t.Skip("the embedded postgres is not started in short mode")
+		}
+		url = startPostgres(t)
+	}
+
+	storetest.Run(t, func(t *testing.T) store.Store {
+		s, err := postgres.NewStore(map[string]interface{}{"databaseUrl": url})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db, err := sql.Open("postgres", url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		if _, err = db.Exec("TRUNCATE flowstate, steps, appstate, snapshopt"); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+// startPostgres starts a postgres on a free port until the end of the test and returns its URL, the binaries
+// are downloaded to the embedded-postgres cache the first time
+func startPostgres(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := uint32(listener.Addr().(*net.TCPAddr).Port)
+	listener.Close()
+
+	dir := t.TempDir()
+	cfg := embeddedpostgres.DefaultConfig().Port(port).RuntimePath(dir).Logger(io.Discard)
+	db := embeddedpostgres.NewDatabase(cfg)
+	if err := db.Start(); err != nil {
+		t.Fatalf("Could not start the embedded postgres, set FLOGO_STATE_TEST_POSTGRES_URL to use another database, %s", err.Error())
+	}
+	t.Cleanup(func() {
+		if err := db.Stop(); err != nil {
+			t.Errorf("Could not stop the embedded postgres, %s", err.Error())
+		}
+	})
+	return cfg.GetConnectionURL() + "?sslmode=disable"
+}
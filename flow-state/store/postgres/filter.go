@@ -0,0 +1,169 @@
+package postgres
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/project-flogo/services/flow-state/store/metadata"
+)
+
+var intervalPattern = regexp.MustCompile(`^(\d+)\s*(second|minute|hour|day|week|month|year)s?$`)
+
+// Filter accumulates conditions of a WHERE clause, every value is bound as a $n parameter
+type Filter struct {
+	conditions []string
+	args       []interface{}
+}
+
+func NewFilter() *Filter {
+	return &Filter{}
+}
+
+// Where adds a condition, each '?' in cond is replaced by the placeholder of the matching arg
+func (f *Filter) Where(cond string, args ...interface{}) *Filter {
+	if strings.Count(cond, "?") != len(args) {
+		panic(fmt.Sprintf("filter condition [%s] expects %d args, got %d", cond, strings.Count(cond, "?"), len(args)))
+	}
+	for _, arg := range args {
+		f.args = append(f.args, arg)
+		cond = strings.Replace(cond, "?", "$"+strconv.Itoa(len(f.args)), 1)
+	}
+	f.conditions = append(f.conditions, cond)
+	return f
+}
+
+// Equal adds a column = value condition
+func (f *Filter) Equal(column string, value interface{}) *Filter {
+	return f.Where(column+" = ?", value)
+}
+
+// EqualIfSet adds a column = value condition when value is not empty
+func (f *Filter) EqualIfSet(column string, value string) *Filter {
+	if len(value) > 0 {
+		f.Equal(column, value)
+	}
+	return f
+}
+
+func (f *Filter) Args() []interface{} {
+	return f.args
+}
+
+func (f *Filter) String() string {
+	if len(f.conditions) == 0 {
+		return ""
+	}
+	return " where " + strings.Join(f.conditions, " and ")
+}
+
+// SelectQuery is a SELECT statement over a single table with optional filter, ordering and paging
+type SelectQuery struct {
+	columns string
+	table   string
+	filter  *Filter
+	orderBy string
+	offset  int
+	limit   int
+	paged   bool
+}
+
+func Select(columns string, table string) *SelectQuery {
+	return &SelectQuery{columns: columns, table: table, filter: NewFilter()}
+}
+
+func (q *SelectQuery) Filter(filter *Filter) *SelectQuery {
+	q.filter = filter
+	return q
+}
+
+// OrderBy sets the ORDER BY expression, it must never contain user input
+func (q *SelectQuery) OrderBy(orderBy string) *SelectQuery {
+	q.orderBy = orderBy
+	return q
+}
+
+// Page limits the result to limit rows starting at offset
+func (q *SelectQuery) Page(offset, limit int) *SelectQuery {
+	q.offset, q.limit, q.paged = offset, limit, true
+	return q
+}
+
+// Build returns the statement and its arguments
+func (q *SelectQuery) Build() (string, []interface{}) {
+	args := append([]interface{}{}, q.filter.Args()...)
+
+	var sb strings.Builder
+	sb.WriteString("select ")
+	sb.WriteString(q.columns)
+	sb.WriteString(" from ")
+	sb.WriteString(q.table)
+	sb.WriteString(q.filter.String())
+	if q.orderBy != "" {
+		sb.WriteString(" order by ")
+		sb.WriteString(q.orderBy)
+	}
+	if q.paged {
+		args = append(args, q.offset, q.limit)
+		sb.WriteString(fmt.Sprintf(" offset $%d limit $%d", len(args)-1, len(args)))
+	}
+	return sb.String(), args
+}
+
+// ParsePage validates offset and limit, paging only applies when both are set
+func ParsePage(offset, limit string) (int, int, bool, error) {
+	if len(offset) == 0 || len(limit) == 0 {
+		return 0, 0, false, nil
+	}
+	o, err := strconv.Atoi(offset)
+	if err != nil || o < 0 {
+		return 0, 0, false, fmt.Errorf("invalid offset [%s], must be a non negative integer", offset)
+	}
+	l, err := strconv.Atoi(limit)
+	if err != nil || l < 0 {
+		return 0, 0, false, fmt.Errorf("invalid limit [%s], must be a non negative integer", limit)
+	}
+	return o, l, true, nil
+}
+
+// ParseInterval validates an interval such as "2 days" and returns it in canonical form
+func ParseInterval(interval string) (string, error) {
+	match := intervalPattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(interval)))
+	if match == nil {
+		return "", fmt.Errorf("invalid interval [%s], expected <integer> <second|minute|hour|day|week|month|year>", interval)
+	}
+	return match[1] + " " + match[2] + "s", nil
+}
+
+// appFilter is the user/app/version scoping shared by the flow listing methods
+func appFilter(mtdata *metadata.Metadata) *Filter {
+	return NewFilter().
+		Equal("userId", mtdata.Username).
+		Equal("appName", mtdata.AppName).
+		Equal("appVersion", mtdata.AppVersion).
+		EqualIfSet("hostId", mtdata.HostId).
+		EqualIfSet("flowname", mtdata.FlowName)
+}
+
+// flowRecordFilter adds the instance, status and time range filters of the instances listing
+func flowRecordFilter(mtdata *metadata.Metadata) (*Filter, error) {
+	filter := appFilter(mtdata).EqualIfSet("status", mtdata.Status)
+
+	if len(mtdata.FlowInstanceId) > 0 {
+		filter.Where("(flowinstanceid = ? or rerunofflowinstanceid = ?)", mtdata.FlowInstanceId, mtdata.FlowInstanceId)
+	}
+
+	if len(mtdata.Interval) > 0 {
+		interval, err := ParseInterval(mtdata.Interval)
+		if err != nil {
+			return nil, err
+		}
+		filter.Where("starttime >= NOW() - CAST(? AS INTERVAL)", interval)
+	}
+
+	if len(mtdata.StartTime) > 0 && len(mtdata.EndTime) > 0 {
+		filter.Where("starttime >= CAST(? AS TIMESTAMP) and starttime <= CAST(? AS TIMESTAMP)", mtdata.StartTime, mtdata.EndTime)
+	}
+	return filter, nil
+}
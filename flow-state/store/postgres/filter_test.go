@@ -0,0 +1,64 @@
+package postgres
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/project-flogo/services/flow-state/store/metadata"
+)
+
+func TestFlowRecordQuery(t *testing.T) {
+	mtdata := &metadata.Metadata{
+		Username:       "user' or '1'='1",
+		AppName:        "app",
+		AppVersion:     "1.0",
+		Status:         "Failed",
+		FlowInstanceId: "abc",
+		Interval:       "2 Days",
+	}
+	filter, err := flowRecordFilter(mtdata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	querySql, args := Select("flowinstanceid", "flowstate").Filter(filter).OrderBy("starttime desc").Page(10, 5).Build()
+	expected := "select flowinstanceid from flowstate where userId = $1 and appName = $2 and appVersion = $3 and status = $4" +
+		" and (flowinstanceid = $5 or rerunofflowinstanceid = $6) and starttime >= NOW() - CAST($7 AS INTERVAL)" +
+		" order by starttime desc offset $8 limit $9"
+	if querySql != expected {
+		t.Fatalf("unexpected query:\n%s\nexpected:\n%s", querySql, expected)
+	}
+
+	expectedArgs := []interface{}{"user' or '1'='1", "app", "1.0", "Failed", "abc", "abc", "2 days", 10, 5}
+	if !reflect.DeepEqual(args, expectedArgs) {
+		t.Fatalf("unexpected args %v", args)
+	}
+}
+
+func TestParsePage(t *testing.T) {
+	if _, _, paged, err := ParsePage("", "10"); err != nil || paged {
+		t.Fatal("paging should only apply when offset and limit are both set")
+	}
+	if offset, limit, paged, err := ParsePage("20", "10"); err != nil || !paged || offset != 20 || limit != 10 {
+		t.Fatalf("unexpected page %d, %d, %v", offset, limit, err)
+	}
+	for _, invalid := range []string{"-1", "1; drop table flowstate", "ten"} {
+		if _, _, _, err := ParsePage(invalid, "10"); err == nil {
+			t.Fatalf("offset [%s] should be rejected", invalid)
+		}
+		if _, _, _, err := ParsePage("0", invalid); err == nil {
+			t.Fatalf("limit [%s] should be rejected", invalid)
+		}
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+	if interval, err := ParseInterval("1 hour"); err != nil || interval != "1 hours" {
+		t.Fatalf("unexpected interval %s, %v", interval, err)
+	}
+	for _, invalid := range []string{"1 day'; delete from flowstate; --", "day", "1.5 days", "3 fortnights"} {
+		if _, err := ParseInterval(invalid); err == nil {
+			t.Fatalf("interval [%s] should be rejected", invalid)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+const (
+	// LatestSchemaVersion is the schema version the step store is written against
+	LatestSchemaVersion = "2.0"
+
+	// migrationLockId serializes concurrent migrations from several state service instances
+	migrationLockId = 7240512
+
+	CreateSchemaVersion = "CREATE TABLE IF NOT EXISTS schema_version (version TEXT PRIMARY KEY, description TEXT, appliedon TIMESTAMP NOT NULL DEFAULT NOW());"
+	SelectSchemaVersion = "SELECT version FROM schema_version ORDER BY appliedon, version;"
+	InsertSchemaVersion = "INSERT INTO schema_version (version, description) VALUES ($1, $2) ON CONFLICT (version) DO NOTHING;"
+)
+
+type migration struct {
+	version     string
+	description string
+	statements  []string
+}
+
+// migrations are applied in order, a version is recorded once all of its statements succeed
+var migrations = []*migration{
+	{
+		version:     "1.0",
+		description: "create flowstate, steps, appstate and snapshopt tables",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS flowstate (
+				flowinstanceid TEXT PRIMARY KEY,
+				userid TEXT,
+				appname TEXT,
+				appversion TEXT,
+				flowname TEXT,
+				hostid TEXT,
+				starttime TIMESTAMP,
+				endtime TIMESTAMP,
+				executiontime NUMERIC,
+				status TEXT,
+				rerunofflowinstanceid TEXT
+			);`,
+			`CREATE TABLE IF NOT EXISTS steps (
+				flowinstanceid TEXT NOT NULL,
+				stepid TEXT NOT NULL,
+				taskname TEXT,
+				status TEXT,
+				starttime TIMESTAMP,
+				endtime TIMESTAMP,
+				stepdata BYTEA,
+				subflowid TEXT,
+				flowname TEXT,
+				rerun BOOLEAN,
+				PRIMARY KEY (flowinstanceid, stepid)
+			);`,
+			`CREATE TABLE IF NOT EXISTS appstate (
+				userid TEXT NOT NULL,
+				appname TEXT NOT NULL,
+				persistenceenabled BOOLEAN,
+				PRIMARY KEY (userid, appname)
+			);`,
+			`CREATE TABLE IF NOT EXISTS snapshopt (
+				flowinstanceid TEXT NOT NULL,
+				hostid TEXT,
+				stepid TEXT,
+				starttime TIMESTAMP,
+				endtime TIMESTAMP,
+				stepdata BYTEA
+			);`,
+			"CREATE INDEX IF NOT EXISTS flowstate_app_idx ON flowstate (userid, appname, appversion);",
+		},
+	},
+	{
+		version:     "2.0",
+		description: "add flowinput, flowoutput and reruncount to flowstate",
+		statements: []string{
+			"ALTER TABLE flowstate ADD COLUMN IF NOT EXISTS flowinput BYTEA;",
+			"ALTER TABLE flowstate ADD COLUMN IF NOT EXISTS flowoutput BYTEA;",
+			"ALTER TABLE flowstate ADD COLUMN IF NOT EXISTS reruncount INTEGER DEFAULT 0;",
+		},
+	},
+}
+
+// Migrate brings the database schema up to LatestSchemaVersion and returns the version it ended at
+func Migrate(db *sql.DB) (string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec("SELECT pg_advisory_xact_lock($1);", migrationLockId); err != nil {
+		return "", fmt.Errorf("unable to acquire schema migration lock: %s", err.Error())
+	}
+
+	if _, err = tx.Exec(CreateSchemaVersion); err != nil {
+		return "", fmt.Errorf("unable to create schema_version table: %s", err.Error())
+	}
+
+	current, err := recordedVersion(tx)
+	if err != nil {
+		return "", err
+	}
+
+	if current == "" {
+		// databases created before schema_version existed are baselined from their tables
+		current, err = detectLegacyVersion(tx)
+		if err != nil {
+			return "", err
+		}
+		if current != "" {
+			logCache.Infof("Found existing schema without version, recording it as version %s", current)
+			if _, err = tx.Exec(InsertSchemaVersion, current, "baseline of existing schema"); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	for _, m := range pendingMigrations(current) {
+		logCache.Infof("Migrating database schema to version %s: %s", m.version, m.description)
+		for _, statement := range m.statements {
+			if _, err = tx.Exec(statement); err != nil {
+				return "", fmt.Errorf("schema migration to version %s failed: %s", m.version, err.Error())
+			}
+		}
+		if _, err = tx.Exec(InsertSchemaVersion, m.version, m.description); err != nil {
+			return "", err
+		}
+		current = m.version
+	}
+
+	if err = tx.Commit(); err != nil {
+		return "", err
+	}
+	return current, nil
+}
+
+// MigrateSchema opens a connection using the persistence settings and migrates the schema
+func MigrateSchema(settings map[string]interface{}) (string, error) {
+	db, err := NewDB(settings)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	return Migrate(db)
+}
+
+// SchemaVersion returns the recorded schema version, empty if the schema has not been created
+func SchemaVersion(db *sql.DB) (string, error) {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'schema_version');").Scan(&exists)
+	if err != nil || !exists {
+		return "", err
+	}
+
+	rows, err := db.Query(SelectSchemaVersion)
+	if err != nil {
+		return "", err
+	}
+	return latestVersion(rows)
+}
+
+func recordedVersion(tx *sql.Tx) (string, error) {
+	rows, err := tx.Query(SelectSchemaVersion)
+	if err != nil {
+		return "", err
+	}
+	return latestVersion(rows)
+}
+
+// latestVersion reads the recorded versions and returns the one applied last in the order of migrations, the versions
+// are not compared as strings since "10.0" sorts before "2.0". A version this build does not know is newer than all
+// of the known ones, the last applied of those is returned.
+func latestVersion(rows *sql.Rows) (string, error) {
+	defer rows.Close()
+	var versions []string
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return "", err
+		}
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return latest(versions), nil
+}
+
+// latest returns the version of versions that comes last in migrations, versions are in the order they were applied
+func latest(versions []string) string {
+	latest, latestIndex := "", -1
+	for _, version := range versions {
+		index := migrationIndex(version)
+		if index < 0 {
+			// unknown versions were applied by a newer build
+			latest, latestIndex = version, len(migrations)
+			continue
+		}
+		if index > latestIndex {
+			latest, latestIndex = version, index
+		}
+	}
+	return latest
+}
+
+func migrationIndex(version string) int {
+	for i, m := range migrations {
+		if m.version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+func detectLegacyVersion(tx *sql.Tx) (string, error) {
+	var tables int
+	err := tx.QueryRow("SELECT count(*) FROM information_schema.tables WHERE table_name in ('flowstate', 'appstate', 'steps');").Scan(&tables)
+	if err != nil {
+		return "", err
+	}
+	if tables < 3 {
+		return "", nil
+	}
+
+	var columns int
+	err = tx.QueryRow("SELECT count(*) FROM information_schema.columns WHERE table_name = 'flowstate' and column_name = 'flowinput';").Scan(&columns)
+	if err != nil {
+		return "", err
+	}
+	if columns > 0 {
+		return "2.0", nil
+	}
+	return "1.0", nil
+}
+
+func pendingMigrations(current string) []*migration {
+	if current == "" {
+		return migrations
+	}
+	if i := migrationIndex(current); i >= 0 {
+		return migrations[i+1:]
+	}
+	return nil
+}
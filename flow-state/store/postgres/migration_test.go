@@ -0,0 +1,29 @@
+package postgres
+
+import "testing"
+
+func TestLatestVersion(t *testing.T) {
+	registered := migrations
+	defer func() { migrations = registered }()
+	migrations = append(append([]*migration(nil), registered...),
+		&migration{version: "3.0"}, &migration{version: "10.0"})
+
+	for _, c := range []struct {
+		versions []string
+		latest   string
+	}{
+		{nil, ""},
+		{[]string{"1.0"}, "1.0"},
+		// versions applied in the same transaction have the same appliedon and come back in text order
+		{[]string{"1.0", "10.0", "2.0", "3.0"}, "10.0"},
+		{[]string{"2.0", "1.0"}, "2.0"},
+		{[]string{"1.0", "2.0", "11.0"}, "11.0"},
+	} {
+		if latest := latest(c.versions); latest != c.latest {
+			t.Fatalf("latest of %v: expected %q, got %q", c.versions, c.latest, latest)
+		}
+	}
+	if pending := pendingMigrations("2.0"); len(pending) != 2 || pending[1].version != "10.0" {
+		t.Fatalf("unexpected pending migrations %v", pending)
+	}
+}
@@ -32,6 +32,8 @@ const (
 type StatefulDB struct {
 	db        *sql.DB
 	dbDetails *DBDetails
+	// tx is set when the statements must run in a transaction
+	tx *sql.Tx
 }
 
 func (s *StatefulDB) InsertFlowState(flowState *state.FlowState) (results *ResultSet, err error) {
@@ -227,6 +229,9 @@ func (s *StatefulDB) getStepStatement(prepared string) (stmt *sql.Stmt, err erro
 		}
 		preparedQueryCache[prepared] = stmt
 	}
+	if s.tx != nil {
+		return s.tx.Stmt(stmt), nil
+	}
 	return stmt, nil
 }
 
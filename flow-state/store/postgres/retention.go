@@ -0,0 +1,85 @@
+package postgres
+
+import (
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/project-flogo/core/data/coerce"
+	"github.com/project-flogo/services/flow-state/retention"
+)
+
+func (s *StepStore) RetentionInstances(filter *retention.Filter) ([]*retention.Instance, error) {
+	if !s.db.dbDetails.Connected {
+		return nil, errors.New("Database is not connected")
+	}
+
+	f := NewFilter().EqualIfSet("appname", filter.App).EqualIfSet("flowname", filter.Flow)
+	if len(filter.Status) > 0 {
+		f.Where("LOWER(status) = LOWER(?)", filter.Status)
+	}
+	querySql, args := Select("flowinstanceid, appname, flowname, status, starttime, endtime", "flowstate").Filter(f).Build()
+	set, err := s.queryWithRetry("RetentionInstances", querySql, args)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make([]*retention.Instance, 0, len(set.Record))
+	for _, record := range set.Record {
+		m := *record
+		instance := &retention.Instance{}
+		instance.FlowInstanceId, _ = coerce.ToString(m["flowinstanceid"])
+		instance.AppName, _ = coerce.ToString(m["appname"])
+		instance.FlowName, _ = coerce.ToString(m["flowname"])
+		instance.Status, _ = coerce.ToString(m["status"])
+		instance.Time, _ = m["starttime"].(time.Time)
+		if endTime, ok := m["endtime"].(time.Time); ok && !endTime.IsZero() {
+			instance.Time = endTime
+		} else {
+			instance.Running = true
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
+
+func (s *StepStore) PurgeInstances(flowIds []string) (int64, error) {
+	if !s.db.dbDetails.Connected {
+		return 0, errors.New("Database is not connected")
+	}
+
+	rows, err := s.db.purgeInstances(flowIds)
+	if err != nil && isConnectionError(err) {
+		if retryErr := s.RetryDBConnection(); retryErr != nil {
+			logCache.Errorf("Could not connect to database server error:, %s", retryErr.Error())
+			return 0, retryErr
+		}
+		logCache.Debug("Retrying from PurgeInstances after successful connection retry  ")
+		rows, err = s.db.purgeInstances(flowIds)
+	}
+	if err != nil {
+		logCache.Errorf("Could not purge flow instances, %s", err.Error())
+	}
+	return rows, err
+}
+
+func (s *StatefulDB) purgeInstances(flowIds []string) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	var rows int64
+	for _, table := range []string{"steps", "snapshopt", "flowstate"} {
+		result, err := tx.Exec("DELETE FROM "+table+" WHERE flowinstanceid = ANY($1)", pq.Array(flowIds))
+		if err != nil {
+			_ = tx.Rollback()
+			return 0, err
+		}
+		n, _ := result.RowsAffected()
+		rows += n
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return rows, nil
+}
@@ -0,0 +1,90 @@
+package postgres
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/project-flogo/core/data/coerce"
+	"github.com/project-flogo/flow/state"
+)
+
+const DeleteSnapshot = "DELETE FROM snapshopt WHERE flowinstanceid = $1"
+
+// SaveSnapshot replaces the snapshot of the flow instance, snapshopt has no key to upsert on
+func (s *StatefulDB) SaveSnapshot(snapshot *state.Snapshot) error {
+	if s.tx == nil {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		txDB := &StatefulDB{db: s.db, dbDetails: s.dbDetails, tx: tx}
+		if err = txDB.SaveSnapshot(snapshot); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+		return tx.Commit()
+	}
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	if _, err = s.delete(DeleteSnapshot, []interface{}{snapshot.Id}); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	_, err = s.insert(SNAPSHOT_INSERT, []interface{}{snapshot.Id, "", "", now, now, data})
+	return err
+}
+
+func (s *StepStore) SaveSnapshot(snapshot *state.Snapshot) error {
+	if !s.db.dbDetails.Connected {
+		return errors.New("Database is not connected")
+	}
+
+	err := s.db.SaveSnapshot(snapshot)
+	if err != nil && isConnectionError(err) {
+		if retryErr := s.RetryDBConnection(); retryErr != nil {
+			logCache.Errorf("Could not connect to database server error:, %s", retryErr.Error())
+			return retryErr
+		}
+		logCache.Debug("Retrying from SaveSnapshot after successful connection retry  ")
+		err = s.db.SaveSnapshot(snapshot)
+	}
+	if err != nil {
+		logCache.Errorf("Could not save snapshot, %s", err.Error())
+	}
+	return err
+}
+
+func (s *StepStore) GetSnapshot(flowId string) *state.Snapshot {
+	if !s.db.dbDetails.Connected {
+		return nil
+	}
+
+	querySql, args := Select("stepdata", "snapshopt").Filter(NewFilter().Equal("flowinstanceid", flowId)).Build()
+	set, err := s.queryWithRetry("GetSnapshot", querySql, args)
+	if err != nil || len(set.Record) == 0 {
+		return nil
+	}
+
+	encoded, err := coerce.ToBytes((*set.Record[0])["stepdata"])
+	if err != nil {
+		logCache.Errorf("decodeBase64 for snapshot data error:, %s", err.Error())
+		return nil
+	}
+	data, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil {
+		logCache.Errorf("decodeBase64 for snapshot data error:, %s", err.Error())
+		return nil
+	}
+
+	snapshot := &state.Snapshot{SnapshotBase: &state.SnapshotBase{}}
+	if err = json.Unmarshal(data, snapshot); err != nil {
+		logCache.Errorf("Could not unmarshal snapshot for [%s], %s", flowId, err.Error())
+		return nil
+	}
+	return snapshot
+}
@@ -16,13 +16,16 @@ import (
 	"github.com/project-flogo/core/data/coerce"
 	metadata2 "github.com/project-flogo/core/data/metadata"
 	"github.com/project-flogo/flow/state"
+	"github.com/project-flogo/services/flow-state/event"
 	"github.com/project-flogo/services/flow-state/store/metadata"
 	"github.com/project-flogo/services/flow-state/store/task"
 )
 
+const SettingAutoMigrate = "autoMigrate"
+
 func NewStore(settings map[string]interface{}) (*StepStore, error) {
 	db, err := NewDB(settings)
-	dbDetails := &DBDetails{}
+	dbDetails := &DBDetails{LatestVersion: LatestSchemaVersion}
 	if err != nil {
 		dbDetails.Connected = false
 		dbDetails.Message = err.Error()
@@ -33,19 +36,32 @@ func NewStore(settings map[string]interface{}) (*StepStore, error) {
 	statefulDB := &StatefulDB{db: db}
 	dbDetails.Connected = true
 	dbDetails.Message = "Connected"
+
+	autoMigrate := true
+	if v, set := settings[SettingAutoMigrate]; set {
+		autoMigrate, _ = coerce.ToBool(v)
+	}
+	if autoMigrate {
+		if _, err := Migrate(db); err != nil {
+			logCache.Errorf("Database schema migration failed: %s", err.Error())
+			dbDetails.Message = err.Error()
+		}
+	}
+
 	dbDetails.getDBDetails(statefulDB)
 	dbDetails.Status = true
 	statefulDB.dbDetails = dbDetails
-	return &StepStore{db: statefulDB, settings: settings}, err
+	return &StepStore{db: statefulDB, settings: settings}, nil
 
 }
 
 type DBDetails struct {
-	SmVersion    string `json:"smVersion"`
-	Connected    bool   `json:"connected"`
-	TablesExists bool   `json:"tablesExists"`
-	Message      string `json:"message"`
-	Status       bool   `json:"status"`
+	SmVersion     string `json:"smVersion"`
+	LatestVersion string `json:"latestVersion"`
+	Connected     bool   `json:"connected"`
+	TablesExists  bool   `json:"tablesExists"`
+	Message       string `json:"message"`
+	Status        bool   `json:"status"`
 }
 
 type StepStore struct {
@@ -59,38 +75,18 @@ type StepStore struct {
 }
 
 func (d *DBDetails) getDBDetails(db *StatefulDB) {
-	sqlSelect := "SELECT count(*) FROM information_schema.tables WHERE table_name in ('flowstate' , 'appstate' , 'steps')"
-	set, err := db.query(sqlSelect, nil)
-	if err != nil {
+	version, err := SchemaVersion(db.db)
+	if err != nil || version == "" {
 		d.TablesExists = false
+		d.SmVersion = "1.0"
 		d.Message = "One or more required tables are missing in the database schema"
-	} else {
-		for _, v := range set.Record {
-			m := *v
-			count, _ := coerce.ToInt(m["count"])
-			if count == 3 {
-				d.TablesExists = true
-			} else {
-				d.TablesExists = false
-				d.Message = "One or more required tables are missing in the database schema"
-
-			}
-		}
-	}
-	if !d.TablesExists {
 		return
 	}
-	sqlSelect = "SELECT column_name FROM information_schema.columns WHERE table_name = 'flowstate' and column_name = 'flowinput'"
-	set, err = db.query(sqlSelect, nil)
-	if err != nil {
-		d.SmVersion = "1.0"
-	} else {
-		if set.Record == nil {
-			d.SmVersion = "1.0"
-		}
-		if len(set.Record) > 0 {
-			d.SmVersion = "2.0"
-		}
+
+	d.TablesExists = true
+	d.SmVersion = version
+	if version != LatestSchemaVersion {
+		d.Message = fmt.Sprintf("Database schema version %s is older than %s, run the migrate command to upgrade", version, LatestSchemaVersion)
 	}
 }
 
@@ -121,15 +117,20 @@ func (s *StepStore) MaxConcurrencyLimit() int {
 
 }
 
+// GetStatus is the status of the last step that changed the status of the root flow, or -1 for an unknown instance
 func (s *StepStore) GetStatus(flowId string) int {
-	s.RLock()
-	sc, ok := s.stepContainers[flowId]
-	s.RUnlock()
+	steps, err := s.GetSteps(flowId)
+	if err != nil || len(steps) == 0 {
+		return -1
+	}
 
-	if ok {
-		return sc.Status()
+	status := 0
+	for _, step := range steps {
+		if change := step.FlowChanges[0]; change != nil && change.SubflowId == 0 && change.Status != -1 {
+			status = change.Status
+		}
 	}
-	return -1
+	return status
 }
 
 //func (s *StepStore) GetFlow(flowId string) *state.FlowInfo {
@@ -146,129 +147,23 @@ func (s *StepStore) GetStatus(flowId string) int {
 //}
 
 func (s *StepStore) GetFailedFlows(metadata *metadata.Metadata) ([]*state.FlowInfo, error) {
-
-	if !s.db.dbDetails.Connected {
-		return nil, errors.New("Database is not connected")
-	}
-
-	var whereStr = "where"
-	if len(metadata.Username) < 0 {
-		return nil, fmt.Errorf("unauthorized, please provide user infomation")
-	}
-	whereStr += " userId='" + metadata.Username + "'"
-
-	if len(metadata.AppName) < 0 {
-		return nil, fmt.Errorf("please provide App name")
-	}
-	whereStr += "  and appName='" + metadata.AppName + "'"
-
-	if len(metadata.AppVersion) < 0 {
-		return nil, fmt.Errorf("please provide App Version")
-	}
-	whereStr += "  and appVersion='" + metadata.AppVersion + "'"
-
-	if len(metadata.HostId) > 0 {
-		whereStr += "  and hostId='" + metadata.HostId + "'"
-	}
-
-	if len(metadata.FlowName) > 0 {
-		whereStr += "  and flowname='" + metadata.FlowName + "'"
-	}
-
-	whereStr += " and status = 'Failed'"
-
-	set, err := s.db.query("select flowinstanceid, flowname, status from flowstate "+whereStr, nil)
-	if err != nil {
-		if err == driver.ErrBadConn || strings.Contains(err.Error(), "connection refused") || strings.Contains(err.Error(), "network is unreachable") ||
-			strings.Contains(err.Error(), "connection reset by peer") || strings.Contains(err.Error(), "dial tcp: lookup") ||
-			strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "timedout") ||
-			strings.Contains(err.Error(), "timed out") || strings.Contains(err.Error(), "net.Error") || strings.Contains(err.Error(), "i/o timeout") {
-			if retryErr := s.RetryDBConnection(); retryErr == nil {
-				logCache.Debugf("Retrying from GetFailedFlows after successful connection retry  ")
-				set, err = s.db.query("select flowinstanceid, flowname, status from flowstate "+whereStr, nil)
-				if err != nil {
-					logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-					return nil, err
-				}
-			} else {
-				logCache.Errorf("Could not connect to database server error:, %s", retryErr.Error())
-				return nil, retryErr
-			}
-		} else {
-			logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-			return nil, err
-		}
-	}
-
-	var flowinfo []*state.FlowInfo
-	for _, v := range set.Record {
-		m := *v
-		id, _ := coerce.ToString(m["flowinstanceid"])
-		flowName, _ := coerce.ToString(m["flowname"])
-		status, _ := coerce.ToString(m["status"])
-		info := &state.FlowInfo{
-			Id:         id,
-			FlowName:   flowName,
-			FlowStatus: status,
-		}
-		flowinfo = append(flowinfo, info)
-	}
-	return flowinfo, err
+	return s.getFlowsByStatus("GetFailedFlows", metadata, "Failed")
 }
 
 func (s *StepStore) GetCompletedFlows(metadata *metadata.Metadata) ([]*state.FlowInfo, error) {
+	return s.getFlowsByStatus("GetCompletedFlows", metadata, "Completed")
+}
+
+func (s *StepStore) getFlowsByStatus(caller string, metadata *metadata.Metadata, status string) ([]*state.FlowInfo, error) {
 
 	if !s.db.dbDetails.Connected {
 		return nil, errors.New("Database is not connected")
 	}
 
-	var whereStr = "where"
-	if len(metadata.Username) < 0 {
-		return nil, fmt.Errorf("unauthorized, please provide user infomation")
-	}
-	whereStr += " userId='" + metadata.Username + "'"
-
-	if len(metadata.AppName) < 0 {
-		return nil, fmt.Errorf("please provide App name")
-	}
-	whereStr += "  and appName='" + metadata.AppName + "'"
-
-	if len(metadata.AppVersion) < 0 {
-		return nil, fmt.Errorf("please provide App Version")
-	}
-	whereStr += "  and appVersion='" + metadata.AppVersion + "'"
-
-	if len(metadata.HostId) > 0 {
-		whereStr += "  and hostId='" + metadata.HostId + "'"
-	}
-
-	if len(metadata.FlowName) > 0 {
-		whereStr += "  and flowname='" + metadata.FlowName + "'"
-	}
-
-	whereStr += " and status = 'Completed'"
-
-	set, err := s.db.query("select flowinstanceid, flowname, status from flowstate "+whereStr, nil)
+	querySql, args := Select("flowinstanceid, flowname, status", "flowstate").Filter(appFilter(metadata).Equal("status", status)).Build()
+	set, err := s.queryWithRetry(caller, querySql, args)
 	if err != nil {
-		if err == driver.ErrBadConn || strings.Contains(err.Error(), "connection refused") || strings.Contains(err.Error(), "network is unreachable") ||
-			strings.Contains(err.Error(), "connection reset by peer") || strings.Contains(err.Error(), "dial tcp: lookup") ||
-			strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "timedout") ||
-			strings.Contains(err.Error(), "timed out") || strings.Contains(err.Error(), "net.Error") || strings.Contains(err.Error(), "i/o timeout") {
-			if retryErr := s.RetryDBConnection(); retryErr == nil {
-				logCache.Debugf("Retrying from GetCompletedFlows after successful connection retry  ")
-				set, err = s.db.query("select flowinstanceid, flowname, status from flowstate "+whereStr, nil)
-				if err != nil {
-					logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-					return nil, err
-				}
-			} else {
-				logCache.Errorf("Could not connect to database server error:, %s", retryErr.Error())
-				return nil, retryErr
-			}
-		} else {
-			logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-			return nil, err
-		}
+		return nil, err
 	}
 
 	var flowinfo []*state.FlowInfo
@@ -293,60 +188,21 @@ func (s *StepStore) GetFlows(metadata *metadata.Metadata) ([]*state.FlowInfo, er
 		return nil, errors.New("Database is not connected")
 	}
 
-	var whereStr = "where"
-	if len(metadata.Username) < 0 {
-		return nil, fmt.Errorf("unauthorized, please provide user infomation")
-	}
-	whereStr += " userId='" + metadata.Username + "'"
-
-	if len(metadata.AppName) < 0 {
-		return nil, fmt.Errorf("please provide App name")
-	}
-	whereStr += "  and appName='" + metadata.AppName + "'"
-
-	if len(metadata.AppVersion) < 0 {
-		return nil, fmt.Errorf("please provide App Version")
-	}
-	whereStr += "  and appVersion='" + metadata.AppVersion + "'"
-
-	if len(metadata.HostId) > 0 {
-		whereStr += "  and hostId='" + metadata.HostId + "'"
-	}
-
-	if len(metadata.FlowName) > 0 {
-		whereStr += "  and flowname='" + metadata.FlowName + "'"
-	}
-
-	if len(metadata.Status) > 0 {
-		whereStr += "  and status='" + metadata.Status + "'"
+	offset, limit, paged, err := ParsePage(metadata.Offset, metadata.Limit)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(metadata.Offset) > 0 && len(metadata.Limit) > 0 {
-		offsetLimitStr := "  offset '" + metadata.Offset + "'  limit  '" + metadata.Limit + "'"
-		whereStr += offsetLimitStr
+	query := Select("flowinstanceid, flowname, status, hostid, starttime, endtime", "flowstate").
+		Filter(appFilter(metadata).EqualIfSet("status", metadata.Status))
+	if paged {
+		query.Page(offset, limit)
 	}
 
-	set, err := s.db.query("select flowinstanceid, flowname, status, hostid, starttime, endtime from flowstate "+whereStr, nil)
+	querySql, args := query.Build()
+	set, err := s.queryWithRetry("GetFlows", querySql, args)
 	if err != nil {
-		if err == driver.ErrBadConn || strings.Contains(err.Error(), "connection refused") || strings.Contains(err.Error(), "network is unreachable") ||
-			strings.Contains(err.Error(), "connection reset by peer") || strings.Contains(err.Error(), "dial tcp: lookup") ||
-			strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "timedout") ||
-			strings.Contains(err.Error(), "timed out") || strings.Contains(err.Error(), "net.Error") || strings.Contains(err.Error(), "i/o timeout") {
-			if retryErr := s.RetryDBConnection(); retryErr == nil {
-				logCache.Debugf("Retrying from GetFlows after successful connection retry  ")
-				set, err = s.db.query("select flowinstanceid, flowname, status, hostid, starttime, endtime from flowstate "+whereStr, nil)
-				if err != nil {
-					logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-					return nil, err
-				}
-			} else {
-				logCache.Errorf("Could not connect to database server error:, %s", retryErr.Error())
-				return nil, retryErr
-			}
-		} else {
-			logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-			return nil, err
-		}
+		return nil, err
 	}
 
 	var flowinfo []*state.FlowInfo
@@ -377,92 +233,40 @@ func (s *StepStore) GetFlowsWithRecordCount(mtdata *metadata.Metadata) (*metadat
 		return nil, errors.New("Database is not connected")
 	}
 
-	var whereStr = "where"
-	if len(mtdata.Username) < 0 {
-		return nil, fmt.Errorf("unauthorized, please provide user infomation")
-	}
-	whereStr += " userId='" + mtdata.Username + "'"
-
-	if len(mtdata.AppName) < 0 {
-		return nil, fmt.Errorf("please provide App name")
-	}
-	whereStr += "  and appName='" + mtdata.AppName + "'"
-
-	if len(mtdata.AppVersion) < 0 {
-		return nil, fmt.Errorf("please provide App Version")
-	}
-	whereStr += "  and appVersion='" + mtdata.AppVersion + "'"
-
-	if len(mtdata.HostId) > 0 {
-		whereStr += "  and hostId='" + mtdata.HostId + "'"
-	}
-
-	if len(mtdata.FlowName) > 0 {
-		whereStr += "  and flowname='" + mtdata.FlowName + "'"
-	}
-
-	if len(mtdata.Status) > 0 {
-		whereStr += "  and status='" + mtdata.Status + "'"
-	}
-
-	if len(mtdata.FlowInstanceId) > 0 {
-		whereStr += "  and (flowinstanceid='" + mtdata.FlowInstanceId + "' or rerunofflowinstanceid='" + mtdata.FlowInstanceId + "' )"
-	}
-
-	if len(mtdata.Interval) > 0 {
-		whereStr += "  and starttime >= NOW() - INTERVAL '" + mtdata.Interval + "'"
+	filter, err := flowRecordFilter(mtdata)
+	if err != nil {
+		return nil, err
 	}
 
-	if len(mtdata.StartTime) > 0 && len(mtdata.EndTime) > 0 {
-		whereStr += "  and starttime >= '" + mtdata.StartTime + "' and starttime <= '" + mtdata.EndTime + "'"
+	offset, limit, paged, err := ParsePage(mtdata.Offset, mtdata.Limit)
+	if err != nil {
+		return nil, err
 	}
 
-	whereStr += " order by starttime desc"
-
-	if len(mtdata.Offset) > 0 && len(mtdata.Limit) > 0 {
-		offsetLimitStr := "  offset '" + mtdata.Offset + "'  limit  '" + mtdata.Limit + "'"
-		whereStr += offsetLimitStr
+	build := func(columns string) (string, []interface{}) {
+		query := Select(columns, "flowstate").Filter(filter).OrderBy("starttime desc")
+		if paged {
+			query.Page(offset, limit)
+		}
+		return query.Build()
 	}
 
-	var set *ResultSet
-	var err error
+	v1Columns := "flowinstanceid, flowname, status, hostid, starttime, endtime, executiontime, rerunofflowinstanceid, count(*) over() AS full_count"
+	columns := "flowinstanceid, flowname, status, hostid, starttime, endtime, executiontime, rerunofflowinstanceid, reruncount, flowinput, count(*) over() AS full_count"
 	if s.db.dbDetails.SmVersion == "1.0" {
-		set, err = s.db.query("select flowinstanceid, flowname, status, hostid, starttime, endtime, executiontime, rerunofflowinstanceid, count(*) over() AS full_count from flowstate "+whereStr, nil)
-	} else {
-		set, err = s.db.query("select flowinstanceid, flowname, status, hostid, starttime, endtime, executiontime, rerunofflowinstanceid, reruncount, flowinput, count(*) over() AS full_count from flowstate "+whereStr, nil)
+		columns = v1Columns
 	}
 
+	querySql, args := build(columns)
+	set, err := s.queryWithRetry("GetFlowsWithRecordCount", querySql, args)
+	if pqerror, ok := err.(*pq.Error); ok && pqerror.Routine == "errorMissingColumn" {
+		querySql, args = build(v1Columns)
+		set, err = s.queryWithRetry("GetFlowsWithRecordCount", querySql, args)
+	}
 	if err != nil {
-		pqerror, ok := err.(*pq.Error)
-		if ok {
-			if pqerror.Routine == "errorMissingColumn" {
-				set, err = s.db.query("select flowinstanceid, flowname, status, hostid, starttime, endtime, executiontime, rerunofflowinstanceid, count(*) over() AS full_count from flowstate "+whereStr, nil)
-			}
-		}
-
-		if err != nil {
-
-			if err == driver.ErrBadConn || strings.Contains(err.Error(), "connection refused") || strings.Contains(err.Error(), "network is unreachable") ||
-				strings.Contains(err.Error(), "connection reset by peer") || strings.Contains(err.Error(), "dial tcp: lookup") ||
-				strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "timedout") ||
-				strings.Contains(err.Error(), "timed out") || strings.Contains(err.Error(), "net.Error") || strings.Contains(err.Error(), "i/o timeout") {
-				if retryErr := s.RetryDBConnection(); retryErr == nil {
-					logCache.Debugf("Retrying from GetFlowsWithRecordCount after successful connection retry  ")
-					set, err = s.db.query("select flowinstanceid, flowname, status, hostid, starttime, endtime, executiontime, rerunofflowinstanceid, reruncount, flowinput, count(*) over() AS full_count from flowstate "+whereStr, nil)
-					if err != nil {
-						logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-						return nil, err
-					}
-				} else {
-					logCache.Errorf("Could not connect to database server error:, %s", retryErr.Error())
-					return nil, retryErr
-				}
-			} else {
-				logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-				return nil, err
-			}
-		}
+		return nil, err
 	}
+
 	var count int32
 	var flowinfo []*state.FlowInfo
 	for _, v := range set.Record {
@@ -510,42 +314,17 @@ func (s *StepStore) GetFlow(flowid string, metadata *metadata.Metadata) (*state.
 		return nil, errors.New("Database is not connected")
 	}
 
-	var whereStr = "where flowinstanceid = '" + flowid + "'"
-	if len(metadata.Username) > 0 {
-		whereStr += " and userId='" + metadata.Username + "'"
-	}
+	filter := NewFilter().
+		Equal("flowinstanceid", flowid).
+		EqualIfSet("userId", metadata.Username).
+		EqualIfSet("appName", metadata.AppName).
+		EqualIfSet("appVersion", metadata.AppVersion).
+		EqualIfSet("hostId", metadata.HostId)
 
-	if len(metadata.AppName) > 0 {
-		whereStr += "  and appName='" + metadata.AppName + "'"
-	}
-	if len(metadata.AppVersion) > 0 {
-		whereStr += "  and appVersion='" + metadata.AppVersion + "'"
-	}
-	if len(metadata.HostId) > 0 {
-		whereStr += "  and hostId='" + metadata.HostId + "'"
-	}
-
-	set, err := s.db.query("select flowinstanceid, flowname, status, flowinput, reruncount, rerunofflowinstanceid from flowstate "+whereStr, nil)
+	querySql, args := Select("flowinstanceid, flowname, status, flowinput, reruncount, rerunofflowinstanceid", "flowstate").Filter(filter).Build()
+	set, err := s.queryWithRetry("GetFlow", querySql, args)
 	if err != nil {
-		if err == driver.ErrBadConn || strings.Contains(err.Error(), "connection refused") || strings.Contains(err.Error(), "network is unreachable") ||
-			strings.Contains(err.Error(), "connection reset by peer") || strings.Contains(err.Error(), "dial tcp: lookup") ||
-			strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "timedout") ||
-			strings.Contains(err.Error(), "timed out") || strings.Contains(err.Error(), "net.Error") || strings.Contains(err.Error(), "i/o timeout") {
-			if retryErr := s.RetryDBConnection(); retryErr == nil {
-				logCache.Debugf("Retrying from GetFlow after successful connection retry  ")
-				set, err = s.db.query("select flowinstanceid, flowname, status, flowinput from flowstate "+whereStr, nil)
-				if err != nil {
-					logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-					return nil, err
-				}
-			} else {
-				logCache.Errorf("Could not connect to database server error:, %s", retryErr.Error())
-				return nil, retryErr
-			}
-		} else {
-			logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-			return nil, err
-		}
+		return nil, err
 	}
 
 	var flowinfo []*state.FlowInfo
@@ -581,52 +360,48 @@ func (s *StepStore) GetFlow(flowid string, metadata *metadata.Metadata) (*state.
 		flowinfo = append(flowinfo, info)
 	}
 	if len(flowinfo) <= 0 {
-		return nil, fmt.Errorf("flow details [%s] not found", flowid)
+		return nil, nil
 	}
 	return flowinfo[0], err
 }
 
-func (s *StepStore) GetFlowNames(metadata *metadata.Metadata) ([]string, error) {
+func (s *StepStore) GetFlowOwner(flowid string) (*metadata.Owner, error) {
 	if !s.db.dbDetails.Connected {
 		return nil, errors.New("Database is not connected")
 	}
 
-	var whereStr = "where "
-	if len(metadata.Username) > 0 {
-		whereStr += " userId='" + metadata.Username + "'"
-	}
-	if len(metadata.AppName) > 0 {
-		whereStr += "  and appName='" + metadata.AppName + "'"
+	querySql, args := Select("userid, appname, appversion", "flowstate").Filter(NewFilter().Equal("flowinstanceid", flowid)).Build()
+	set, err := s.queryWithRetry("GetFlowOwner", querySql, args)
+	if err != nil {
+		return nil, err
 	}
-	if len(metadata.AppVersion) > 0 {
-		whereStr += "  and appVersion='" + metadata.AppVersion + "'"
+	if len(set.Record) == 0 {
+		return nil, nil
 	}
 
-	if len(metadata.HostId) > 0 {
-		whereStr += "  and hostId='" + metadata.HostId + "'"
+	m := *set.Record[0]
+	owner := &metadata.Owner{}
+	owner.Username, _ = coerce.ToString(m["userid"])
+	owner.AppName, _ = coerce.ToString(m["appname"])
+	owner.AppVersion, _ = coerce.ToString(m["appversion"])
+	return owner, nil
+}
+
+func (s *StepStore) GetFlowNames(metadata *metadata.Metadata) ([]string, error) {
+	if !s.db.dbDetails.Connected {
+		return nil, errors.New("Database is not connected")
 	}
 
-	set, err := s.db.query("select distinct(flowname) from flowstate "+whereStr, nil)
+	filter := NewFilter().
+		EqualIfSet("userId", metadata.Username).
+		EqualIfSet("appName", metadata.AppName).
+		EqualIfSet("appVersion", metadata.AppVersion).
+		EqualIfSet("hostId", metadata.HostId)
+
+	querySql, args := Select("distinct(flowname)", "flowstate").Filter(filter).Build()
+	set, err := s.queryWithRetry("GetFlowNames", querySql, args)
 	if err != nil {
-		if err == driver.ErrBadConn || strings.Contains(err.Error(), "connection refused") || strings.Contains(err.Error(), "network is unreachable") ||
-			strings.Contains(err.Error(), "connection reset by peer") || strings.Contains(err.Error(), "dial tcp: lookup") ||
-			strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "timedout") ||
-			strings.Contains(err.Error(), "timed out") || strings.Contains(err.Error(), "net.Error") || strings.Contains(err.Error(), "i/o timeout") {
-			if retryErr := s.RetryDBConnection(); retryErr == nil {
-				logCache.Debugf("Retrying from GetFlowNames after successful connection retry  ")
-				set, err = s.db.query("select distinct(flowname) from flowstate "+whereStr, nil)
-				if err != nil {
-					logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-					return nil, err
-				}
-			} else {
-				logCache.Errorf("Could not connect to database server error:, %s", retryErr.Error())
-				return nil, retryErr
-			}
-		} else {
-			logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-			return nil, err
-		}
+		return nil, err
 	}
 
 	var flownameArray []string
@@ -644,35 +419,14 @@ func (s *StepStore) GetAppVersions(metadata *metadata.Metadata) ([]string, error
 		return nil, errors.New("Database is not connected")
 	}
 
-	var whereStr = "where "
-	if len(metadata.Username) > 0 {
-		whereStr += " userId='" + metadata.Username + "'"
-	}
-	if len(metadata.AppName) > 0 {
-		whereStr += "  and appname='" + metadata.AppName + "'"
-	}
+	filter := NewFilter().
+		EqualIfSet("userId", metadata.Username).
+		EqualIfSet("appname", metadata.AppName)
 
-	set, err := s.db.query("select distinct(appVersion) from flowstate "+whereStr, nil)
+	querySql, args := Select("distinct(appVersion)", "flowstate").Filter(filter).Build()
+	set, err := s.queryWithRetry("GetAppVersions", querySql, args)
 	if err != nil {
-		if err == driver.ErrBadConn || strings.Contains(err.Error(), "connection refused") || strings.Contains(err.Error(), "network is unreachable") ||
-			strings.Contains(err.Error(), "connection reset by peer") || strings.Contains(err.Error(), "dial tcp: lookup") ||
-			strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "timedout") ||
-			strings.Contains(err.Error(), "timed out") || strings.Contains(err.Error(), "net.Error") || strings.Contains(err.Error(), "i/o timeout") {
-			if retryErr := s.RetryDBConnection(); retryErr == nil {
-				logCache.Debugf("Retrying from GetAppVersions after successful connection retry  ")
-				set, err = s.db.query("select distinct(appVersion) from flowstate "+whereStr, nil)
-				if err != nil {
-					logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-					return nil, err
-				}
-			} else {
-				logCache.Errorf("Could not connect to database server error:, %s", retryErr.Error())
-				return nil, retryErr
-			}
-		} else {
-			logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-			return nil, err
-		}
+		return nil, err
 	}
 
 	var appVersionArray []string
@@ -690,36 +444,16 @@ func (s *StepStore) GetAppState(metadata *metadata.Metadata) (string, error) {
 		return "", errors.New("Database is not connected")
 	}
 
-	var whereStr = "where "
-	if len(metadata.Username) > 0 {
-		whereStr += " userId='" + metadata.Username + "'"
-	}
-	if len(metadata.AppName) > 0 {
-		whereStr += "  and appname='" + metadata.AppName + "'"
-	}
+	filter := NewFilter().
+		EqualIfSet("userId", metadata.Username).
+		EqualIfSet("appname", metadata.AppName)
 
-	set, err := s.db.query("select persistenceEnabled from appstate "+whereStr, nil)
+	querySql, args := Select("persistenceEnabled", "appstate").Filter(filter).Build()
+	set, err := s.queryWithRetry("GetAppState", querySql, args)
 	if err != nil {
-		if err == driver.ErrBadConn || strings.Contains(err.Error(), "connection refused") || strings.Contains(err.Error(), "network is unreachable") ||
-			strings.Contains(err.Error(), "connection reset by peer") || strings.Contains(err.Error(), "dial tcp: lookup") ||
-			strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "timedout") ||
-			strings.Contains(err.Error(), "timed out") || strings.Contains(err.Error(), "net.Error") || strings.Contains(err.Error(), "i/o timeout") {
-			if retryErr := s.RetryDBConnection(); retryErr == nil {
-				logCache.Debugf("Retrying from GetAppState after successful connection retry  ")
-				set, err = s.db.query("select persistenceEnabled from appstate  "+whereStr, nil)
-				if err != nil {
-					logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-					return "", err
-				}
-			} else {
-				logCache.Errorf("Could not connect to database server error:, %s", retryErr.Error())
-				return "", retryErr
-			}
-		} else {
-			logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-			return "", err
-		}
+		return "", err
 	}
+
 	persistenceEnabled := ""
 	for _, v := range set.Record {
 		m := *v
@@ -754,7 +488,6 @@ func (s *StepStore) SaveAppState(metadata *metadata.Metadata) error {
 }
 
 func (s *StepStore) SaveStep(step *state.Step) error {
-
 	if !s.db.dbDetails.Connected {
 		return errors.New("Database is not connected")
 	}
@@ -776,7 +509,11 @@ func (s *StepStore) SaveStep(step *state.Step) error {
 			return retryErr
 		}
 	}
-	return err
+	if err != nil {
+		return err
+	}
+	event.PostStepEvent(step)
+	return nil
 }
 
 func (s *StepStore) DeleteSteps(flowId string, stepId string) error {
@@ -811,27 +548,10 @@ func (s *StepStore) GetSteps(flowId string) ([]*state.Step, error) {
 		return nil, errors.New("Database is not connected")
 	}
 
-	set, err := s.db.query("select stepdata from steps where flowinstanceid = '"+flowId+"'", nil)
+	querySql, args := Select("stepdata", "steps").Filter(NewFilter().Equal("flowinstanceid", flowId)).OrderBy("cast(stepid as integer)").Build()
+	set, err := s.queryWithRetry("GetSteps", querySql, args)
 	if err != nil {
-		if err == driver.ErrBadConn || strings.Contains(err.Error(), "connection refused") || strings.Contains(err.Error(), "network is unreachable") ||
-			strings.Contains(err.Error(), "connection reset by peer") || strings.Contains(err.Error(), "dial tcp: lookup") ||
-			strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "timedout") ||
-			strings.Contains(err.Error(), "timed out") || strings.Contains(err.Error(), "net.Error") || strings.Contains(err.Error(), "i/o timeout") {
-			if retryErr := s.RetryDBConnection(); retryErr == nil {
-				logCache.Debugf("Retrying from GetSteps after successful connection retry  ")
-				set, err = s.db.query("select stepdata from steps where flowinstanceid = '"+flowId+"'", nil)
-				if err != nil {
-					logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-					return nil, err
-				}
-			} else {
-				logCache.Errorf("Could not connect to database server error:, %s", retryErr.Error())
-				return nil, retryErr
-			}
-		} else {
-			logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-			return nil, err
-		}
+		return nil, err
 	}
 
 	var steps []*state.Step
@@ -854,9 +574,6 @@ func (s *StepStore) GetSteps(flowId string) ([]*state.Step, error) {
 		steps = append(steps, step)
 	}
 
-	if len(steps) <= 0 {
-		return nil, fmt.Errorf("step for flow instance [%s] not found", flowId)
-	}
 	return steps, err
 }
 
@@ -865,27 +582,11 @@ func (s *StepStore) GetStepsAsTasks(flowId string) ([][]*task.Task, error) {
 	if !s.db.dbDetails.Connected {
 		return nil, errors.New("Database is not connected")
 	}
-	set, err := s.db.query("select stepdata from steps where flowinstanceid = '"+flowId+"'", nil)
+
+	querySql, args := Select("stepdata", "steps").Filter(NewFilter().Equal("flowinstanceid", flowId)).OrderBy("cast(stepid as integer)").Build()
+	set, err := s.queryWithRetry("GetStepsAsTasks", querySql, args)
 	if err != nil {
-		if err == driver.ErrBadConn || strings.Contains(err.Error(), "connection refused") || strings.Contains(err.Error(), "network is unreachable") ||
-			strings.Contains(err.Error(), "connection reset by peer") || strings.Contains(err.Error(), "dial tcp: lookup") ||
-			strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "timedout") ||
-			strings.Contains(err.Error(), "timed out") || strings.Contains(err.Error(), "net.Error") || strings.Contains(err.Error(), "i/o timeout") {
-			if retryErr := s.RetryDBConnection(); retryErr == nil {
-				logCache.Debugf("Retrying from GetStepsAsTasks after successful connection retry  ")
-				set, err = s.db.query("select stepdata from steps where flowinstanceid = '"+flowId+"'", nil)
-				if err != nil {
-					logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-					return nil, err
-				}
-			} else {
-				logCache.Errorf("Could not connect to database server error:, %s", retryErr.Error())
-				return nil, retryErr
-			}
-		} else {
-			logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-			return nil, err
-		}
+		return nil, err
 	}
 
 	var steps []*state.Step
@@ -908,7 +609,7 @@ func (s *StepStore) GetStepsAsTasks(flowId string) ([][]*task.Task, error) {
 	}
 
 	if len(steps) <= 0 {
-		return nil, fmt.Errorf("step for flow instance [%s] not found", flowId)
+		return nil, nil
 	}
 	var taskValueArray [][]*task.Task
 	for _, stepval := range steps {
@@ -927,32 +628,18 @@ func (s *StepStore) GetStepdataForActivity(flowId, stepid, taskname string) ([]*
 	if !s.db.dbDetails.Connected {
 		return nil, errors.New("Database is not connected")
 	}
-	query := "select stepdata from steps where flowinstanceid = '" + flowId + "' and stepid = '" + stepid + "'"
-	if taskname != "" {
-		query += " and taskname = '" + taskname + "'"
-	}
-	set, err := s.db.query(query, nil)
+
+	filter := NewFilter().
+		Equal("flowinstanceid", flowId).
+		Equal("stepid", stepid).
+		EqualIfSet("taskname", taskname)
+
+	querySql, args := Select("stepdata", "steps").Filter(filter).Build()
+	set, err := s.queryWithRetry("GetStepdataForActivity", querySql, args)
 	if err != nil {
-		if err == driver.ErrBadConn || strings.Contains(err.Error(), "connection refused") || strings.Contains(err.Error(), "network is unreachable") ||
-			strings.Contains(err.Error(), "connection reset by peer") || strings.Contains(err.Error(), "dial tcp: lookup") ||
-			strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "timedout") ||
-			strings.Contains(err.Error(), "timed out") || strings.Contains(err.Error(), "net.Error") || strings.Contains(err.Error(), "i/o timeout") {
-			if retryErr := s.RetryDBConnection(); retryErr == nil {
-				logCache.Debugf("Retrying from GetStepdataForActivity after successful connection retry  ")
-				set, err = s.db.query(query, nil)
-				if err != nil {
-					logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-					return nil, err
-				}
-			} else {
-				logCache.Errorf("Could not connect to database server error:, %s", retryErr.Error())
-				return nil, retryErr
-			}
-		} else {
-			logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-			return nil, err
-		}
+		return nil, err
 	}
+
 	var step *state.Step
 	for _, v := range set.Record {
 		m := *v
@@ -1016,28 +703,18 @@ func (s *StepStore) GetStepIdOfEnclosingCallSubflow(flowid, taskname, subflowid
 		return "", errors.New("Database is not connected")
 	}
 
-	set, err := s.db.query("select stepid from steps where taskname = '"+taskname+"' and flowinstanceid= '"+flowid+"' and subflowid= '"+subflowid+"' and status != 'Waiting'", nil)
+	filter := NewFilter().
+		Equal("taskname", taskname).
+		Equal("flowinstanceid", flowid).
+		Equal("subflowid", subflowid).
+		Where("status != 'Waiting'")
+
+	querySql, args := Select("stepid", "steps").Filter(filter).Build()
+	set, err := s.queryWithRetry("GetStepIdOfEnclosingCallSubflow", querySql, args)
 	if err != nil {
-		if err == driver.ErrBadConn || strings.Contains(err.Error(), "connection refused") || strings.Contains(err.Error(), "network is unreachable") ||
-			strings.Contains(err.Error(), "connection reset by peer") || strings.Contains(err.Error(), "dial tcp: lookup") ||
-			strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "timedout") ||
-			strings.Contains(err.Error(), "timed out") || strings.Contains(err.Error(), "net.Error") || strings.Contains(err.Error(), "i/o timeout") {
-			if retryErr := s.RetryDBConnection(); retryErr == nil {
-				logCache.Debugf("Retrying from GetStepIdOfEnclosingCallSubflow after successful connection retry  ")
-				set, err = s.db.query("select stepid from steps where taskname = '"+taskname+"' and flowinstanceid= '"+flowid+"' and subflowid= '"+subflowid+"' and status != 'Waiting'", nil)
-				if err != nil {
-					logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-					return "", err
-				}
-			} else {
-				logCache.Errorf("Could not connect to database server error:, %s", retryErr.Error())
-				return "", retryErr
-			}
-		} else {
-			logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-			return "", err
-		}
+		return "", err
 	}
+
 	var nextstepid string
 	for _, v := range set.Record {
 		m := *v
@@ -1052,29 +729,19 @@ func (s *StepStore) GetStepsStatus(flowId string) ([]map[string]string, error) {
 		return nil, errors.New("Database is not connected")
 	}
 
-	set, err := s.db.query("select stepid, taskname, status, starttime, flowname, rerun, subflowid from steps where flowinstanceid = '"+flowId+"' and stepid != '0' order by cast(stepid as integer)", nil)
+	filter := NewFilter().
+		Equal("flowinstanceid", flowId).
+		Where("stepid != '0'")
 
+	querySql, args := Select("stepid, taskname, status, starttime, flowname, rerun, subflowid", "steps").
+		Filter(filter).
+		OrderBy("cast(stepid as integer)").
+		Build()
+	set, err := s.queryWithRetry("GetStepsStatus", querySql, args)
 	if err != nil {
-		if err == driver.ErrBadConn || strings.Contains(err.Error(), "connection refused") || strings.Contains(err.Error(), "network is unreachable") ||
-			strings.Contains(err.Error(), "connection reset by peer") || strings.Contains(err.Error(), "dial tcp: lookup") ||
-			strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "timedout") ||
-			strings.Contains(err.Error(), "timed out") || strings.Contains(err.Error(), "net.Error") || strings.Contains(err.Error(), "i/o timeout") {
-			if retryErr := s.RetryDBConnection(); retryErr == nil {
-				logCache.Debugf("Retrying from GetStepsStatus after successful connection retry  ")
-				set, err = s.db.query("select stepid, taskname, status, starttime, flowname, rerun, subflowid from steps where flowinstanceid = '"+flowId+"' and stepid != '0' order by cast(stepid as integer)", nil)
-				if err != nil {
-					logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-					return nil, err
-				}
-			} else {
-				logCache.Errorf("Could not connect to database server error:, %s", retryErr.Error())
-				return nil, retryErr
-			}
-		} else {
-			logCache.Errorf("Could not connect to database server error:, %s", err.Error())
-			return nil, err
-		}
+		return nil, err
 	}
+
 	var waitingSteps []map[string]string
 	var steps []map[string]string
 OUTER:
@@ -1129,9 +796,9 @@ OUTER:
 }
 
 func (s *StepStore) Delete(flowId string) {
-	s.Lock()
-	delete(s.stepContainers, flowId)
-	s.Unlock()
+	if _, err := s.PurgeInstances([]string{flowId}); err != nil {
+		logCache.Errorf("Could not delete flow instance [%s], %s", flowId, err.Error())
+	}
 }
 
 type stepContainer struct {
@@ -1174,20 +841,7 @@ func (sc *stepContainer) Steps() []*state.Step {
 	return steps
 }
 
-func (s *StepStore) SaveSnapshot(snapshot *state.Snapshot) error {
-	//replaces existing snapshot
-	return nil
-}
-
-func (s *StepStore) GetSnapshot(flowId string) *state.Snapshot {
-	if snapshot, ok := s.snapshots.Load(flowId); ok {
-		return snapshot.(*state.Snapshot)
-	}
-	return nil
-}
-
 func (s *StepStore) RecordStart(flowState *state.FlowState) error {
-
 	if !s.db.dbDetails.Connected {
 		return errors.New("Database is not connected")
 	}
@@ -1208,11 +862,14 @@ func (s *StepStore) RecordStart(flowState *state.FlowState) error {
 			return retryErr
 		}
 	}
-	return err
+	if err != nil {
+		return err
+	}
+	event.PostStartEvent(flowState)
+	return nil
 }
 
 func (s *StepStore) RecordEnd(flowState *state.FlowState) error {
-
 	if !s.db.dbDetails.Connected {
 		return errors.New("Database is not connected")
 	}
@@ -1234,7 +891,11 @@ func (s *StepStore) RecordEnd(flowState *state.FlowState) error {
 			return retryErr
 		}
 	}
-	return err
+	if err != nil {
+		return err
+	}
+	event.PostEndEvent(flowState)
+	return nil
 }
 
 func (s *StepStore) RetryDBConnection() error {
@@ -1293,3 +954,36 @@ func (s *StepStore) RetryDBConnection() error {
 	}
 	return nil
 }
+
+// queryWithRetry runs a select and, on a connection failure, retries it once the connection is restored
+func (s *StepStore) queryWithRetry(caller string, querySql string, args []interface{}) (*ResultSet, error) {
+	set, err := s.db.query(querySql, args)
+	if err == nil {
+		return set, nil
+	}
+
+	if !isConnectionError(err) {
+		logCache.Errorf("Could not connect to database server error:, %s", err.Error())
+		return nil, err
+	}
+
+	if retryErr := s.RetryDBConnection(); retryErr != nil {
+		logCache.Errorf("Could not connect to database server error:, %s", retryErr.Error())
+		return nil, retryErr
+	}
+
+	logCache.Debugf("Retrying from %s after successful connection retry  ", caller)
+	set, err = s.db.query(querySql, args)
+	if err != nil {
+		logCache.Errorf("Could not connect to database server error:, %s", err.Error())
+		return nil, err
+	}
+	return set, nil
+}
+
+func isConnectionError(err error) bool {
+	return errors.Is(err, driver.ErrBadConn) || strings.Contains(err.Error(), "connection refused") || strings.Contains(err.Error(), "network is unreachable") ||
+		strings.Contains(err.Error(), "connection reset by peer") || strings.Contains(err.Error(), "dial tcp: lookup") ||
+		strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "timedout") ||
+		strings.Contains(err.Error(), "timed out") || strings.Contains(err.Error(), "net.Error") || strings.Contains(err.Error(), "i/o timeout")
+}
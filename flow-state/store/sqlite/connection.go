@@ -0,0 +1,122 @@
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/project-flogo/core/data/metadata"
+	"github.com/project-flogo/core/support/log"
+)
+
+var logCache = log.ChildLogger(log.RootLogger(), "sqlite.connection")
+
+const defaultBusyTimeout = 5000
+
+type sqliteConnection struct {
+	Path        string `md:"path"`
+	BusyTimeout int    `md:"busytimeout"`
+}
+
+// schema mirrors the postgres flowstate, steps, appstate and snapshopt tables
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS flowstate (
+		flowinstanceid TEXT PRIMARY KEY,
+		userid TEXT,
+		appname TEXT,
+		appversion TEXT,
+		flowname TEXT,
+		hostid TEXT,
+		starttime TIMESTAMP,
+		endtime TIMESTAMP,
+		executiontime NUMERIC,
+		status TEXT,
+		rerunofflowinstanceid TEXT,
+		flowinput BLOB,
+		flowoutput BLOB,
+		reruncount INTEGER DEFAULT 0
+	)`,
+	`CREATE INDEX IF NOT EXISTS flowstate_app_idx ON flowstate (userid, appname, appversion)`,
+	`CREATE TABLE IF NOT EXISTS steps (
+		flowinstanceid TEXT NOT NULL,
+		stepid TEXT NOT NULL,
+		taskname TEXT,
+		status TEXT,
+		starttime TIMESTAMP,
+		endtime TIMESTAMP,
+		stepdata BLOB,
+		subflowid TEXT,
+		flowname TEXT,
+		rerun BOOLEAN,
+		PRIMARY KEY (flowinstanceid, stepid)
+	)`,
+	`CREATE TABLE IF NOT EXISTS appstate (
+		userid TEXT NOT NULL,
+		appname TEXT NOT NULL,
+		persistenceenabled BOOLEAN,
+		PRIMARY KEY (userid, appname)
+	)`,
+	`CREATE TABLE IF NOT EXISTS snapshopt (
+		flowinstanceid TEXT PRIMARY KEY,
+		hostid TEXT,
+		stepid TEXT,
+		starttime TIMESTAMP,
+		endtime TIMESTAMP,
+		stepdata BLOB
+	)`,
+}
+
+// NewDB opens the sqlite database file and creates the schema if it does not exist yet
+func NewDB(settings map[string]interface{}) (*sql.DB, error) {
+	s := &sqliteConnection{}
+	err := metadata.MapToStruct(settings, s, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.Path == "" {
+		return nil, fmt.Errorf("Required Parameter Path is missing")
+	}
+	if s.BusyTimeout <= 0 {
+		s.BusyTimeout = defaultBusyTimeout
+	}
+
+	if s.Path != ":memory:" {
+		if dir := filepath.Dir(s.Path); dir != "" {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return nil, fmt.Errorf("Could not create database directory %s, %s", dir, err.Error())
+			}
+		}
+	}
+
+	dsn := fmt.Sprintf("file:%s?_busy_timeout=%d&_journal_mode=WAL&_synchronous=NORMAL", s.Path, s.BusyTimeout)
+	logCache.Debugf("Opening sqlite database %s", s.Path)
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open database %s, %s", s.Path, err.Error())
+	}
+	// sqlite allows a single writer, serialize access through one connection
+	db.SetMaxOpenConns(1)
+
+	if err = db.Ping(); err != nil {
+		return nil, fmt.Errorf("Could not open database %s, %s", s.Path, err.Error())
+	}
+
+	if err = createSchema(db); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func createSchema(db *sql.DB) error {
+	for _, ddl := range schema {
+		if _, err := db.Exec(ddl); err != nil {
+			return fmt.Errorf("Could not create database schema, %s", err.Error())
+		}
+	}
+	logCache.Debug("sqlite database schema is ready")
+	return nil
+}
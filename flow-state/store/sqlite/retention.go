@@ -0,0 +1,69 @@
+package sqlite
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/project-flogo/services/flow-state/retention"
+)
+
+func (s *StepStore) RetentionInstances(filter *retention.Filter) ([]*retention.Instance, error) {
+	where := &whereClause{}
+	where.addOptional("LOWER(status) = LOWER(?)", filter.Status)
+	where.addOptional("appname = ?", filter.App)
+	where.addOptional("flowname = ?", filter.Flow)
+
+	rows, err := s.db.Query("SELECT flowinstanceid, appname, flowname, status, starttime, endtime FROM flowstate"+where.String(), where.args...)
+	if err != nil {
+		logCache.Errorf("Could not query flow instances for retention, %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var instances []*retention.Instance
+	for rows.Next() {
+		var id, appName, flowName, status sql.NullString
+		var startTime, endTime sql.NullTime
+		if err := rows.Scan(&id, &appName, &flowName, &status, &startTime, &endTime); err != nil {
+			return nil, err
+		}
+		instance := &retention.Instance{FlowInstanceId: id.String, AppName: appName.String, FlowName: flowName.String, Status: status.String, Time: startTime.Time}
+		if endTime.Valid && !endTime.Time.IsZero() {
+			instance.Time = endTime.Time
+		} else {
+			instance.Running = true
+		}
+		instances = append(instances, instance)
+	}
+	return instances, rows.Err()
+}
+
+func (s *StepStore) PurgeInstances(flowIds []string) (int64, error) {
+	if len(flowIds) == 0 {
+		return 0, nil
+	}
+	args := make([]interface{}, len(flowIds))
+	for i, id := range flowIds {
+		args[i] = id
+	}
+	in := " WHERE flowinstanceid IN (?" + strings.Repeat(",?", len(flowIds)-1) + ")"
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	var rows int64
+	for _, table := range []string{"steps", "snapshopt", "flowstate"} {
+		result, err := tx.Exec("DELETE FROM "+table+in, args...)
+		if err != nil {
+			_ = tx.Rollback()
+			return 0, err
+		}
+		n, _ := result.RowsAffected()
+		rows += n
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return rows, nil
+}
@@ -0,0 +1,615 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/project-flogo/flow/state"
+	"github.com/project-flogo/services/flow-state/event"
+	"github.com/project-flogo/services/flow-state/store/metadata"
+	"github.com/project-flogo/services/flow-state/store/task"
+)
+
+const (
+	UpsertFlowState     = "INSERT INTO flowstate (flowinstanceid, userid, appname, appversion, flowname, hostid, flowinput, flowoutput, reruncount, starttime, endtime, status, rerunofflowinstanceid) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?) ON CONFLICT (flowinstanceid) DO UPDATE SET hostid = excluded.hostid, flowname = excluded.flowname, userid = excluded.userid, status = excluded.status, flowinput = excluded.flowinput, flowoutput = excluded.flowoutput, reruncount = excluded.reruncount, starttime = excluded.starttime, endtime = excluded.endtime"
+	UpdateFlowState     = "UPDATE flowstate SET endtime = ?, status = ?, flowoutput = ?, executiontime = ? WHERE flowinstanceid = ?"
+	IncrementRerunCount = "UPDATE flowstate SET reruncount = reruncount + 1 WHERE flowinstanceid = ?"
+
+	UpsertSteps = "INSERT INTO steps (flowinstanceid, stepid, taskname, status, starttime, endtime, stepdata, subflowid, flowname, rerun) VALUES (?,?,?,?,?,?,?,?,?,?) ON CONFLICT (flowinstanceid, stepid) DO UPDATE SET status = excluded.status, starttime = excluded.starttime, endtime = excluded.endtime, stepdata = excluded.stepdata"
+	DeleteSteps = "DELETE FROM steps WHERE flowinstanceid = ? AND CAST(stepid AS INTEGER) >= ?"
+
+	UpsertAppState = "INSERT INTO appstate (userid, appname, persistenceenabled) VALUES (?,?,?) ON CONFLICT (userid, appname) DO UPDATE SET persistenceenabled = excluded.persistenceenabled"
+	UpsertSnapshot = "INSERT INTO snapshopt (flowinstanceid, hostid, stepid, starttime, endtime, stepdata) VALUES (?,?,?,?,?,?) ON CONFLICT (flowinstanceid) DO UPDATE SET stepdata = excluded.stepdata, endtime = excluded.endtime"
+)
+
+func NewStore(settings map[string]interface{}) (*StepStore, error) {
+	db, err := NewDB(settings)
+	if err != nil {
+		return nil, err
+	}
+	return &StepStore{db: db, details: &DBDetails{Connected: true, TablesExists: true, Message: "Connected", Status: true}}, nil
+}
+
+type DBDetails struct {
+	Connected    bool   `json:"connected"`
+	TablesExists bool   `json:"tablesExists"`
+	Message      string `json:"message"`
+	Status       bool   `json:"status"`
+}
+
+// StepStore is a store.Store backed by a local sqlite database file
+type StepStore struct {
+	db      *sql.DB
+	details *DBDetails
+}
+
+func (s *StepStore) Status() interface{} {
+	if err := s.db.Ping(); err != nil {
+		s.details.Status = false
+		s.details.Message = err.Error()
+	} else {
+		s.details.Status = true
+		s.details.Message = "Connected"
+	}
+	return s.details
+}
+
+func (s *StepStore) MaxConcurrencyLimit() int {
+	// sqlite serializes writers, more workers only contend on the database lock
+	return 1
+}
+
+func (s *StepStore) GetStatus(flowId string) int {
+	steps, err := s.GetSteps(flowId)
+	if err != nil || len(steps) == 0 {
+		return -1
+	}
+
+	status := 0
+	for _, step := range steps {
+		if len(step.FlowChanges) > 0 {
+			if change := step.FlowChanges[0]; change != nil && change.SubflowId == 0 && change.Status != -1 {
+				status = change.Status
+			}
+		}
+	}
+	return status
+}
+
+func (s *StepStore) GetFlow(flowId string, mtdata *metadata.Metadata) (*state.FlowInfo, error) {
+	where := &whereClause{}
+	where.add("flowinstanceid = ?", flowId)
+	where.addOptional("userid = ?", mtdata.Username)
+	where.addOptional("appname = ?", mtdata.AppName)
+	where.addOptional("appversion = ?", mtdata.AppVersion)
+	where.addOptional("hostid = ?", mtdata.HostId)
+
+	row := s.db.QueryRow("SELECT flowinstanceid, flowname, status, flowinput FROM flowstate"+where.String(), where.args...)
+
+	var id, flowName, status sql.NullString
+	var flowInput []byte
+	if err := row.Scan(&id, &flowName, &status, &flowInput); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		logCache.Errorf("Could not query flow details, %s", err.Error())
+		return nil, err
+	}
+
+	var inputs map[string]interface{}
+	if len(flowInput) > 0 {
+		if err := json.Unmarshal(flowInput, &inputs); err != nil {
+			return nil, err
+		}
+	}
+
+	return &state.FlowInfo{
+		Id:         id.String,
+		FlowName:   flowName.String,
+		FlowStatus: status.String,
+		FlowURI:    "res://flow:" + flowName.String,
+		FlowInputs: inputs,
+	}, nil
+}
+
+func (s *StepStore) GetFlows(mtdata *metadata.Metadata) ([]*state.FlowInfo, error) {
+	where := appFilter(mtdata)
+	where.addOptional("status = ?", mtdata.Status)
+	return s.listFlows(where, "", mtdata)
+}
+
+func (s *StepStore) GetFailedFlows(mtdata *metadata.Metadata) ([]*state.FlowInfo, error) {
+	where := appFilter(mtdata)
+	where.add("status = ?", "Failed")
+	return s.listFlows(where, "", nil)
+}
+
+func (s *StepStore) GetCompletedFlows(mtdata *metadata.Metadata) ([]*state.FlowInfo, error) {
+	where := appFilter(mtdata)
+	where.add("status = ?", "Completed")
+	return s.listFlows(where, "", nil)
+}
+
+func (s *StepStore) GetFlowsWithRecordCount(mtdata *metadata.Metadata) (*metadata.FlowRecord, error) {
+	where := appFilter(mtdata)
+	where.addOptional("status = ?", mtdata.Status)
+	if len(mtdata.FlowInstanceId) > 0 {
+		where.add("(flowinstanceid = ? OR rerunofflowinstanceid = ?)", mtdata.FlowInstanceId, mtdata.FlowInstanceId)
+	}
+	if len(mtdata.Interval) > 0 {
+		interval, err := metadata.ParseInterval(mtdata.Interval)
+		if err != nil {
+			return nil, err
+		}
+		where.add("starttime >= ?", time.Now().UTC().Add(-interval))
+	}
+	if len(mtdata.StartTime) > 0 && len(mtdata.EndTime) > 0 {
+		start, err := metadata.ParseTime(mtdata.StartTime)
+		if err != nil {
+			return nil, err
+		}
+		end, err := metadata.ParseTime(mtdata.EndTime)
+		if err != nil {
+			return nil, err
+		}
+		where.add("starttime >= ? AND starttime <= ?", start, end)
+	}
+
+	var count int32
+	row := s.db.QueryRow("SELECT count(*) FROM flowstate"+where.String(), where.args...)
+	if err := row.Scan(&count); err != nil {
+		logCache.Errorf("Could not count flow instances, %s", err.Error())
+		return nil, err
+	}
+
+	flows, err := s.listFlows(where, " ORDER BY starttime DESC", mtdata)
+	if err != nil {
+		return nil, err
+	}
+	return &metadata.FlowRecord{Count: count, FlowData: flows}, nil
+}
+
+func (s *StepStore) listFlows(where *whereClause, orderBy string, page *metadata.Metadata) ([]*state.FlowInfo, error) {
+	query := "SELECT flowinstanceid, flowname, status, hostid, starttime, endtime, executiontime, rerunofflowinstanceid, reruncount, flowinput FROM flowstate" + where.String() + orderBy
+	args := where.args
+	if page != nil && len(page.Offset) > 0 && len(page.Limit) > 0 {
+		offset, err := strconv.Atoi(page.Offset)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("invalid offset [%s]", page.Offset)
+		}
+		limit, err := strconv.Atoi(page.Limit)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid limit [%s]", page.Limit)
+		}
+		query += " LIMIT ? OFFSET ?"
+		args = append(args[:len(args):len(args)], limit, offset)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		logCache.Errorf("Could not query flow instances, %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var flowinfo []*state.FlowInfo
+	for rows.Next() {
+		var id, flowName, status, hostId, originalId sql.NullString
+		var startTime, endTime sql.NullTime
+		var executionTime sql.NullFloat64
+		var rerunCount sql.NullInt64
+		var flowInput []byte
+		if err := rows.Scan(&id, &flowName, &status, &hostId, &startTime, &endTime, &executionTime, &originalId, &rerunCount, &flowInput); err != nil {
+			return nil, err
+		}
+
+		info := &state.FlowInfo{
+			Id:                 id.String,
+			FlowName:           flowName.String,
+			HostId:             hostId.String,
+			FlowStatus:         status.String,
+			StartTime:          formatTime(startTime),
+			EndTime:            formatTime(endTime),
+			OriginalInstanceId: originalId.String,
+			RerunCount:         int(rerunCount.Int64),
+		}
+		if executionTime.Valid {
+			info.ExecutionTime = strconv.FormatFloat(executionTime.Float64, 'f', -1, 64)
+		}
+		if flowInput != nil {
+			info.FlowInputs = make(map[string]interface{})
+		}
+		flowinfo = append(flowinfo, info)
+	}
+	return flowinfo, rows.Err()
+}
+
+func (s *StepStore) SaveStep(step *state.Step) error {
+	tasks, err := task.StepToTask(step)
+	if err != nil {
+		return err
+	}
+	if len(tasks) == 0 {
+		return fmt.Errorf("No Tasks Found")
+	}
+
+	flowname := tasks[0].Flowname
+	if strings.Contains(flowname, ":") {
+		flowname = flowname[strings.LastIndex(flowname, ":")+1:]
+	}
+
+	stepData, err := json.Marshal(step)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(UpsertSteps, step.FlowId, strconv.Itoa(step.Id), tasks[0].Id, string(tasks[0].Status), step.StartTime.UTC(), step.EndTime.UTC(),
+		stepData, strconv.Itoa(tasks[0].SubflowId), flowname, step.Rerun)
+	if err != nil {
+		logCache.Errorf("Could not save step, %s", err.Error())
+		return err
+	}
+	event.PostStepEvent(step)
+	return nil
+}
+
+func (s *StepStore) GetSteps(flowId string) ([]*state.Step, error) {
+	rows, err := s.db.Query("SELECT stepdata FROM steps WHERE flowinstanceid = ? ORDER BY CAST(stepid AS INTEGER)", flowId)
+	if err != nil {
+		logCache.Errorf("Could not query steps, %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var steps []*state.Step
+	for rows.Next() {
+		var stepData []byte
+		if err := rows.Scan(&stepData); err != nil {
+			return nil, err
+		}
+		var step *state.Step
+		if err := json.Unmarshal(stepData, &step); err != nil {
+			logCache.Errorf("Marshalling error:, %s", err.Error())
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, rows.Err()
+}
+
+func (s *StepStore) GetStepsAsTasks(flowId string) ([][]*task.Task, error) {
+	steps, err := s.GetSteps(flowId)
+	if err != nil || steps == nil {
+		return nil, err
+	}
+
+	var taskValueArray [][]*task.Task
+	for _, step := range steps {
+		taskValue, err := task.StepToTask(step)
+		if err != nil {
+			return nil, err
+		}
+		taskValueArray = append(taskValueArray, taskValue)
+	}
+	return taskValueArray, nil
+}
+
+func (s *StepStore) GetStepdataForActivity(flowId, stepid, taskname string) ([]*task.Task, error) {
+	where := &whereClause{}
+	where.add("flowinstanceid = ?", flowId)
+	where.add("stepid = ?", stepid)
+	where.addOptional("taskname = ?", taskname)
+
+	var stepData []byte
+	err := s.db.QueryRow("SELECT stepdata FROM steps"+where.String(), where.args...).Scan(&stepData)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("No step data found for matching input")
+		}
+		return nil, err
+	}
+
+	var step *state.Step
+	if err = json.Unmarshal(stepData, &step); err != nil {
+		return nil, err
+	}
+	taskValue, err := task.StepToTask(step)
+	if err != nil {
+		return nil, err
+	}
+
+	// a waiting callsubflow task has its output recorded on the step that completed it
+	if len(taskValue) == 2 && strings.EqualFold(string(taskValue[0].Status), "waiting") {
+		nextStepId, err := s.getStepIdOfEnclosingCallSubflow(flowId, taskValue[0].Id, strconv.Itoa(taskValue[0].SubflowId))
+		if err != nil {
+			return nil, err
+		}
+		if nextStepId != "" {
+			taskArray, err := s.GetStepdataForActivity(flowId, nextStepId, taskValue[0].Id)
+			if err != nil {
+				return nil, err
+			}
+			taskArray[0].StepId, _ = strconv.Atoi(stepid)
+			return taskArray, nil
+		}
+	}
+	return taskValue, nil
+}
+
+func (s *StepStore) getStepIdOfEnclosingCallSubflow(flowId, taskname, subflowid string) (string, error) {
+	var nextStepId string
+	err := s.db.QueryRow("SELECT stepid FROM steps WHERE taskname = ? AND flowinstanceid = ? AND subflowid = ? AND status != 'Waiting' ORDER BY CAST(stepid AS INTEGER) DESC LIMIT 1",
+		taskname, flowId, subflowid).Scan(&nextStepId)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return nextStepId, err
+}
+
+func (s *StepStore) GetStepsStatus(flowId string) ([]map[string]string, error) {
+	rows, err := s.db.Query("SELECT stepid, taskname, status, starttime, flowname, rerun, subflowid FROM steps WHERE flowinstanceid = ? AND stepid != '0' ORDER BY CAST(stepid AS INTEGER)", flowId)
+	if err != nil {
+		logCache.Errorf("Could not query steps status, %s", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var steps []map[string]string
+	var waitingSteps []map[string]string
+OUTER:
+	for rows.Next() {
+		var stepId, taskName, status, flowName, subflowId sql.NullString
+		var startTime sql.NullTime
+		var rerun sql.NullBool
+		if err := rows.Scan(&stepId, &taskName, &status, &startTime, &flowName, &rerun, &subflowId); err != nil {
+			return nil, err
+		}
+
+		stepData := map[string]string{
+			"stepId":    stepId.String,
+			"status":    status.String,
+			"taskName":  taskName.String,
+			"flowname":  flowName.String,
+			"rerun":     strconv.FormatBool(rerun.Bool),
+			"subflowid": subflowId.String,
+			"starttime": formatTime(startTime),
+		}
+
+		// merge the completion of a callsubflow task into its earlier waiting entry
+		if strings.EqualFold(status.String, "completed") || strings.EqualFold(status.String, "failed") {
+			for i, waitingStep := range waitingSteps {
+				if waitingStep["taskName"] == taskName.String && waitingStep["subflowid"] == subflowId.String {
+					waitingStep["status"] = status.String
+					waitingSteps = append(waitingSteps[:i], waitingSteps[i+1:]...)
+					continue OUTER
+				}
+			}
+		}
+
+		steps = append(steps, stepData)
+		if strings.EqualFold(status.String, "waiting") {
+			waitingSteps = append(waitingSteps, stepData)
+		}
+	}
+	return steps, rows.Err()
+}
+
+func (s *StepStore) GetFlowNames(mtdata *metadata.Metadata) ([]string, error) {
+	where := &whereClause{}
+	where.addOptional("userid = ?", mtdata.Username)
+	where.addOptional("appname = ?", mtdata.AppName)
+	where.addOptional("appversion = ?", mtdata.AppVersion)
+	where.addOptional("hostid = ?", mtdata.HostId)
+	return s.distinct("flowname", where)
+}
+
+func (s *StepStore) GetAppVersions(mtdata *metadata.Metadata) ([]string, error) {
+	where := &whereClause{}
+	where.addOptional("userid = ?", mtdata.Username)
+	where.addOptional("appname = ?", mtdata.AppName)
+	return s.distinct("appversion", where)
+}
+
+func (s *StepStore) distinct(column string, where *whereClause) ([]string, error) {
+	rows, err := s.db.Query("SELECT DISTINCT "+column+" FROM flowstate"+where.String()+" ORDER BY "+column, where.args...)
+	if err != nil {
+		logCache.Errorf("Could not query %s, %s", column, err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value sql.NullString
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value.String)
+	}
+	return values, rows.Err()
+}
+
+func (s *StepStore) GetAppState(mtdata *metadata.Metadata) (string, error) {
+	where := &whereClause{}
+	where.addOptional("userid = ?", mtdata.Username)
+	where.addOptional("appname = ?", mtdata.AppName)
+
+	var enabled sql.NullBool
+	err := s.db.QueryRow("SELECT persistenceenabled FROM appstate"+where.String(), where.args...).Scan(&enabled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", err
+	}
+	return strconv.FormatBool(enabled.Bool), nil
+}
+
+func (s *StepStore) SaveAppState(mtdata *metadata.Metadata) error {
+	_, err := s.db.Exec(UpsertAppState, mtdata.Username, mtdata.AppName, mtdata.PersistEnabled)
+	return err
+}
+
+func (s *StepStore) Delete(flowId string) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		logCache.Errorf("Could not delete flow instance [%s], %s", flowId, err.Error())
+		return
+	}
+	for _, table := range []string{"steps", "snapshopt", "flowstate"} {
+		if _, err = tx.Exec("DELETE FROM "+table+" WHERE flowinstanceid = ?", flowId); err != nil {
+			_ = tx.Rollback()
+			logCache.Errorf("Could not delete flow instance [%s], %s", flowId, err.Error())
+			return
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		logCache.Errorf("Could not delete flow instance [%s], %s", flowId, err.Error())
+	}
+}
+
+func (s *StepStore) GetFlowOwner(flowId string) (*metadata.Owner, error) {
+	var userId, appName, appVersion sql.NullString
+	err := s.db.QueryRow("SELECT userid, appname, appversion FROM flowstate WHERE flowinstanceid = ?", flowId).Scan(&userId, &appName, &appVersion)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &metadata.Owner{Username: userId.String, AppName: appName.String, AppVersion: appVersion.String}, nil
+}
+
+func (s *StepStore) SaveSnapshot(snapshot *state.Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	_, err = s.db.Exec(UpsertSnapshot, snapshot.Id, "", "", now, now, data)
+	return err
+}
+
+func (s *StepStore) GetSnapshot(flowId string) *state.Snapshot {
+	var data []byte
+	err := s.db.QueryRow("SELECT stepdata FROM snapshopt WHERE flowinstanceid = ?", flowId).Scan(&data)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			logCache.Errorf("Could not query snapshot for [%s], %s", flowId, err.Error())
+		}
+		return nil
+	}
+
+	snapshot := &state.Snapshot{SnapshotBase: &state.SnapshotBase{}}
+	if err = json.Unmarshal(data, snapshot); err != nil {
+		logCache.Errorf("Could not unmarshal snapshot for [%s], %s", flowId, err.Error())
+		return nil
+	}
+	return snapshot
+}
+
+func (s *StepStore) RecordStart(flowState *state.FlowState) error {
+	if flowState.FlowInputs == nil {
+		flowState.FlowInputs = make(map[string]interface{})
+	}
+	flowInputs, err := json.Marshal(flowState.FlowInputs)
+	if err != nil {
+		return err
+	}
+
+	if flowState.OriginalInstanceId != "" {
+		if _, err = s.db.Exec(IncrementRerunCount, flowState.OriginalInstanceId); err != nil {
+			return err
+		}
+	}
+
+	_, err = s.db.Exec(UpsertFlowState, flowState.FlowInstanceId, flowState.UserId, flowState.AppName, flowState.AppVersion, flowState.FlowName, flowState.HostId,
+		flowInputs, nil, flowState.RerunCount, flowState.StartTime.UTC(), flowState.EndTime.UTC(), flowState.FlowStats, flowState.OriginalInstanceId)
+	if err != nil {
+		return err
+	}
+	event.PostStartEvent(flowState)
+	return nil
+}
+
+func (s *StepStore) RecordEnd(flowState *state.FlowState) error {
+	var flowOutputs []byte
+	if flowState.FlowOutputs != nil {
+		flowOutputs, _ = json.Marshal(flowState.FlowOutputs)
+	}
+
+	var executionTime interface{}
+	var startTime sql.NullTime
+	err := s.db.QueryRow("SELECT starttime FROM flowstate WHERE flowinstanceid = ?", flowState.FlowInstanceId).Scan(&startTime)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if startTime.Valid {
+		executionTime = float64(flowState.EndTime.Sub(startTime.Time).Microseconds()) / 1000
+	}
+
+	_, err = s.db.Exec(UpdateFlowState, flowState.EndTime.UTC(), flowState.FlowStats, flowOutputs, executionTime, flowState.FlowInstanceId)
+	if err != nil {
+		return err
+	}
+	event.PostEndEvent(flowState)
+	return nil
+}
+
+func (s *StepStore) DeleteSteps(flowId string, stepId string) error {
+	intStepId, err := strconv.Atoi(stepId)
+	if err != nil {
+		return fmt.Errorf("Error while converting stepid to Int: %s", err.Error())
+	}
+	_, err = s.db.Exec(DeleteSteps, flowId, intStepId)
+	return err
+}
+
+// Close closes the underlying database
+func (s *StepStore) Close() error {
+	return s.db.Close()
+}
+
+func appFilter(mtdata *metadata.Metadata) *whereClause {
+	where := &whereClause{}
+	where.add("userid = ?", mtdata.Username)
+	where.add("appname = ?", mtdata.AppName)
+	where.add("appversion = ?", mtdata.AppVersion)
+	where.addOptional("hostid = ?", mtdata.HostId)
+	where.addOptional("flowname = ?", mtdata.FlowName)
+	return where
+}
+
+type whereClause struct {
+	conditions []string
+	args       []interface{}
+}
+
+func (w *whereClause) add(condition string, args ...interface{}) {
+	w.conditions = append(w.conditions, condition)
+	w.args = append(w.args, args...)
+}
+
+func (w *whereClause) addOptional(condition string, value string) {
+	if len(value) > 0 {
+		w.add(condition, value)
+	}
+}
+
+func (w *whereClause) String() string {
+	if len(w.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(w.conditions, " AND ")
+}
+
+func formatTime(t sql.NullTime) string {
+	if !t.Valid {
+		return ""
+	}
+	return t.Time.String()
+}
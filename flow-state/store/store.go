@@ -3,9 +3,12 @@ package store
 import (
 	"fmt"
 	"github.com/project-flogo/flow/state"
+	"github.com/project-flogo/services/flow-state/store/dynamodb"
+	"github.com/project-flogo/services/flow-state/store/file"
 	"github.com/project-flogo/services/flow-state/store/mem"
 	"github.com/project-flogo/services/flow-state/store/metadata"
 	"github.com/project-flogo/services/flow-state/store/postgres"
+	"github.com/project-flogo/services/flow-state/store/sqlite"
 	"github.com/project-flogo/services/flow-state/store/task"
 )
 
@@ -16,12 +19,14 @@ const (
 	CosmosDB   = "cosmosdb"
 	RestServer = "REST"
 	Postgres   = "postgres"
+	Sqlite     = "sqlite"
 )
 
 type Store interface {
 	MaxConcurrencyLimit() int
 	Status() interface{}
 	GetStatus(flowId string) int
+	// GetFlow returns the recorded flow state, or the state known from the steps when the start was not recorded
 	GetFlow(flowId string, metadata *metadata.Metadata) (*state.FlowInfo, error)
 	GetFlows(metadata *metadata.Metadata) ([]*state.FlowInfo, error)
 	GetFailedFlows(metadata *metadata.Metadata) ([]*state.FlowInfo, error)
@@ -44,6 +49,12 @@ type Store interface {
 	DeleteSteps(flowId string, stepId string) error
 }
 
+// OwnerStore is implemented by stores that know the user and app of a flow instance,
+// GetFlowOwner returns nil when the flow instance was not started
+type OwnerStore interface {
+	GetFlowOwner(flowId string) (*metadata.Owner, error)
+}
+
 //type SnapshotStore interface {
 //	GetStatus(flowId string) int
 //	GetFlow(flowId string) *state.FlowInfo
@@ -76,10 +87,34 @@ func InitStorage(settings map[string]interface{}) error {
 		if err != nil {
 			return err
 		}
+	case Sqlite:
+		fmt.Println("Store type is: Sqlite")
+		var err error
+		store, err = sqlite.NewStore(settings)
+		if err != nil {
+			return err
+		}
+	case DynamoDB:
+		fmt.Println("Store type is: DynamoDB")
+		var err error
+		store, err = dynamodb.NewStore(settings)
+		if err != nil {
+			return err
+		}
+	case File:
+		fmt.Println("Store type is: File")
+		var err error
+		store, err = file.NewStore(settings)
+		if err != nil {
+			return err
+		}
 	case Memory:
 		fmt.Println("Store type is: Memory")
-
-		store = mem.NewStore()
+		var err error
+		store, err = mem.NewBoundedStore(settings)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
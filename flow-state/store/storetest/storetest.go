@@ -0,0 +1,504 @@
+// Package storetest checks a store.Store implementation against the contract the state service relies on
+package storetest
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/project-flogo/flow/model"
+	"github.com/project-flogo/flow/state"
+	"github.com/project-flogo/flow/state/change"
+	"github.com/project-flogo/services/flow-state/event"
+	"github.com/project-flogo/services/flow-state/store"
+	"github.com/project-flogo/services/flow-state/store/batch"
+	"github.com/project-flogo/services/flow-state/store/metadata"
+)
+
+const (
+	user    = "storetest"
+	app     = "orders"
+	version = "1.0"
+)
+
+// Factory returns an empty store, it is called once per test
+type Factory func(t *testing.T) store.Store
+
+// Run runs the conformance tests against the stores created by newStore
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s store.Store)
+	}{
+		{"Lifecycle", testLifecycle},
+		{"Steps", testSteps},
+		{"DeleteSteps", testDeleteSteps},
+		{"Snapshots", testSnapshots},
+		{"Delete", testDelete},
+		{"Listing", testListing},
+		{"Rerun", testRerun},
+		{"AppState", testAppState},
+		{"Concurrency", testConcurrency},
+		{"Events", testEvents},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStore(t))
+		})
+	}
+}
+
+// base is the start time of the instances, truncated so every backend stores it without loss
+var base = time.Now().UTC().Add(-time.Hour).Truncate(time.Millisecond)
+
+func flowState(id, flowName string, started time.Time) *state.FlowState {
+	return &state.FlowState{
+		FlowInstanceId: id,
+		UserId:         user,
+		AppName:        app,
+		AppVersion:     version,
+		FlowName:       flowName,
+		HostId:         "host",
+		FlowStats:      "Active",
+		StartTime:      started,
+		FlowInputs:     map[string]interface{}{"order": id},
+	}
+}
+
+func step(flowId string, id int, flowStatus model.FlowStatus, taskId string) *state.Step {
+	return &state.Step{
+		Id:        id,
+		FlowId:    flowId,
+		StartTime: base.Add(time.Duration(id) * time.Second),
+		EndTime:   base.Add(time.Duration(id) * time.Second),
+		FlowChanges: map[int]*change.Flow{
+			0: {
+				FlowURI: "res://flow:create",
+				TaskId:  taskId,
+				Status:  int(flowStatus),
+				Tasks:   map[string]*change.Task{taskId: {Status: int(model.TaskStatusDone)}},
+			},
+		},
+	}
+}
+
+func appMetadata() *metadata.Metadata {
+	return &metadata.Metadata{Username: user, AppName: app, AppVersion: version}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func stepIds(steps []*state.Step) []int {
+	ids := make([]int, len(steps))
+	for i, s := range steps {
+		ids[i] = s.Id
+	}
+	return ids
+}
+
+func flowIds(flows []*state.FlowInfo) []string {
+	ids := make([]string, len(flows))
+	for i, f := range flows {
+		ids[i] = f.Id
+	}
+	return ids
+}
+
+func equal(a, b interface{}) bool {
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+func testLifecycle(t *testing.T, s store.Store) {
+	if info, err := s.GetFlow("missing", appMetadata()); err != nil || info != nil {
+		t.Fatalf("GetFlow of an unknown instance: expected nil, nil, got %v, %v", info, err)
+	}
+
+	must(t, s.RecordStart(flowState("a", "create", base)))
+	info, err := s.GetFlow("a", appMetadata())
+	must(t, err)
+	if info == nil || info.Id != "a" || info.FlowName != "create" || info.FlowStatus != "Active" {
+		t.Fatalf("GetFlow after RecordStart: unexpected %+v", info)
+	}
+	if info.FlowInputs["order"] != "a" {
+		t.Fatalf("GetFlow: expected the flow inputs, got %v", info.FlowInputs)
+	}
+
+	if owners, ok := s.(store.OwnerStore); ok {
+		owner, err := owners.GetFlowOwner("a")
+		must(t, err)
+		if owner == nil || owner.Username != user || owner.AppName != app || owner.AppVersion != version {
+			t.Fatalf("GetFlowOwner: unexpected %+v", owner)
+		}
+		if owner, err = owners.GetFlowOwner("missing"); err != nil || owner != nil {
+			t.Fatalf("GetFlowOwner of an unknown instance: expected nil, nil, got %v, %v", owner, err)
+		}
+	}
+
+	end := flowState("a", "create", base)
+	end.FlowStats = "Completed"
+	end.EndTime = base.Add(1500 * time.Millisecond)
+	end.FlowOutputs = map[string]interface{}{"ok": true}
+	must(t, s.RecordEnd(end))
+
+	info, err = s.GetFlow("a", appMetadata())
+	must(t, err)
+	if info == nil || info.FlowStatus != "Completed" {
+		t.Fatalf("GetFlow after RecordEnd: unexpected %+v", info)
+	}
+
+	record, err := s.GetFlowsWithRecordCount(appMetadata())
+	must(t, err)
+	if record == nil || record.Count != 1 || len(record.FlowData) != 1 {
+		t.Fatalf("GetFlowsWithRecordCount: unexpected %+v", record)
+	}
+	if flow := record.FlowData[0]; flow.FlowStatus != "Completed" || flow.HostId != "host" || flow.StartTime == "" || flow.EndTime == "" || flow.ExecutionTime == "" {
+		t.Fatalf("GetFlowsWithRecordCount: unexpected %+v", flow)
+	}
+}
+
+func testSteps(t *testing.T, s store.Store) {
+	if steps, err := s.GetSteps("missing"); err != nil || steps != nil {
+		t.Fatalf("GetSteps of an unknown instance: expected nil, nil, got %v, %v", steps, err)
+	}
+	if status := s.GetStatus("missing"); status != -1 {
+		t.Fatalf("GetStatus of an unknown instance: expected -1, got %d", status)
+	}
+
+	must(t, s.RecordStart(flowState("a", "create", base)))
+	must(t, s.SaveStep(step("a", 1, model.FlowStatusActive, "log")))
+	must(t, s.SaveStep(step("a", 3, model.FlowStatusActive, "reply")))
+	must(t, s.SaveStep(step("a", 2, model.FlowStatusActive, "map")))
+	// a step saved again replaces the earlier one
+	must(t, s.SaveStep(step("a", 2, model.FlowStatusCompleted, "map")))
+
+	steps, err := s.GetSteps("a")
+	must(t, err)
+	if !equal(stepIds(steps), []int{1, 2, 3}) {
+		t.Fatalf("GetSteps: expected steps 1, 2, 3 in order, got %v", stepIds(steps))
+	}
+	if status := steps[1].FlowChanges[0].Status; status != int(model.FlowStatusCompleted) {
+		t.Fatalf("GetSteps: expected the replaced step, got flow status %d", status)
+	}
+	if status := s.GetStatus("a"); status < int(model.FlowStatusActive) {
+		t.Fatalf("GetStatus: expected the flow status from the steps, got %d", status)
+	}
+
+	tasks, err := s.GetStepsAsTasks("a")
+	must(t, err)
+	if len(tasks) != 3 || len(tasks[0]) == 0 || tasks[0][0].Id != "log" || tasks[2][0].Id != "reply" {
+		t.Fatalf("GetStepsAsTasks: unexpected %v", tasks)
+	}
+
+	status, err := s.GetStepsStatus("a")
+	must(t, err)
+	if len(status) != 3 || status[0]["stepId"] != "1" || status[0]["taskName"] != "log" || status[0]["flowname"] != "create" || status[2]["stepId"] != "3" {
+		t.Fatalf("GetStepsStatus: unexpected %v", status)
+	}
+
+	data, err := s.GetStepdataForActivity("a", "2", "map")
+	must(t, err)
+	if len(data) != 1 || data[0].Id != "map" || data[0].StepId != 2 {
+		t.Fatalf("GetStepdataForActivity: unexpected %v", data)
+	}
+	if _, err = s.GetStepdataForActivity("a", "9", ""); err == nil {
+		t.Fatal("GetStepdataForActivity of an unknown step: expected an error")
+	}
+}
+
+func testDeleteSteps(t *testing.T, s store.Store) {
+	must(t, s.RecordStart(flowState("a", "create", base)))
+	for id := 1; id <= 12; id++ {
+		must(t, s.SaveStep(step("a", id, model.FlowStatusActive, "log")))
+	}
+
+	// step ids are compared as numbers, step 10 comes after step 2
+	must(t, s.DeleteSteps("a", "2"))
+	steps, err := s.GetSteps("a")
+	must(t, err)
+	if !equal(stepIds(steps), []int{1}) {
+		t.Fatalf("DeleteSteps: expected only step 1 left, got %v", stepIds(steps))
+	}
+
+	// the rerun records the steps again from the deleted one
+	must(t, s.SaveStep(step("a", 2, model.FlowStatusActive, "log")))
+	steps, err = s.GetSteps("a")
+	must(t, err)
+	if !equal(stepIds(steps), []int{1, 2}) {
+		t.Fatalf("SaveStep after DeleteSteps: expected steps 1, 2, got %v", stepIds(steps))
+	}
+
+	if err = s.DeleteSteps("a", "x"); err == nil {
+		t.Fatal("DeleteSteps with an invalid step id: expected an error")
+	}
+}
+
+func testSnapshots(t *testing.T, s store.Store) {
+	if snapshot := s.GetSnapshot("missing"); snapshot != nil {
+		t.Fatalf("GetSnapshot of an unknown instance: expected nil, got %+v", snapshot)
+	}
+
+	snapshot := &state.Snapshot{SnapshotBase: &state.SnapshotBase{FlowURI: "res://flow:create", Status: int(model.FlowStatusActive)}, Id: "a"}
+	must(t, s.SaveSnapshot(snapshot))
+	snapshot = &state.Snapshot{SnapshotBase: &state.SnapshotBase{FlowURI: "res://flow:create", Status: int(model.FlowStatusCompleted)}, Id: "a"}
+	must(t, s.SaveSnapshot(snapshot))
+
+	saved := s.GetSnapshot("a")
+	if saved == nil || saved.Id != "a" || saved.SnapshotBase == nil || saved.Status != int(model.FlowStatusCompleted) {
+		t.Fatalf("GetSnapshot: expected the latest snapshot, got %+v", saved)
+	}
+}
+
+func testDelete(t *testing.T, s store.Store) {
+	must(t, s.RecordStart(flowState("a", "create", base)))
+	must(t, s.SaveStep(step("a", 1, model.FlowStatusActive, "log")))
+	must(t, s.SaveSnapshot(&state.Snapshot{SnapshotBase: &state.SnapshotBase{}, Id: "a"}))
+	must(t, s.RecordStart(flowState("b", "create", base)))
+
+	s.Delete("a")
+
+	if steps, err := s.GetSteps("a"); err != nil || steps != nil {
+		t.Fatalf("GetSteps after Delete: expected nil, nil, got %v, %v", steps, err)
+	}
+	if snapshot := s.GetSnapshot("a"); snapshot != nil {
+		t.Fatal("GetSnapshot after Delete: expected nil")
+	}
+	if info, err := s.GetFlow("a", appMetadata()); err != nil || info != nil {
+		t.Fatalf("GetFlow after Delete: expected nil, nil, got %v, %v", info, err)
+	}
+	if info, err := s.GetFlow("b", appMetadata()); err != nil || info == nil {
+		t.Fatalf("GetFlow of another instance after Delete: unexpected %v, %v", info, err)
+	}
+}
+
+func testListing(t *testing.T, s store.Store) {
+	for i, id := range []string{"a", "b", "c", "d", "e"} {
+		flowName := "create"
+		if i%2 == 1 {
+			flowName = "cancel"
+		}
+		must(t, s.RecordStart(flowState(id, flowName, base.Add(time.Duration(i)*time.Minute))))
+	}
+	other := flowState("other", "create", base)
+	other.UserId = "someone"
+	must(t, s.RecordStart(other))
+	for id, status := range map[string]string{"a": "Completed", "b": "Failed", "c": "Completed"} {
+		end := flowState(id, "", base)
+		end.FlowStats = status
+		end.EndTime = base.Add(10 * time.Minute)
+		must(t, s.RecordEnd(end))
+	}
+
+	record, err := s.GetFlowsWithRecordCount(appMetadata())
+	must(t, err)
+	if record.Count != 5 || !equal(flowIds(record.FlowData), []string{"e", "d", "c", "b", "a"}) {
+		t.Fatalf("GetFlowsWithRecordCount: expected the instances of the app newest first, got %d %v", record.Count, flowIds(record.FlowData))
+	}
+
+	mtdata := appMetadata()
+	mtdata.Offset, mtdata.Limit = "1", "2"
+	record, err = s.GetFlowsWithRecordCount(mtdata)
+	must(t, err)
+	if record.Count != 5 || !equal(flowIds(record.FlowData), []string{"d", "c"}) {
+		t.Fatalf("GetFlowsWithRecordCount page: expected 5 instances and page d, c, got %d %v", record.Count, flowIds(record.FlowData))
+	}
+
+	mtdata = appMetadata()
+	mtdata.FlowName, mtdata.Status = "create", "Completed"
+	record, err = s.GetFlowsWithRecordCount(mtdata)
+	must(t, err)
+	if record.Count != 2 || !equal(flowIds(record.FlowData), []string{"c", "a"}) {
+		t.Fatalf("GetFlowsWithRecordCount by flow and status: expected c, a, got %d %v", record.Count, flowIds(record.FlowData))
+	}
+
+	mtdata = appMetadata()
+	mtdata.StartTime = base.Add(90 * time.Second).Format(time.RFC3339Nano)
+	mtdata.EndTime = base.Add(3 * time.Minute).Format(time.RFC3339Nano)
+	record, err = s.GetFlowsWithRecordCount(mtdata)
+	must(t, err)
+	if !equal(flowIds(record.FlowData), []string{"d", "c"}) {
+		t.Fatalf("GetFlowsWithRecordCount by time range: expected d, c, got %v", flowIds(record.FlowData))
+	}
+
+	flows, err := s.GetFlows(&metadata.Metadata{Username: "nobody", AppName: app, AppVersion: version})
+	must(t, err)
+	if len(flows) != 0 {
+		t.Fatalf("GetFlows of another user: expected no instances, got %v", flowIds(flows))
+	}
+
+	failed, err := s.GetFailedFlows(appMetadata())
+	must(t, err)
+	if !equal(flowIds(failed), []string{"b"}) {
+		t.Fatalf("GetFailedFlows: expected b, got %v", flowIds(failed))
+	}
+	completed, err := s.GetCompletedFlows(appMetadata())
+	must(t, err)
+	ids := flowIds(completed)
+	sort.Strings(ids)
+	if !equal(ids, []string{"a", "c"}) {
+		t.Fatalf("GetCompletedFlows: expected a, c, got %v", ids)
+	}
+
+	names, err := s.GetFlowNames(appMetadata())
+	must(t, err)
+	sort.Strings(names)
+	if !equal(names, []string{"cancel", "create"}) {
+		t.Fatalf("GetFlowNames: expected cancel, create, got %v", names)
+	}
+	versions, err := s.GetAppVersions(&metadata.Metadata{Username: user, AppName: app})
+	must(t, err)
+	if !equal(versions, []string{version}) {
+		t.Fatalf("GetAppVersions: expected %s, got %v", version, versions)
+	}
+}
+
+func testRerun(t *testing.T, s store.Store) {
+	must(t, s.RecordStart(flowState("a", "create", base)))
+	rerun := flowState("a-rerun", "create", base.Add(time.Minute))
+	rerun.OriginalInstanceId = "a"
+	must(t, s.RecordStart(rerun))
+
+	mtdata := appMetadata()
+	mtdata.FlowInstanceId = "a"
+	record, err := s.GetFlowsWithRecordCount(mtdata)
+	must(t, err)
+	if record.Count != 2 || !equal(flowIds(record.FlowData), []string{"a-rerun", "a"}) {
+		t.Fatalf("GetFlowsWithRecordCount by instance: expected the rerun and the original, got %v", flowIds(record.FlowData))
+	}
+	if original := record.FlowData[1]; original.RerunCount != 1 {
+		t.Fatalf("RecordStart of a rerun: expected the original rerun count to be 1, got %d", original.RerunCount)
+	}
+	if record.FlowData[0].OriginalInstanceId != "a" {
+		t.Fatalf("RecordStart of a rerun: expected the original instance id, got %+v", record.FlowData[0])
+	}
+}
+
+func testAppState(t *testing.T, s store.Store) {
+	mtdata := &metadata.Metadata{Username: user, AppName: app}
+	enabled, err := s.GetAppState(mtdata)
+	must(t, err)
+	if enabled != "" {
+		t.Fatalf("GetAppState of an unknown app: expected an empty state, got %q", enabled)
+	}
+
+	mtdata.PersistEnabled = true
+	must(t, s.SaveAppState(mtdata))
+	if enabled, _ = s.GetAppState(mtdata); enabled != "true" {
+		t.Fatalf("GetAppState: expected true, got %q", enabled)
+	}
+	mtdata.PersistEnabled = false
+	must(t, s.SaveAppState(mtdata))
+	if enabled, _ = s.GetAppState(mtdata); enabled != "false" {
+		t.Fatalf("GetAppState: expected false, got %q", enabled)
+	}
+}
+
+func testConcurrency(t *testing.T, s store.Store) {
+	const instances, steps = 8, 10
+
+	var wg sync.WaitGroup
+	errs := make(chan error, instances)
+	for i := 0; i < instances; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			if err := s.RecordStart(flowState(id, "create", base)); err != nil {
+				errs <- err
+				return
+			}
+			for n := 1; n <= steps; n++ {
+				if err := s.SaveStep(step(id, n, model.FlowStatusActive, "log")); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := s.GetSteps(id); err != nil {
+					errs <- err
+					return
+				}
+			}
+			end := flowState(id, "create", base)
+			end.FlowStats = "Completed"
+			end.EndTime = base.Add(time.Second)
+			errs <- s.RecordEnd(end)
+		}(fmt.Sprintf("i%d", i))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		must(t, err)
+	}
+
+	for i := 0; i < instances; i++ {
+		id := fmt.Sprintf("i%d", i)
+		list, err := s.GetSteps(id)
+		must(t, err)
+		if len(list) != steps {
+			t.Fatalf("GetSteps of %s: expected %d steps, got %d", id, steps, len(list))
+		}
+	}
+	completed, err := s.GetCompletedFlows(appMetadata())
+	must(t, err)
+	if len(completed) != instances {
+		t.Fatalf("GetCompletedFlows: expected %d instances, got %d", instances, len(completed))
+	}
+}
+
+// testEvents checks that the saved starts, steps and ends are published to the step stream, and so are the
+// items of a batch when the store applies batches
+func testEvents(t *testing.T, s store.Store) {
+	hub := event.Steps
+	defer func() { event.Steps = hub }()
+	event.Steps = event.NewHub(0, 0, 0)
+	event.StartStepListener()
+	subscriber := event.Steps.Subscribe(nil, event.Lag)
+	defer subscriber.Close()
+
+	expect := func(id string, kinds ...event.Kind) {
+		t.Helper()
+		for _, kind := range kinds {
+			select {
+			case e := <-subscriber.Events():
+				if e.Kind != kind || e.FlowInstanceId != id {
+					t.Fatalf("expected the %s event of %s, got the %s event of %s", kind, id, e.Kind, e.FlowInstanceId)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("expected the %s event of %s", kind, id)
+			}
+		}
+		select {
+		case e := <-subscriber.Events():
+			t.Fatalf("unexpected %s event of %s", e.Kind, e.FlowInstanceId)
+		default:
+		}
+	}
+
+	end := func(id string) *state.FlowState {
+		end := flowState(id, "create", base)
+		end.FlowStats = "Completed"
+		end.EndTime = base.Add(time.Second)
+		return end
+	}
+
+	must(t, s.RecordStart(flowState("a", "create", base)))
+	must(t, s.SaveStep(step("a", 1, model.FlowStatusActive, "log")))
+	must(t, s.RecordEnd(end("a")))
+	expect("a", event.KindStart, event.KindStep, event.KindEnd)
+
+	batchStore, ok := s.(batch.Store)
+	if !ok {
+		return
+	}
+	must(t, batchStore.SaveBatch([]*batch.Item{
+		{Kind: batch.Start, FlowState: flowState("b", "create", base)},
+		{Kind: batch.Step, Step: step("b", 1, model.FlowStatusActive, "log")},
+		{Kind: batch.Step, Step: step("b", 2, model.FlowStatusCompleted, "reply")},
+		{Kind: batch.End, FlowState: end("b")},
+	}))
+	expect("b", event.KindStart, event.KindStep, event.KindStep, event.KindEnd)
+}
@@ -0,0 +1,114 @@
+package task
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/project-flogo/flow/state"
+	"github.com/project-flogo/services/flow-state/store/metadata"
+)
+
+// StepsAsTasks converts the steps of a flow instance to the tasks of each step
+func StepsAsTasks(steps []*state.Step) ([][]*Task, error) {
+	var taskValueArray [][]*Task
+	for _, step := range steps {
+		taskValue, err := StepToTask(step)
+		if err != nil {
+			return nil, err
+		}
+		taskValueArray = append(taskValueArray, taskValue)
+	}
+	return taskValueArray, nil
+}
+
+// StepsStatus lists the status of the task of each step of a flow instance, the completion of a callsubflow
+// task is merged into its earlier waiting entry
+func StepsStatus(steps []*state.Step) ([]map[string]string, error) {
+	var stepsStatus []map[string]string
+	var waitingSteps []map[string]string
+OUTER:
+	for _, step := range steps {
+		if step.Id == 0 {
+			continue
+		}
+		tasks, err := StepToTask(step)
+		if err != nil {
+			return nil, err
+		}
+		if len(tasks) == 0 {
+			continue
+		}
+		status := string(tasks[0].Status)
+		stepData := map[string]string{
+			"stepId":    strconv.Itoa(step.Id),
+			"status":    status,
+			"taskName":  tasks[0].Id,
+			"flowname":  metadata.FlowName(tasks[0].Flowname),
+			"rerun":     strconv.FormatBool(step.Rerun),
+			"subflowid": strconv.Itoa(tasks[0].SubflowId),
+			"starttime": metadata.FormatTime(step.StartTime),
+		}
+
+		if strings.EqualFold(status, "completed") || strings.EqualFold(status, "failed") {
+			for i, waitingStep := range waitingSteps {
+				if waitingStep["taskName"] == tasks[0].Id && waitingStep["subflowid"] == stepData["subflowid"] {
+					waitingStep["status"] = status
+					waitingSteps = append(waitingSteps[:i], waitingSteps[i+1:]...)
+					continue OUTER
+				}
+			}
+		}
+
+		stepsStatus = append(stepsStatus, stepData)
+		if strings.EqualFold(status, "waiting") {
+			waitingSteps = append(waitingSteps, stepData)
+		}
+	}
+	return stepsStatus, nil
+}
+
+// StepdataForActivity returns the tasks of the step of the flow instance steps, the step has to be of the task
+// when taskname is set
+func StepdataForActivity(steps []*state.Step, id int, taskname string) ([]*Task, error) {
+	for _, step := range steps {
+		if step.Id != id {
+			continue
+		}
+		taskValue, err := StepToTask(step)
+		if err != nil {
+			return nil, err
+		}
+		if len(taskValue) == 0 || (taskname != "" && taskValue[0].Id != taskname) {
+			break
+		}
+
+		// a waiting callsubflow task has its output recorded on the step that completed it
+		if len(taskValue) == 2 && strings.EqualFold(string(taskValue[0].Status), "waiting") {
+			if nextStepId := enclosingCallSubflowStep(steps, taskValue[0].Id, taskValue[0].SubflowId); nextStepId >= 0 {
+				taskArray, err := StepdataForActivity(steps, nextStepId, taskValue[0].Id)
+				if err != nil {
+					return nil, err
+				}
+				taskArray[0].StepId = id
+				return taskArray, nil
+			}
+		}
+		return taskValue, nil
+	}
+	return nil, fmt.Errorf("No step data found for matching input")
+}
+
+// enclosingCallSubflowStep is the last step of the task that is not waiting, or -1
+func enclosingCallSubflowStep(steps []*state.Step, taskname string, subflowId int) int {
+	for i := len(steps) - 1; i >= 0; i-- {
+		tasks, err := StepToTask(steps[i])
+		if err != nil || len(tasks) == 0 {
+			continue
+		}
+		if tasks[0].Id == taskname && tasks[0].SubflowId == subflowId && !strings.EqualFold(string(tasks[0].Status), "waiting") {
+			return steps[i].Id
+		}
+	}
+	return -1
+}
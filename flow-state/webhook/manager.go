@@ -0,0 +1,677 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	flowEvent "github.com/project-flogo/flow/support/event"
+	"github.com/project-flogo/services/flow-state/event"
+	"github.com/project-flogo/services/flow-state/store"
+)
+
+const (
+	EventHeader     = "X-Flogo-Event"
+	DeliveryHeader  = "X-Flogo-Delivery"
+	SignatureHeader = "X-Flogo-Signature"
+
+	// queueSize is the number of flow instance ends buffered by the hub for the manager
+	queueSize     = 1024
+	maxRetryDelay = 5 * time.Minute
+	// flushInterval is how often the changes of the delivery log are saved
+	flushInterval = time.Second
+)
+
+// Delivery statuses
+const (
+	Pending   = "pending"
+	Delivered = "delivered"
+	Failed    = "failed"
+)
+
+// Payload is the body posted to the URL of a subscription
+type Payload struct {
+	// Id is the id of the delivery, it is the same for every attempt
+	Id             string    `json:"id"`
+	Event          string    `json:"event"`
+	Time           time.Time `json:"time"`
+	FlowInstanceId string    `json:"flowInstanceId"`
+	AppName        string    `json:"app"`
+	AppVersion     string    `json:"version,omitempty"`
+	FlowName       string    `json:"flow"`
+	Status         string    `json:"status"`
+	// FailedTask and StepId are the task that failed and its step, for the failed instances
+	FailedTask string `json:"failedTask,omitempty"`
+	StepId     string `json:"stepId,omitempty"`
+}
+
+// Delivery is an entry of the delivery log of a subscription
+type Delivery struct {
+	Id             string    `json:"id"`
+	SubscriptionId string    `json:"subscriptionId"`
+	Event          string    `json:"event"`
+	FlowInstanceId string    `json:"flowInstanceId"`
+	Status         string    `json:"status"`
+	Attempts       int       `json:"attempts"`
+	ResponseCode   int       `json:"responseCode,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	Created        time.Time `json:"created"`
+	LastAttempt    time.Time `json:"lastAttempt"`
+}
+
+// job is a delivery waiting for its next attempt
+type job struct {
+	sub      *Subscription
+	body     []byte
+	delivery *Delivery
+	delay    time.Duration
+}
+
+// deliveryLog is the content of the deliveries file, the logs of the subscriptions, the deliveries to attempt again
+// and the flow instance ends not matched with the subscriptions yet
+type deliveryLog struct {
+	Logs    map[string][]*Delivery `json:"logs"`
+	Pending []*pendingDelivery     `json:"pending,omitempty"`
+	Ends    []*event.Event         `json:"ends,omitempty"`
+}
+
+// pendingDelivery is a delivery that is neither delivered nor failed yet, with its body
+type pendingDelivery struct {
+	Delivery *Delivery       `json:"delivery"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// Manager keeps the webhook subscriptions and posts the end of the flow instances they match
+type Manager struct {
+	cfg    *Config
+	subs   *subscriptions
+	steps  store.Store
+	client *http.Client
+
+	hub *event.Hub
+	sub *event.Subscriber
+
+	logPath string
+	logLock sync.Mutex
+	// logs are the most recent deliveries of the subscriptions, from the oldest
+	logs map[string][]*Delivery
+	// pending are the deliveries that are neither delivered nor failed, by delivery id
+	pending map[string]*job
+	// ends are the flow instance ends waiting to be matched with the subscriptions, from the oldest
+	ends []*event.Event
+	// ready are the pending deliveries waiting for a worker, from the oldest
+	ready []*job
+	// dirty is set when the logs changed since they were saved
+	dirty bool
+
+	// wake signals the workers that an end or a delivery is ready
+	wake     chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// New loads the subscriptions of the config, steps is the store of the failed tasks and of the owners of the instances
+func New(cfg *Config, steps store.Store) (*Manager, error) {
+	subs, err := openSubscriptions(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	// the webhooks are posted directly, a proxy would connect to the addresses the config does not permit
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: cfg.dialControl}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Manager{
+		cfg:     cfg,
+		subs:    subs,
+		steps:   steps,
+		client:  &http.Client{Timeout: time.Duration(cfg.Timeout) * time.Millisecond, Transport: transport},
+		hub:     event.Steps,
+		logPath: filepath.Join(cfg.Dir, deliveriesFile),
+		logs:    make(map[string][]*Delivery),
+		pending: make(map[string]*job),
+		wake:    make(chan struct{}, 1),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	if err = m.loadLog(); err != nil {
+		cancel()
+		return nil, err
+	}
+	return m, nil
+}
+
+// loadLog reads the delivery logs and the pending deliveries of the subscriptions that still exist
+func (m *Manager) loadLog() error {
+	b, err := os.ReadFile(m.logPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	saved := &deliveryLog{}
+	if err = json.Unmarshal(b, saved); err != nil {
+		return fmt.Errorf("Could not read webhook deliveries [%s], %s", m.logPath, err.Error())
+	}
+
+	byId := make(map[string]*Delivery)
+	for subId, log := range saved.Logs {
+		if _, err := m.subs.get(subId); err != nil {
+			continue
+		}
+		m.logs[subId] = log
+		for _, d := range log {
+			byId[d.Id] = d
+		}
+	}
+	for _, p := range saved.Pending {
+		sub, err := m.subs.get(p.Delivery.SubscriptionId)
+		if err != nil {
+			continue
+		}
+		// the delivery is shared with the log so the attempts update both
+		d := p.Delivery
+		if logged, ok := byId[d.Id]; ok {
+			d = logged
+		}
+		delay := time.Duration(m.cfg.RetryDelay) * time.Millisecond
+		for i := 1; i < d.Attempts && delay < maxRetryDelay; i++ {
+			delay *= 2
+		}
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+		m.pending[d.Id] = &job{sub: sub, body: p.Body, delivery: d, delay: delay}
+	}
+	m.ends = saved.Ends
+	return nil
+}
+
+// saveLog writes the delivery logs and the pending deliveries when they changed
+func (m *Manager) saveLog() {
+	m.logLock.Lock()
+	if !m.dirty {
+		m.logLock.Unlock()
+		return
+	}
+	saved := &deliveryLog{Logs: m.logs, Ends: m.ends}
+	for _, j := range m.pending {
+		saved.Pending = append(saved.Pending, &pendingDelivery{Delivery: j.delivery, Body: j.body})
+	}
+	b, err := json.Marshal(saved)
+	m.dirty = false
+	m.logLock.Unlock()
+
+	if err == nil {
+		err = writeFile(m.logPath, b)
+	}
+	if err != nil {
+		logCache.Errorf("Could not save webhook deliveries [%s], %s", m.logPath, err.Error())
+		m.logLock.Lock()
+		m.dirty = true
+		m.logLock.Unlock()
+	}
+}
+
+// Create saves a new subscription of the user and returns it with its secret
+func (m *Manager) Create(user string, sub *Subscription) (*Subscription, error) {
+	sub.Id, sub.User = newId(), user
+	if err := sub.validate(); err != nil {
+		return nil, err
+	}
+	if err := m.cfg.checkAddress(sub.URL); err != nil {
+		return nil, err
+	}
+	if sub.Secret == "" {
+		sub.Secret = newId() + newId()
+	}
+	sub.Created = time.Now().UTC()
+	sub.Updated = sub.Created
+	err := m.subs.update(func(byId map[string]*Subscription) error {
+		byId[sub.Id] = sub
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	c := *sub
+	return &c, nil
+}
+
+// Get returns the subscription, ErrNotFound when it does not exist
+func (m *Manager) Get(id string) (*Subscription, error) {
+	return m.subs.get(id)
+}
+
+// List returns the subscriptions of the user, of an app and flow when they are not empty
+func (m *Manager) List(user, app, flow string) []*Subscription {
+	return m.subs.list(func(sub *Subscription) bool {
+		return sub.User == user && (app == "" || sub.App == app) && (flow == "" || sub.Flow == flow)
+	})
+}
+
+// Update replaces the flow, statuses and url of the subscription, and its secret when it is set
+func (m *Manager) Update(id string, change *Subscription) (*Subscription, error) {
+	var updated *Subscription
+	err := m.subs.update(func(byId map[string]*Subscription) error {
+		current, ok := byId[id]
+		if !ok {
+			return ErrNotFound
+		}
+		sub := *current
+		sub.Flow, sub.Statuses, sub.URL = change.Flow, change.Statuses, change.URL
+		if change.Secret != "" {
+			sub.Secret = change.Secret
+		}
+		if err := sub.validate(); err != nil {
+			return err
+		}
+		if err := m.cfg.checkAddress(sub.URL); err != nil {
+			return err
+		}
+		sub.Updated = time.Now().UTC()
+		byId[id] = &sub
+		updated = &sub
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	c := *updated
+	return &c, nil
+}
+
+// Delete removes the subscription and its delivery log
+func (m *Manager) Delete(id string) error {
+	err := m.subs.update(func(byId map[string]*Subscription) error {
+		if _, ok := byId[id]; !ok {
+			return ErrNotFound
+		}
+		delete(byId, id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	m.logLock.Lock()
+	delete(m.logs, id)
+	for deliveryId, j := range m.pending {
+		if j.sub.Id == id {
+			delete(m.pending, deliveryId)
+		}
+	}
+	ready := m.ready[:0]
+	for _, j := range m.ready {
+		if j.sub.Id != id {
+			ready = append(ready, j)
+		}
+	}
+	m.ready = ready
+	m.dirty = true
+	m.logLock.Unlock()
+	return nil
+}
+
+// Deliveries returns the most recent deliveries of the subscription, from the newest
+func (m *Manager) Deliveries(id string) []*Delivery {
+	m.logLock.Lock()
+	defer m.logLock.Unlock()
+	log := m.logs[id]
+	deliveries := make([]*Delivery, 0, len(log))
+	for i := len(log) - 1; i >= 0; i-- {
+		d := *log[i]
+		deliveries = append(deliveries, &d)
+	}
+	return deliveries
+}
+
+// Start posts the end of the flow instances until Stop is called, and attempts again the deliveries that were
+// pending when the manager was last stopped
+func (m *Manager) Start() {
+	m.sub = m.hub.SubscribeWithBuffer(&event.Filter{Kind: event.KindEnd}, event.Lag, queueSize)
+	m.wg.Add(1)
+	go m.receive()
+	for i := 0; i < m.cfg.Workers; i++ {
+		m.wg.Add(1)
+		go m.work()
+	}
+	m.wg.Add(1)
+	go m.flush()
+	event.StartStepListener()
+
+	m.logLock.Lock()
+	for _, j := range m.pending {
+		m.ready = append(m.ready, j)
+	}
+	m.logLock.Unlock()
+	m.signal()
+}
+
+// Stop stops the deliveries and saves the delivery log, the deliveries waiting for a retry are attempted again
+// once the manager is started again
+func (m *Manager) Stop() {
+	m.stopOnce.Do(func() {
+		if m.sub != nil {
+			m.sub.Close()
+		}
+		m.cancel()
+		m.wg.Wait()
+		// the ends the hub already sent are saved with the log
+		if m.sub != nil {
+			for len(m.sub.Events()) > 0 {
+				m.received(<-m.sub.Events())
+			}
+		}
+		m.saveLog()
+	})
+}
+
+// flush saves the changes of the delivery log until Stop is called
+func (m *Manager) flush() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.saveLog()
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+// ended queues a delivery of the end of the flow instance to every subscription it matches, it is called by the
+// workers as it may read the owner and the failed task of the instance from the store
+func (m *Manager) ended(e *event.Event) {
+	status := e.Status
+	if e.FlowState != nil && e.FlowState.FlowStats != "" {
+		status = e.FlowState.FlowStats
+	}
+	kind := eventName(status)
+	if kind == "" {
+		return
+	}
+
+	user := e.UserName
+	payload := &Payload{
+		Event:          kind,
+		Time:           time.Now().UTC(),
+		FlowInstanceId: e.FlowInstanceId,
+		AppName:        e.AppName,
+		AppVersion:     e.AppVersion,
+		FlowName:       e.FlowName,
+		Status:         status,
+	}
+	if fs := e.FlowState; fs != nil {
+		if user == "" {
+			user = fs.UserId
+		}
+		if payload.AppName == "" {
+			payload.AppName, payload.AppVersion = fs.AppName, fs.AppVersion
+		}
+		if payload.FlowName == "" {
+			payload.FlowName = fs.FlowName
+		}
+	}
+	if owners, ok := m.steps.(store.OwnerStore); ok && (user == "" || payload.AppName == "") {
+		if owner, err := owners.GetFlowOwner(e.FlowInstanceId); err == nil && owner != nil {
+			user, payload.AppName, payload.AppVersion = owner.Username, owner.AppName, owner.AppVersion
+		}
+	}
+	if user == "" {
+		logCache.Debugf("Webhooks skip flow instance [%s], its owner is unknown", e.FlowInstanceId)
+		return
+	}
+
+	subs := m.subs.list(func(sub *Subscription) bool { return sub.matches(user, payload.AppName, payload.FlowName, status) })
+	if len(subs) == 0 {
+		return
+	}
+	if strings.EqualFold(status, flowEvent.FAILED) {
+		payload.FailedTask, payload.StepId = m.failedTask(e.FlowInstanceId)
+	}
+
+	for _, sub := range subs {
+		p := *payload
+		p.Id = newId()
+		body, err := json.Marshal(&p)
+		if err != nil {
+			logCache.Errorf("Could not encode webhook of flow instance [%s], %s", e.FlowInstanceId, err.Error())
+			continue
+		}
+		d := &Delivery{Id: p.Id, SubscriptionId: sub.Id, Event: kind, FlowInstanceId: e.FlowInstanceId, Status: Pending, Created: p.Time}
+		j := &job{sub: sub, body: body, delivery: d, delay: time.Duration(m.cfg.RetryDelay) * time.Millisecond}
+		m.record(j)
+		m.enqueue(j)
+	}
+}
+
+// eventName is the event of the final status, empty when the status is not final
+func eventName(status string) string {
+	for _, name := range Statuses {
+		if strings.EqualFold(status, name) {
+			return "flow." + strings.ToLower(name)
+		}
+	}
+	return ""
+}
+
+// failedTask finds the task that failed and its step in the steps of the flow instance
+func (m *Manager) failedTask(flowId string) (taskName, stepId string) {
+	steps, err := m.steps.GetStepsStatus(flowId)
+	if err != nil {
+		logCache.Warnf("Could not get the failed task of flow instance [%s], %s", flowId, err.Error())
+		return "", ""
+	}
+	for _, s := range steps {
+		if s["status"] == flowEvent.FAILED {
+			stepId = s["stepId"]
+			taskName = s["taskName"]
+		}
+	}
+	return taskName, stepId
+}
+
+// receive drains the ends of the flow instances from the hub until Stop is called. It never waits for the
+// workers, the ends wait for them in the delivery log.
+func (m *Manager) receive() {
+	defer m.wg.Done()
+	for {
+		select {
+		case e := <-m.sub.Events():
+			if missed := m.sub.Missed(); missed > 0 {
+				logCache.Warnf("Webhooks missed %d flow instance ends, the hub buffer is full", missed)
+			}
+			m.received(e)
+		case <-m.ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *Manager) received(e *event.Event) {
+	m.logLock.Lock()
+	m.ends = append(m.ends, e)
+	m.dirty = true
+	m.logLock.Unlock()
+	m.signal()
+}
+
+// enqueue adds the pending delivery to the deliveries waiting for a worker
+func (m *Manager) enqueue(j *job) {
+	m.logLock.Lock()
+	m.ready = append(m.ready, j)
+	m.logLock.Unlock()
+	m.signal()
+}
+
+// signal wakes a worker, a worker that takes an end or a delivery wakes another one when more are waiting
+func (m *Manager) signal() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next waits for an end or a delivery, the ends first, it returns neither once Stop is called
+func (m *Manager) next() (*event.Event, *job) {
+	for {
+		m.logLock.Lock()
+		var e *event.Event
+		var j *job
+		if len(m.ends) > 0 {
+			e = m.ends[0]
+			m.ends[0], m.ends = nil, m.ends[1:]
+			m.dirty = true
+		} else if len(m.ready) > 0 {
+			j = m.ready[0]
+			m.ready[0], m.ready = nil, m.ready[1:]
+		}
+		more := len(m.ends) > 0 || len(m.ready) > 0
+		m.logLock.Unlock()
+		if more {
+			m.signal()
+		}
+		if e != nil || j != nil {
+			return e, j
+		}
+		select {
+		case <-m.wake:
+		case <-m.ctx.Done():
+			return nil, nil
+		}
+	}
+}
+
+// work matches the ends of the flow instances with the subscriptions and attempts the deliveries until Stop is called
+func (m *Manager) work() {
+	defer m.wg.Done()
+	for m.ctx.Err() == nil {
+		switch e, j := m.next(); {
+		case e != nil:
+			m.ended(e)
+		case j != nil:
+			m.attempt(j)
+		}
+	}
+}
+
+// attempt posts the payload, it is retried later on network errors, timeouts, throttling and server errors but
+// not when the address is not allowed
+func (m *Manager) attempt(j *job) {
+	code, err := m.post(j)
+	if m.ctx.Err() != nil {
+		// the attempt was interrupted by Stop, the delivery is still pending
+		return
+	}
+	var denied *deniedError
+	final := !retryable(code) || errors.As(err, &denied)
+	var attempts int
+	m.update(j.delivery, func(d *Delivery) {
+		d.Attempts++
+		d.LastAttempt, d.ResponseCode, d.Error = time.Now().UTC(), code, ""
+		if err != nil {
+			d.Error = err.Error()
+		}
+		switch {
+		case err == nil:
+			d.Status = Delivered
+		case final || d.Attempts >= m.cfg.MaxAttempts:
+			d.Status = Failed
+		}
+		attempts = d.Attempts
+	})
+	if err == nil {
+		return
+	}
+	if final || attempts >= m.cfg.MaxAttempts {
+		logCache.Errorf("Webhook [%s] could not deliver [%s] to [%s] after %d attempts, %s", j.sub.Id, j.delivery.Id, j.sub.URL, attempts, err.Error())
+		return
+	}
+	logCache.Debugf("Webhook [%s] retries delivery [%s] in %s, %s", j.sub.Id, j.delivery.Id, j.delay, err.Error())
+	delay := j.delay
+	if j.delay *= 2; j.delay > maxRetryDelay {
+		j.delay = maxRetryDelay
+	}
+	time.AfterFunc(delay, func() {
+		if m.ctx.Err() == nil {
+			m.enqueue(j)
+		}
+	})
+}
+
+func (m *Manager) post(j *job) (int, error) {
+	req, err := http.NewRequestWithContext(m.ctx, http.MethodPost, j.sub.URL, bytes.NewReader(j.body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, j.delivery.Event)
+	req.Header.Set(DeliveryHeader, j.delivery.Id)
+	req.Header.Set(SignatureHeader, Sign(j.sub.Secret, j.body))
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook url responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// retryable tells if a delivery that failed with the response code is attempted again, 0 is a network error
+func retryable(code int) bool {
+	return code == 0 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+// Sign returns the signature of the body, sha256= followed by the hex HMAC-SHA256 of the body keyed with the secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// record appends the delivery of the job to the log of its subscription, dropping the oldest entries beyond the
+// log size, and keeps the job until the delivery is delivered or failed
+func (m *Manager) record(j *job) {
+	d := j.delivery
+	m.logLock.Lock()
+	defer m.logLock.Unlock()
+	log := append(m.logs[d.SubscriptionId], d)
+	if len(log) > m.cfg.LogSize {
+		log = append([]*Delivery(nil), log[len(log)-m.cfg.LogSize:]...)
+	}
+	m.logs[d.SubscriptionId] = log
+	m.pending[d.Id] = j
+	m.dirty = true
+}
+
+func (m *Manager) update(d *Delivery, change func(*Delivery)) {
+	m.logLock.Lock()
+	change(d)
+	if d.Status != Pending {
+		delete(m.pending, d.Id)
+	}
+	m.dirty = true
+	m.logLock.Unlock()
+}
@@ -0,0 +1,393 @@
+// Package webhook posts the end of the flow instances to the URLs subscribed to their app and flow
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/project-flogo/core/data/coerce"
+	"github.com/project-flogo/core/data/metadata"
+	"github.com/project-flogo/core/support/log"
+	flowEvent "github.com/project-flogo/flow/support/event"
+)
+
+var logCache = log.ChildLogger(log.RootLogger(), "webhook")
+
+const (
+	DefaultDir         = "webhooks"
+	DefaultMaxAttempts = 5
+	DefaultRetryDelay  = 1000
+	DefaultTimeout     = 10000
+	DefaultWorkers     = 4
+	DefaultLogSize     = 100
+
+	subscriptionsFile = "webhooks.json"
+	deliveriesFile    = "deliveries.json"
+
+	// lookupTimeout is the time allowed to resolve the host of a subscription when it is saved
+	lookupTimeout = 5 * time.Second
+)
+
+// DefaultDeniedNetworks are the loopback, link-local and unspecified addresses, the webhooks are not posted to them
+// unless allowedNetworks or deniedNetworks is set
+var DefaultDeniedNetworks = []string{"127.0.0.0/8", "::1/128", "169.254.0.0/16", "fe80::/10", "0.0.0.0/8", "::/128"}
+
+// ErrNotFound is returned for an unknown subscription
+var ErrNotFound = errors.New("webhook not found")
+
+// ValidationError is returned for a subscription that can not be saved as it is
+type ValidationError string
+
+func (e ValidationError) Error() string {
+	return string(e)
+}
+
+// Config is the config of the webhooks
+type Config struct {
+	// Dir is the directory of the subscriptions file
+	Dir string `md:"dir"`
+	// MaxAttempts is the number of times a delivery is attempted
+	MaxAttempts int `md:"maxAttempts"`
+	// RetryDelay is the delay before the first retry in milliseconds, it doubles with every retry
+	RetryDelay int `md:"retryDelay"`
+	// Timeout is the time allowed for a delivery in milliseconds
+	Timeout int `md:"timeout"`
+	// Workers is the number of deliveries sent at once
+	Workers int `md:"workers"`
+	// LogSize is the number of deliveries kept in the log of a subscription
+	LogSize int `md:"logSize"`
+
+	// allowed and denied are the networks of the allowedNetworks and deniedNetworks settings, lists of CIDRs.
+	// When allowed is set the webhooks are only posted to its addresses, otherwise to every address but the
+	// denied ones.
+	allowed []*net.IPNet
+	denied  []*net.IPNet
+}
+
+// NewConfig reads the webhook settings and applies defaults, it returns nil when the settings are not set
+func NewConfig(settings map[string]interface{}) (*Config, error) {
+	if settings == nil {
+		return nil, nil
+	}
+	cfg := &Config{}
+	if err := metadata.MapToStruct(settings, cfg, false); err != nil {
+		return nil, err
+	}
+	var err error
+	if cfg.allowed, err = networks(settings["allowedNetworks"], nil); err != nil {
+		return nil, fmt.Errorf("invalid webhook allowedNetworks, %s", err.Error())
+	}
+	if cfg.denied, err = networks(settings["deniedNetworks"], DefaultDeniedNetworks); err != nil {
+		return nil, fmt.Errorf("invalid webhook deniedNetworks, %s", err.Error())
+	}
+	if cfg.Dir == "" {
+		cfg.Dir = DefaultDir
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = DefaultMaxAttempts
+	}
+	if cfg.RetryDelay <= 0 {
+		cfg.RetryDelay = DefaultRetryDelay
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = DefaultWorkers
+	}
+	if cfg.LogSize <= 0 {
+		cfg.LogSize = DefaultLogSize
+	}
+	return cfg, nil
+}
+
+// networks parses a list of CIDRs given as an array or as a comma or space separated string
+func networks(value interface{}, defaultValue []string) ([]*net.IPNet, error) {
+	var cidrs []string
+	switch v := value.(type) {
+	case nil:
+		cidrs = defaultValue
+	case string:
+		cidrs = strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' })
+	default:
+		values, err := coerce.ToArray(value)
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range values {
+			s, _ := coerce.ToString(value)
+			cidrs = append(cidrs, s)
+		}
+	}
+	result := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, network)
+	}
+	return result, nil
+}
+
+// permits tells if the webhooks may be posted to the address
+func (c *Config) permits(ip net.IP) bool {
+	if len(c.allowed) > 0 {
+		return containsIP(c.allowed, ip)
+	}
+	return !containsIP(c.denied, ip)
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAddress rejects the url when its host is, or resolves to, an address the config does not permit. A host
+// that can not be resolved yet is accepted, the address is checked again by dialControl when the webhook is posted.
+func (c *Config) checkAddress(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ValidationError(fmt.Sprintf("webhook url [%s] is not a valid http or https url", rawURL))
+	}
+	var ips []net.IP
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		ips = append(ips, ip)
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+		addrs, _ := net.DefaultResolver.LookupIPAddr(ctx, u.Hostname())
+		cancel()
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	for _, ip := range ips {
+		if !c.permits(ip) {
+			return ValidationError(fmt.Sprintf("webhook url [%s] targets address [%s], which is not allowed", rawURL, ip))
+		}
+	}
+	return nil
+}
+
+// deniedError is the error of a delivery to an address the config does not permit
+type deniedError struct {
+	ip net.IP
+}
+
+func (e *deniedError) Error() string {
+	return fmt.Sprintf("webhook address [%s] is not allowed", e.ip)
+}
+
+// dialControl rejects the connections to the addresses the config does not permit, once the host is resolved
+func (c *Config) dialControl(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !c.permits(ip) {
+		return &deniedError{ip: ip}
+	}
+	return nil
+}
+
+// Statuses are the final statuses of the flow instances that fire the webhooks
+var Statuses = []string{flowEvent.FAILED, flowEvent.COMPLETED, flowEvent.CANCELLED}
+
+// Subscription posts the end of the instances of an app, or of one of its flows, to a URL
+type Subscription struct {
+	Id   string `json:"id"`
+	User string `json:"user,omitempty"`
+	App  string `json:"app"`
+	// Flow is the name of the flow, empty for every flow of the app
+	Flow string `json:"flow,omitempty"`
+	// Statuses are the final statuses firing the webhook, all of Statuses by default
+	Statuses []string `json:"statuses,omitempty"`
+	URL      string   `json:"url"`
+	// Secret is the HMAC key of the signatures of the deliveries, it is generated when not set
+	Secret  string    `json:"secret,omitempty"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// validate checks the subscription and normalizes its statuses
+func (s *Subscription) validate() error {
+	if s.App == "" {
+		return ValidationError("webhook app is required")
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ValidationError(fmt.Sprintf("webhook url [%s] is not a valid http or https url", s.URL))
+	}
+	if len(s.Statuses) == 0 {
+		s.Statuses = append([]string(nil), Statuses...)
+	}
+	for i, status := range s.Statuses {
+		known := false
+		for _, name := range Statuses {
+			if strings.EqualFold(status, name) {
+				s.Statuses[i], known = name, true
+			}
+		}
+		if !known {
+			return ValidationError(fmt.Sprintf("webhook status [%s] is not one of %s", status, strings.Join(Statuses, ", ")))
+		}
+	}
+	return nil
+}
+
+// matches tells if the end of an instance of the user fires the subscription, a subscription is only fired by the
+// instances of the user who created it
+func (s *Subscription) matches(user, app, flow, status string) bool {
+	if s.User != user || s.App != app || (s.Flow != "" && s.Flow != flow) {
+		return false
+	}
+	for _, name := range s.Statuses {
+		if strings.EqualFold(name, status) {
+			return true
+		}
+	}
+	return false
+}
+
+func newId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// subscriptions keeps the subscriptions in memory and saves them to a JSON file of the directory
+type subscriptions struct {
+	path string
+
+	mu   sync.RWMutex
+	byId map[string]*Subscription
+}
+
+func openSubscriptions(dir string) (*subscriptions, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("Could not create webhook directory [%s], %s", dir, err.Error())
+	}
+	s := &subscriptions{path: filepath.Join(dir, subscriptionsFile), byId: make(map[string]*Subscription)}
+	b, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []*Subscription
+	if err = json.Unmarshal(b, &list); err != nil {
+		return nil, fmt.Errorf("Could not read webhooks [%s], %s", s.path, err.Error())
+	}
+	for _, sub := range list {
+		s.byId[sub.Id] = sub
+	}
+	return s, nil
+}
+
+// list returns copies of the subscriptions selected by keep, by app, flow and id
+func (s *subscriptions) list(keep func(*Subscription) bool) []*Subscription {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var list []*Subscription
+	for _, sub := range s.byId {
+		if keep(sub) {
+			c := *sub
+			list = append(list, &c)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].App != list[j].App {
+			return list[i].App < list[j].App
+		}
+		if list[i].Flow != list[j].Flow {
+			return list[i].Flow < list[j].Flow
+		}
+		return list[i].Id < list[j].Id
+	})
+	return list
+}
+
+func (s *subscriptions) get(id string) (*Subscription, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sub, ok := s.byId[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	c := *sub
+	return &c, nil
+}
+
+// update applies the change to the subscriptions and saves them, they are left unchanged when saving fails
+func (s *subscriptions) update(change func(byId map[string]*Subscription) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	byId := make(map[string]*Subscription, len(s.byId))
+	for id, sub := range s.byId {
+		byId[id] = sub
+	}
+	if err := change(byId); err != nil {
+		return err
+	}
+	if err := s.save(byId); err != nil {
+		return fmt.Errorf("Could not save webhooks [%s], %s", s.path, err.Error())
+	}
+	s.byId = byId
+	return nil
+}
+
+// save writes the subscriptions to the file
+func (s *subscriptions) save(byId map[string]*Subscription) error {
+	list := make([]*Subscription, 0, len(byId))
+	for _, sub := range byId {
+		list = append(list, sub)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+	b, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(s.path, b)
+}
+
+// writeFile replaces the file by writing a temporary file and renaming it
+func writeFile(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
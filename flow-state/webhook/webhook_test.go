@@ -0,0 +1,401 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/project-flogo/flow/state"
+	"github.com/project-flogo/services/flow-state/event"
+	"github.com/project-flogo/services/flow-state/store"
+	"github.com/project-flogo/services/flow-state/store/metadata"
+)
+
+// stepStore knows the steps and owners of the flow instances, the other methods of the store are not used
+type stepStore struct {
+	store.Store
+	steps  map[string][]map[string]string
+	owners map[string]*metadata.Owner
+}
+
+func (s *stepStore) GetStepsStatus(flowId string) ([]map[string]string, error) {
+	return s.steps[flowId], nil
+}
+
+func (s *stepStore) GetFlowOwner(flowId string) (*metadata.Owner, error) {
+	return s.owners[flowId], nil
+}
+
+type request struct {
+	header  http.Header
+	body    []byte
+	payload *Payload
+}
+
+// receiver records the webhooks it receives, the first failures requests are answered with the code
+type receiver struct {
+	*httptest.Server
+	failures int
+	code     int
+
+	mu       sync.Mutex
+	attempts int
+	requests []*request
+}
+
+func newReceiver(failures, code int) *receiver {
+	r := &receiver{failures: failures, code: code}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.attempts++
+		if r.attempts <= r.failures {
+			w.WriteHeader(r.code)
+			return
+		}
+		payload := &Payload{}
+		_ = json.Unmarshal(body, payload)
+		r.requests = append(r.requests, &request{header: req.Header, body: body, payload: payload})
+	}))
+	return r
+}
+
+func (r *receiver) received() []*request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*request(nil), r.requests...)
+}
+
+func newManager(t *testing.T, dir string, steps store.Store) (*Manager, *event.Hub) {
+	t.Helper()
+	// the receivers of the tests listen on the loopback addresses, which are denied by default
+	cfg, err := NewConfig(map[string]interface{}{"dir": dir, "retryDelay": 1, "maxAttempts": 3, "allowedNetworks": "127.0.0.0/8, ::1/128"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := New(cfg, steps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.hub = event.NewHub(0, 0, 0)
+	return m, m.hub
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSubscriptions(t *testing.T) {
+	dir := t.TempDir()
+	m, _ := newManager(t, dir, &stepStore{})
+
+	for _, sub := range []*Subscription{
+		{URL: "http://localhost/hook"},
+		{App: "orders", URL: "ftp://localhost/hook"},
+		{App: "orders", URL: "http://localhost/hook", Statuses: []string{"Active"}},
+	} {
+		if _, err := m.Create("admin", sub); err == nil {
+			t.Fatalf("expected %+v to be rejected", sub)
+		}
+	}
+
+	created, err := m.Create("admin", &Subscription{App: "orders", Flow: "create", URL: "http://localhost/hook", Statuses: []string{"failed"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Id == "" || created.Secret == "" || created.User != "admin" || created.Statuses[0] != "Failed" {
+		t.Fatalf("unexpected subscription %+v", created)
+	}
+	if _, err = m.Create("admin", &Subscription{App: "billing", URL: "https://localhost/hook", Secret: "s3cret"}); err != nil {
+		t.Fatal(err)
+	}
+	if list := m.List("admin", "orders", ""); len(list) != 1 || list[0].Id != created.Id {
+		t.Fatalf("unexpected subscriptions %+v", list)
+	}
+	if list := m.List("other", "", ""); len(list) != 0 {
+		t.Fatalf("expected no subscriptions of another user, got %+v", list)
+	}
+
+	updated, err := m.Update(created.Id, &Subscription{URL: "http://localhost/other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Flow != "" || len(updated.Statuses) != 3 || updated.Secret != created.Secret || updated.App != "orders" {
+		t.Fatalf("unexpected update %+v", updated)
+	}
+	if _, err = m.Update("missing", &Subscription{URL: "http://localhost/hook"}); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	// the subscriptions are loaded again from the directory
+	reopened, _ := newManager(t, dir, &stepStore{})
+	if sub, err := reopened.Get(created.Id); err != nil || sub.URL != "http://localhost/other" || sub.Secret != created.Secret {
+		t.Fatalf("unexpected reloaded subscription %+v %v", sub, err)
+	}
+	if err = reopened.Delete(created.Id); err != nil {
+		t.Fatal(err)
+	}
+	if err = reopened.Delete(created.Id); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	reopened, _ = newManager(t, dir, &stepStore{})
+	if list := reopened.List("admin", "", ""); len(list) != 1 || list[0].App != "billing" {
+		t.Fatalf("unexpected subscriptions after delete %+v", list)
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	r := newReceiver(2, http.StatusServiceUnavailable)
+	defer r.Close()
+	steps := &stepStore{
+		steps: map[string][]map[string]string{"i1": {
+			{"stepId": "1", "taskName": "log", "status": "Completed"},
+			{"stepId": "2", "taskName": "invoke", "status": "Failed"},
+		}},
+		owners: map[string]*metadata.Owner{"i3": {Username: "admin", AppName: "orders", AppVersion: "1.0.0"}},
+	}
+	m, hub := newManager(t, t.TempDir(), steps)
+	failed, _ := m.Create("admin", &Subscription{App: "orders", Flow: "create", URL: r.URL, Statuses: []string{"Failed"}})
+	m.Start()
+	defer m.Stop()
+
+	hub.PublishStart(&state.FlowState{FlowInstanceId: "i1", UserId: "admin", AppName: "orders", AppVersion: "1.0.0", FlowName: "create", FlowStats: "Active"})
+	hub.PublishStart(&state.FlowState{FlowInstanceId: "i2", UserId: "admin", AppName: "orders", FlowName: "create", FlowStats: "Active"})
+	hub.PublishEnd(&state.FlowState{FlowInstanceId: "i2", FlowStats: "Completed"})
+	hub.PublishEnd(&state.FlowState{FlowInstanceId: "i1", FlowStats: "Failed"})
+
+	waitFor(t, func() bool { return len(r.received()) == 1 })
+	req := r.received()[0]
+	if p := req.payload; p.Event != "flow.failed" || p.FlowInstanceId != "i1" || p.AppName != "orders" || p.AppVersion != "1.0.0" ||
+		p.FlowName != "create" || p.Status != "Failed" || p.FailedTask != "invoke" || p.StepId != "2" {
+		t.Fatalf("unexpected payload %+v", p)
+	}
+	if req.header.Get(SignatureHeader) != Sign(failed.Secret, req.body) || req.header.Get(EventHeader) != "flow.failed" ||
+		req.header.Get(DeliveryHeader) != req.payload.Id {
+		t.Fatalf("unexpected headers %v", req.header)
+	}
+	waitFor(t, func() bool { return m.Deliveries(failed.Id)[0].Status == Delivered })
+	if d := m.Deliveries(failed.Id); len(d) != 1 || d[0].Attempts != 3 || d[0].ResponseCode != http.StatusOK || d[0].FlowInstanceId != "i1" {
+		t.Fatalf("unexpected deliveries %+v", d)
+	}
+
+	// the app of an instance the hub does not know comes from the store
+	completed, _ := m.Create("admin", &Subscription{App: "orders", URL: r.URL, Statuses: []string{"Completed"}})
+	hub.PublishEnd(&state.FlowState{FlowInstanceId: "i3", FlowName: "ship", FlowStats: "Completed"})
+	waitFor(t, func() bool { return len(r.received()) == 2 })
+	if p := r.received()[1].payload; p.Event != "flow.completed" || p.AppName != "orders" || p.FlowName != "ship" || p.FailedTask != "" {
+		t.Fatalf("unexpected payload %+v", p)
+	}
+	waitFor(t, func() bool { d := m.Deliveries(completed.Id); return len(d) == 1 && d[0].Status == Delivered })
+	if d := m.Deliveries(completed.Id)[0]; d.Attempts != 1 || d.Event != "flow.completed" {
+		t.Fatalf("unexpected delivery %+v", d)
+	}
+}
+
+func TestDeliverFailures(t *testing.T) {
+	// client errors are not retried
+	r := newReceiver(1, http.StatusGone)
+	defer r.Close()
+	m, hub := newManager(t, t.TempDir(), &stepStore{})
+	sub, _ := m.Create("admin", &Subscription{App: "orders", URL: r.URL})
+	m.Start()
+	defer m.Stop()
+
+	hub.PublishStart(&state.FlowState{FlowInstanceId: "i1", UserId: "admin", AppName: "orders", FlowName: "create", FlowStats: "Active"})
+	hub.PublishEnd(&state.FlowState{FlowInstanceId: "i1", FlowStats: "Cancelled"})
+	waitFor(t, func() bool { d := m.Deliveries(sub.Id); return len(d) == 1 && d[0].Status == Failed })
+	if d := m.Deliveries(sub.Id)[0]; d.Attempts != 1 || d.ResponseCode != http.StatusGone || d.Event != "flow.cancelled" || d.Error == "" {
+		t.Fatalf("unexpected delivery %+v", d)
+	}
+
+	// server errors are retried until the attempts are exhausted
+	r.mu.Lock()
+	r.failures, r.code = r.attempts+10, http.StatusInternalServerError
+	r.mu.Unlock()
+	hub.PublishStart(&state.FlowState{FlowInstanceId: "i2", UserId: "admin", AppName: "orders", FlowName: "create", FlowStats: "Active"})
+	hub.PublishEnd(&state.FlowState{FlowInstanceId: "i2", FlowStats: "Completed"})
+	waitFor(t, func() bool { d := m.Deliveries(sub.Id); return len(d) == 2 && d[0].Status == Failed })
+	if d := m.Deliveries(sub.Id)[0]; d.Attempts != 3 || d.ResponseCode != http.StatusInternalServerError || d.FlowInstanceId != "i2" {
+		t.Fatalf("unexpected delivery %+v", d)
+	}
+	if len(r.received()) != 0 {
+		t.Fatalf("expected no webhook to be received, got %d", len(r.received()))
+	}
+}
+
+func TestNetworks(t *testing.T) {
+	cfg, err := NewConfig(map[string]interface{}{"dir": t.TempDir(), "retryDelay": 1, "maxAttempts": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := New(cfg, &stepStore{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.hub = event.NewHub(0, 0, 0)
+
+	for _, url := range []string{"http://127.0.0.1/hook", "http://localhost:8080/hook", "http://[::1]/hook",
+		"http://169.254.169.254/latest/meta-data", "http://[fe80::1]/hook", "http://0.0.0.0/hook", "http://[::ffff:127.0.0.1]/hook"} {
+		if _, err := m.Create("admin", &Subscription{App: "orders", URL: url}); err == nil {
+			t.Fatalf("expected %s to be rejected", url)
+		}
+	}
+	if _, err = m.Create("admin", &Subscription{App: "orders", URL: "http://10.1.2.3/hook"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the address is checked again when the webhook is posted
+	r := newReceiver(0, 0)
+	defer r.Close()
+	sub := &Subscription{Id: "local", User: "admin", App: "billing", URL: r.URL, Statuses: Statuses, Secret: "s3cret"}
+	if err = m.subs.update(func(byId map[string]*Subscription) error { byId[sub.Id] = sub; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	m.Start()
+	defer m.Stop()
+	m.hub.PublishStart(&state.FlowState{FlowInstanceId: "i1", UserId: "admin", AppName: "billing", FlowName: "create", FlowStats: "Active"})
+	m.hub.PublishEnd(&state.FlowState{FlowInstanceId: "i1", FlowStats: "Completed"})
+	waitFor(t, func() bool { d := m.Deliveries(sub.Id); return len(d) == 1 && d[0].Status == Failed })
+	if d := m.Deliveries(sub.Id)[0]; d.Attempts != 1 || !strings.Contains(d.Error, "not allowed") || len(r.received()) != 0 {
+		t.Fatalf("unexpected delivery %+v", d)
+	}
+
+	if _, err = NewConfig(map[string]interface{}{"deniedNetworks": []interface{}{"10.0.0.0/8", "invalid"}}); err == nil {
+		t.Fatal("expected an invalid network to be rejected")
+	}
+	cfg, err = NewConfig(map[string]interface{}{"deniedNetworks": []interface{}{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.permits(net.ParseIP("10.0.0.1")) || !cfg.permits(net.ParseIP("127.0.0.1")) {
+		t.Fatal("expected the denied networks to replace the default ones")
+	}
+}
+
+func TestDeliveryLog(t *testing.T) {
+	r := newReceiver(1, http.StatusServiceUnavailable)
+	defer r.Close()
+	dir := t.TempDir()
+	m, hub := newManager(t, dir, &stepStore{})
+	// the retry is still waiting when the manager stops
+	m.cfg.RetryDelay = 60000
+	sub, _ := m.Create("admin", &Subscription{App: "orders", URL: r.URL})
+	m.Start()
+
+	hub.PublishStart(&state.FlowState{FlowInstanceId: "i1", UserId: "admin", AppName: "orders", FlowName: "create", FlowStats: "Active"})
+	hub.PublishEnd(&state.FlowState{FlowInstanceId: "i1", FlowStats: "Completed"})
+	waitFor(t, func() bool { d := m.Deliveries(sub.Id); return len(d) == 1 && d[0].Attempts == 1 })
+	m.Stop()
+	if d := m.Deliveries(sub.Id)[0]; d.Status != Pending || d.ResponseCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected delivery %+v", d)
+	}
+
+	// the log is loaded again and the pending delivery is sent once the manager starts
+	reopened, _ := newManager(t, dir, &stepStore{})
+	pending := reopened.Deliveries(sub.Id)
+	if len(pending) != 1 || pending[0].Status != Pending || pending[0].Attempts != 1 || pending[0].FlowInstanceId != "i1" {
+		t.Fatalf("unexpected reloaded deliveries %+v", pending)
+	}
+	reopened.Start()
+	defer reopened.Stop()
+	waitFor(t, func() bool { return reopened.Deliveries(sub.Id)[0].Status == Delivered })
+	if d := reopened.Deliveries(sub.Id)[0]; d.Id != pending[0].Id || d.Attempts != 2 || len(r.received()) != 1 || r.received()[0].payload.Id != d.Id {
+		t.Fatalf("unexpected delivery %+v", d)
+	}
+
+	// the deliveries of a deleted subscription are not loaded
+	if err := reopened.Delete(sub.Id); err != nil {
+		t.Fatal(err)
+	}
+	reopened.Stop()
+	if reopened, _ = newManager(t, dir, &stepStore{}); len(reopened.Deliveries(sub.Id)) != 0 {
+		t.Fatalf("expected no deliveries of a deleted subscription, got %+v", reopened.Deliveries(sub.Id))
+	}
+}
+
+func TestDeliverOwnInstances(t *testing.T) {
+	r := newReceiver(0, 0)
+	defer r.Close()
+	steps := &stepStore{owners: map[string]*metadata.Owner{"i2": {Username: "bob", AppName: "orders"}}}
+	m, hub := newManager(t, t.TempDir(), steps)
+	alice, _ := m.Create("alice", &Subscription{App: "orders", URL: r.URL})
+	bob, _ := m.Create("bob", &Subscription{App: "orders", URL: r.URL})
+	m.Start()
+	defer m.Stop()
+
+	hub.PublishStart(&state.FlowState{FlowInstanceId: "i1", UserId: "alice", AppName: "orders", FlowName: "create", FlowStats: "Active"})
+	hub.PublishEnd(&state.FlowState{FlowInstanceId: "i1", FlowStats: "Failed"})
+	// the owner of an instance the hub does not know comes from the store
+	hub.PublishEnd(&state.FlowState{FlowInstanceId: "i2", FlowName: "create", FlowStats: "Completed"})
+	// an instance without a known owner fires no subscription
+	hub.PublishEnd(&state.FlowState{FlowInstanceId: "i3", AppName: "orders", FlowName: "create", FlowStats: "Completed"})
+
+	waitFor(t, func() bool { return len(r.received()) == 2 })
+	time.Sleep(20 * time.Millisecond)
+	if d := m.Deliveries(alice.Id); len(d) != 1 || d[0].FlowInstanceId != "i1" {
+		t.Fatalf("expected only the instance of alice to be delivered to alice, got %+v", d)
+	}
+	if d := m.Deliveries(bob.Id); len(d) != 1 || d[0].FlowInstanceId != "i2" {
+		t.Fatalf("expected only the instance of bob to be delivered to bob, got %+v", d)
+	}
+	if len(r.received()) != 2 {
+		t.Fatalf("expected 2 webhooks, got %d", len(r.received()))
+	}
+}
+
+func TestDeliverBacklog(t *testing.T) {
+	const instances = 1200
+	release := make(chan struct{})
+	var received int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+		atomic.AddInt32(&received, 1)
+	}))
+	defer server.Close()
+	m, hub := newManager(t, t.TempDir(), &stepStore{})
+	if _, err := m.Create("admin", &Subscription{App: "orders", URL: server.URL}); err != nil {
+		t.Fatal(err)
+	}
+	m.Start()
+	defer m.Stop()
+
+	// every worker waits for the endpoint, the ends and deliveries beyond the hub buffer wait in the log
+	waiting := func() int {
+		m.logLock.Lock()
+		defer m.logLock.Unlock()
+		return len(m.ends) + len(m.pending)
+	}
+	for i := 0; i < instances; i++ {
+		id := fmt.Sprintf("i%d", i)
+		hub.PublishStart(&state.FlowState{FlowInstanceId: id, UserId: "admin", AppName: "orders", FlowName: "create", FlowStats: "Active"})
+		hub.PublishEnd(&state.FlowState{FlowInstanceId: id, FlowStats: "Completed"})
+		if i%100 == 99 {
+			waitFor(t, func() bool { return waiting() == i+1 })
+		}
+	}
+	close(release)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for atomic.LoadInt32(&received) != instances || waiting() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d webhooks, got %d with %d waiting", instances, atomic.LoadInt32(&received), waiting())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,98 @@
+package flow
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Change is a difference between two revisions of a flow, in the form of a JSON Patch (RFC 6902) operation
+// with the replaced or removed value added
+type Change struct {
+	Op       string      `json:"op"`
+	Path     string      `json:"path"`
+	Value    interface{} `json:"value,omitempty"`
+	OldValue interface{} `json:"oldValue,omitempty"`
+}
+
+// MarshalJSON writes the value of add and replace operations even when it is null
+func (c *Change) MarshalJSON() ([]byte, error) {
+	type change Change
+	if c.Op == "remove" {
+		return json.Marshal((*change)(c))
+	}
+	return json.Marshal(&struct {
+		*change
+		Value interface{} `json:"value"`
+	}{(*change)(c), c.Value})
+}
+
+// Diff lists the changes turning the from flow body into the to flow body, applied in order they are a valid JSON Patch
+func Diff(from, to interface{}) []*Change {
+	return diff("", from, to, nil)
+}
+
+func diff(path string, from, to interface{}, changes []*Change) []*Change {
+	switch f := from.(type) {
+	case map[string]interface{}:
+		t, ok := to.(map[string]interface{})
+		if !ok {
+			break
+		}
+		var keys []string
+		for k := range f {
+			keys = append(keys, k)
+		}
+		for k := range t {
+			if _, ok := f[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fv, inFrom := f[k]
+			tv, inTo := t[k]
+			p := path + "/" + escape(k)
+			switch {
+			case !inTo:
+				changes = append(changes, &Change{Op: "remove", Path: p, OldValue: fv})
+			case !inFrom:
+				changes = append(changes, &Change{Op: "add", Path: p, Value: tv})
+			default:
+				changes = diff(p, fv, tv, changes)
+			}
+		}
+		return changes
+	case []interface{}:
+		t, ok := to.([]interface{})
+		if !ok {
+			break
+		}
+		n := len(f)
+		if len(t) < n {
+			n = len(t)
+		}
+		for i := 0; i < n; i++ {
+			changes = diff(path+"/"+strconv.Itoa(i), f[i], t[i], changes)
+		}
+		for i := n; i < len(t); i++ {
+			changes = append(changes, &Change{Op: "add", Path: path + "/" + strconv.Itoa(i), Value: t[i]})
+		}
+		// removed from the end so the indexes of the remaining elements do not move
+		for i := len(f) - 1; i >= n; i-- {
+			changes = append(changes, &Change{Op: "remove", Path: path + "/" + strconv.Itoa(i), OldValue: f[i]})
+		}
+		return changes
+	}
+	if !reflect.DeepEqual(from, to) {
+		changes = append(changes, &Change{Op: "replace", Path: path, Value: to, OldValue: from})
+	}
+	return changes
+}
+
+// escape escapes a key as a JSON Pointer (RFC 6901) reference token
+func escape(key string) string {
+	return strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+}
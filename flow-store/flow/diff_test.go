@@ -0,0 +1,38 @@
+package flow
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	var from, to interface{}
+	_ = json.Unmarshal([]byte(`{"name": "orders", "tasks": [{"id": "log"}, {"id": "rest"}, {"id": "return"}], "a/b": 1, "old": true}`), &from)
+	_ = json.Unmarshal([]byte(`{"name": "billing", "tasks": [{"id": "log", "level": "debug"}], "a/b": 1, "new": null}`), &to)
+
+	changes, _ := json.Marshal(Diff(from, to))
+	expected := `[{"op":"replace","path":"/name","oldValue":"orders","value":"billing"},` +
+		`{"op":"add","path":"/new","value":null},` +
+		`{"op":"remove","path":"/old","oldValue":true},` +
+		`{"op":"add","path":"/tasks/0/level","value":"debug"},` +
+		`{"op":"remove","path":"/tasks/2","oldValue":{"id":"return"}},` +
+		`{"op":"remove","path":"/tasks/1","oldValue":{"id":"rest"}}]`
+	if string(changes) != expected {
+		t.Fatalf("expected %s, got %s", expected, changes)
+	}
+
+	if changes := Diff(from, from); len(changes) != 0 {
+		t.Fatalf("expected no changes between equal flows, got %d", len(changes))
+	}
+}
+
+func TestHash(t *testing.T) {
+	a := map[string]interface{}{"name": "orders", "tasks": []interface{}{"log"}}
+	b := map[string]interface{}{"tasks": []interface{}{"log"}, "name": "orders"}
+	if Hash(a) != Hash(b) || len(Hash(a)) != 64 {
+		t.Fatalf("expected equal hashes for equal flows, got %s and %s", Hash(a), Hash(b))
+	}
+	if Hash(a) == Hash(map[string]interface{}{"name": "billing"}) {
+		t.Fatal("expected different hashes for different flows")
+	}
+}
@@ -1,13 +1,86 @@
 package flow
 
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Metadata describes a revision of a flow, every save creates a new revision and the flow is its current revision
 type Metadata struct {
 	Id           string `json:"id"`
 	Name         string `json:"name"`
 	Description  string `json:"description"`
 	CreationDate string `json:"creationDate"`
+	// Revision numbers start at 1 and grow with every save
+	Revision     int    `json:"revision"`
+	Author       string `json:"author,omitempty"`
+	RevisionDate string `json:"revisionDate"`
+	// Hash is the SHA-256 of the flow JSON
+	Hash string `json:"hash"`
 }
 
 type Flow struct {
 	Metadata *Metadata   `json:"metadata"`
 	Flow     interface{} `json:"flow"`
 }
+
+// Id is the id of the flow body, or an empty string when the storage has to generate it
+func Id(body map[string]interface{}) string {
+	if id, ok := body["id"].(string); ok {
+		return id
+	}
+	return ""
+}
+
+// NewId generates a random flow id, so generated ids do not collide with the ids of flows saved before a restart
+func NewId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic("could not generate a flow id, " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Hash is the hex SHA-256 of the JSON of the flow body, the keys of the JSON objects are sorted so equal bodies have equal hashes
+func Hash(body interface{}) string {
+	content, err := json.Marshal(body)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// NewRevision creates the revision saved after the latest revision of the flow, it keeps the creation date of the flow
+func NewRevision(current *Metadata, latest int, body map[string]interface{}, author string) *Flow {
+	fl := New(current.Id, body, author)
+	fl.Metadata.Revision = latest + 1
+	fl.Metadata.CreationDate = current.CreationDate
+	return fl
+}
+
+// New creates the first revision of the flow saved with the id, its metadata is read from the body
+func New(id string, body map[string]interface{}, author string) *Flow {
+	// the body is validated before it is saved, a name or description of another type is ignored
+	name, _ := body["name"].(string)
+	description, _ := body["description"].(string)
+	now := time.Now().String()
+	flowMetadata := &Metadata{
+		Id:           id,
+		Name:         name,
+		Description:  description,
+		CreationDate: now,
+		Revision:     1,
+		Author:       author,
+		RevisionDate: now,
+		Hash:         Hash(body),
+	}
+
+	return &Flow{
+		Metadata: flowMetadata,
+		Flow:     body,
+	}
+}
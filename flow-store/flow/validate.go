@@ -0,0 +1,393 @@
+package flow
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Violation is a reason a flow body is not a valid Flogo flow, Path is the JSON Pointer (RFC 6901) of the invalid value
+type Violation struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists the violations of an invalid flow body
+type ValidationError struct {
+	Violations []*Violation
+}
+
+func (e *ValidationError) Error() string {
+	var messages []string
+	for _, v := range e.Violations {
+		messages = append(messages, v.Path+": "+v.Message)
+	}
+	return "flow is not valid, " + strings.Join(messages, ", ")
+}
+
+// link types of the Flogo flow model, by name and by number
+var linkTypes = map[string]bool{
+	"default": true, "dependency": true, "0": true,
+	"expression": true, "1": true,
+	"label": true, "2": true,
+	"error": true, "3": true,
+	"exprOtherwise": true, "4": true,
+}
+
+// opening is the opening parenthesis or bracket of the closing ones
+var opening = map[rune]rune{')': '(', ']': '[', '}': '{'}
+
+// Validate checks the flow body against the Flogo flow model, its tasks, links, error handler, mappings
+// and activity refs, and returns a *ValidationError listing the violations
+func Validate(body map[string]interface{}) error {
+	v := &validator{}
+	v.flow(body)
+	if len(v.violations) == 0 {
+		return nil
+	}
+	return &ValidationError{Violations: v.violations}
+}
+
+type validator struct {
+	violations []*Violation
+	// aliases are the aliases of the activities imported by the flow, nil when it has no imports
+	aliases map[string]bool
+}
+
+func (v *validator) add(path string, format string, args ...interface{}) {
+	v.violations = append(v.violations, &Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func pointer(path string, token interface{}) string {
+	if i, ok := token.(int); ok {
+		return path + "/" + strconv.Itoa(i)
+	}
+	return path + "/" + escape(token.(string))
+}
+
+func (v *validator) flow(body map[string]interface{}) {
+	if name, ok := body["name"].(string); !ok || strings.TrimSpace(name) == "" {
+		v.add("/name", "name is required")
+	}
+	if id, ok := body["id"]; ok {
+		if s, ok := id.(string); !ok || s == "" {
+			v.add("/id", "id must be a non empty string")
+		}
+	}
+	for _, key := range []string{"description", "model"} {
+		if value, ok := body[key]; ok {
+			if _, ok := value.(string); !ok {
+				v.add("/"+key, "%s must be a string", key)
+			}
+		}
+	}
+	if value, ok := body["explicitReply"]; ok {
+		if _, ok := value.(bool); !ok {
+			v.add("/explicitReply", "explicitReply must be a boolean")
+		}
+	}
+	v.imports(body["imports"])
+	v.metadata(body["metadata"])
+	v.scope("", body)
+
+	if value, ok := body["errorHandler"]; ok {
+		handler, ok := value.(map[string]interface{})
+		if !ok {
+			v.add("/errorHandler", "errorHandler must be an object")
+			return
+		}
+		v.scope("/errorHandler", handler)
+	}
+}
+
+// imports reads the aliases of the imports, written as "<path>" or "<alias> <path>" with an optional "@<version>"
+func (v *validator) imports(value interface{}) {
+	if value == nil {
+		return
+	}
+	imports, ok := value.([]interface{})
+	if !ok {
+		v.add("/imports", "imports must be an array")
+		return
+	}
+	v.aliases = make(map[string]bool)
+	for i, value := range imports {
+		s, ok := value.(string)
+		fields := strings.Fields(s)
+		if !ok || len(fields) == 0 || len(fields) > 2 {
+			v.add(pointer("/imports", i), "import must be a path, optionally preceded by an alias")
+			continue
+		}
+		path := strings.Split(fields[len(fields)-1], "@")[0]
+		alias := path[strings.LastIndex(path, "/")+1:]
+		if len(fields) == 2 {
+			alias = fields[0]
+		}
+		v.aliases[alias] = true
+	}
+}
+
+func (v *validator) metadata(value interface{}) {
+	if value == nil {
+		return
+	}
+	md, ok := value.(map[string]interface{})
+	if !ok {
+		v.add("/metadata", "metadata must be an object")
+		return
+	}
+	for _, key := range []string{"input", "output"} {
+		value, ok := md[key]
+		if !ok {
+			continue
+		}
+		path := "/metadata/" + key
+		attributes, ok := value.([]interface{})
+		if !ok {
+			v.add(path, "%s must be an array", key)
+			continue
+		}
+		names := make(map[string]bool)
+		for i, value := range attributes {
+			attribute, ok := value.(map[string]interface{})
+			if !ok {
+				v.add(pointer(path, i), "attribute must be an object")
+				continue
+			}
+			name, ok := attribute["name"].(string)
+			if !ok || name == "" {
+				v.add(pointer(path, i)+"/name", "attribute name is required")
+			} else if names[name] {
+				v.add(pointer(path, i)+"/name", "attribute [%s] is defined more than once", name)
+			}
+			names[name] = true
+			if t, ok := attribute["type"]; ok {
+				if _, ok := t.(string); !ok {
+					v.add(pointer(path, i)+"/type", "attribute type must be a string")
+				}
+			}
+		}
+	}
+}
+
+// scope checks the tasks and links of the flow or of its error handler, links only join tasks of the same scope
+func (v *validator) scope(path string, scope map[string]interface{}) {
+	tasks := make(map[string]bool)
+	if value, ok := scope["tasks"]; ok && value != nil {
+		list, ok := value.([]interface{})
+		if !ok {
+			v.add(path+"/tasks", "tasks must be an array")
+		}
+		for i, value := range list {
+			v.task(pointer(path+"/tasks", i), value, tasks)
+		}
+	}
+
+	if value, ok := scope["links"]; ok && value != nil {
+		list, ok := value.([]interface{})
+		if !ok {
+			v.add(path+"/links", "links must be an array")
+		}
+		for i, value := range list {
+			v.link(pointer(path+"/links", i), value, tasks)
+		}
+	}
+}
+
+func (v *validator) task(path string, value interface{}, ids map[string]bool) {
+	task, ok := value.(map[string]interface{})
+	if !ok {
+		v.add(path, "task must be an object")
+		return
+	}
+	id, ok := task["id"].(string)
+	if !ok || id == "" {
+		v.add(path+"/id", "task id is required")
+	} else if ids[id] {
+		v.add(path+"/id", "task [%s] is defined more than once", id)
+	} else {
+		ids[id] = true
+	}
+	for _, key := range []string{"name", "type", "description"} {
+		if value, ok := task[key]; ok {
+			if _, ok := value.(string); !ok {
+				v.add(path+"/"+key, "%s must be a string", key)
+			}
+		}
+	}
+	v.mappings(path+"/settings", task["settings"])
+
+	value, ok = task["activity"]
+	if !ok || value == nil {
+		v.add(path+"/activity", "task activity is required")
+		return
+	}
+	activity, ok := value.(map[string]interface{})
+	if !ok {
+		v.add(path+"/activity", "activity must be an object")
+		return
+	}
+	v.ref(path+"/activity", activity)
+	for _, key := range []string{"settings", "input", "output"} {
+		v.mappings(path+"/activity/"+key, activity[key])
+	}
+}
+
+// ref checks the activity ref, either the alias of an imported activity or its import path
+func (v *validator) ref(path string, activity map[string]interface{}) {
+	value, ok := activity["ref"]
+	if t, isString := activity["type"].(string); !ok && isString && t != "" {
+		// deprecated, the type is the alias of the activity
+		value, path = "#"+t, path+"/type"
+	} else {
+		path = path + "/ref"
+	}
+	ref, ok := value.(string)
+	switch {
+	case !ok || ref == "":
+		v.add(path, "activity ref is required")
+	case strings.HasPrefix(ref, "#"):
+		alias := ref[1:]
+		if !isIdentifier(alias) {
+			v.add(path, "activity ref [%s] is not a valid alias", ref)
+		} else if v.aliases != nil && !v.aliases[alias] {
+			v.add(path, "activity [%s] is not imported", ref)
+		}
+	case strings.ContainsAny(ref, " \t\n") || !strings.Contains(ref, "/") || strings.Contains(ref, "//") || strings.HasSuffix(ref, "/"):
+		v.add(path, "activity ref [%s] is not a valid import path", ref)
+	}
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if !(r == '_' || r == '-' || r == '.' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func (v *validator) link(path string, value interface{}, tasks map[string]bool) {
+	link, ok := value.(map[string]interface{})
+	if !ok {
+		v.add(path, "link must be an object")
+		return
+	}
+	for _, key := range []string{"from", "to"} {
+		id, ok := link[key].(string)
+		if !ok || id == "" {
+			v.add(path+"/"+key, "link %s is required", key)
+		} else if !tasks[id] {
+			v.add(path+"/"+key, "task [%s] not found", id)
+		}
+	}
+
+	linkType := "default"
+	if value, ok := link["type"]; ok {
+		switch t := value.(type) {
+		case string:
+			linkType = t
+		case float64:
+			linkType = strconv.FormatFloat(t, 'f', -1, 64)
+		}
+		if !linkTypes[linkType] {
+			v.add(path+"/type", "link type [%v] is not supported", value)
+		}
+	}
+	expression, isString := link["value"].(string)
+	if _, ok := link["value"]; ok && !isString {
+		v.add(path+"/value", "link value must be a string")
+	}
+	if linkType == "expression" || linkType == "1" {
+		if strings.TrimSpace(expression) == "" {
+			v.add(path+"/value", "expression link requires an expression")
+		} else {
+			v.expression(path+"/value", expression)
+		}
+	}
+}
+
+// mappings checks the names and expressions of task settings and activity settings, input and output mappings,
+// expressions are the strings starting with = of the values, object mappings included
+func (v *validator) mappings(path string, value interface{}) {
+	if value == nil {
+		return
+	}
+	mappings, ok := value.(map[string]interface{})
+	if !ok {
+		v.add(path, "mappings must be an object")
+		return
+	}
+	var names []string
+	for name := range mappings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if strings.TrimSpace(name) == "" {
+			v.add(pointer(path, name), "mapping name is required")
+		}
+		v.expressions(pointer(path, name), mappings[name])
+	}
+}
+
+func (v *validator) expressions(path string, value interface{}) {
+	switch t := value.(type) {
+	case string:
+		if strings.HasPrefix(t, "=") {
+			v.expression(path, t[1:])
+		}
+	case map[string]interface{}:
+		var keys []string
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v.expressions(pointer(path, k), t[k])
+		}
+	case []interface{}:
+		for i, e := range t {
+			v.expressions(pointer(path, i), e)
+		}
+	}
+}
+
+// expression checks the expression is not empty and its quotes, parentheses and brackets are balanced
+func (v *validator) expression(path string, expression string) {
+	if strings.TrimSpace(expression) == "" {
+		v.add(path, "expression is empty")
+		return
+	}
+	var open []rune
+	var quote rune
+	for i, r := range expression {
+		if quote != 0 {
+			if r == quote && (i == 0 || expression[i-1] != '\\') {
+				quote = 0
+			}
+			continue
+		}
+		switch r {
+		case '"', '\'', '`':
+			quote = r
+		case '(', '[', '{':
+			open = append(open, r)
+		case ')', ']', '}':
+			if len(open) == 0 || open[len(open)-1] != opening[r] {
+				v.add(path, "expression [%s] has an unbalanced %c", expression, r)
+				return
+			}
+			open = open[:len(open)-1]
+		}
+	}
+	if quote != 0 {
+		v.add(path, "expression [%s] has an unterminated string", expression)
+	} else if len(open) > 0 {
+		v.add(path, "expression [%s] has an unclosed %c", expression, open[len(open)-1])
+	}
+}
@@ -0,0 +1,102 @@
+package flow
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func violations(t *testing.T, flow string) map[string]string {
+	t.Helper()
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(flow), &body); err != nil {
+		t.Fatal(err)
+	}
+	err := Validate(body)
+	if err == nil {
+		return nil
+	}
+	found := make(map[string]string)
+	for _, v := range err.(*ValidationError).Violations {
+		found[v.Path] = v.Message
+	}
+	return found
+}
+
+func TestValidateValidFlow(t *testing.T) {
+	found := violations(t, `{
+		"id": "orders",
+		"name": "orders",
+		"imports": ["github.com/project-flogo/contrib/activity/log", "rest github.com/project-flogo/contrib/activity/rest@v1.2.0"],
+		"metadata": {"input": [{"name": "order", "type": "object"}], "output": [{"name": "status", "type": "string"}]},
+		"tasks": [
+			{"id": "log", "activity": {"ref": "#log", "input": {"message": "=string.concat(\"order \", $flow.order.id)"}}},
+			{"id": "post", "settings": {"retryOnError": {"count": 3}}, "activity": {"ref": "#rest", "settings": {"uri": "http://orders"},
+				"input": {"content": {"mapping": {"id": "=$flow.order[\"id\"]", "items": ["=$flow.order.items"]}}}}},
+			{"id": "return", "activity": {"ref": "github.com/project-flogo/flow/activity/actreturn", "settings": {"mappings": {"status": "ok"}}}}
+		],
+		"links": [
+			{"from": "log", "to": "post"},
+			{"from": "post", "to": "return", "type": "expression", "value": "$activity[post].status == 200"}
+		],
+		"errorHandler": {
+			"tasks": [{"id": "log", "activity": {"type": "log", "input": {"message": "=$error.message"}}}]
+		}
+	}`)
+	if found != nil {
+		t.Fatalf("expected a valid flow, got %v", found)
+	}
+}
+
+func TestValidateViolations(t *testing.T) {
+	found := violations(t, `{
+		"description": 1,
+		"imports": ["github.com/project-flogo/contrib/activity/log"],
+		"metadata": {"input": [{"name": "order"}, {"name": "order"}, {}]},
+		"tasks": [
+			{"id": "log", "activity": {"ref": "#log", "input": {"message": "=", "level": "=string.concat(\"a\", $flow.b", "": "x"}}},
+			{"id": "log", "activity": {"ref": "#rest"}},
+			{"id": "rest", "activity": {"ref": "rest"}},
+			{"id": "mapper", "activity": {"ref": "#log", "output": {"a/b": {"mapping": {"c": "=$flow.d]"}}}}},
+			{"activity": {}},
+			{"id": "noop"},
+			"task"
+		],
+		"links": [
+			{"from": "log", "to": "missing"},
+			{"to": "rest", "type": "loop"},
+			{"from": "log", "to": "rest", "type": "expression"}
+		],
+		"errorHandler": {"links": [{"from": "log", "to": "log"}]}
+	}`)
+	expected := map[string]string{
+		"/name":                                   "name is required",
+		"/description":                            "description must be a string",
+		"/metadata/input/1/name":                  "attribute [order] is defined more than once",
+		"/metadata/input/2/name":                  "attribute name is required",
+		"/tasks/0/activity/input/message":         "expression is empty",
+		"/tasks/0/activity/input/level":           "expression [string.concat(\"a\", $flow.b] has an unclosed (",
+		"/tasks/0/activity/input/":                "mapping name is required",
+		"/tasks/1/id":                             "task [log] is defined more than once",
+		"/tasks/1/activity/ref":                   "activity [#rest] is not imported",
+		"/tasks/2/activity/ref":                   "activity ref [rest] is not a valid import path",
+		"/tasks/3/activity/output/a~1b/mapping/c": "expression [$flow.d]] has an unbalanced ]",
+		"/tasks/4/id":                             "task id is required",
+		"/tasks/4/activity/ref":                   "activity ref is required",
+		"/tasks/5/activity":                       "task activity is required",
+		"/tasks/6":                                "task must be an object",
+		"/links/0/to":                             "task [missing] not found",
+		"/links/1/from":                           "link from is required",
+		"/links/1/type":                           "link type [loop] is not supported",
+		"/links/2/value":                          "expression link requires an expression",
+		"/errorHandler/links/0/from":              "task [log] not found",
+		"/errorHandler/links/0/to":                "task [log] not found",
+	}
+	for path, message := range expected {
+		if found[path] != message {
+			t.Errorf("%s: expected %q, got %q", path, message, found[path])
+		}
+	}
+	if len(found) != len(expected) {
+		t.Fatalf("expected %d violations, got %d: %v", len(expected), len(found), found)
+	}
+}
@@ -1,9 +1,11 @@
 module github.com/project-flogo/services/flow-store
 
 require (
+	github.com/alicebob/miniredis/v2 v2.30.4
+	github.com/gomodule/redigo v1.8.9
 	github.com/julienschmidt/httprouter v1.3.0
+	github.com/lib/pq v1.10.7
 	github.com/pkg/errors v0.9.1 // indirect
-	github.com/stretchr/testify v1.4.0 // indirect
 	go.uber.org/atomic v1.5.1 // indirect
 	go.uber.org/multierr v1.4.0 // indirect
 	go.uber.org/zap v1.9.1
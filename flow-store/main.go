@@ -11,6 +11,7 @@ import (
 	"go.uber.org/zap/zapcore"
 	"io/ioutil"
 	"net/http"
+	"strconv"
 )
 
 var log = newLogger()
@@ -53,6 +54,10 @@ func main() {
 	flowRouter.GET("/v1/flows/:id/metadata", GetFlowMetadata)
 	flowRouter.POST("/v1/flows", SaveFlow)
 	flowRouter.DELETE("/v1/flows/:id", DeleteFlow)
+	flowRouter.GET("/v1/flows/:id/revisions", ListFlowRevisions)
+	flowRouter.GET("/v1/flows/:id/revisions/:revision", GetFlowRevision)
+	flowRouter.POST("/v1/flows/:id/revisions/:revision/promote", PromoteFlowRevision)
+	flowRouter.GET("/v1/flows/:id/diff", DiffFlowRevisions)
 
 	log.Info("Started server on localhost:" + *Port)
 	http.ListenAndServe(":"+*Port, &FlowServer{flowRouter})
@@ -99,7 +104,18 @@ func Ping(response http.ResponseWriter, request *http.Request, _ httprouter.Para
 
 func ListAllFlow(response http.ResponseWriter, request *http.Request, _ httprouter.Params) {
 	log.Debug("List all flows")
-	flows := storage.AllFlows()
+	var flows []*flow.Flow
+	var err error
+	if name := request.URL.Query().Get("name"); name != "" {
+		flows, err = storage.FlowsByName(name)
+	} else {
+		flows, err = storage.AllFlows()
+	}
+	if err != nil {
+		handlerErrorResponse(response, http.StatusInternalServerError, fmt.Errorf("List flows error [%s]", err.Error()))
+		log.Error(fmt.Sprintf("List flows error :%v", err))
+		return
+	}
 	var metadatas []*flow.Metadata
 	for _, v := range flows {
 		metadatas = append(metadatas, v.Metadata)
@@ -161,12 +177,25 @@ func SaveFlow(response http.ResponseWriter, request *http.Request, _ httprouter.
 	flowInfo := map[string]interface{}{}
 	unmarshalErr := json.Unmarshal(flowContent, &flowInfo)
 	if unmarshalErr != nil {
-		handlerErrorResponse(response, http.StatusInternalServerError, fmt.Errorf("Unmarshal flow body error while save flow"))
+		handlerErrorResponse(response, http.StatusBadRequest, fmt.Errorf("Unmarshal flow body error while save flow [%s]", unmarshalErr.Error()))
 		log.Error(fmt.Sprintf("Unmarshal flow body error while save flow:%v", unmarshalErr))
 		return
 	}
+	if err := flow.Validate(flowInfo); err != nil {
+		flowError := NewError(fmt.Errorf("flow is not valid"), http.StatusBadRequest)
+		flowError.Violations = err.(*flow.ValidationError).Violations
+		writeError(response, flowError)
+		log.Debug(err.Error())
+		return
+	}
 
-	id := storage.SaveFlow(flowInfo)
+	// the author of the revision is the user of the request, as for the state service
+	id, err := storage.SaveFlow(flowInfo, request.Header.Get("username"))
+	if err != nil {
+		handlerErrorResponse(response, http.StatusInternalServerError, fmt.Errorf("Save flow error [%s]", err.Error()))
+		log.Error(fmt.Sprintf("Save flow error :%v", err))
+		return
+	}
 	metadata, err := storage.GetFlowMetadata(id)
 	if err != nil {
 		handlerErrorResponse(response, http.StatusInternalServerError, fmt.Errorf("Get flow from BD error, flow id: "+id))
@@ -196,11 +225,129 @@ func DeleteFlow(response http.ResponseWriter, request *http.Request, params http
 	}
 }
 
+func ListFlowRevisions(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	id := params.ByName("id")
+	log.Debug("List flow revisions " + id)
+
+	revisions, err := storage.FlowRevisions(id)
+	if err != nil {
+		handlerErrorResponse(response, http.StatusInternalServerError, fmt.Errorf("List flow [%s] revisions error [%s]", id, err.Error()))
+		log.Error(fmt.Sprintf("List flow "+id+" revisions error :%v", err))
+		return
+	}
+	if len(revisions) == 0 {
+		handlerErrorResponse(response, http.StatusNotFound, fmt.Errorf("flow [%s] not found", id))
+		return
+	}
+	writeJSON(response, revisions)
+}
+
+func GetFlowRevision(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	id := params.ByName("id")
+	log.Debug("Get flow " + id + " revision " + params.ByName("revision"))
+
+	fl, ok := flowRevision(response, id, params.ByName("revision"))
+	if ok {
+		writeJSON(response, fl)
+	}
+}
+
+// PromoteFlowRevision makes a revision current, promoting an older revision rolls the flow back to it
+func PromoteFlowRevision(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	id := params.ByName("id")
+	log.Info("Promote flow " + id + " revision " + params.ByName("revision"))
+
+	fl, ok := flowRevision(response, id, params.ByName("revision"))
+	if !ok {
+		return
+	}
+	if err := storage.PromoteRevision(id, fl.Metadata.Revision); err != nil {
+		handlerErrorResponse(response, http.StatusInternalServerError, fmt.Errorf("Promote flow [%s] revision error [%s]", id, err.Error()))
+		log.Error(fmt.Sprintf("Promote flow "+id+" revision error :%v", err))
+		return
+	}
+	metadata, err := storage.GetFlowMetadata(id)
+	if err != nil {
+		handlerErrorResponse(response, http.StatusInternalServerError, fmt.Errorf("Get flow metadata [%s] error [%s]", id, err))
+		log.Error(fmt.Sprintf("Get flow metadata "+id+" error :%v", err))
+		return
+	}
+	writeJSON(response, metadata)
+}
+
+// FlowDiff lists the changes from a revision of a flow to another one
+type FlowDiff struct {
+	From    *flow.Metadata `json:"from"`
+	To      *flow.Metadata `json:"to"`
+	Changes []*flow.Change `json:"changes"`
+}
+
+// DiffFlowRevisions compares the revisions of the from and to query parameters, to is the current revision by default
+func DiffFlowRevisions(response http.ResponseWriter, request *http.Request, params httprouter.Params) {
+	id := params.ByName("id")
+	query := request.URL.Query()
+	log.Debug("Diff flow " + id + " revisions " + query.Get("from") + " and " + query.Get("to"))
+
+	to := query.Get("to")
+	if to == "" {
+		metadata, err := storage.GetFlowMetadata(id)
+		if err != nil {
+			handlerErrorResponse(response, http.StatusNotFound, fmt.Errorf("flow [%s] not found", id))
+			return
+		}
+		to = strconv.Itoa(metadata.Revision)
+	}
+	from, ok := flowRevision(response, id, query.Get("from"))
+	if !ok {
+		return
+	}
+	toFlow, ok := flowRevision(response, id, to)
+	if !ok {
+		return
+	}
+	changes := flow.Diff(from.Flow, toFlow.Flow)
+	if changes == nil {
+		changes = []*flow.Change{}
+	}
+	writeJSON(response, &FlowDiff{From: from.Metadata, To: toFlow.Metadata, Changes: changes})
+}
+
+// flowRevision reads the revision of the flow, the error response is written when it can not be read
+func flowRevision(response http.ResponseWriter, id string, revision string) (*flow.Flow, bool) {
+	n, err := strconv.Atoi(revision)
+	if err != nil || n < 1 {
+		handlerErrorResponse(response, http.StatusBadRequest, fmt.Errorf("invalid revision [%s]", revision))
+		return nil, false
+	}
+	fl, err := storage.GetFlowRevision(id, n)
+	if err != nil {
+		handlerErrorResponse(response, http.StatusInternalServerError, fmt.Errorf("Get flow [%s] revision [%d] error [%s]", id, n, err.Error()))
+		log.Error(fmt.Sprintf("Get flow "+id+" revision error :%v", err))
+		return nil, false
+	}
+	if fl == nil {
+		handlerErrorResponse(response, http.StatusNotFound, fmt.Errorf("flow [%s] revision [%d] not found", id, n))
+		return nil, false
+	}
+	return fl, true
+}
+
+func writeJSON(response http.ResponseWriter, v interface{}) {
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(response).Encode(v); err != nil {
+		log.Error(err.Error())
+	}
+}
+
 func handlerErrorResponse(response http.ResponseWriter, code int, err error) {
-	flowErorr := NewError(err, code)
+	writeError(response, NewError(err, code))
+}
+
+func writeError(response http.ResponseWriter, flowError *FlowError) {
 	response.Header().Set("Content-Type", "application/json")
-	response.WriteHeader(code)
-	if err := json.NewEncoder(response).Encode(flowErorr); err != nil {
+	response.WriteHeader(flowError.Code)
+	if err := json.NewEncoder(response).Encode(flowError); err != nil {
 		log.Error(err.Error())
 	}
 }
@@ -215,4 +362,6 @@ func NewError(err error, code int) *FlowError {
 type FlowError struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
+	// Violations lists why a saved flow is not valid
+	Violations []*flow.Violation `json:"violations,omitempty"`
 }
@@ -3,9 +3,20 @@ package api
 import "github.com/project-flogo/services/flow-store/flow"
 
 type Storage interface {
-	AllFlows() []*flow.Flow
-	SaveFlow(flow map[string]interface{}) string
+	AllFlows() ([]*flow.Flow, error)
+	// FlowsByName lists the flows saved with the name
+	FlowsByName(name string) ([]*flow.Flow, error)
+	// SaveFlow saves the flow body as a new revision made current, the id is generated when the body has none
+	SaveFlow(flow map[string]interface{}, author string) (string, error)
 	GetFlow(path string) (interface{}, error)
+	// DeleteFlow deletes the flow with all its revisions
 	DeleteFlow(path string) error
 	GetFlowMetadata(flowId string) (*flow.Metadata, error)
+
+	// FlowRevisions lists the metadata of the revisions of the flow from the first one, it is empty for an unknown flow
+	FlowRevisions(flowId string) ([]*flow.Metadata, error)
+	// GetFlowRevision returns the revision of the flow, or nil when there is no such revision
+	GetFlowRevision(flowId string, revision int) (*flow.Flow, error)
+	// PromoteRevision makes the revision the current revision of the flow, later saves still get new revision numbers
+	PromoteRevision(flowId string, revision int) error
 }
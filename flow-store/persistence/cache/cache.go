@@ -4,13 +4,8 @@ import (
 	"fmt"
 	"github.com/project-flogo/services/flow-store/flow"
 	"github.com/project-flogo/services/flow-store/persistence/api"
-	"strconv"
-	"sync/atomic"
-	"time"
 )
 
-var flowId int64 = 0
-
 type cacheStorage struct {
 	cache *memCache
 }
@@ -19,18 +14,39 @@ func NewCacheStorage() api.Storage {
 	return &cacheStorage{cache: NewCache()}
 }
 
-func (f *cacheStorage) AllFlows() []*flow.Flow {
+func (f *cacheStorage) AllFlows() ([]*flow.Flow, error) {
 	var flows []*flow.Flow
 	for _, v := range f.cache.AllFlows() {
 		flows = append(flows, v)
 	}
-	return flows
+	return flows, nil
+}
+
+func (f *cacheStorage) FlowsByName(name string) ([]*flow.Flow, error) {
+	var flows []*flow.Flow
+	for _, v := range f.cache.AllFlows() {
+		if v.Metadata.Name == name {
+			flows = append(flows, v)
+		}
+	}
+	return flows, nil
 }
 
-func (f *cacheStorage) SaveFlow(flow map[string]interface{}) string {
-	fl := getFlow(flow)
-	f.cache.AddFlow(fl.Metadata.Id, fl)
-	return fl.Metadata.Id
+func (f *cacheStorage) SaveFlow(body map[string]interface{}, author string) (string, error) {
+	if id := flow.Id(body); id != "" {
+		f.cache.AddFlow(id, func() *flow.Flow {
+			return flow.New(id, body, author)
+		}, func(current *flow.Metadata, latest int) *flow.Flow {
+			return flow.NewRevision(current, latest, body, author)
+		})
+		return id, nil
+	}
+	for {
+		id := flow.NewId()
+		if f.cache.AddNewFlow(id, flow.New(id, body, author)) {
+			return id, nil
+		}
+	}
 }
 
 func (f *cacheStorage) GetFlow(id string) (interface{}, error) {
@@ -59,35 +75,25 @@ func (f *cacheStorage) GetFlowMetadata(flowId string) (*flow.Metadata, error) {
 	return fl.Metadata, nil
 }
 
-func getFlow(body map[string]interface{}) *flow.Flow {
-
-	var id string
-	var idtmp, ok = body["id"]
-	if ok && idtmp != nil {
-		id = idtmp.(string)
-	} else {
-		id = strconv.FormatInt(incrementalFlowId(), 10)
-	}
-
-	var description string
-	des, ok := body["description"]
-	if ok {
-		description = des.(string)
-	}
-	flowMetadata := &flow.Metadata{
-		Id:           id,
-		Name:         body["name"].(string),
-		Description:  description,
-		CreationDate: time.Now().String(),
+func (f *cacheStorage) FlowRevisions(flowId string) ([]*flow.Metadata, error) {
+	var revisions []*flow.Metadata
+	for _, v := range f.cache.Revisions(flowId) {
+		revisions = append(revisions, v.Metadata)
 	}
+	return revisions, nil
+}
 
-	flow := &flow.Flow{
-		Metadata: flowMetadata,
-		Flow:    body,
+func (f *cacheStorage) GetFlowRevision(flowId string, revision int) (*flow.Flow, error) {
+	revisions := f.cache.Revisions(flowId)
+	if revision < 1 || revision > len(revisions) {
+		return nil, nil
 	}
-	return flow
+	return revisions[revision-1], nil
 }
 
-func incrementalFlowId() int64 {
-	return atomic.AddInt64(&flowId, 1)
+func (f *cacheStorage) PromoteRevision(flowId string, revision int) error {
+	if !f.cache.Promote(flowId, revision) {
+		return fmt.Errorf("flow [%s] revision [%d] not found", flowId, revision)
+	}
+	return nil
 }
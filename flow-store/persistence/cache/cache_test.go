@@ -0,0 +1,14 @@
+package cache
+
+import (
+	"testing"
+
+	"github.com/project-flogo/services/flow-store/persistence/api"
+	"github.com/project-flogo/services/flow-store/persistence/storagetest"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) api.Storage {
+		return NewCacheStorage()
+	})
+}
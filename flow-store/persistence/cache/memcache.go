@@ -1,41 +1,102 @@
 package cache
 
 import (
-	"github.com/project-flogo/services/flow-store/flow"
 	"sync"
+
+	"github.com/project-flogo/services/flow-store/flow"
 )
 
+// history is the revisions of a flow, revisions[n-1] is the revision n
+type history struct {
+	current   int
+	revisions []*flow.Flow
+}
+
+func (h *history) Current() *flow.Flow {
+	return h.revisions[h.current-1]
+}
+
 type memCache struct {
-	flows map[string]*flow.Flow
+	lock  sync.RWMutex
+	flows map[string]*history
 }
 
-var lock = sync.RWMutex{}
+// AddFlow adds a revision of the flow with the key and makes it current, newFlow is called for a new key
+// and nextRevision after the latest revision of a known key
+func (c *memCache) AddFlow(key string, newFlow func() *flow.Flow, nextRevision func(current *flow.Metadata, latest int) *flow.Flow) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	h, ok := c.flows[key]
+	if !ok {
+		c.flows[key] = &history{current: 1, revisions: []*flow.Flow{newFlow()}}
+		return
+	}
+	h.revisions = append(h.revisions, nextRevision(h.Current().Metadata, len(h.revisions)))
+	h.current = len(h.revisions)
+}
 
-func (c *memCache) AddFlow(key string, value *flow.Flow) {
-	lock.Lock()
-	if c.flows == nil {
-		c.flows = make(map[string]*flow.Flow)
+// AddNewFlow adds the flow unless the key is taken, and reports whether it was added
+func (c *memCache) AddNewFlow(key string, value *flow.Flow) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, taken := c.flows[key]; taken {
+		return false
 	}
-	c.flows[key] = value
-	lock.Unlock()
+	c.flows[key] = &history{current: 1, revisions: []*flow.Flow{value}}
+	return true
 }
 
+// AllFlows returns the current revisions of the flows
 func (c *memCache) AllFlows() map[string]*flow.Flow {
-	return c.flows
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	flows := make(map[string]*flow.Flow, len(c.flows))
+	for k, v := range c.flows {
+		flows[k] = v.Current()
+	}
+	return flows
 }
 
+// GetFlow returns the current revision of the flow
 func (c *memCache) GetFlow(key string) *flow.Flow {
-	return c.flows[key]
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if h, ok := c.flows[key]; ok {
+		return h.Current()
+	}
+	return nil
+}
+
+// Revisions returns the revisions of the flow, the slice must not be changed
+func (c *memCache) Revisions(key string) []*flow.Flow {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if h, ok := c.flows[key]; ok {
+		return h.revisions[:len(h.revisions):len(h.revisions)]
+	}
+	return nil
+}
+
+// Promote makes the revision current, and reports whether the flow has the revision
+func (c *memCache) Promote(key string, revision int) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	h, ok := c.flows[key]
+	if !ok || revision < 1 || revision > len(h.revisions) {
+		return false
+	}
+	h.current = revision
+	return true
 }
 
 func (c *memCache) DeleteFlow(key string) {
-	lock.Lock()
+	c.lock.Lock()
 	delete(c.flows, key)
-	lock.Unlock()
+	c.lock.Unlock()
 }
 
 func NewCache() *memCache {
 	return &memCache{
-		flows: make(map[string]*flow.Flow),
+		flows: make(map[string]*history),
 	}
 }
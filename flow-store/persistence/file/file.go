@@ -0,0 +1,328 @@
+// Package file stores every flow as a JSON file of a directory
+//
+//	<dir>/<id>.json                  the metadata and body of the current revision of the flow, the id is path escaped
+//	<dir>/<id>.revisions/<n>.json    the revision n of the flow
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/project-flogo/services/flow-store/flow"
+	"github.com/project-flogo/services/flow-store/persistence/api"
+)
+
+const (
+	DefaultDir = "flows"
+
+	ext          = ".json"
+	revisionsExt = ".revisions"
+)
+
+type fileStorage struct {
+	dir  string
+	lock sync.RWMutex
+}
+
+// NewStorage creates the storage, the directory is created when it does not exist
+func NewStorage(dir string) (api.Storage, error) {
+	if dir == "" {
+		dir = DefaultDir
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("flows directory [%s] can not be created: %s", dir, err.Error())
+	}
+	return &fileStorage{dir: dir}, nil
+}
+
+func (f *fileStorage) path(id string) string {
+	return filepath.Join(f.dir, escape(id)+ext)
+}
+
+func (f *fileStorage) revisionsDir(id string) string {
+	return filepath.Join(f.dir, escape(id)+revisionsExt)
+}
+
+func (f *fileStorage) revisionPath(id string, revision int) string {
+	return filepath.Join(f.revisionsDir(id), strconv.Itoa(revision)+ext)
+}
+
+func escape(id string) string {
+	name := url.PathEscape(id)
+	// a leading dot is escaped as well, the temporary files are the hidden files of the directory
+	if strings.HasPrefix(name, ".") {
+		name = "%2E" + name[1:]
+	}
+	return name
+}
+
+func (f *fileStorage) AllFlows() ([]*flow.Flow, error) {
+	return f.list(func(*flow.Flow) bool { return true })
+}
+
+func (f *fileStorage) FlowsByName(name string) ([]*flow.Flow, error) {
+	return f.list(func(fl *flow.Flow) bool { return fl.Metadata.Name == name })
+}
+
+// list reads the flows of the directory sorted by id
+func (f *fileStorage) list(keep func(*flow.Flow) bool) ([]*flow.Flow, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	files, err := ioutil.ReadDir(f.dir)
+	if err != nil {
+		return nil, err
+	}
+	var flows []*flow.Flow
+	for _, info := range files {
+		name := info.Name()
+		if info.IsDir() || !strings.HasSuffix(name, ext) || strings.HasPrefix(name, ".") {
+			continue
+		}
+		id, err := url.PathUnescape(strings.TrimSuffix(name, ext))
+		if err != nil {
+			continue
+		}
+		fl, err := read(f.path(id), id)
+		if err != nil {
+			return nil, err
+		}
+		if fl != nil && keep(fl) {
+			flows = append(flows, fl)
+		}
+	}
+	sort.Slice(flows, func(i, j int) bool { return flows[i].Metadata.Id < flows[j].Metadata.Id })
+	return flows, nil
+}
+
+// read reads the flow file of the id, or returns nil when there is no file
+func read(path string, id string) (*flow.Flow, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	fl := &flow.Flow{}
+	if err = json.Unmarshal(content, fl); err != nil || fl.Metadata == nil {
+		return nil, fmt.Errorf("flow [%s] can not be read: %v", id, err)
+	}
+	fl.Metadata.Id = id
+	return fl, nil
+}
+
+// SaveFlow writes the flow as a new revision and then as the current revision, the id is generated when the body has none
+func (f *fileStorage) SaveFlow(body map[string]interface{}, author string) (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	id := flow.Id(body)
+	for id == "" {
+		// skip the generated ids of flows already in the directory
+		id = flow.NewId()
+		if f.exists(f.path(id)) || f.exists(f.revisionsDir(id)) {
+			id = ""
+		}
+	}
+
+	current, err := read(f.path(id), id)
+	if err != nil {
+		return "", err
+	}
+	fl := flow.New(id, body, author)
+	if current == nil {
+		// revisions left by a delete that did not complete belong to the deleted flow
+		if err = os.RemoveAll(f.revisionsDir(id)); err != nil {
+			return "", err
+		}
+	} else {
+		latest, err := f.latest(id)
+		if err != nil {
+			return "", err
+		}
+		fl = flow.NewRevision(current.Metadata, latest, body, author)
+	}
+	content, err := json.Marshal(fl)
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(f.revisionsDir(id), 0755); err == nil {
+		err = f.write(f.revisionPath(id, fl.Metadata.Revision), content)
+	}
+	if err == nil {
+		err = f.write(f.path(id), content)
+	}
+	if err != nil {
+		return "", fmt.Errorf("flow [%s] can not be saved: %s", id, err.Error())
+	}
+	return id, nil
+}
+
+func (f *fileStorage) exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// revisions lists the revision numbers of the flow in order
+func (f *fileStorage) revisions(id string) ([]int, error) {
+	files, err := ioutil.ReadDir(f.revisionsDir(id))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var revisions []int
+	for _, info := range files {
+		if !strings.HasSuffix(info.Name(), ext) {
+			continue
+		}
+		if n, err := strconv.Atoi(strings.TrimSuffix(info.Name(), ext)); err == nil {
+			revisions = append(revisions, n)
+		}
+	}
+	sort.Ints(revisions)
+	return revisions, nil
+}
+
+func (f *fileStorage) latest(id string) (int, error) {
+	revisions, err := f.revisions(id)
+	if err != nil || len(revisions) == 0 {
+		return 0, err
+	}
+	return revisions[len(revisions)-1], nil
+}
+
+// write replaces the file atomically, the content is flushed to a temporary file which is then renamed,
+// so a crash leaves either the previous or the new flow
+func (f *fileStorage) write(path string, content []byte) error {
+	tmp, err := ioutil.TempFile(f.dir, ".flow-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	f.syncDir()
+	return nil
+}
+
+// syncDir flushes the directory so renames and removals survive a crash
+func (f *fileStorage) syncDir() {
+	d, err := os.Open(f.dir)
+	if err != nil {
+		return
+	}
+	// not every platform supports syncing a directory
+	_ = d.Sync()
+	d.Close()
+}
+
+func (f *fileStorage) GetFlow(id string) (interface{}, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	fl, err := read(f.path(id), id)
+	if err != nil || fl == nil {
+		return nil, err
+	}
+	return fl.Flow, nil
+}
+
+func (f *fileStorage) DeleteFlow(id string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	err := os.Remove(f.path(id))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("flow [%s] not found", id)
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.RemoveAll(f.revisionsDir(id)); err != nil {
+		return err
+	}
+	f.syncDir()
+	return nil
+}
+
+func (f *fileStorage) GetFlowMetadata(id string) (*flow.Metadata, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	fl, err := read(f.path(id), id)
+	if err != nil {
+		return nil, err
+	}
+	if fl == nil {
+		return nil, fmt.Errorf("flow [%s] not found", id)
+	}
+	return fl.Metadata, nil
+}
+
+func (f *fileStorage) FlowRevisions(id string) ([]*flow.Metadata, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	revisions, err := f.revisions(id)
+	if err != nil {
+		return nil, err
+	}
+	var metadata []*flow.Metadata
+	for _, n := range revisions {
+		fl, err := read(f.revisionPath(id, n), id)
+		if err != nil {
+			return nil, err
+		}
+		if fl != nil {
+			metadata = append(metadata, fl.Metadata)
+		}
+	}
+	return metadata, nil
+}
+
+func (f *fileStorage) GetFlowRevision(id string, revision int) (*flow.Flow, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return read(f.revisionPath(id, revision), id)
+}
+
+// PromoteRevision copies the revision over the current revision of the flow
+func (f *fileStorage) PromoteRevision(id string, revision int) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	fl, err := read(f.revisionPath(id, revision), id)
+	if err != nil {
+		return err
+	}
+	if fl == nil || !f.exists(f.path(id)) {
+		return fmt.Errorf("flow [%s] revision [%d] not found", id, revision)
+	}
+	content, err := json.Marshal(fl)
+	if err != nil {
+		return err
+	}
+	if err = f.write(f.path(id), content); err != nil {
+		return fmt.Errorf("flow [%s] can not be saved: %s", id, err.Error())
+	}
+	return nil
+}
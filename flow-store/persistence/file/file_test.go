@@ -0,0 +1,91 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/project-flogo/services/flow-store/persistence/api"
+	"github.com/project-flogo/services/flow-store/persistence/storagetest"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "flowstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) api.Storage {
+		s, err := NewStorage(tempDir(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestRestart(t *testing.T) {
+	dir := tempDir(t)
+	s, err := NewStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := s.SaveFlow(map[string]interface{}{"name": "orders", "description": "create orders"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.SaveFlow(map[string]interface{}{"id": "../.hidden/flow", "name": "billing"}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, err := NewStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md, err := restarted.GetFlowMetadata(id)
+	if err != nil || md.Name != "orders" || md.Description != "create orders" {
+		t.Fatalf("expected the saved flow after a restart, got %+v, %v", md, err)
+	}
+	billing, err := restarted.FlowsByName("billing")
+	if err != nil || len(billing) != 1 || billing[0].Metadata.Id != "../.hidden/flow" {
+		t.Fatalf("expected the flow with an escaped id after a restart, got %v, %v", billing, err)
+	}
+	if generated, _ := restarted.SaveFlow(map[string]interface{}{"name": "orders"}, ""); generated == id {
+		t.Fatalf("expected a new generated id, got %s", generated)
+	}
+}
+
+func TestAtomicWrite(t *testing.T) {
+	dir := tempDir(t)
+	s, err := NewStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.SaveFlow(map[string]interface{}{"id": "a", "name": "orders"}, ""); err != nil {
+		t.Fatal(err)
+	}
+	// a temporary file left by a crash during a save is ignored
+	if err = ioutil.WriteFile(filepath.Join(dir, ".flow-123"), []byte(`{"metadata":`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.SaveFlow(map[string]interface{}{"id": "a", "name": "billing"}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 3 || files[1].Name() != "a.json" || files[2].Name() != "a.revisions" {
+		t.Fatalf("expected the flow file, its revisions and the crash leftover only, got %d files", len(files))
+	}
+	if revisions, _ := ioutil.ReadDir(filepath.Join(dir, "a.revisions")); len(revisions) != 2 {
+		t.Fatalf("expected 2 revision files, got %d", len(revisions))
+	}
+	all, err := s.AllFlows()
+	if err != nil || len(all) != 1 || all[0].Metadata.Name != "billing" {
+		t.Fatalf("expected the replaced flow, got %v, %v", all, err)
+	}
+}
@@ -1,24 +1,41 @@
 package persistence
 
 import (
+	"os"
+
 	"github.com/project-flogo/services/flow-store/persistence/api"
 	"github.com/project-flogo/services/flow-store/persistence/cache"
+	"github.com/project-flogo/services/flow-store/persistence/file"
+	"github.com/project-flogo/services/flow-store/persistence/postgres"
+	"github.com/project-flogo/services/flow-store/persistence/redis"
 )
 
 var storage api.Storage
 
 func init() {
-	storage = cache.NewCacheStorage()
+	var err error
+	switch os.Getenv("FLOGO_PERSISTENCE_DB") {
+	case "redis":
+		settings, err := redis.SettingsFromEnv()
+		if err != nil {
+			panic("Redis persistence settings are invalid, " + err.Error())
+		}
+		storage = redis.NewStorage(settings)
+	case "file":
+		storage, err = file.NewStorage(os.Getenv("FLOGO_FLOWS_DIR"))
+		if err != nil {
+			panic("File persistence can not be opened, " + err.Error())
+		}
+	case "postgres":
+		storage, err = postgres.NewStorage(os.Getenv("FLOGO_POSTGRES_URL"))
+		if err != nil {
+			panic("Postgres persistence can not be opened, " + err.Error())
+		}
+	default:
+		storage = cache.NewCacheStorage()
+	}
 }
 
 func GetStorage() api.Storage {
 	return storage
 }
-
-//func isRedis() bool {
-//	v := os.Getenv("FLOGO_PERSISTENCE_DB")
-//	if v != "" && v == "redis" {
-//		return true
-//	}
-//	return false
-//}
@@ -0,0 +1,268 @@
+// Package postgres stores the flows in a PostgreSQL database, the tables are created when they do not exist
+//
+//	flows            the current revision of every flow and the number of its latest revision
+//	flow_revisions   every revision of the flows
+package postgres
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	_ "github.com/lib/pq"
+	"github.com/project-flogo/services/flow-store/flow"
+	"github.com/project-flogo/services/flow-store/persistence/api"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS flows (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	description TEXT NOT NULL,
+	creation_date TEXT NOT NULL,
+	flow JSONB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS flows_name ON flows (name);
+ALTER TABLE flows ADD COLUMN IF NOT EXISTS revision INTEGER NOT NULL DEFAULT 0,
+	ADD COLUMN IF NOT EXISTS author TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS revision_date TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS hash TEXT NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS latest_revision INTEGER NOT NULL DEFAULT 0;
+CREATE TABLE IF NOT EXISTS flow_revisions (
+	id TEXT NOT NULL,
+	revision INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	description TEXT NOT NULL,
+	creation_date TEXT NOT NULL,
+	author TEXT NOT NULL,
+	revision_date TEXT NOT NULL,
+	hash TEXT NOT NULL,
+	flow JSONB NOT NULL,
+	PRIMARY KEY (id, revision)
+);`
+
+const (
+	columns = "id, name, description, creation_date, revision, author, revision_date, hash, flow"
+
+	// insertFlow inserts the first revision of a flow, it does nothing when the id is taken
+	insertFlow = `INSERT INTO flows (` + columns + `, latest_revision) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $5)
+ON CONFLICT (id) DO NOTHING`
+	updateFlow = `UPDATE flows SET name = $2, description = $3, creation_date = $4, revision = $5, author = $6, revision_date = $7, hash = $8, flow = $9,
+latest_revision = GREATEST(latest_revision, $5) WHERE id = $1`
+	insertRevision  = `INSERT INTO flow_revisions (` + columns + `) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
+	selectFlows     = "SELECT " + columns + " FROM flows"
+	selectRevisions = "SELECT " + columns + " FROM flow_revisions"
+)
+
+type postgresStorage struct {
+	db *sql.DB
+}
+
+// NewStorage connects to the database of the url and creates the flows table when it does not exist
+func NewStorage(url string) (api.Storage, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("flows database can not be reached: %s", err.Error())
+	}
+	if _, err = db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("flows table can not be created: %s", err.Error())
+	}
+	return &postgresStorage{db: db}, nil
+}
+
+func (p *postgresStorage) AllFlows() ([]*flow.Flow, error) {
+	return p.query(selectFlows + " ORDER BY id")
+}
+
+func (p *postgresStorage) FlowsByName(name string) ([]*flow.Flow, error) {
+	return p.query(selectFlows+" WHERE name = $1 ORDER BY id", name)
+}
+
+func (p *postgresStorage) query(query string, args ...interface{}) ([]*flow.Flow, error) {
+	rows, err := p.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var flows []*flow.Flow
+	for rows.Next() {
+		fl, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		flows = append(flows, fl)
+	}
+	return flows, rows.Err()
+}
+
+func scan(row interface{ Scan(...interface{}) error }) (*flow.Flow, error) {
+	md := &flow.Metadata{}
+	var content []byte
+	if err := row.Scan(&md.Id, &md.Name, &md.Description, &md.CreationDate, &md.Revision, &md.Author, &md.RevisionDate, &md.Hash, &content); err != nil {
+		return nil, err
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(content, &body); err != nil {
+		return nil, fmt.Errorf("flow [%s] can not be read: %s", md.Id, err.Error())
+	}
+	return &flow.Flow{Metadata: md, Flow: body}, nil
+}
+
+func args(fl *flow.Flow, content []byte) []interface{} {
+	md := fl.Metadata
+	return []interface{}{md.Id, md.Name, md.Description, md.CreationDate, md.Revision, md.Author, md.RevisionDate, md.Hash, content}
+}
+
+// SaveFlow adds a revision of the flow and makes it current in a transaction, a generated id is used only when no flow has it
+func (p *postgresStorage) SaveFlow(body map[string]interface{}, author string) (string, error) {
+	content, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+
+	id := flow.Id(body)
+	generated := id == ""
+	for {
+		if generated {
+			id = flow.NewId()
+		}
+		saved, err := p.save(id, generated, body, author, content)
+		if err != nil {
+			return "", err
+		}
+		if saved {
+			return id, nil
+		}
+	}
+}
+
+// save reports false when the flow has to be saved again, because the generated id is taken
+// or because a flow with the id was inserted concurrently
+func (p *postgresStorage) save(id string, generated bool, body map[string]interface{}, author string, content []byte) (bool, error) {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	current, err := scan(tx.QueryRow(selectFlows+" WHERE id = $1 FOR UPDATE", id))
+	if err != nil && err != sql.ErrNoRows {
+		return false, err
+	}
+	var fl *flow.Flow
+	if err == sql.ErrNoRows {
+		fl = flow.New(id, body, author)
+		result, err := tx.Exec(insertFlow, args(fl, content)...)
+		if err != nil {
+			return false, err
+		}
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			return false, err
+		}
+	} else {
+		if generated {
+			return false, nil
+		}
+		var latest int
+		if err = tx.QueryRow("SELECT latest_revision FROM flows WHERE id = $1", id).Scan(&latest); err != nil {
+			return false, err
+		}
+		fl = flow.NewRevision(current.Metadata, latest, body, author)
+		if _, err = tx.Exec(updateFlow, args(fl, content)...); err != nil {
+			return false, err
+		}
+	}
+	if _, err = tx.Exec(insertRevision, args(fl, content)...); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+func (p *postgresStorage) get(id string) (*flow.Flow, error) {
+	fl, err := scan(p.db.QueryRow(selectFlows+" WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return fl, err
+}
+
+func (p *postgresStorage) GetFlow(id string) (interface{}, error) {
+	fl, err := p.get(id)
+	if err != nil || fl == nil {
+		return nil, err
+	}
+	return fl.Flow, nil
+}
+
+func (p *postgresStorage) DeleteFlow(id string) error {
+	tx, err := p.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM flows WHERE id = $1", id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("flow [%s] not found", id)
+	}
+	if _, err = tx.Exec("DELETE FROM flow_revisions WHERE id = $1", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *postgresStorage) GetFlowMetadata(id string) (*flow.Metadata, error) {
+	fl, err := p.get(id)
+	if err != nil {
+		return nil, err
+	}
+	if fl == nil {
+		return nil, fmt.Errorf("flow [%s] not found", id)
+	}
+	return fl.Metadata, nil
+}
+
+func (p *postgresStorage) FlowRevisions(id string) ([]*flow.Metadata, error) {
+	flows, err := p.query(selectRevisions+" WHERE id = $1 ORDER BY revision", id)
+	if err != nil {
+		return nil, err
+	}
+	var revisions []*flow.Metadata
+	for _, fl := range flows {
+		revisions = append(revisions, fl.Metadata)
+	}
+	return revisions, nil
+}
+
+func (p *postgresStorage) GetFlowRevision(id string, revision int) (*flow.Flow, error) {
+	fl, err := scan(p.db.QueryRow(selectRevisions+" WHERE id = $1 AND revision = $2", id, revision))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return fl, err
+}
+
+func (p *postgresStorage) PromoteRevision(id string, revision int) error {
+	result, err := p.db.Exec(`UPDATE flows f SET name = r.name, description = r.description, revision = r.revision, author = r.author,
+revision_date = r.revision_date, hash = r.hash, flow = r.flow FROM flow_revisions r WHERE f.id = $1 AND r.id = $1 AND r.revision = $2`, id, revision)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("flow [%s] revision [%d] not found", id, revision)
+	}
+	return nil
+}
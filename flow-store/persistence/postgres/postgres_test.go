@@ -0,0 +1,28 @@
+package postgres
+
+import (
+	"os"
+	"testing"
+
+	"github.com/project-flogo/services/flow-store/persistence/api"
+	"github.com/project-flogo/services/flow-store/persistence/storagetest"
+)
+
+// TestConformance runs against the database of FLOGO_FLOW_STORE_TEST_POSTGRES_URL, its flows and flow_revisions tables are emptied before every test
+func TestConformance(t *testing.T) {
+	url := os.Getenv("FLOGO_FLOW_STORE_TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("FLOGO_FLOW_STORE_TEST_POSTGRES_URL is not set")
+	}
+
+	storagetest.Run(t, func(t *testing.T) api.Storage {
+		s, err := NewStorage(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = s.(*postgresStorage).db.Exec("TRUNCATE flows, flow_revisions"); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
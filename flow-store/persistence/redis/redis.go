@@ -0,0 +1,391 @@
+// Package redis stores the flows in Redis
+//
+//	<prefix>flow:<id>       hash of the JSON and metadata of the current revision of the flow
+//	<prefix>revisions:<id>  hash of the JSON of the flow.Flow of every revision by revision number
+//	<prefix>flows           sorted set of the flow ids by save time
+//	<prefix>name:<name>     set of the ids of the flows with the name
+//	<prefix>nextId          counter of the generated flow ids
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/project-flogo/services/flow-store/flow"
+	"github.com/project-flogo/services/flow-store/persistence/api"
+)
+
+const (
+	DefaultAddress = "localhost:6379"
+	DefaultPrefix  = "flogo:flowstore:"
+
+	// maxRetryDelay caps the doubling delay before a save or delete is retried when the flow changes meanwhile
+	maxRetryDelay = 100 * time.Millisecond
+)
+
+// Settings are the connection settings, read from the environment by SettingsFromEnv
+type Settings struct {
+	Address  string
+	Password string
+	DB       int
+	Prefix   string
+}
+
+// SettingsFromEnv reads FLOGO_REDIS_ADDRESS, FLOGO_REDIS_PASSWORD, FLOGO_REDIS_DB and FLOGO_REDIS_PREFIX
+func SettingsFromEnv() (*Settings, error) {
+	s := &Settings{Address: os.Getenv("FLOGO_REDIS_ADDRESS"), Password: os.Getenv("FLOGO_REDIS_PASSWORD"), Prefix: os.Getenv("FLOGO_REDIS_PREFIX")}
+	if db := os.Getenv("FLOGO_REDIS_DB"); db != "" {
+		n, err := strconv.Atoi(db)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid FLOGO_REDIS_DB [%s]", db)
+		}
+		s.DB = n
+	}
+	return s, nil
+}
+
+type redisStorage struct {
+	pool   *redis.Pool
+	prefix string
+}
+
+// NewStorage creates the storage, connections are opened when the storage is used
+func NewStorage(settings *Settings) api.Storage {
+	address := settings.Address
+	if address == "" {
+		address = DefaultAddress
+	}
+	prefix := settings.Prefix
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+
+	options := []redis.DialOption{redis.DialDatabase(settings.DB), redis.DialConnectTimeout(5 * time.Second)}
+	if settings.Password != "" {
+		options = append(options, redis.DialPassword(settings.Password))
+	}
+	return &redisStorage{
+		prefix: prefix,
+		pool: &redis.Pool{
+			MaxIdle:     8,
+			IdleTimeout: 5 * time.Minute,
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial("tcp", address, options...)
+			},
+			TestOnBorrow: func(c redis.Conn, idle time.Time) error {
+				if time.Since(idle) < time.Minute {
+					return nil
+				}
+				_, err := c.Do("PING")
+				return err
+			},
+		},
+	}
+}
+
+func (r *redisStorage) flowKey(id string) string {
+	return r.prefix + "flow:" + id
+}
+
+func (r *redisStorage) revisionsKey(id string) string {
+	return r.prefix + "revisions:" + id
+}
+
+func (r *redisStorage) nameKey(name string) string {
+	return r.prefix + "name:" + name
+}
+
+func (r *redisStorage) flowsKey() string {
+	return r.prefix + "flows"
+}
+
+func (r *redisStorage) AllFlows() ([]*flow.Flow, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+	ids, err := redis.Strings(conn.Do("ZRANGE", r.flowsKey(), 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	return r.flows(conn, ids)
+}
+
+func (r *redisStorage) FlowsByName(name string) ([]*flow.Flow, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+	ids, err := redis.Strings(conn.Do("SMEMBERS", r.nameKey(name)))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+	return r.flows(conn, ids)
+}
+
+// flows reads the flows, ids deleted meanwhile are skipped
+func (r *redisStorage) flows(conn redis.Conn, ids []string) ([]*flow.Flow, error) {
+	var flows []*flow.Flow
+	for _, id := range ids {
+		fl, err := r.read(conn, id)
+		if err != nil {
+			return nil, err
+		}
+		if fl != nil {
+			flows = append(flows, fl)
+		}
+	}
+	return flows, nil
+}
+
+func (r *redisStorage) read(conn redis.Conn, id string) (*flow.Flow, error) {
+	values, err := redis.StringMap(conn.Do("HGETALL", r.flowKey(id)))
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	fl := &flow.Flow{Metadata: metadata(id, values)}
+	var body map[string]interface{}
+	if err = json.Unmarshal([]byte(values["flow"]), &body); err != nil {
+		return nil, fmt.Errorf("flow [%s] can not be read: %s", id, err.Error())
+	}
+	fl.Flow = body
+	return fl, nil
+}
+
+func metadata(id string, values map[string]string) *flow.Metadata {
+	revision, _ := strconv.Atoi(values["revision"])
+	return &flow.Metadata{Id: id, Name: values["name"], Description: values["description"], CreationDate: values["creationDate"],
+		Revision: revision, Author: values["author"], RevisionDate: values["revisionDate"], Hash: values["hash"]}
+}
+
+// current queues the commands making the flow its current revision, indexed under its name instead of the previous name
+func (r *redisStorage) current(conn redis.Conn, fl *flow.Flow, previous *flow.Metadata) error {
+	content, err := json.Marshal(fl.Flow)
+	if err != nil {
+		return err
+	}
+	md := fl.Metadata
+	if previous != nil && previous.Name != md.Name {
+		_ = conn.Send("SREM", r.nameKey(previous.Name), md.Id)
+	}
+	_ = conn.Send("DEL", r.flowKey(md.Id))
+	_ = conn.Send("HSET", r.flowKey(md.Id), "flow", content, "name", md.Name, "description", md.Description, "creationDate", md.CreationDate,
+		"revision", md.Revision, "author", md.Author, "revisionDate", md.RevisionDate, "hash", md.Hash)
+	_ = conn.Send("ZADD", r.flowsKey(), time.Now().UnixNano(), md.Id)
+	_ = conn.Send("SADD", r.nameKey(md.Name), md.Id)
+	return nil
+}
+
+// SaveFlow adds a revision of the flow and makes it current, the id is generated from a counter when the body has none
+func (r *redisStorage) SaveFlow(body map[string]interface{}, author string) (string, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	id := flow.Id(body)
+	for id == "" {
+		n, err := redis.Int64(conn.Do("INCR", r.prefix+"nextId"))
+		if err != nil {
+			return "", err
+		}
+		// skip the ids of flows saved with an explicit id
+		taken, err := redis.Bool(conn.Do("EXISTS", r.flowKey(strconv.FormatInt(n, 10))))
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			id = strconv.FormatInt(n, 10)
+		}
+	}
+
+	err := r.update(conn, id, func(current *flow.Metadata, latest int) error {
+		fl := flow.New(id, body, author)
+		if current != nil {
+			fl = flow.NewRevision(current, latest, body, author)
+		}
+		revision, err := json.Marshal(fl)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			// revisions left by a delete that did not complete belong to the deleted flow
+			_ = conn.Send("DEL", r.revisionsKey(id))
+		}
+		_ = conn.Send("HSET", r.revisionsKey(id), fl.Metadata.Revision, revision)
+		return r.current(conn, fl, current)
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+func (r *redisStorage) GetFlow(id string) (interface{}, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+	fl, err := r.read(conn, id)
+	if err != nil || fl == nil {
+		return nil, err
+	}
+	return fl.Flow, nil
+}
+
+func (r *redisStorage) DeleteFlow(id string) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	found := true
+	err := r.update(conn, id, func(current *flow.Metadata, _ int) error {
+		found = current != nil
+		if !found {
+			return nil
+		}
+		_ = conn.Send("DEL", r.flowKey(id), r.revisionsKey(id))
+		_ = conn.Send("ZREM", r.flowsKey(), id)
+		_ = conn.Send("SREM", r.nameKey(current.Name), id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("flow [%s] not found", id)
+	}
+	return nil
+}
+
+func (r *redisStorage) GetFlowMetadata(id string) (*flow.Metadata, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+	values, err := redis.StringMap(conn.Do("HGETALL", r.flowKey(id)))
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("flow [%s] not found", id)
+	}
+	return metadata(id, values), nil
+}
+
+func (r *redisStorage) FlowRevisions(id string) ([]*flow.Metadata, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+	values, err := redis.StringMap(conn.Do("HGETALL", r.revisionsKey(id)))
+	if err != nil {
+		return nil, err
+	}
+	var revisions []*flow.Metadata
+	for _, v := range values {
+		fl := &flow.Flow{}
+		if err = json.Unmarshal([]byte(v), fl); err != nil || fl.Metadata == nil {
+			return nil, fmt.Errorf("flow [%s] revisions can not be read: %v", id, err)
+		}
+		revisions = append(revisions, fl.Metadata)
+	}
+	sort.Slice(revisions, func(i, j int) bool { return revisions[i].Revision < revisions[j].Revision })
+	return revisions, nil
+}
+
+func (r *redisStorage) GetFlowRevision(id string, revision int) (*flow.Flow, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+	return r.revision(conn, id, revision)
+}
+
+func (r *redisStorage) revision(conn redis.Conn, id string, revision int) (*flow.Flow, error) {
+	content, err := redis.Bytes(conn.Do("HGET", r.revisionsKey(id), revision))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	fl := &flow.Flow{}
+	if err = json.Unmarshal(content, fl); err != nil || fl.Metadata == nil {
+		return nil, fmt.Errorf("flow [%s] revision [%d] can not be read: %v", id, revision, err)
+	}
+	return fl, nil
+}
+
+func (r *redisStorage) PromoteRevision(id string, revision int) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+
+	// revisions are immutable, so the revision is read before the transaction
+	fl, err := r.revision(conn, id, revision)
+	if err != nil {
+		return err
+	}
+	found := fl != nil
+	if found {
+		err = r.update(conn, id, func(current *flow.Metadata, latest int) error {
+			// the flow may have been deleted, or deleted and saved again, since the revision was read
+			found = current != nil && revision <= latest && current.CreationDate == fl.Metadata.CreationDate
+			if !found {
+				return nil
+			}
+			return r.current(conn, fl, current)
+		})
+	}
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("flow [%s] revision [%d] not found", id, revision)
+	}
+	return nil
+}
+
+// update runs the commands queued by send in a transaction, send gets the metadata of the current revision of the flow,
+// nil for an unknown flow, and its latest revision number. The transaction is retried until it commits when the flow is
+// changed meanwhile, one of the concurrent writers commits every time so they all eventually do
+func (r *redisStorage) update(conn redis.Conn, id string, send func(current *flow.Metadata, latest int) error) error {
+	delay := time.Millisecond
+	for {
+		if _, err := conn.Do("WATCH", r.flowKey(id), r.revisionsKey(id)); err != nil {
+			return err
+		}
+		values, err := redis.StringMap(conn.Do("HGETALL", r.flowKey(id)))
+		var latest int
+		if err == nil {
+			latest, err = redis.Int(conn.Do("HLEN", r.revisionsKey(id)))
+		}
+		if err != nil {
+			_, _ = conn.Do("UNWATCH")
+			return err
+		}
+		var current *flow.Metadata
+		if len(values) > 0 {
+			current = metadata(id, values)
+			// flows saved before revisions were kept have no revision
+			if latest < current.Revision {
+				latest = current.Revision
+			}
+		}
+
+		if err = conn.Send("MULTI"); err != nil {
+			return err
+		}
+		if err = send(current, latest); err != nil {
+			_, _ = conn.Do("DISCARD")
+			return err
+		}
+		reply, err := conn.Do("EXEC")
+		if err != nil {
+			return err
+		}
+		if reply != nil {
+			return nil
+		}
+		// back off so concurrent writers of the flow do not keep aborting each other
+		time.Sleep(delay/2 + time.Duration(rand.Int63n(int64(delay))))
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
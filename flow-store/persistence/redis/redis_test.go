@@ -0,0 +1,146 @@
+package redis
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/project-flogo/services/flow-store/persistence/api"
+	"github.com/project-flogo/services/flow-store/persistence/storagetest"
+)
+
+func newStorage(t *testing.T) (*redisStorage, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	return NewStorage(&Settings{Address: server.Addr()}).(*redisStorage), server
+}
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) api.Storage {
+		s, _ := newStorage(t)
+		return s
+	})
+}
+
+func TestSaveAndGet(t *testing.T) {
+	s, _ := newStorage(t)
+
+	id, err := s.SaveFlow(map[string]interface{}{"name": "orders", "description": "create orders", "tasks": []interface{}{"log"}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "1" {
+		t.Fatalf("expected the generated id 1, got %s", id)
+	}
+
+	body, err := s.GetFlow(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body.(map[string]interface{})["name"] != "orders" {
+		t.Fatalf("expected the saved flow, got %v", body)
+	}
+	md, err := s.GetFlowMetadata(id)
+	if err != nil || md.Name != "orders" || md.Description != "create orders" || md.CreationDate == "" {
+		t.Fatalf("unexpected metadata %+v, %v", md, err)
+	}
+
+	if body, err = s.GetFlow("missing"); body != nil || err != nil {
+		t.Fatalf("expected nil, nil for an unknown flow, got %v, %v", body, err)
+	}
+	if _, err = s.GetFlowMetadata("missing"); err == nil {
+		t.Fatal("expected an error for the metadata of an unknown flow")
+	}
+}
+
+func TestNameIndex(t *testing.T) {
+	s, _ := newStorage(t)
+
+	for _, body := range []map[string]interface{}{
+		{"id": "a", "name": "orders"},
+		{"id": "b", "name": "orders"},
+		{"id": "c", "name": "billing"},
+	} {
+		if _, err := s.SaveFlow(body, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// renaming moves the flow to the index of its new name
+	if _, err := s.SaveFlow(map[string]interface{}{"id": "b", "name": "billing"}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	orders, err := s.FlowsByName("orders")
+	if err != nil || len(orders) != 1 || orders[0].Metadata.Id != "a" {
+		t.Fatalf("expected flow a named orders, got %v, %v", orders, err)
+	}
+	billing, _ := s.FlowsByName("billing")
+	if len(billing) != 2 || billing[0].Metadata.Id != "b" || billing[1].Metadata.Id != "c" {
+		t.Fatalf("expected flows b and c named billing, got %d flows", len(billing))
+	}
+
+	if err = s.DeleteFlow("c"); err != nil {
+		t.Fatal(err)
+	}
+	if billing, _ = s.FlowsByName("billing"); len(billing) != 1 {
+		t.Fatalf("expected the deleted flow removed from the name index, got %d flows", len(billing))
+	}
+	all, _ := s.AllFlows()
+	if len(all) != 2 {
+		t.Fatalf("expected 2 flows, got %d", len(all))
+	}
+	if err = s.DeleteFlow("c"); err == nil {
+		t.Fatal("expected an error deleting an unknown flow")
+	}
+}
+
+func TestRestart(t *testing.T) {
+	s, server := newStorage(t)
+	if _, err := s.SaveFlow(map[string]interface{}{"name": "orders"}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new storage on the same server sees the flows and does not reuse the generated ids
+	restarted := NewStorage(&Settings{Address: server.Addr()})
+	all, err := restarted.AllFlows()
+	if err != nil || len(all) != 1 {
+		t.Fatalf("expected the saved flow after a restart, got %v, %v", all, err)
+	}
+	id, err := restarted.SaveFlow(map[string]interface{}{"name": "billing"}, "")
+	if err != nil || id != "2" {
+		t.Fatalf("expected the generated id 2, got %s, %v", id, err)
+	}
+}
+
+func TestConcurrentSaves(t *testing.T) {
+	s, _ := newStorage(t)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := "orders"
+			if i%2 == 1 {
+				name = "billing"
+			}
+			if _, err := s.SaveFlow(map[string]interface{}{"id": "a", "name": name}, ""); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// the flow is indexed under its final name only
+	md, err := s.GetFlowMetadata("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders, _ := s.FlowsByName("orders")
+	billing, _ := s.FlowsByName("billing")
+	if len(orders)+len(billing) != 1 {
+		t.Fatalf("expected the flow in one name index, got %d orders and %d billing", len(orders), len(billing))
+	}
+	if all, _ := s.FlowsByName(md.Name); len(all) != 1 {
+		t.Fatalf("expected the flow indexed under %s", md.Name)
+	}
+}
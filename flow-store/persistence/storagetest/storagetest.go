@@ -0,0 +1,307 @@
+// Package storagetest checks an api.Storage implementation against the behaviour the flow service relies on
+package storagetest
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/project-flogo/services/flow-store/flow"
+	"github.com/project-flogo/services/flow-store/persistence/api"
+)
+
+// Factory returns an empty storage, it is called once per test
+type Factory func(t *testing.T) api.Storage
+
+// Run runs the conformance tests against the storages created by newStorage
+func Run(t *testing.T, newStorage Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s api.Storage)
+	}{
+		{"SaveAndGet", testSaveAndGet},
+		{"GeneratedIds", testGeneratedIds},
+		{"Overwrite", testOverwrite},
+		{"Delete", testDelete},
+		{"Revisions", testRevisions},
+		{"Promote", testPromote},
+		{"Concurrency", testConcurrency},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, newStorage(t))
+		})
+	}
+}
+
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func ids(flows []*flow.Flow) []string {
+	var ids []string
+	for _, f := range flows {
+		ids = append(ids, f.Metadata.Id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func testSaveAndGet(t *testing.T, s api.Storage) {
+	if body, err := s.GetFlow("missing"); body != nil || err != nil {
+		t.Fatalf("GetFlow of an unknown flow: expected nil, nil, got %v, %v", body, err)
+	}
+	if _, err := s.GetFlowMetadata("missing"); err == nil {
+		t.Fatal("GetFlowMetadata of an unknown flow: expected an error")
+	}
+
+	id, err := s.SaveFlow(map[string]interface{}{"id": "orders", "name": "Orders", "description": "create orders", "data": map[string]interface{}{"tasks": []interface{}{"log"}}}, "")
+	must(t, err)
+	if id != "orders" {
+		t.Fatalf("SaveFlow: expected the id of the body, got %s", id)
+	}
+
+	body, err := s.GetFlow("orders")
+	must(t, err)
+	content, ok := body.(map[string]interface{})
+	if !ok || content["name"] != "Orders" || content["data"] == nil {
+		t.Fatalf("GetFlow: expected the saved body, got %v", body)
+	}
+	md, err := s.GetFlowMetadata("orders")
+	must(t, err)
+	if md.Id != "orders" || md.Name != "Orders" || md.Description != "create orders" || md.CreationDate == "" || md.Revision != 1 || md.Hash == "" {
+		t.Fatalf("GetFlowMetadata: unexpected %+v", md)
+	}
+
+	all, err := s.AllFlows()
+	must(t, err)
+	if fmt.Sprint(ids(all)) != "[orders]" {
+		t.Fatalf("AllFlows: expected orders, got %v", ids(all))
+	}
+}
+
+func testGeneratedIds(t *testing.T, s api.Storage) {
+	// flows imported with ids that a counter restarted from 0 would generate
+	for _, id := range []string{"1", "2", "3"} {
+		_, err := s.SaveFlow(map[string]interface{}{"id": id, "name": "imported"}, "")
+		must(t, err)
+	}
+
+	seen := map[string]bool{"1": true, "2": true, "3": true}
+	for i := 0; i < 10; i++ {
+		id, err := s.SaveFlow(map[string]interface{}{"name": "generated"}, "")
+		must(t, err)
+		if id == "" || seen[id] {
+			t.Fatalf("SaveFlow: expected a new generated id, got %q", id)
+		}
+		seen[id] = true
+	}
+
+	imported, err := s.FlowsByName("imported")
+	must(t, err)
+	if len(imported) != 3 {
+		t.Fatalf("SaveFlow with a generated id: expected the imported flows to be kept, got %v", ids(imported))
+	}
+	generated, err := s.FlowsByName("generated")
+	must(t, err)
+	if len(generated) != 10 {
+		t.Fatalf("FlowsByName: expected 10 generated flows, got %d", len(generated))
+	}
+}
+
+func testOverwrite(t *testing.T, s api.Storage) {
+	_, err := s.SaveFlow(map[string]interface{}{"id": "a", "name": "orders"}, "")
+	must(t, err)
+	_, err = s.SaveFlow(map[string]interface{}{"id": "b", "name": "orders"}, "")
+	must(t, err)
+	_, err = s.SaveFlow(map[string]interface{}{"id": "a", "name": "billing", "description": "renamed"}, "")
+	must(t, err)
+
+	md, err := s.GetFlowMetadata("a")
+	must(t, err)
+	if md.Name != "billing" || md.Description != "renamed" {
+		t.Fatalf("SaveFlow of an existing id: expected the flow to be replaced, got %+v", md)
+	}
+	orders, err := s.FlowsByName("orders")
+	must(t, err)
+	if fmt.Sprint(ids(orders)) != "[b]" {
+		t.Fatalf("FlowsByName after a rename: expected b, got %v", ids(orders))
+	}
+	billing, err := s.FlowsByName("billing")
+	must(t, err)
+	if fmt.Sprint(ids(billing)) != "[a]" {
+		t.Fatalf("FlowsByName after a rename: expected a, got %v", ids(billing))
+	}
+}
+
+func testDelete(t *testing.T, s api.Storage) {
+	_, err := s.SaveFlow(map[string]interface{}{"id": "a", "name": "orders"}, "")
+	must(t, err)
+	_, err = s.SaveFlow(map[string]interface{}{"id": "b", "name": "orders"}, "")
+	must(t, err)
+
+	must(t, s.DeleteFlow("a"))
+	if body, err := s.GetFlow("a"); body != nil || err != nil {
+		t.Fatalf("GetFlow after DeleteFlow: expected nil, nil, got %v, %v", body, err)
+	}
+	orders, err := s.FlowsByName("orders")
+	must(t, err)
+	if fmt.Sprint(ids(orders)) != "[b]" {
+		t.Fatalf("FlowsByName after DeleteFlow: expected b, got %v", ids(orders))
+	}
+	if err = s.DeleteFlow("a"); err == nil {
+		t.Fatal("DeleteFlow of an unknown flow: expected an error")
+	}
+	if revisions, err := s.FlowRevisions("a"); err != nil || len(revisions) != 0 {
+		t.Fatalf("FlowRevisions after DeleteFlow: expected none, got %d, %v", len(revisions), err)
+	}
+
+	// a flow saved again after it was deleted starts over
+	_, err = s.SaveFlow(map[string]interface{}{"id": "a", "name": "billing"}, "")
+	must(t, err)
+	revisions, err := s.FlowRevisions("a")
+	must(t, err)
+	if len(revisions) != 1 || revisions[0].Revision != 1 || revisions[0].Name != "billing" {
+		t.Fatalf("FlowRevisions of a flow saved after DeleteFlow: expected its first revision only, got %d", len(revisions))
+	}
+}
+
+func testRevisions(t *testing.T, s api.Storage) {
+	first := map[string]interface{}{"id": "a", "name": "orders", "tasks": []interface{}{"log"}}
+	_, err := s.SaveFlow(first, "alice")
+	must(t, err)
+	_, err = s.SaveFlow(map[string]interface{}{"id": "a", "name": "billing", "tasks": []interface{}{"log", "rest"}}, "bob")
+	must(t, err)
+	_, err = s.SaveFlow(map[string]interface{}{"id": "a", "tasks": []interface{}{"log"}, "name": "orders"}, "carol")
+	must(t, err)
+
+	revisions, err := s.FlowRevisions("a")
+	must(t, err)
+	if len(revisions) != 3 {
+		t.Fatalf("FlowRevisions: expected 3 revisions, got %d", len(revisions))
+	}
+	for i, author := range []string{"alice", "bob", "carol"} {
+		md := revisions[i]
+		if md.Id != "a" || md.Revision != i+1 || md.Author != author || md.RevisionDate == "" || md.CreationDate != revisions[0].CreationDate {
+			t.Fatalf("FlowRevisions: unexpected revision %d %+v", i+1, md)
+		}
+	}
+	if revisions[1].Name != "billing" || revisions[0].Hash != revisions[2].Hash || revisions[0].Hash == revisions[1].Hash {
+		t.Fatalf("FlowRevisions: expected equal hashes for equal flows only, got %s, %s and %s", revisions[0].Hash, revisions[1].Hash, revisions[2].Hash)
+	}
+
+	md, err := s.GetFlowMetadata("a")
+	must(t, err)
+	if md.Revision != 3 || md.Author != "carol" {
+		t.Fatalf("GetFlowMetadata: expected the latest revision to be current, got %+v", md)
+	}
+	second, err := s.GetFlowRevision("a", 2)
+	must(t, err)
+	if second == nil || second.Metadata.Revision != 2 || second.Metadata.Author != "bob" || len(second.Flow.(map[string]interface{})["tasks"].([]interface{})) != 2 {
+		t.Fatalf("GetFlowRevision: expected the revision 2, got %v", second)
+	}
+	for _, revision := range []int{0, 4} {
+		if fl, err := s.GetFlowRevision("a", revision); fl != nil || err != nil {
+			t.Fatalf("GetFlowRevision of an unknown revision: expected nil, nil, got %v, %v", fl, err)
+		}
+	}
+	if fl, err := s.GetFlowRevision("missing", 1); fl != nil || err != nil {
+		t.Fatalf("GetFlowRevision of an unknown flow: expected nil, nil, got %v, %v", fl, err)
+	}
+	if revisions, err := s.FlowRevisions("missing"); err != nil || len(revisions) != 0 {
+		t.Fatalf("FlowRevisions of an unknown flow: expected none, got %d, %v", len(revisions), err)
+	}
+}
+
+func testPromote(t *testing.T, s api.Storage) {
+	_, err := s.SaveFlow(map[string]interface{}{"id": "a", "name": "orders", "version": "1"}, "alice")
+	must(t, err)
+	_, err = s.SaveFlow(map[string]interface{}{"id": "a", "name": "billing", "version": "2"}, "bob")
+	must(t, err)
+
+	must(t, s.PromoteRevision("a", 1))
+	md, err := s.GetFlowMetadata("a")
+	must(t, err)
+	if md.Revision != 1 || md.Name != "orders" || md.Author != "alice" {
+		t.Fatalf("GetFlowMetadata after PromoteRevision: expected the revision 1, got %+v", md)
+	}
+	body, err := s.GetFlow("a")
+	must(t, err)
+	if body.(map[string]interface{})["version"] != "1" {
+		t.Fatalf("GetFlow after PromoteRevision: expected the revision 1, got %v", body)
+	}
+	orders, err := s.FlowsByName("orders")
+	must(t, err)
+	billing, err := s.FlowsByName("billing")
+	must(t, err)
+	if len(orders) != 1 || len(billing) != 0 {
+		t.Fatalf("FlowsByName after PromoteRevision: expected the flow under the name of the revision, got %d orders and %d billing", len(orders), len(billing))
+	}
+
+	// saves after a rollback still get new revision numbers
+	_, err = s.SaveFlow(map[string]interface{}{"id": "a", "name": "orders", "version": "3"}, "carol")
+	must(t, err)
+	md, err = s.GetFlowMetadata("a")
+	must(t, err)
+	if md.Revision != 3 {
+		t.Fatalf("SaveFlow after PromoteRevision: expected the revision 3, got %d", md.Revision)
+	}
+	revisions, err := s.FlowRevisions("a")
+	must(t, err)
+	if len(revisions) != 3 {
+		t.Fatalf("FlowRevisions after PromoteRevision: expected 3 revisions, got %d", len(revisions))
+	}
+
+	if err = s.PromoteRevision("a", 4); err == nil {
+		t.Fatal("PromoteRevision of an unknown revision: expected an error")
+	}
+	if err = s.PromoteRevision("missing", 1); err == nil {
+		t.Fatal("PromoteRevision of an unknown flow: expected an error")
+	}
+}
+
+func testConcurrency(t *testing.T, s api.Storage) {
+	const writers, saves = 8, 5
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*saves)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; n < saves; n++ {
+				if _, err := s.SaveFlow(map[string]interface{}{"name": "generated"}, ""); err != nil {
+					errs <- err
+				}
+				if _, err := s.SaveFlow(map[string]interface{}{"id": "shared", "name": fmt.Sprintf("writer%d", i)}, ""); err != nil {
+					errs <- err
+				}
+				if _, err := s.AllFlows(); err != nil {
+					errs <- err
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		must(t, err)
+	}
+
+	generated, err := s.FlowsByName("generated")
+	must(t, err)
+	if len(generated) != writers*saves {
+		t.Fatalf("concurrent SaveFlow: expected %d generated flows, got %d", writers*saves, len(generated))
+	}
+	md, err := s.GetFlowMetadata("shared")
+	must(t, err)
+	shared, err := s.FlowsByName(md.Name)
+	must(t, err)
+	if fmt.Sprint(ids(shared)) != "[shared]" {
+		t.Fatalf("concurrent SaveFlow of an id: expected it indexed under its last name %s, got %v", md.Name, ids(shared))
+	}
+}